@@ -0,0 +1,496 @@
+package cachemanager
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MaxBatchKeys bounds the number of keys accepted by MGet and MSet.
+const MaxBatchKeys = 1000
+
+type MGetRequest struct {
+	Keys []string `json:"keys"`
+}
+
+// MGetResult is the outcome for one requested key. Error is set instead of failing
+// the whole request, so one bad key does not hide the others.
+type MGetResult struct {
+	Key string `json:"key"`
+	// Value is JSON-encoded.
+	Value     json.RawMessage `json:"value,omitempty"`
+	Hit       bool            `json:"hit"`
+	Found     bool            `json:"found"`
+	Source    string          `json:"source,omitempty"` // "l1", "l2", "origin", "stale"
+	Stale     bool            `json:"stale,omitempty"`
+	Version   uint64          `json:"version,omitempty"`
+	ExpiresAt *time.Time      `json:"expires_at,omitempty"`
+	Error     string          `json:"error,omitempty"`
+}
+
+type MGetResponse struct {
+	Results []MGetResult `json:"results"` // Same order as the request
+}
+
+type MSetRequest struct {
+	Entries []SetRequest `json:"entries"` // Each entry names its own key
+}
+
+type MSetResult struct {
+	Key       string     `json:"key"`
+	Success   bool       `json:"success"`
+	Version   uint64     `json:"version,omitempty"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	Error     string     `json:"error,omitempty"`
+}
+
+type MSetResponse struct {
+	Results []MSetResult `json:"results"` // Same order as the request
+}
+
+// batchOutcome is the resolution of one key missed by L1.
+type batchOutcome struct {
+	entry *CacheEntry
+	err   error
+}
+
+// MGet retrieves many keys in one request. In cluster mode the keys are split by
+// owner and each part is served by its owner, as one batch per peer.
+// L1 hits are answered directly; all misses share one L2 lookup (MGET when the L2
+// implements BatchRemoteCache) and, for keys still missing, one origin call when the
+// origin implements BatchOriginFetcher. Identical concurrent batches are coalesced.
+// Complexity: O(k) for k keys, plus at most one L2 and one origin round trip.
+//
+//encore:api public method=POST path=/api/cache/mget
+func MGet(ctx context.Context, req *MGetRequest) (*MGetResponse, error) {
+	if svc == nil {
+		return nil, errors.New("service not initialized")
+	}
+	return svc.MGet(ctx, req)
+}
+
+func (s *Service) MGet(ctx context.Context, req *MGetRequest) (*MGetResponse, error) {
+	if err := checkBatchSize("keys", len(req.Keys)); err != nil {
+		return nil, err
+	}
+	if s.cluster == nil {
+		return s.mgetLocal(ctx, req)
+	}
+
+	results := make([]MGetResult, len(req.Keys))
+	local := s.routeBatch(req.Keys, func(peer Peer, indexes []int) error {
+		part := &MGetRequest{Keys: make([]string, len(indexes))}
+		for j, i := range indexes {
+			part.Keys[j] = req.Keys[i]
+		}
+		resp, err := peer.MGet(ctx, part)
+		if err == nil && len(resp.Results) != len(indexes) {
+			err = fmt.Errorf("%w: %d results for %d keys", ErrPeerUnavailable, len(resp.Results), len(indexes))
+		}
+		for j, i := range indexes {
+			switch {
+			case err == nil:
+				results[i] = resp.Results[j]
+			case !errors.Is(err, ErrPeerUnavailable):
+				results[i] = MGetResult{Key: req.Keys[i], Error: err.Error()}
+			}
+		}
+		return err
+	})
+	if len(local) > 0 {
+		part := &MGetRequest{Keys: make([]string, len(local))}
+		for j, i := range local {
+			part.Keys[j] = req.Keys[i]
+		}
+		resp, err := s.mgetLocal(ctx, part)
+		if err != nil {
+			return nil, err
+		}
+		for j, i := range local {
+			results[i] = resp.Results[j]
+		}
+	}
+	return &MGetResponse{Results: results}, nil
+}
+
+// mgetLocal serves a batch from this instance's cache levels, ignoring ownership.
+func (s *Service) mgetLocal(ctx context.Context, req *MGetRequest) (*MGetResponse, error) {
+	if err := checkBatchSize("keys", len(req.Keys)); err != nil {
+		return nil, err
+	}
+
+	startTime := time.Now()
+	results := make([]MGetResult, len(req.Keys))
+	missing := make(map[string][]int) // key -> positions in results
+
+	for i, key := range req.Keys {
+		results[i].Key = key
+		if err := validateKey(key); err != nil {
+			results[i].Error = err.Error()
+			continue
+		}
+		if _, pending := missing[key]; pending {
+			missing[key] = append(missing[key], i)
+			continue
+		}
+
+		resp, ok, err := s.getL1(ctx, key)
+		if !ok {
+			missing[key] = []int{i}
+			continue
+		}
+		s.recordOp(opGet, key, resp.Hit, startTime, len(resp.Value))
+		results[i] = mgetResult(key, resp, err)
+	}
+
+	if len(missing) == 0 {
+		return &MGetResponse{Results: results}, nil
+	}
+
+	keys := make([]string, 0, len(missing))
+	for key := range missing {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	// Coalesce identical concurrent batches. The NUL-joined coalescer key cannot
+	// collide with a single-key Get.
+	shared, _ := s.coalescer.Do("mget\x00"+strings.Join(keys, "\x00"), func() (interface{}, error) {
+		return s.fetchBatch(ctx, keys), nil
+	})
+	outcomes := shared.(map[string]batchOutcome)
+
+	for _, key := range keys {
+		out := outcomes[key]
+		var resp *GetResponse
+		size := 0
+		if out.err != nil {
+			s.recordMiss(key)
+		} else {
+			resp = responseFromEntry(out.entry)
+			size = len(out.entry.Value)
+		}
+		for _, i := range missing[key] {
+			s.recordOp(opGet, key, false, startTime, size)
+			results[i] = mgetResult(key, resp, out.err)
+		}
+	}
+
+	return &MGetResponse{Results: results}, nil
+}
+
+// checkBatchSize rejects empty batches and those over MaxBatchKeys.
+func checkBatchSize(what string, n int) error {
+	if n == 0 {
+		return fmt.Errorf("%s cannot be empty", what)
+	}
+	if n > MaxBatchKeys {
+		return fmt.Errorf("too many %s: %d (max %d)", what, n, MaxBatchKeys)
+	}
+	return nil
+}
+
+// routeBatch forwards the positions of keys owned by each peer with forward, one
+// call per peer, run concurrently. Returns the positions to serve locally, in
+// request order: the keys this instance owns and those whose owner could not be
+// reached (forward returned ErrPeerUnavailable).
+func (s *Service) routeBatch(keys []string, forward func(peer Peer, indexes []int) error) []int {
+	local, remote := s.splitByOwner(keys)
+	callErrs := make([]error, len(remote))
+	var wg sync.WaitGroup
+	for n, part := range remote {
+		wg.Add(1)
+		go func(n int, part ownerBatch) {
+			defer wg.Done()
+			callErrs[n] = forward(part.peer, part.indexes)
+		}(n, part)
+	}
+	wg.Wait()
+
+	fallback := false
+	for n, part := range remote {
+		if !s.forwarded(callErrs[n]) {
+			local = append(local, part.indexes...)
+			fallback = true
+		}
+	}
+	if fallback {
+		sort.Ints(local)
+	}
+	return local
+}
+
+func mgetResult(key string, resp *GetResponse, err error) MGetResult {
+	if err != nil {
+		return MGetResult{Key: key, Error: err.Error()}
+	}
+	return MGetResult{
+		Key:       key,
+		Value:     resp.Value,
+		Hit:       resp.Hit,
+		Found:     resp.Found,
+		Source:    resp.Source,
+		Stale:     resp.Stale,
+		Version:   resp.Version,
+		ExpiresAt: resp.ExpiresAt,
+	}
+}
+
+// fetchBatch resolves L1 misses through L2, then origin, populating both levels.
+func (s *Service) fetchBatch(ctx context.Context, keys []string) map[string]batchOutcome {
+	outcomes := make(map[string]batchOutcome, len(keys))
+	now := time.Now()
+
+	remaining := keys
+	if s.l2Allowed() {
+		remaining = remaining[:0:0]
+		found := s.getL2Multi(ctx, keys)
+		for _, key := range keys {
+			if data, ok := found[key]; ok {
+				if entry, ok := s.entryFromL2(key, data, now); ok {
+					outcomes[key] = batchOutcome{entry: entry}
+					continue
+				}
+			}
+			remaining = append(remaining, key)
+		}
+	}
+
+	if len(remaining) == 0 {
+		return outcomes
+	}
+
+	if s.originFetch == nil {
+		for _, key := range remaining {
+			outcomes[key] = batchOutcome{err: errOriginNotConfigured}
+		}
+		return outcomes
+	}
+
+	batch, ok := s.originFetch.(BatchOriginFetcher)
+	if !ok {
+		// Per-key origin calls, shared with concurrent single-key Gets.
+		for _, key := range remaining {
+			result, err := s.coalescer.Do(key, func() (interface{}, error) {
+				return s.fetchFromOrigin(ctx, key, nil)
+			})
+			if err != nil {
+				outcomes[key] = batchOutcome{err: err}
+				continue
+			}
+			outcomes[key] = batchOutcome{entry: result.(*CacheEntry)}
+		}
+		return outcomes
+	}
+
+	if !s.originBreaker.Allow() {
+		for _, key := range remaining {
+			outcomes[key] = batchOutcome{err: fmt.Errorf("origin fetch failed: %w", ErrCircuitOpen)}
+		}
+		return outcomes
+	}
+	fetchStart := time.Now()
+	values, err := batch.FetchBatch(ctx, remaining)
+	s.recordOrigin(err)
+	// Recompute cost is attributed per key for XFetch.
+	delta := time.Since(fetchStart) / time.Duration(len(remaining))
+
+	for _, key := range remaining {
+		var entry *CacheEntry
+		var keyErr error
+		switch value, found := values[key]; {
+		case err != nil:
+			entry, keyErr = s.cacheOriginFailure(key, err, nil)
+		case !found:
+			entry, keyErr = s.cacheOriginFailure(key, ErrNotFound, nil)
+		default:
+			entry, keyErr = s.storeOriginValue(key, value, delta, s.config.StaleTTL)
+		}
+		outcomes[key] = batchOutcome{entry: entry, err: keyErr}
+	}
+	return outcomes
+}
+
+// getL2Multi reads keys from L2 in one round trip when supported, recording
+// per-key hit/miss metrics. L2 errors are counted and treated as misses. The caller
+// has checked the L2 breaker once, so per-key reads record one outcome.
+func (s *Service) getL2Multi(ctx context.Context, keys []string) map[string][]byte {
+	if batch, ok := s.l2Cache.(BatchRemoteCache); ok {
+		found, err := batch.GetMulti(ctx, keys)
+		s.recordL2(err)
+		if err != nil {
+			return nil
+		}
+		s.metrics.L2Misses.Add(int64(len(keys) - len(found)))
+		return found
+	}
+
+	found := make(map[string][]byte, len(keys))
+	failures := 0
+	for _, key := range keys {
+		data, ok, err := s.l2Cache.Get(ctx, key)
+		switch {
+		case err != nil:
+			failures++
+		case !ok:
+			s.metrics.L2Misses.Add(1)
+		default:
+			found[key] = data
+		}
+	}
+	s.recordL2Batch(failures)
+	return found
+}
+
+// MSet stores many entries in one request, writing L2 per Config.WriteMode like Set.
+// In cluster mode the entries are split by owner like MGet.
+// Each entry is validated independently and may carry its own if_version/if_absent
+// condition; a failed condition is reported in that entry's result. Unconditional L2
+// writes are sent in one round trip when the L2 implements BatchRemoteCache.
+// Complexity: O(k) for k entries, plus one L2 round trip (and one per conditional entry).
+//
+//encore:api public method=POST path=/api/cache/mset
+func MSet(ctx context.Context, req *MSetRequest) (*MSetResponse, error) {
+	if svc == nil {
+		return nil, errors.New("service not initialized")
+	}
+	return svc.MSet(ctx, req)
+}
+
+func (s *Service) MSet(ctx context.Context, req *MSetRequest) (*MSetResponse, error) {
+	if err := checkBatchSize("entries", len(req.Entries)); err != nil {
+		return nil, err
+	}
+	if s.cluster == nil {
+		return s.msetLocal(ctx, req)
+	}
+
+	keys := make([]string, len(req.Entries))
+	for i := range req.Entries {
+		keys[i] = req.Entries[i].Key
+	}
+	results := make([]MSetResult, len(req.Entries))
+	local := s.routeBatch(keys, func(peer Peer, indexes []int) error {
+		part := &MSetRequest{Entries: make([]SetRequest, len(indexes))}
+		for j, i := range indexes {
+			part.Entries[j] = req.Entries[i]
+		}
+		resp, err := peer.MSet(ctx, part)
+		if err == nil && len(resp.Results) != len(indexes) {
+			err = fmt.Errorf("%w: %d results for %d entries", ErrPeerUnavailable, len(resp.Results), len(indexes))
+		}
+		for j, i := range indexes {
+			switch {
+			case err == nil:
+				results[i] = resp.Results[j]
+				if s.hotKeys.isHot(keys[i]) {
+					s.l1For(keys[i]).Delete(keys[i]) // Drop the pinned copy this write made stale
+				}
+			case !errors.Is(err, ErrPeerUnavailable):
+				results[i] = MSetResult{Key: keys[i], Error: err.Error()}
+			}
+		}
+		return err
+	})
+	if len(local) > 0 {
+		part := &MSetRequest{Entries: make([]SetRequest, len(local))}
+		for j, i := range local {
+			part.Entries[j] = req.Entries[i]
+		}
+		resp, err := s.msetLocal(ctx, part)
+		if err != nil {
+			return nil, err
+		}
+		for j, i := range local {
+			results[i] = resp.Results[j]
+		}
+	}
+	return &MSetResponse{Results: results}, nil
+}
+
+// msetLocal writes a batch on this instance, ignoring ownership.
+func (s *Service) msetLocal(ctx context.Context, req *MSetRequest) (*MSetResponse, error) {
+	if err := checkBatchSize("entries", len(req.Entries)); err != nil {
+		return nil, err
+	}
+
+	startTime := time.Now()
+	results := make([]MSetResult, len(req.Entries))
+	items := make([]RemoteCacheItem, 0, len(req.Entries))
+	tagged := make(map[string][]string) // key -> tags to index once items are in L2
+
+	for i := range req.Entries {
+		entryReq := &req.Entries[i]
+		results[i].Key = entryReq.Key
+
+		// Conditional entries are checked and written against L2 one by one.
+		if !entryReq.condition().IsZero() && s.writesL2() {
+			entry, err := s.setConditional(ctx, entryReq.Key, entryReq)
+			if err != nil {
+				results[i].Error = err.Error()
+				continue
+			}
+			results[i].Success = true
+			results[i].Version = entry.Version
+			results[i].ExpiresAt = &entry.ExpiresAt
+			s.recordOp(opSet, entryReq.Key, false, startTime, len(entryReq.Value))
+			continue
+		}
+
+		entry, ttl, err := s.setL1(entryReq.Key, entryReq)
+		if err != nil {
+			results[i].Error = err.Error()
+			continue
+		}
+		results[i].Success = true
+		results[i].Version = entry.Version
+		results[i].ExpiresAt = &entry.ExpiresAt
+		s.recordOp(opSet, entryReq.Key, false, startTime, len(entryReq.Value))
+
+		if s.writesL2() && s.config.WriteMode == WriteModeBehind {
+			s.storeL2(entryReq.Key, entry, ttl)
+		} else if s.writesL2() {
+			data, err := s.encodeL2(entry)
+			if err != nil {
+				results[i].Success = false
+				results[i].Error = fmt.Sprintf("failed to marshal entry: %v", err)
+				continue
+			}
+			items = append(items, RemoteCacheItem{Key: entryReq.Key, Value: data, TTL: ttl})
+			if len(entry.Tags) > 0 {
+				tagged[entryReq.Key] = entry.Tags
+			}
+		}
+	}
+
+	if len(items) > 0 && s.l2Breaker.Allow() {
+		s.setL2Multi(ctx, items)
+		for key, tags := range tagged {
+			s.tagL2(ctx, key, tags)
+		}
+	}
+
+	return &MSetResponse{Results: results}, nil
+}
+
+// setL2Multi writes items to L2, in one round trip when supported. The caller has
+// checked the L2 breaker once, so per-key writes record one outcome. Failures are
+// counted but not surfaced, matching Set (L1 is authoritative).
+func (s *Service) setL2Multi(ctx context.Context, items []RemoteCacheItem) {
+	if batch, ok := s.l2Cache.(BatchRemoteCache); ok {
+		s.recordL2(batch.SetMulti(ctx, items))
+		return
+	}
+	failures := 0
+	for _, item := range items {
+		if err := s.l2Cache.Set(ctx, item.Key, item.Value, item.TTL); err != nil {
+			failures++
+		}
+	}
+	s.recordL2Batch(failures)
+}
@@ -0,0 +1,296 @@
+package cachemanager
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrCircuitOpen is returned when a dependency's circuit breaker rejects a call.
+var ErrCircuitOpen = errors.New("circuit breaker open")
+
+// BreakerState is the state of a CircuitBreaker.
+type BreakerState int
+
+const (
+	BreakerClosed   BreakerState = iota // Calls flow; outcomes are counted
+	BreakerOpen                         // Calls are rejected until the cooldown ends
+	BreakerHalfOpen                     // A few probe calls decide whether to close or reopen
+)
+
+func (s BreakerState) String() string {
+	switch s {
+	case BreakerOpen:
+		return "open"
+	case BreakerHalfOpen:
+		return "half-open"
+	default:
+		return "closed"
+	}
+}
+
+// BreakerConfig tunes the circuit breakers around origin and L2.
+type BreakerConfig struct {
+	Disabled    bool          // Never reject calls
+	FailureRate float64       // Failure ratio within Window that opens the breaker (default 0.5)
+	MinRequests int           // Calls within Window before FailureRate is evaluated (default 20)
+	Window      time.Duration // Length of the counting window (default 10s)
+	Cooldown    time.Duration // Time open before probing (default 5s)
+	Probes      int           // Consecutive successful probes that close the breaker (default 3)
+}
+
+func (c BreakerConfig) withDefaults() BreakerConfig {
+	if c.FailureRate <= 0 || c.FailureRate > 1 {
+		c.FailureRate = 0.5
+	}
+	if c.MinRequests <= 0 {
+		c.MinRequests = 20
+	}
+	if c.Window <= 0 {
+		c.Window = 10 * time.Second
+	}
+	if c.Cooldown <= 0 {
+		c.Cooldown = 5 * time.Second
+	}
+	if c.Probes <= 0 {
+		c.Probes = 3
+	}
+	return c
+}
+
+// BreakerStatus is a breaker's state as reported in MetricsResponse.
+type BreakerStatus struct {
+	State    string     `json:"state"`               // "closed", "open" or "half-open"
+	Requests int64      `json:"requests"`            // Calls in the current window
+	Failures int64      `json:"failures"`            // Failed calls in the current window
+	Opens    int64      `json:"opens"`               // Times the breaker has opened
+	Rejected int64      `json:"rejected"`            // Calls rejected while open
+	OpenedAt *time.Time `json:"opened_at,omitempty"` // Set while open or half-open
+}
+
+// CircuitBreaker stops calling a failing dependency so requests fail (or fall back)
+// immediately instead of waiting on timeouts, and gives it time to recover.
+//
+// Closed, it counts calls in fixed windows and opens once at least MinRequests calls
+// in a window fail at FailureRate or more. Open, it rejects calls for Cooldown, then
+// turns half-open and admits up to Probes concurrent calls: Probes successes in a row
+// close it, any failure reopens it.
+//
+// Design Notes:
+//   - Fixed windows instead of a sliding window: one lock-protected counter pair,
+//     and a dependency that is failing keeps failing across the boundary anyway.
+//   - A nil *CircuitBreaker allows everything, so services built without breakers
+//     (e.g. in tests) need no special casing.
+//   - Every Allow that returns true must be followed by exactly one Record, or by
+//     Release if the call was not made after all. Batches admitted by one Allow
+//     record one outcome (see recordL2Batch).
+type CircuitBreaker struct {
+	config BreakerConfig
+
+	mu          sync.Mutex
+	state       BreakerState
+	windowStart time.Time
+	requests    int64
+	failures    int64
+	openedAt    time.Time
+	probing     int // Probe calls in flight while half-open
+	probeOK     int // Consecutive successful probes while half-open
+	opens       int64
+	rejected    int64
+}
+
+// NewCircuitBreaker creates a closed breaker.
+func NewCircuitBreaker(config BreakerConfig) *CircuitBreaker {
+	return &CircuitBreaker{config: config.withDefaults()}
+}
+
+// Allow reports whether a call may proceed now.
+// Complexity: O(1).
+func (b *CircuitBreaker) Allow() bool {
+	if b == nil || b.config.Disabled {
+		return true
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := time.Now()
+	switch b.state {
+	case BreakerOpen:
+		if now.Sub(b.openedAt) < b.config.Cooldown {
+			b.rejected++
+			return false
+		}
+		b.state = BreakerHalfOpen
+		b.probing, b.probeOK = 0, 0
+		fallthrough
+	case BreakerHalfOpen:
+		if b.probing >= b.config.Probes {
+			b.rejected++
+			return false
+		}
+		b.probing++
+		return true
+	default:
+		return true
+	}
+}
+
+// Release returns a call admitted by Allow that was not made, freeing its probe slot
+// without counting an outcome.
+func (b *CircuitBreaker) Release() {
+	if b == nil || b.config.Disabled {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state == BreakerHalfOpen && b.probing > 0 {
+		b.probing--
+	}
+}
+
+// Record reports the outcome of a call.
+func (b *CircuitBreaker) Record(success bool) {
+	if b == nil || b.config.Disabled {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := time.Now()
+	switch b.state {
+	case BreakerOpen:
+		// Outcome of a call admitted before the breaker opened.
+		return
+	case BreakerHalfOpen:
+		if b.probing > 0 {
+			b.probing--
+		}
+		if !success {
+			b.openUnsafe(now)
+			return
+		}
+		b.probeOK++
+		if b.probeOK >= b.config.Probes {
+			b.state = BreakerClosed
+			b.windowStart = now
+			b.requests, b.failures = 0, 0
+		}
+		return
+	}
+
+	if now.Sub(b.windowStart) >= b.config.Window {
+		b.windowStart = now
+		b.requests, b.failures = 0, 0
+	}
+	b.requests++
+	if !success {
+		b.failures++
+	}
+	if b.requests >= int64(b.config.MinRequests) &&
+		float64(b.failures)/float64(b.requests) >= b.config.FailureRate {
+		b.openUnsafe(now)
+	}
+}
+
+func (b *CircuitBreaker) openUnsafe(now time.Time) {
+	b.state = BreakerOpen
+	b.openedAt = now
+	b.opens++
+}
+
+// State returns the current state. An open breaker whose cooldown has passed is
+// reported as half-open, since the next call will probe.
+func (b *CircuitBreaker) State() BreakerState {
+	if b == nil {
+		return BreakerClosed
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.stateUnsafe(time.Now())
+}
+
+func (b *CircuitBreaker) stateUnsafe(now time.Time) BreakerState {
+	if b.state == BreakerOpen && now.Sub(b.openedAt) >= b.config.Cooldown {
+		return BreakerHalfOpen
+	}
+	return b.state
+}
+
+// Status returns a snapshot for metrics.
+func (b *CircuitBreaker) Status() BreakerStatus {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	state := b.stateUnsafe(time.Now())
+	status := BreakerStatus{
+		State:    state.String(),
+		Requests: b.requests,
+		Failures: b.failures,
+		Opens:    b.opens,
+		Rejected: b.rejected,
+	}
+	if state != BreakerClosed {
+		openedAt := b.openedAt
+		status.OpenedAt = &openedAt
+	}
+	return status
+}
+
+// l2Allowed reports whether L2 may be called now: L2 is configured and its breaker
+// is not open. A true result must be followed by recordL2.
+func (s *Service) l2Allowed() bool {
+	return s.config.L2Enabled && s.l2Cache != nil && s.l2Breaker.Allow()
+}
+
+// recordL2 reports an L2 call's outcome to the breaker, counting errors.
+func (s *Service) recordL2(err error) {
+	if err != nil {
+		s.metrics.L2Errors.Add(1)
+	}
+	s.l2Breaker.Record(err == nil)
+}
+
+// deleteL2 runs an L2 delete even while the breaker is open: skipping it would let
+// instances refill L1 with the value just invalidated. Only a delete the breaker
+// admitted is recorded, so one made while open is not mistaken for a probe.
+func (s *Service) deleteL2(del func() error) {
+	if !s.config.L2Enabled || s.l2Cache == nil {
+		return
+	}
+	admitted := s.l2Breaker.Allow()
+	err := del()
+	switch {
+	case admitted:
+		s.recordL2(err)
+	case err != nil:
+		s.metrics.L2Errors.Add(1)
+	}
+}
+
+// recordL2Batch reports the calls of a batch admitted by one Allow as a single
+// outcome, failed if any call failed. Errors are still counted per call.
+func (s *Service) recordL2Batch(failures int) {
+	s.metrics.L2Errors.Add(int64(failures))
+	s.l2Breaker.Record(failures == 0)
+}
+
+// recordOrigin reports an origin call's outcome. Not-found is a valid answer, not a
+// failure of the origin.
+func (s *Service) recordOrigin(err error) {
+	s.originBreaker.Record(err == nil || errors.Is(err, ErrNotFound))
+}
+
+// breakerStatuses reports the breakers for MetricsResponse, or nil without breakers.
+func (s *Service) breakerStatuses() map[string]BreakerStatus {
+	if s.originBreaker == nil && s.l2Breaker == nil {
+		return nil
+	}
+	statuses := make(map[string]BreakerStatus, 2)
+	if s.originBreaker != nil {
+		statuses["origin"] = s.originBreaker.Status()
+	}
+	if s.l2Breaker != nil {
+		statuses["l2"] = s.l2Breaker.Status()
+	}
+	return statuses
+}
@@ -1,8 +1,10 @@
 package cachemanager
 
 import (
-	"container/list"
+	"container/heap"
 	"encoding/json"
+	"math"
+	"sort"
 	"strings"
 	"sync"
 	"time"
@@ -12,136 +14,664 @@ type CacheEntry struct {
 	Value     json.RawMessage `json:"value"`
 	CachedAt  time.Time       `json:"cached_at"`
 	ExpiresAt time.Time       `json:"expires_at"`
-	Source    string          `json:"source"` // "l1", "l2", "origin"
+	Source    string          `json:"source"`              // "l1", "l2", "origin"
+	StaleTTL  time.Duration   `json:"stale_ttl,omitempty"` // How long the value may be served after ExpiresAt while refreshing
+	Delta     time.Duration   `json:"delta,omitempty"`     // Time the origin took to compute the value (XFetch)
+	Tombstone bool            `json:"tombstone,omitempty"` // Origin reported the key as not found (negative cache)
+	Version   uint64          `json:"version,omitempty"`   // Increases on every write of the key (see nextVersion)
+	Tags      []string        `json:"tags,omitempty"`      // Surrogate keys the entry can be invalidated by
+	BaseTTL   time.Duration   `json:"base_ttl,omitempty"`  // TTL the entry was written with; Touch and sliding hits restore it
+	Sliding   bool            `json:"sliding,omitempty"`   // Every fresh hit restarts BaseTTL
+
+	// OriginError is a cached origin failure message. Only held in L1, never written to L2.
+	OriginError string `json:"-"`
+
+	// renewL2 is set on an L1 hit that slid the expiry far enough past the one last
+	// written to L2 that the L2 copy should be rewritten.
+	renewL2 bool
+	// hits counts L1 read hits of this value, including the one returning it.
+	hits int64
 }
 
-type lruEntry struct {
-	key       string
-	value     json.RawMessage
-	expiresAt time.Time
-	element   *list.Element // pointer to list element for O(1) removal
+// NoExpiry is the TTL of an entry that never expires (see Persist). Entries are still
+// subject to capacity eviction.
+const NoExpiry time.Duration = math.MaxInt64
+
+// noExpiry is the ExpiresAt of a persistent entry: never reached, and far enough out
+// that time.Time.Sub from any realistic now saturates to NoExpiry.
+var noExpiry = time.Date(9999, 12, 31, 0, 0, 0, 0, time.UTC)
+
+// persistent reports whether expiresAt marks an entry that never expires.
+func persistent(expiresAt time.Time) bool {
+	return !expiresAt.Before(noExpiry)
+}
+
+// l2TTL is the L2 expiry for an entry expiring at expiresAt, 0 (none) if persistent.
+func l2TTL(expiresAt, now time.Time) time.Duration {
+	if persistent(expiresAt) {
+		return 0
+	}
+	return expiresAt.Sub(now)
+}
+
+// EntryOptions carries optional per-entry metadata for L1Cache.SetWithOptions.
+type EntryOptions struct {
+	StaleTTL time.Duration // Keep serving the entry through GetStale this long after expiry
+	Delta    time.Duration // Recompute time recorded for probabilistic early refresh
+	Version  uint64        // Version to store, e.g. one read from L2 (0 = next version for the key)
+	Tags     []string      // Surrogate keys indexed for DeleteTag
+	Source   string        // Where the value came from, reported by ScanKeys (e.g. "origin", "set")
+	BaseTTL  time.Duration // TTL restored by Touch and sliding hits (0 = the ttl written)
+	Sliding  bool          // Restart BaseTTL on every fresh hit
+
+	Tombstone   bool   // Entry records an origin not-found (value is empty)
+	OriginError string // Entry records an origin failure with this message (value is empty)
+}
+
+// EvictionReason says why an entry left L1.
+type EvictionReason string
+
+const (
+	EvictionCapacity    EvictionReason = "capacity"    // Chosen by the eviction policy to stay within budget
+	EvictionExpired     EvictionReason = "expired"     // TTL and stale window passed
+	EvictionInvalidated EvictionReason = "invalidated" // Deleted by key, pattern, tag or namespace flush
+	EvictionReplaced    EvictionReason = "replaced"    // Overwritten by a new value for the key
+)
+
+// Eviction describes an entry removed from L1.
+type Eviction struct {
+	Key    string
+	Reason EvictionReason
+	Age    time.Duration // Time since the removed value was written
+}
+
+// EvictionListener is told about every entry removed from an L1Cache. It is called
+// with the entry's shard locked, so it must return quickly and must not call back
+// into the cache; hand the eviction off (e.g. to a buffer) for anything slower.
+type EvictionListener func(Eviction)
+
+type l1Entry struct {
+	key        string
+	value      json.RawMessage
+	expiresAt  time.Time
+	staleUntil time.Time     // expiresAt + stale window; entry is retained until then
+	delta      time.Duration // origin recompute time, 0 if unknown
+	size       int64         // len(key) + len(value), charged against the byte budget
+	version    uint64
+	tags       []string
+	source     string    // EntryOptions.Source
+	accessedAt time.Time // last read hit, zero if never read
+	hits       int64     // read hits since the value was written
+
+	baseTTL  time.Duration // TTL restored by touch and sliding hits, 0 if persistent
+	sliding  bool
+	l2Expiry time.Time // expiry this cache last wrote or read for the key's L2 copy
+	storedAt time.Time // when the current value was written
+
+	heapIndex int // position in the shard's expiry heap, -1 if it never expires
+
+	tombstone   bool   // negative cache entry for an origin not-found
+	originError string // negative cache entry for an origin failure
+}
+
+// WriteCondition guards a conditional write. The zero value always matches.
+type WriteCondition struct {
+	IfAbsent  bool   // Write only if the key holds no value (tombstones count as absent)
+	IfVersion uint64 // Write only if the key's current version equals this (0 = any)
+}
+
+// IsZero reports whether the condition matches unconditionally.
+func (c WriteCondition) IsZero() bool {
+	return !c.IfAbsent && c.IfVersion == 0
+}
+
+// Matches evaluates the condition against the key's current state. present is false
+// when the key is missing, expired past its stale window, or a negative cache entry.
+func (c WriteCondition) Matches(version uint64, present bool) bool {
+	if c.IfAbsent && present {
+		return false
+	}
+	if c.IfVersion != 0 && (!present || version != c.IfVersion) {
+		return false
+	}
+	return true
+}
+
+// nextVersion returns a version greater than prev. Versions are wall-clock
+// microseconds bumped past prev, so they increase per key even when writes come from
+// instances that never saw each other's L1 entries, and stay within the 2^53 range
+// that JSON clients can represent exactly.
+func nextVersion(prev uint64) uint64 {
+	v := uint64(time.Now().UnixMicro())
+	if v <= prev {
+		v = prev + 1
+	}
+	return v
+}
+
+// DefaultL1Shards is the shard count used when Config.L1Shards is unset.
+const DefaultL1Shards = 16
+
+// L1Options configures an L1Cache.
+type L1Options struct {
+	MaxEntries int           // Maximum entries across all shards
+	MaxBytes   int64         // Maximum key+value bytes across all shards (0 = unlimited)
+	Shards     int           // Number of shards, rounded up to a power of two (0 = 1)
+	Policy     PolicyFactory // Replacement policy per shard (nil = LRU)
 }
 
-// L1Cache implements a thread-safe in-memory cache with LRU eviction and TTL expiration.
+// L1Cache implements a thread-safe in-memory cache with pluggable capacity eviction
+// (LRU by default, W-TinyLFU optional) and TTL expiration.
+// Keys are distributed across N independently locked shards by FNV-1a hash, so
+// concurrent operations on different keys rarely contend on the same lock.
 // Trade-offs:
-// - RWMutex chosen over sync.Map for better control over eviction and TTL.
-// - sync.Map lacks ordered iteration needed for LRU, and atomic eviction is complex.
-// - Global lock on write is acceptable for <100K ops/sec; shard for higher loads.
+//   - RWMutex chosen over sync.Map for better control over eviction and TTL.
+//   - sync.Map lacks ordered iteration needed for LRU, and atomic eviction is complex.
+//   - Each shard owns its own EvictionPolicy instance, called under the shard lock, so
+//     policies need no internal synchronization. Victim selection is per shard, which
+//     approximates a global policy once shards hold more than a few entries.
+//   - TTL expiry is enforced by the cache itself regardless of policy.
+//   - Capacity (entries and bytes) is split evenly across shards (rounded up), so
+//     totals may slightly exceed the configured limits.
+//   - The byte budget counts key and value bytes only, not map/list overhead
+//     (~150 bytes per entry), so size it with some headroom.
 type L1Cache struct {
+	shards     []*l1Shard
+	mask       uint32
+	maxEntries int
+	maxBytes   int64
+}
+
+// l1Shard is a single segment of L1Cache guarded by its own lock.
+type l1Shard struct {
 	mu         sync.RWMutex
-	cache      map[string]*lruEntry
-	lruList    *list.List
+	cache      map[string]*l1Entry
+	policy     EvictionPolicy
+	newPolicy  PolicyFactory
 	maxEntries int
+	maxBytes   int64 // 0 = unlimited
+	bytes      int64 // current key+value bytes held by the shard
+
+	// tags indexes tag -> keys for the entries in this shard. Kept per shard so the
+	// index is updated under the same lock as the entries it points to.
+	tags map[string]map[string]struct{}
+
+	expiry      expiryHeap // Expiring entries by staleUntil, so cleanup visits only due ones
+	evictions   int64      // Entries removed for capacity
+	expirations int64      // Entries removed past their stale window
+
+	onEvict EvictionListener // nil = no listener
 }
 
-// NewL1Cache creates a new L1 cache with specified capacity.
+// NewL1Cache creates a new single-shard LRU cache with specified capacity.
+// A single shard keeps strict global LRU ordering; use NewShardedL1Cache for
+// high-concurrency workloads.
 func NewL1Cache(maxEntries int) *L1Cache {
-	return &L1Cache{
-		cache:      make(map[string]*lruEntry, maxEntries),
-		lruList:    list.New(),
+	return NewL1CacheWithOptions(L1Options{MaxEntries: maxEntries, Shards: 1})
+}
+
+// NewShardedL1Cache creates an LRU cache split into shardCount independently locked
+// segments. shardCount is rounded up to a power of two and capped so that every
+// shard holds at least one entry.
+func NewShardedL1Cache(maxEntries, shardCount int) *L1Cache {
+	return NewL1CacheWithOptions(L1Options{MaxEntries: maxEntries, Shards: shardCount})
+}
+
+// NewL1CacheWithBudget creates a sharded LRU cache bounded by both entry count and
+// total key+value bytes. maxBytes <= 0 disables the byte budget.
+func NewL1CacheWithBudget(maxEntries int, maxBytes int64, shardCount int) *L1Cache {
+	return NewL1CacheWithOptions(L1Options{MaxEntries: maxEntries, MaxBytes: maxBytes, Shards: shardCount})
+}
+
+// NewL1CacheWithOptions creates an L1 cache from opts.
+func NewL1CacheWithOptions(opts L1Options) *L1Cache {
+	maxEntries := opts.MaxEntries
+	maxBytes := opts.MaxBytes
+	if maxBytes < 0 {
+		maxBytes = 0
+	}
+	newPolicy := opts.Policy
+	if newPolicy == nil {
+		newPolicy = NewLRUPolicyFactory()
+	}
+
+	shardCount := opts.Shards
+	if shardCount <= 0 {
+		shardCount = 1
+	}
+	if maxEntries > 0 && shardCount > maxEntries {
+		shardCount = maxEntries
+	}
+
+	n := 1
+	for n < shardCount {
+		n <<= 1
+	}
+
+	perShard := (maxEntries + n - 1) / n
+	perShardBytes := (maxBytes + int64(n) - 1) / int64(n)
+
+	c := &L1Cache{
+		shards:     make([]*l1Shard, n),
+		mask:       uint32(n - 1),
+		maxEntries: maxEntries,
+		maxBytes:   maxBytes,
+	}
+	for i := range c.shards {
+		c.shards[i] = newL1Shard(perShard, perShardBytes, newPolicy)
+	}
+	return c
+}
+
+func newL1Shard(maxEntries int, maxBytes int64, newPolicy PolicyFactory) *l1Shard {
+	return &l1Shard{
+		cache:      make(map[string]*l1Entry, maxEntries),
+		tags:       make(map[string]map[string]struct{}),
+		policy:     newPolicy(maxEntries),
+		newPolicy:  newPolicy,
 		maxEntries: maxEntries,
+		maxBytes:   maxBytes,
+	}
+}
+
+// entrySize is the number of bytes an entry charges against the byte budget.
+func entrySize(key string, value json.RawMessage) int64 {
+	return int64(len(key) + len(value))
+}
+
+// shardFor selects the shard owning key using an inlined FNV-1a hash
+// (avoids the allocation of hash/fnv on the hot path).
+func (c *L1Cache) shardFor(key string) *l1Shard {
+	if len(c.shards) == 1 {
+		return c.shards[0]
+	}
+	h := uint32(2166136261)
+	for i := 0; i < len(key); i++ {
+		h ^= uint32(key[i])
+		h *= 16777619
 	}
+	return c.shards[h&c.mask]
+}
+
+// ShardCount returns the number of shards backing the cache.
+func (c *L1Cache) ShardCount() int {
+	return len(c.shards)
 }
 
-// Get retrieves a value from L1 cache and updates LRU ordering.
+// Get retrieves a value from L1 cache and records the access with the eviction policy.
 // Returns (entry, true) if found and not expired, (nil, false) otherwise.
 // Complexity: O(1) average.
 func (c *L1Cache) Get(key string) (*CacheEntry, bool) {
-	c.mu.RLock()
-	entry, exists := c.cache[key]
-	c.mu.RUnlock()
+	entry, _, ok := c.shardFor(key).get(key, false)
+	return entry, ok
+}
 
+// GetStale is like Get but also returns entries that have expired and are still
+// inside their stale window, reporting stale=true for those. Callers serving a stale
+// value are expected to trigger a refresh.
+// Complexity: O(1) average.
+func (c *L1Cache) GetStale(key string) (entry *CacheEntry, stale bool, ok bool) {
+	return c.shardFor(key).get(key, true)
+}
+
+// SourceOf returns the EntryOptions.Source of key's live entry without recording an
+// access. ok is false if the key is missing or expired.
+// Complexity: O(1) average.
+func (c *L1Cache) SourceOf(key string) (source string, ok bool) {
+	s := c.shardFor(key)
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	entry, exists := s.cache[key]
+	if !exists || time.Now().After(entry.expiresAt) {
+		return "", false
+	}
+	return entry.source, true
+}
+
+func (s *l1Shard) get(key string, allowStale bool) (*CacheEntry, bool, bool) {
+	// Write lock: a hit updates the eviction policy's ordering.
+	s.mu.Lock()
+	entry, exists := s.cache[key]
 	if !exists {
-		return nil, false
+		s.mu.Unlock()
+		return nil, false, false
 	}
 
-	// Check expiration (lazy)
-	if time.Now().After(entry.expiresAt) {
-		c.mu.Lock()
-		c.deleteUnsafe(key)
-		c.mu.Unlock()
-		return nil, false
+	// Check expiration (lazy); entries in their stale window are kept for GetStale.
+	now := time.Now()
+	if now.After(entry.staleUntil) {
+		s.deleteUnsafe(key, EvictionExpired)
+		s.expirations++
+		s.mu.Unlock()
+		return nil, false, false
 	}
 
-	c.mu.Lock()
-	c.lruList.MoveToFront(entry.element)
-	c.mu.Unlock()
+	stale := now.After(entry.expiresAt)
+	if stale && !allowStale {
+		s.mu.Unlock()
+		return nil, false, false
+	}
 
+	s.policy.OnAccess(key)
+	entry.accessedAt = now
+	entry.hits++
+	renewL2 := false
+	if entry.sliding && !stale {
+		renewL2 = entry.slide(now)
+		s.scheduleUnsafe(entry)
+	}
+	result := entry.cacheEntry()
+	result.renewL2 = renewL2
+	s.mu.Unlock()
+
+	return result, stale, true
+}
+
+// cacheEntry copies the entry for a caller outside the shard lock. Must be called
+// with the lock held.
+func (e *l1Entry) cacheEntry() *CacheEntry {
 	return &CacheEntry{
-		Value:     entry.value,
-		CachedAt:  entry.expiresAt.Add(-1 * time.Hour), // approximate
-		ExpiresAt: entry.expiresAt,
+		Value:     e.value,
+		CachedAt:  e.expiresAt.Add(-1 * time.Hour), // approximate
+		ExpiresAt: e.expiresAt,
 		Source:    "l1",
-	}, true
+		StaleTTL:  e.staleUntil.Sub(e.expiresAt),
+		Delta:     e.delta,
+		Tombstone: e.tombstone,
+		Version:   e.version,
+		Tags:      e.tags,
+		BaseTTL:   e.baseTTL,
+		Sliding:   e.sliding,
+
+		OriginError: e.originError,
+		hits:        e.hits,
+	}
+}
+
+// setExpiry moves the entry's expiry, keeping the length of its stale window. The
+// caller must then reschedule it with scheduleUnsafe.
+func (e *l1Entry) setExpiry(expiresAt time.Time) {
+	window := e.staleUntil.Sub(e.expiresAt)
+	e.expiresAt = expiresAt
+	e.staleUntil = expiresAt.Add(window)
 }
 
-// Set stores a value in L1 cache with TTL, evicting LRU entry if at capacity.
-// Complexity: O(1).
+// slide restarts a sliding entry's TTL at now. Reports whether the L2 copy is due
+// for a rewrite, i.e. its expiry is less than half a TTL away, in which case the
+// caller is expected to rewrite it. Rewriting at most once per half TTL keeps L2
+// traffic independent of the read rate.
+func (e *l1Entry) slide(now time.Time) bool {
+	e.setExpiry(now.Add(e.baseTTL))
+	if e.l2Expiry.Sub(now) >= e.baseTTL/2 {
+		return false
+	}
+	e.l2Expiry = e.expiresAt
+	return true
+}
+
+// Set stores a value in L1 cache with TTL, then evicts policy-selected victims until
+// both the entry and byte budgets fit. Admission policies such as W-TinyLFU may pick
+// the new key itself as the victim, in which case the write is dropped from L1.
+// A value larger than a shard's whole byte budget is never cached (it would otherwise
+// flush the shard) and any previous value for the key is dropped.
+// Complexity: O(1) amortized.
 func (c *L1Cache) Set(key string, value json.RawMessage, ttl time.Duration) {
-	c.mu.Lock()
-	defer c.mu.Unlock()
+	c.shardFor(key).set(key, value, ttl, EntryOptions{})
+}
 
-	expiresAt := time.Now().Add(ttl)
+// SetWithOptions is like Set but records per-entry metadata. A positive StaleTTL keeps
+// the entry past its expiry so it can still be served through GetStale while a
+// refresh is in flight. Returns the version stored with the entry.
+// Complexity: O(1) amortized.
+func (c *L1Cache) SetWithOptions(key string, value json.RawMessage, ttl time.Duration, opts EntryOptions) uint64 {
+	return c.shardFor(key).set(key, value, ttl, opts)
+}
 
-	if entry, exists := c.cache[key]; exists {
+// SetIf is SetWithOptions guarded by cond, which is evaluated against the current
+// entry under the same lock as the write, so concurrent conditional writers on this
+// instance cannot both succeed. Returns the stored version, or ErrVersionConflict.
+// Complexity: O(1) amortized.
+func (c *L1Cache) SetIf(key string, value json.RawMessage, ttl time.Duration, opts EntryOptions, cond WriteCondition) (uint64, error) {
+	s := c.shardFor(key)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !cond.IsZero() {
+		var version uint64
+		present := false
+		if entry, ok := s.cache[key]; ok && !time.Now().After(entry.staleUntil) {
+			version = entry.version
+			present = !entry.tombstone && entry.originError == ""
+		}
+		if !cond.Matches(version, present) {
+			return 0, ErrVersionConflict
+		}
+	}
+	return s.setUnsafe(key, value, ttl, opts), nil
+}
+
+func (s *l1Shard) set(key string, value json.RawMessage, ttl time.Duration, opts EntryOptions) uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.setUnsafe(key, value, ttl, opts)
+}
+
+// setUnsafe stores the entry and returns its version. Must be called with write lock held.
+func (s *l1Shard) setUnsafe(key string, value json.RawMessage, ttl time.Duration, opts EntryOptions) uint64 {
+	staleTTL := opts.StaleTTL
+	if staleTTL < 0 {
+		staleTTL = 0
+	}
+	expiresAt := noExpiry
+	if ttl != NoExpiry {
+		expiresAt = time.Now().Add(ttl)
+	}
+	staleUntil := expiresAt.Add(staleTTL)
+	size := entrySize(key, value)
+	baseTTL := opts.BaseTTL
+	if baseTTL == 0 && ttl != NoExpiry {
+		baseTTL = ttl
+	}
+	sliding := opts.Sliding && ttl != NoExpiry && baseTTL > 0
+
+	version := opts.Version
+	if version == 0 {
+		var prev uint64
+		if entry, exists := s.cache[key]; exists {
+			prev = entry.version
+		}
+		version = nextVersion(prev)
+	}
+
+	if s.maxBytes > 0 && size > s.maxBytes {
+		s.deleteUnsafe(key, EvictionReplaced)
+		return version
+	}
+
+	now := time.Now()
+	if entry, exists := s.cache[key]; exists {
+		reason := EvictionReplaced
+		if now.After(entry.staleUntil) {
+			reason = EvictionExpired
+		}
+		s.notifyUnsafe(entry, reason, now)
+		s.bytes += size - entry.size
+		s.untagUnsafe(key, entry.tags)
+		entry.tags = opts.Tags
 		entry.value = value
+		entry.size = size
 		entry.expiresAt = expiresAt
-		c.lruList.MoveToFront(entry.element)
-		return
+		entry.staleUntil = staleUntil
+		entry.delta = opts.Delta
+		entry.version = version
+		entry.source = opts.Source
+		entry.baseTTL = baseTTL
+		entry.sliding = sliding
+		entry.l2Expiry = expiresAt
+		entry.storedAt = now
+		entry.hits = 0
+		entry.tombstone = opts.Tombstone
+		entry.originError = opts.OriginError
+		s.scheduleUnsafe(entry)
+	} else {
+		entry := &l1Entry{
+			key:        key,
+			value:      value,
+			expiresAt:  expiresAt,
+			staleUntil: staleUntil,
+			delta:      opts.Delta,
+			size:       size,
+			version:    version,
+			tags:       opts.Tags,
+			source:     opts.Source,
+			baseTTL:    baseTTL,
+			sliding:    sliding,
+			l2Expiry:   expiresAt,
+			storedAt:   now,
+
+			tombstone:   opts.Tombstone,
+			originError: opts.OriginError,
+			heapIndex:   -1,
+		}
+		s.cache[key] = entry
+		s.scheduleUnsafe(entry)
+		s.bytes += size
 	}
+	s.tagUnsafe(key, opts.Tags)
+	s.policy.OnSet(key, value, ttl)
 
-	if c.lruList.Len() >= c.maxEntries {
-		c.evictLRUUnsafe()
+	for s.overCapacityUnsafe() {
+		if !s.evictOneUnsafe() {
+			break
+		}
 	}
+	return version
+}
 
-	entry := &lruEntry{
-		key:       key,
-		value:     value,
-		expiresAt: expiresAt,
-	}
-	entry.element = c.lruList.PushFront(entry)
-	c.cache[key] = entry
+// overCapacityUnsafe reports whether the shard exceeds its entry or byte budget.
+func (s *l1Shard) overCapacityUnsafe() bool {
+	return len(s.cache) > s.maxEntries || (s.maxBytes > 0 && s.bytes > s.maxBytes)
 }
 
 // Delete removes a key from L1 cache.
 // Returns true if key existed, false otherwise.
 func (c *L1Cache) Delete(key string) bool {
-	c.mu.Lock()
-	defer c.mu.Unlock()
-	return c.deleteUnsafe(key)
+	s := c.shardFor(key)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.deleteUnsafe(key, EvictionInvalidated)
 }
 
 // deleteUnsafe is the non-locking internal delete implementation.
-func (c *L1Cache) deleteUnsafe(key string) bool {
-	entry, exists := c.cache[key]
+func (s *l1Shard) deleteUnsafe(key string, reason EvictionReason) bool {
+	if !s.removeUnsafe(key, reason) {
+		return false
+	}
+	s.policy.OnRemove(key)
+	return true
+}
+
+// removeUnsafe drops key from the map and byte accounting without notifying the
+// policy, and reports the removal to the eviction listener.
+func (s *l1Shard) removeUnsafe(key string, reason EvictionReason) bool {
+	entry, exists := s.cache[key]
 	if !exists {
 		return false
 	}
 
-	c.lruList.Remove(entry.element)
-	delete(c.cache, key)
+	s.notifyUnsafe(entry, reason, time.Now())
+	delete(s.cache, key)
+	s.bytes -= entry.size
+	s.untagUnsafe(key, entry.tags)
+	if entry.heapIndex >= 0 {
+		heap.Remove(&s.expiry, entry.heapIndex)
+	}
 	return true
 }
 
+// tagUnsafe adds key to the index of each tag. Must be called with write lock held.
+func (s *l1Shard) tagUnsafe(key string, tags []string) {
+	for _, tag := range tags {
+		keys, ok := s.tags[tag]
+		if !ok {
+			keys = make(map[string]struct{})
+			s.tags[tag] = keys
+		}
+		keys[key] = struct{}{}
+	}
+}
+
+// untagUnsafe removes key from the index of each tag, dropping tags left empty.
+// Must be called with write lock held.
+func (s *l1Shard) untagUnsafe(key string, tags []string) {
+	for _, tag := range tags {
+		keys := s.tags[tag]
+		delete(keys, key)
+		if len(keys) == 0 {
+			delete(s.tags, tag)
+		}
+	}
+}
+
+// DeleteTag removes every entry carrying tag. Shards are locked one at a time.
+// Returns number of keys deleted.
+// Complexity: O(m) for m tagged entries, independent of cache size.
+func (c *L1Cache) DeleteTag(tag string) int {
+	count := 0
+	for _, s := range c.shards {
+		count += s.deleteTag(tag)
+	}
+	return count
+}
+
+func (s *l1Shard) deleteTag(tag string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	keys := s.tags[tag]
+	toDelete := make([]string, 0, len(keys))
+	for key := range keys {
+		toDelete = append(toDelete, key)
+	}
+
+	count := 0
+	for _, key := range toDelete {
+		if s.deleteUnsafe(key, EvictionInvalidated) {
+			count++
+		}
+	}
+	return count
+}
+
 // DeletePattern removes all keys matching a pattern (e.g., "user:*").
+// Shards are locked one at a time, so concurrent operations on other shards proceed.
 // Returns number of keys deleted.
 func (c *L1Cache) DeletePattern(pattern string) int {
-	c.mu.Lock()
-	defer c.mu.Unlock()
+	prefix := strings.TrimSuffix(pattern, "*")
+
+	count := 0
+	for _, s := range c.shards {
+		count += s.deletePattern(pattern, prefix)
+	}
+	return count
+}
+
+func (s *l1Shard) deletePattern(pattern, prefix string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 
 	count := 0
-	prefix := strings.TrimSuffix(pattern, "*")
 
 	// Collect matching keys first to avoid modification during iteration
 	var toDelete []string
-	for key := range c.cache {
+	for key := range s.cache {
 		if matchesPattern(key, pattern, prefix) {
 			toDelete = append(toDelete, key)
 		}
 	}
 
 	for _, key := range toDelete {
-		if c.deleteUnsafe(key) {
+		if s.deleteUnsafe(key, EvictionInvalidated) {
 			count++
 		}
 	}
@@ -157,57 +687,292 @@ func matchesPattern(key, pattern, prefix string) bool {
 	return key == pattern
 }
 
-// CleanupExpired removes all expired entries.
-// Returns number of entries removed.
-func (c *L1Cache) CleanupExpired() int {
-	c.mu.Lock()
-	defer c.mu.Unlock()
+// KeyInfo describes an L1 entry without its value, as returned by ScanKeys.
+type KeyInfo struct {
+	Key        string     `json:"key"`
+	TTL        int        `json:"ttl"` // Seconds until expiry, rounded up (0 once stale, -1 if it never expires)
+	ExpiresAt  time.Time  `json:"expires_at"`
+	Stale      bool       `json:"stale,omitempty"` // Past expiry, kept only for stale serving
+	Size       int64      `json:"size"`            // Key+value bytes charged against the byte budget
+	Source     string     `json:"source"`          // "origin", "l2", "set", "incr", "refresh", "peer", "snapshot"
+	Version    uint64     `json:"version,omitempty"`
+	Tombstone  bool       `json:"tombstone,omitempty"`
+	Sliding    bool       `json:"sliding,omitempty"`     // Each read restarts the TTL
+	Error      string     `json:"error,omitempty"`       // Cached origin failure
+	LastAccess *time.Time `json:"last_access,omitempty"` // Last read hit; unset if never read
+	Hits       int64      `json:"hits,omitempty"`        // Read hits since the value was written
+}
 
+// ScanKeys returns, in key order, up to limit entries whose keys sort after after and
+// satisfy match (nil matches every key). Entries past their stale window are skipped.
+// Reading neither counts as an access nor changes eviction order.
+//
+// Design Notes:
+//   - The position is the last key returned rather than an offset or a map position,
+//     so paging resumes correctly however the cache changes in between: every key
+//     present for the whole scan is returned exactly once, and keys added or removed
+//     meanwhile may or may not be.
+//   - Shards are read-locked one at a time, so a page is not a point-in-time view.
+//
+// Complexity: O(n log limit) per call for n entries.
+func (c *L1Cache) ScanKeys(after string, limit int, match func(key string) bool) []KeyInfo {
+	if limit <= 0 {
+		return nil
+	}
 	now := time.Now()
-	count := 0
+	var page []KeyInfo
+	// Once the page is full, only keys sorting before its last key can still enter it.
+	before, full := "", false
+	for _, s := range c.shards {
+		page = s.scanKeys(page, after, before, full, match, now)
+		if len(page) > limit {
+			sortKeyInfos(page)
+			page = page[:limit]
+			before, full = page[limit-1].Key, true
+		}
+	}
+	sortKeyInfos(page)
+	return page
+}
 
-	var expired []string
-	for key, entry := range c.cache {
-		if now.After(entry.expiresAt) {
-			expired = append(expired, key)
+// scanKeys appends the live entries with keys in (after, before) that satisfy match,
+// or in (after, ∞) unless bounded.
+func (s *l1Shard) scanKeys(page []KeyInfo, after, before string, bounded bool, match func(string) bool, now time.Time) []KeyInfo {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for key, entry := range s.cache {
+		if key <= after || (bounded && key >= before) || now.After(entry.staleUntil) {
+			continue
+		}
+		if match != nil && !match(key) {
+			continue
+		}
+		info := KeyInfo{
+			Key:       key,
+			ExpiresAt: entry.expiresAt,
+			Stale:     now.After(entry.expiresAt),
+			Size:      entry.size,
+			Source:    entry.source,
+			Version:   entry.version,
+			Tombstone: entry.tombstone,
+			Sliding:   entry.sliding,
+			Error:     entry.originError,
+			Hits:      entry.hits,
 		}
+		if persistent(entry.expiresAt) {
+			info.TTL = -1
+		} else if !info.Stale {
+			info.TTL = int((entry.expiresAt.Sub(now) + time.Second - 1) / time.Second)
+		}
+		if !entry.accessedAt.IsZero() {
+			accessedAt := entry.accessedAt
+			info.LastAccess = &accessedAt
+		}
+		page = append(page, info)
+	}
+	return page
+}
+
+func sortKeyInfos(infos []KeyInfo) {
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Key < infos[j].Key })
+}
+
+// CleanupExpired removes all expired entries whose stale window has also passed.
+// Returns number of entries removed.
+//
+// Design Notes:
+//   - Each shard keeps its expiring entries in a min-heap by staleUntil, updated
+//     whenever an entry's expiry changes, so a pass pops only the entries that are
+//     due instead of scanning the whole map under the write lock.
+//   - Persistent entries are never in the heap.
+//
+// Complexity: O(k log n) for k expired entries out of n.
+func (c *L1Cache) CleanupExpired() int {
+	now := time.Now()
+
+	count := 0
+	for _, s := range c.shards {
+		count += s.cleanupExpired(now)
 	}
+	return count
+}
 
-	for _, key := range expired {
-		if c.deleteUnsafe(key) {
+func (s *l1Shard) cleanupExpired(now time.Time) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	count := 0
+	for len(s.expiry) > 0 && now.After(s.expiry[0].staleUntil) {
+		if s.deleteUnsafe(s.expiry[0].key, EvictionExpired) {
 			count++
 		}
 	}
+	s.expirations += int64(count)
 
 	return count
 }
 
-// evictLRUUnsafe removes the least recently used entry.
+// scheduleUnsafe places entry in the expiry heap after its expiry was set or moved.
 // Must be called with write lock held.
-func (c *L1Cache) evictLRUUnsafe() {
-	if c.lruList.Len() == 0 {
-		return
+// Complexity: O(log n).
+func (s *l1Shard) scheduleUnsafe(entry *l1Entry) {
+	switch {
+	case persistent(entry.expiresAt):
+		if entry.heapIndex >= 0 {
+			heap.Remove(&s.expiry, entry.heapIndex)
+		}
+	case entry.heapIndex >= 0:
+		heap.Fix(&s.expiry, entry.heapIndex)
+	default:
+		heap.Push(&s.expiry, entry)
 	}
+}
+
+// expiryHeap is a min-heap of entries by staleUntil, the time they are removed.
+type expiryHeap []*l1Entry
+
+func (h expiryHeap) Len() int           { return len(h) }
+func (h expiryHeap) Less(i, j int) bool { return h[i].staleUntil.Before(h[j].staleUntil) }
+func (h expiryHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].heapIndex = i
+	h[j].heapIndex = j
+}
 
-	oldest := c.lruList.Back()
-	if oldest != nil {
-		entry := oldest.Value.(*lruEntry)
-		c.lruList.Remove(oldest)
-		delete(c.cache, entry.key)
+func (h *expiryHeap) Push(x interface{}) {
+	e := x.(*l1Entry)
+	e.heapIndex = len(*h)
+	*h = append(*h, e)
+}
+
+func (h *expiryHeap) Pop() interface{} {
+	old := *h
+	e := old[len(old)-1]
+	old[len(old)-1] = nil
+	e.heapIndex = -1
+	*h = old[:len(old)-1]
+	return e
+}
+
+// evictOneUnsafe removes the entry chosen by the shard's eviction policy.
+// Returns false if the policy has no victim to offer.
+// Must be called with write lock held.
+func (s *l1Shard) evictOneUnsafe() bool {
+	victim, ok := s.policy.Victim()
+	if !ok {
+		return false
+	}
+	s.removeUnsafe(victim, EvictionCapacity)
+	s.evictions++
+	return true
+}
+
+// notifyUnsafe reports entry's removal to the eviction listener, if any.
+// Must be called with write lock held.
+func (s *l1Shard) notifyUnsafe(entry *l1Entry, reason EvictionReason, now time.Time) {
+	if s.onEvict != nil {
+		s.onEvict(Eviction{Key: entry.key, Reason: reason, Age: now.Sub(entry.storedAt)})
+	}
+}
+
+// SetEvictionListener installs listener for every entry removed from the cache from
+// now on (nil removes it). See EvictionListener for the constraints it must meet.
+func (c *L1Cache) SetEvictionListener(listener EvictionListener) {
+	for _, s := range c.shards {
+		s.mu.Lock()
+		s.onEvict = listener
+		s.mu.Unlock()
 	}
 }
 
 // Size returns the current number of entries in L1 cache.
 func (c *L1Cache) Size() int {
-	c.mu.RLock()
-	defer c.mu.RUnlock()
-	return len(c.cache)
+	total := 0
+	for _, s := range c.shards {
+		s.mu.RLock()
+		total += len(s.cache)
+		s.mu.RUnlock()
+	}
+	return total
+}
+
+// Evictions returns the number of entries removed to stay within capacity.
+func (c *L1Cache) Evictions() int64 {
+	var total int64
+	for _, s := range c.shards {
+		s.mu.RLock()
+		total += s.evictions
+		s.mu.RUnlock()
+	}
+	return total
+}
+
+// Expirations returns the number of entries removed after their TTL and stale
+// window passed, by CleanupExpired or on access.
+func (c *L1Cache) Expirations() int64 {
+	var total int64
+	for _, s := range c.shards {
+		s.mu.RLock()
+		total += s.expirations
+		s.mu.RUnlock()
+	}
+	return total
 }
 
-// Clear removes all entries from the cache.
+// Bytes returns the total key+value bytes currently held in L1 cache.
+func (c *L1Cache) Bytes() int64 {
+	var total int64
+	for _, s := range c.shards {
+		s.mu.RLock()
+		total += s.bytes
+		s.mu.RUnlock()
+	}
+	return total
+}
+
+// Resize changes the entry and byte budgets, evicting entries immediately if the
+// cache is now over either limit. Shard count is fixed at creation; each shard's
+// policy keeps its state (W-TinyLFU keeps its original segment sizing until Clear).
+// Complexity: O(e) for e evicted entries.
+func (c *L1Cache) Resize(maxEntries int, maxBytes int64) {
+	if maxBytes < 0 {
+		maxBytes = 0
+	}
+	n := len(c.shards)
+	perShard := (maxEntries + n - 1) / n
+	perShardBytes := (maxBytes + int64(n) - 1) / int64(n)
+
+	for _, s := range c.shards {
+		s.mu.Lock()
+		s.maxEntries = perShard
+		s.maxBytes = perShardBytes
+		for s.overCapacityUnsafe() {
+			if !s.evictOneUnsafe() {
+				break
+			}
+		}
+		s.mu.Unlock()
+	}
+	c.maxEntries = maxEntries
+	c.maxBytes = maxBytes
+}
+
+// Clear removes all entries from the cache, reporting them as invalidated.
 func (c *L1Cache) Clear() {
-	c.mu.Lock()
-	defer c.mu.Unlock()
-	c.cache = make(map[string]*lruEntry, c.maxEntries)
-	c.lruList = list.New()
-}
\ No newline at end of file
+	for _, s := range c.shards {
+		s.mu.Lock()
+		if s.onEvict != nil {
+			now := time.Now()
+			for _, entry := range s.cache {
+				s.notifyUnsafe(entry, EvictionInvalidated, now)
+			}
+		}
+		s.cache = make(map[string]*l1Entry, s.maxEntries)
+		s.tags = make(map[string]map[string]struct{})
+		s.expiry = nil
+		s.policy = s.newPolicy(s.maxEntries)
+		s.bytes = 0
+		s.mu.Unlock()
+	}
+}
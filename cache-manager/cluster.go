@@ -0,0 +1,539 @@
+package cachemanager
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"encore.dev/beta/errs"
+
+	"encore.app/pkg/utils"
+)
+
+// ErrPeerUnavailable is returned by a Peer that could not be reached. The caller
+// serves the key locally instead.
+var ErrPeerUnavailable = errors.New("peer unavailable")
+
+// ClusterConfig enables owner routing: the key space is partitioned over the members
+// with utils.HashRing and each instance serves Get/Set only for the keys it owns,
+// forwarding the rest to their owner. Without peers every instance serves every key.
+type ClusterConfig struct {
+	NodeID   string        // This instance's ID; must be one of Peers
+	Peers    []PeerConfig  // Static membership, including this instance
+	Replicas int           // Virtual nodes per unit of weight (0 = utils.DefaultReplicas)
+	Timeout  time.Duration // Per forwarded call, after which the key is served locally (default 500ms)
+}
+
+// PeerConfig is one cluster member.
+type PeerConfig struct {
+	ID     string
+	Addr   string // Base URL of the instance's API, e.g. "http://cache-2:4000"
+	Weight int    // Relative share of the key space (0 = 1)
+}
+
+// ClusterConfigFromEnv reads CACHE_NODE_ID and CACHE_PEERS, a comma-separated list of
+// id=url members (e.g. "cache-1=http://cache-1:4000,cache-2=http://cache-2:4000").
+// Malformed members are skipped.
+func ClusterConfigFromEnv() ClusterConfig {
+	cfg := ClusterConfig{NodeID: os.Getenv("CACHE_NODE_ID")}
+	for _, member := range strings.Split(os.Getenv("CACHE_PEERS"), ",") {
+		id, addr, ok := strings.Cut(strings.TrimSpace(member), "=")
+		if !ok || id == "" || addr == "" {
+			continue
+		}
+		cfg.Peers = append(cfg.Peers, PeerConfig{ID: id, Addr: addr})
+	}
+	return cfg
+}
+
+func (c ClusterConfig) enabled() bool {
+	return len(c.Peers) > 0
+}
+
+func (c ClusterConfig) validate() error {
+	if !c.enabled() {
+		return nil
+	}
+	seen := make(map[string]bool, len(c.Peers))
+	for _, p := range c.Peers {
+		if p.ID == "" {
+			return errors.New("cluster: peer id cannot be empty")
+		}
+		if seen[p.ID] {
+			return fmt.Errorf("cluster: duplicate peer %q", p.ID)
+		}
+		seen[p.ID] = true
+		if p.ID != c.NodeID && p.Addr == "" {
+			return fmt.Errorf("cluster: peer %q has no address", p.ID)
+		}
+	}
+	if !seen[c.NodeID] {
+		return fmt.Errorf("cluster: node id %q is not a peer", c.NodeID)
+	}
+	return nil
+}
+
+// Peer is another cache-manager instance that keys can be forwarded to. Calls are
+// served by the peer itself and never forwarded again.
+type Peer interface {
+	Get(ctx context.Context, key string) (*GetResponse, error)
+	Set(ctx context.Context, key string, req *SetRequest) (*SetResponse, error)
+	Incr(ctx context.Context, key string, req *IncrRequest) (*IncrResponse, error)
+	Expire(ctx context.Context, key string, req *ExpireRequest) (*ExpiryResponse, error)
+	Touch(ctx context.Context, key string) (*ExpiryResponse, error)
+	Persist(ctx context.Context, key string) (*ExpiryResponse, error)
+	MGet(ctx context.Context, req *MGetRequest) (*MGetResponse, error)
+	MSet(ctx context.Context, req *MSetRequest) (*MSetResponse, error)
+}
+
+// ClusterMember is a member as passed to SetCluster.
+type ClusterMember struct {
+	ID     string
+	Weight int  // Relative share of the key space (0 = 1)
+	Peer   Peer // How to reach the member; ignored for this instance
+}
+
+// cluster routes keys to their owning member.
+//
+// Design Notes:
+//   - Static membership: the ring is built once and never rebalanced, so every
+//     instance given the same peer list agrees on every key's owner.
+//   - An unreachable owner is not removed from the ring. The key is served locally
+//     for that call only, so a flapping peer does not reshuffle the key space; the
+//     local copy is read again only while the owner stays unreachable.
+//   - Get, Set, Incr and the expiry updates (Expire, Touch, Persist) are routed; a
+//     counter in particular must be incremented in one place, or instances without
+//     a shared L2 would each count separately. MGet and MSet are split by owner and
+//     each part is forwarded as one batch. Invalidations already reach every
+//     instance via Pub/Sub.
+type cluster struct {
+	self  string
+	ring  *utils.HashRing
+	peers map[string]Peer // Excludes self
+}
+
+// SetCluster enables owner routing across members, which must include self.
+// Members and weights must be identical on every instance.
+func (s *Service) SetCluster(self string, members []ClusterMember, replicas int) error {
+	c := &cluster{
+		self:  self,
+		ring:  utils.NewHashRing(replicas),
+		peers: make(map[string]Peer, len(members)),
+	}
+	for _, m := range members {
+		if err := c.ring.AddNode(m.ID, m.Weight); err != nil {
+			return fmt.Errorf("cluster: %w", err)
+		}
+		if m.ID == self {
+			continue
+		}
+		if m.Peer == nil {
+			return fmt.Errorf("cluster: peer %q has no transport", m.ID)
+		}
+		c.peers[m.ID] = m.Peer
+	}
+	if !containsString(c.ring.Nodes(), self) {
+		return fmt.Errorf("cluster: node id %q is not a member", self)
+	}
+	s.cluster = c
+	return nil
+}
+
+// clusterFromConfig joins the members of config over HTTP.
+func (s *Service) clusterFromConfig(config ClusterConfig) error {
+	if err := config.validate(); err != nil {
+		return err
+	}
+	timeout := config.Timeout
+	if timeout <= 0 {
+		timeout = 500 * time.Millisecond
+	}
+	members := make([]ClusterMember, 0, len(config.Peers))
+	for _, p := range config.Peers {
+		m := ClusterMember{ID: p.ID, Weight: p.Weight}
+		if p.ID != config.NodeID {
+			m.Peer = NewHTTPPeer(p.Addr, timeout)
+		}
+		members = append(members, m)
+	}
+	return s.SetCluster(config.NodeID, members, config.Replicas)
+}
+
+// ownerPeer returns the peer owning key, or nil if key is served locally.
+// Complexity: O(log M) for M virtual nodes.
+func (s *Service) ownerPeer(key string) Peer {
+	_, peer := s.owner(key)
+	return peer
+}
+
+// owner returns the ID and peer of key's owner; peer is nil if key is served locally.
+func (s *Service) owner(key string) (string, Peer) {
+	if s.cluster == nil || key == "" {
+		return "", nil
+	}
+	owner := s.cluster.ring.GetNode(key)
+	if owner == s.cluster.self {
+		return owner, nil
+	}
+	return owner, s.cluster.peers[owner]
+}
+
+// ownerBatch is the part of a batch owned by one peer, as positions in the request.
+type ownerBatch struct {
+	peer    Peer
+	indexes []int
+}
+
+// splitByOwner groups the positions of keys by owning peer. Positions of keys served
+// locally are returned in local, in request order.
+// Complexity: O(k log M) for k keys.
+func (s *Service) splitByOwner(keys []string) (local []int, remote []ownerBatch) {
+	parts := make(map[string]int) // owner -> position in remote
+	for i, key := range keys {
+		owner, peer := s.owner(key)
+		if peer == nil {
+			local = append(local, i)
+			continue
+		}
+		n, ok := parts[owner]
+		if !ok {
+			n = len(remote)
+			parts[owner] = n
+			remote = append(remote, ownerBatch{peer: peer})
+		}
+		remote[n].indexes = append(remote[n].indexes, i)
+	}
+	return local, remote
+}
+
+// forwarded reports whether err came from an owner that answered, as opposed to
+// one that could not be reached, and counts the outcome.
+func (s *Service) forwarded(err error) bool {
+	if errors.Is(err, ErrPeerUnavailable) {
+		s.metrics.ClusterFallbacks.Add(1)
+		return false
+	}
+	s.metrics.ClusterForwards.Add(1)
+	return true
+}
+
+// PeerGet serves key from this instance, for a peer that routed it here.
+//
+//encore:api public method=GET path=/api/cache/peer/entry/:key
+func PeerGet(ctx context.Context, key string) (*GetResponse, error) {
+	if svc == nil {
+		return nil, errors.New("service not initialized")
+	}
+	resp, err := svc.getLocal(ctx, key)
+	if errors.Is(err, ErrCircuitOpen) {
+		return nil, peerCircuitOpen(err)
+	}
+	return resp, err
+}
+
+// peerCircuitOpen reports a breaker open on this instance as ResourceExhausted, not
+// Unavailable: the owner answered, so the calling peer must pass the failure on
+// rather than treat the owner as down and serve its own copy.
+func peerCircuitOpen(err error) error {
+	return errs.WrapCode(err, errs.ResourceExhausted, "circuit open")
+}
+
+// PeerSet writes key on this instance, for a peer that routed it here.
+//
+//encore:api public method=PUT path=/api/cache/peer/entry/:key
+func PeerSet(ctx context.Context, key string, req *SetRequest) (*SetResponse, error) {
+	if svc == nil {
+		return nil, errors.New("service not initialized")
+	}
+	resp, err := svc.setLocal(ctx, key, req)
+	if errors.Is(err, ErrVersionConflict) {
+		return nil, errs.WrapCode(err, errs.Aborted, "version conflict")
+	}
+	if errors.Is(err, ErrCircuitOpen) {
+		return nil, peerCircuitOpen(err)
+	}
+	return resp, err
+}
+
+// PeerIncr increments key on this instance, for a peer that routed it here.
+//
+//encore:api public method=POST path=/api/cache/peer/entry/:key/incr
+func PeerIncr(ctx context.Context, key string, req *IncrRequest) (*IncrResponse, error) {
+	if svc == nil {
+		return nil, errors.New("service not initialized")
+	}
+	resp, err := svc.incrLocal(ctx, key, req)
+	if errors.Is(err, ErrCircuitOpen) {
+		return nil, peerCircuitOpen(err)
+	}
+	return incrResult(resp, err)
+}
+
+// PeerExpire sets key's expiry on this instance, for a peer that routed it here.
+//
+//encore:api public method=POST path=/api/cache/peer/entry/:key/expire
+func PeerExpire(ctx context.Context, key string, req *ExpireRequest) (*ExpiryResponse, error) {
+	if svc == nil {
+		return nil, errors.New("service not initialized")
+	}
+	u, err := req.update()
+	if err != nil {
+		return nil, err
+	}
+	return peerExpiryResult(svc.updateExpiryLocal(ctx, key, u))
+}
+
+// PeerTouch restarts key's TTL on this instance, for a peer that routed it here.
+//
+//encore:api public method=POST path=/api/cache/peer/entry/:key/touch
+func PeerTouch(ctx context.Context, key string) (*ExpiryResponse, error) {
+	if svc == nil {
+		return nil, errors.New("service not initialized")
+	}
+	return peerExpiryResult(svc.updateExpiryLocal(ctx, key, expiryUpdate{touch: true}))
+}
+
+// PeerPersist removes key's expiry on this instance, for a peer that routed it here.
+//
+//encore:api public method=POST path=/api/cache/peer/entry/:key/persist
+func PeerPersist(ctx context.Context, key string) (*ExpiryResponse, error) {
+	if svc == nil {
+		return nil, errors.New("service not initialized")
+	}
+	return peerExpiryResult(svc.updateExpiryLocal(ctx, key, expiryUpdate{ttl: NoExpiry}))
+}
+
+// peerExpiryResult is expiryResult for the peer endpoints.
+func peerExpiryResult(resp *ExpiryResponse, err error) (*ExpiryResponse, error) {
+	if errors.Is(err, ErrCircuitOpen) {
+		return nil, peerCircuitOpen(err)
+	}
+	return expiryResult(resp, err)
+}
+
+// PeerMGet serves a batch from this instance, for a peer that routed it here.
+//
+//encore:api public method=POST path=/api/cache/peer/mget
+func PeerMGet(ctx context.Context, req *MGetRequest) (*MGetResponse, error) {
+	if svc == nil {
+		return nil, errors.New("service not initialized")
+	}
+	return svc.mgetLocal(ctx, req)
+}
+
+// PeerMSet writes a batch on this instance, for a peer that routed it here.
+//
+//encore:api public method=POST path=/api/cache/peer/mset
+func PeerMSet(ctx context.Context, req *MSetRequest) (*MSetResponse, error) {
+	if svc == nil {
+		return nil, errors.New("service not initialized")
+	}
+	return svc.msetLocal(ctx, req)
+}
+
+// HTTPPeer reaches another instance through its peer endpoints.
+type HTTPPeer struct {
+	baseURL string
+	client  *http.Client
+}
+
+// NewHTTPPeer creates a peer for the instance at baseURL.
+func NewHTTPPeer(baseURL string, timeout time.Duration) *HTTPPeer {
+	return &HTTPPeer{
+		baseURL: strings.TrimRight(baseURL, "/"),
+		client:  &http.Client{Timeout: timeout},
+	}
+}
+
+func (p *HTTPPeer) Get(ctx context.Context, key string) (*GetResponse, error) {
+	var resp GetResponse
+	if err := p.do(ctx, http.MethodGet, entryPath(key), nil, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+func (p *HTTPPeer) Set(ctx context.Context, key string, req *SetRequest) (*SetResponse, error) {
+	var resp SetResponse
+	if err := p.do(ctx, http.MethodPut, entryPath(key), req, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+func (p *HTTPPeer) Incr(ctx context.Context, key string, req *IncrRequest) (*IncrResponse, error) {
+	var resp IncrResponse
+	if err := p.do(ctx, http.MethodPost, entryPath(key)+"/incr", req, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+func (p *HTTPPeer) Expire(ctx context.Context, key string, req *ExpireRequest) (*ExpiryResponse, error) {
+	return p.expiry(ctx, key, "/expire", req)
+}
+
+func (p *HTTPPeer) Touch(ctx context.Context, key string) (*ExpiryResponse, error) {
+	return p.expiry(ctx, key, "/touch", nil)
+}
+
+func (p *HTTPPeer) Persist(ctx context.Context, key string) (*ExpiryResponse, error) {
+	return p.expiry(ctx, key, "/persist", nil)
+}
+
+func (p *HTTPPeer) expiry(ctx context.Context, key, op string, req *ExpireRequest) (*ExpiryResponse, error) {
+	var body interface{}
+	if req != nil {
+		body = req
+	}
+	var resp ExpiryResponse
+	if err := p.do(ctx, http.MethodPost, entryPath(key)+op, body, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+func (p *HTTPPeer) MGet(ctx context.Context, req *MGetRequest) (*MGetResponse, error) {
+	var resp MGetResponse
+	if err := p.do(ctx, http.MethodPost, "/api/cache/peer/mget", req, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+func (p *HTTPPeer) MSet(ctx context.Context, req *MSetRequest) (*MSetResponse, error) {
+	var resp MSetResponse
+	if err := p.do(ctx, http.MethodPost, "/api/cache/peer/mset", req, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// entryPath is the peer endpoint path for key.
+func entryPath(key string) string {
+	return "/api/cache/peer/entry/" + url.PathEscape(key)
+}
+
+// peerCodeErrors maps the error codes the peer endpoints answer with back to the
+// errors they wrap, so a forwarded call fails like a local one.
+var peerCodeErrors = map[string]error{
+	errs.NotFound.String():           ErrNotFound,
+	errs.Aborted.String():            ErrVersionConflict,
+	errs.FailedPrecondition.String(): ErrNotCounter,
+	errs.OutOfRange.String():         ErrCounterOverflow,
+	errs.ResourceExhausted.String():  ErrCircuitOpen,
+}
+
+// do calls the peer endpoint at path. Transport failures, timeouts and gateway or
+// unavailability statuses are reported as ErrPeerUnavailable; other API errors are
+// returned as the peer's own answer.
+func (p *HTTPPeer) do(ctx context.Context, method, path string, body, out interface{}) error {
+	var reqBody io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return fmt.Errorf("peer request: %w", err)
+		}
+		reqBody = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, p.baseURL+path, reqBody)
+	if err != nil {
+		return fmt.Errorf("peer request: %w", err)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrPeerUnavailable, err)
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusBadGateway ||
+		resp.StatusCode == http.StatusServiceUnavailable ||
+		resp.StatusCode == http.StatusGatewayTimeout:
+		return fmt.Errorf("%w: %s", ErrPeerUnavailable, resp.Status)
+	case resp.StatusCode >= 300:
+		var apiErr struct {
+			Code    string `json:"code"`
+			Message string `json:"message"`
+		}
+		_ = json.NewDecoder(io.LimitReader(resp.Body, 64<<10)).Decode(&apiErr)
+		if known, ok := peerCodeErrors[apiErr.Code]; ok {
+			return fmt.Errorf("%w: %s", known, apiErr.Message)
+		}
+		if apiErr.Message == "" {
+			apiErr.Message = resp.Status
+		}
+		return fmt.Errorf("peer: %s", apiErr.Message)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("%w: bad response: %v", ErrPeerUnavailable, err)
+	}
+	return nil
+}
+
+// localPeer serves forwarded calls with an in-process Service.
+type localPeer struct {
+	s *Service
+}
+
+// NewLocalPeer wraps s as a Peer, for running several instances in one process.
+func NewLocalPeer(s *Service) Peer {
+	return localPeer{s: s}
+}
+
+func (p localPeer) Get(ctx context.Context, key string) (*GetResponse, error) {
+	return p.s.getLocal(ctx, key)
+}
+
+func (p localPeer) Set(ctx context.Context, key string, req *SetRequest) (*SetResponse, error) {
+	return p.s.setLocal(ctx, key, req)
+}
+
+func (p localPeer) Incr(ctx context.Context, key string, req *IncrRequest) (*IncrResponse, error) {
+	return p.s.incrLocal(ctx, key, req)
+}
+
+func (p localPeer) Expire(ctx context.Context, key string, req *ExpireRequest) (*ExpiryResponse, error) {
+	u, err := req.update()
+	if err != nil {
+		return nil, err
+	}
+	return p.s.updateExpiryLocal(ctx, key, u)
+}
+
+func (p localPeer) Touch(ctx context.Context, key string) (*ExpiryResponse, error) {
+	return p.s.updateExpiryLocal(ctx, key, expiryUpdate{touch: true})
+}
+
+func (p localPeer) Persist(ctx context.Context, key string) (*ExpiryResponse, error) {
+	return p.s.updateExpiryLocal(ctx, key, expiryUpdate{ttl: NoExpiry})
+}
+
+func (p localPeer) MGet(ctx context.Context, req *MGetRequest) (*MGetResponse, error) {
+	return p.s.mgetLocal(ctx, req)
+}
+
+func (p localPeer) MSet(ctx context.Context, req *MSetRequest) (*MSetResponse, error) {
+	return p.s.msetLocal(ctx, req)
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,196 @@
+package cachemanager
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// Codec compresses cache values and L2 payloads.
+//
+// A compressed value is framed as one header byte holding the codec's ID followed by
+// the codec's output. IDs are control bytes that no JSON document starts with, so
+// uncompressed values and payloads written before compression was enabled are read
+// unchanged.
+//
+// Design Notes:
+//   - Values are compressed in L1 (the byte budget counts compressed bytes) and
+//     decompressed on every L1 hit, trading CPU per hit for memory.
+//   - The L2 payload (entry metadata plus value) is compressed as a whole, so L2
+//     network bytes shrink by the full ratio.
+//   - Decoding picks the codec from the header byte, so changing Config.CompressionCodec
+//     keeps existing entries readable as long as the old codec stays registered.
+type Codec interface {
+	ID() byte     // Header byte; see RegisterCodec for the valid range
+	Name() string // Name accepted by Config.CompressionCodec
+	Encode(src []byte) ([]byte, error)
+	Decode(src []byte) ([]byte, error)
+}
+
+// Codec names accepted by CodecByName and Config.CompressionCodec.
+const (
+	CodecGzip = "gzip"
+)
+
+// gzipCodecID is the header byte of gzip-compressed values.
+const gzipCodecID byte = 0x01
+
+var (
+	codecsMu     sync.RWMutex
+	codecsByID   = make(map[byte]Codec)
+	codecsByName = make(map[string]Codec)
+)
+
+func init() {
+	if err := RegisterCodec(NewGzipCodec(gzip.BestSpeed)); err != nil {
+		panic(err)
+	}
+}
+
+// RegisterCodec makes c available to CodecByName and to decoding. Its ID must be in
+// 0x01-0x1f, excluding JSON whitespace (\t, \n, \r), and unique, as must its name.
+func RegisterCodec(c Codec) error {
+	id := c.ID()
+	if id == 0 || id >= 0x20 || id == '\t' || id == '\n' || id == '\r' {
+		return fmt.Errorf("codec %q: invalid id 0x%02x", c.Name(), id)
+	}
+
+	codecsMu.Lock()
+	defer codecsMu.Unlock()
+	if existing, ok := codecsByID[id]; ok {
+		return fmt.Errorf("codec %q: id 0x%02x already used by %q", c.Name(), id, existing.Name())
+	}
+	if _, ok := codecsByName[c.Name()]; ok {
+		return fmt.Errorf("codec %q already registered", c.Name())
+	}
+	codecsByID[id] = c
+	codecsByName[c.Name()] = c
+	return nil
+}
+
+// CodecByName resolves a registered codec. An empty name selects gzip.
+func CodecByName(name string) (Codec, error) {
+	if name == "" {
+		name = CodecGzip
+	}
+	codecsMu.RLock()
+	defer codecsMu.RUnlock()
+	c, ok := codecsByName[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown compression codec %q", name)
+	}
+	return c, nil
+}
+
+func codecByID(id byte) (Codec, bool) {
+	codecsMu.RLock()
+	defer codecsMu.RUnlock()
+	c, ok := codecsByID[id]
+	return c, ok
+}
+
+// GzipCodec compresses with compress/gzip. Writers are pooled, since allocating one
+// costs far more than compressing a typical cache value.
+type GzipCodec struct {
+	level   int
+	writers sync.Pool // *gzip.Writer
+}
+
+// NewGzipCodec creates a gzip codec at level (gzip.BestSpeed..gzip.BestCompression).
+func NewGzipCodec(level int) *GzipCodec {
+	return &GzipCodec{level: level}
+}
+
+func (c *GzipCodec) ID() byte     { return gzipCodecID }
+func (c *GzipCodec) Name() string { return CodecGzip }
+
+func (c *GzipCodec) Encode(src []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w, ok := c.writers.Get().(*gzip.Writer)
+	if ok {
+		w.Reset(&buf)
+	} else {
+		var err error
+		if w, err = gzip.NewWriterLevel(&buf, c.level); err != nil {
+			return nil, err
+		}
+	}
+	defer c.writers.Put(w)
+
+	if _, err := w.Write(src); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (c *GzipCodec) Decode(src []byte) ([]byte, error) {
+	r, err := gzip.NewReader(bytes.NewReader(src))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return io.ReadAll(r)
+}
+
+var errUnknownCodec = errors.New("compressed with unknown codec")
+
+// compress frames data with the configured codec when it is at least
+// Config.CompressionThreshold bytes and compression actually makes it smaller.
+// Otherwise, or on a codec error, data is returned unchanged.
+func (s *Service) compress(data []byte) []byte {
+	if s.codec == nil || s.config.CompressionThreshold <= 0 || len(data) < s.config.CompressionThreshold {
+		return data
+	}
+
+	encoded, err := s.codec.Encode(data)
+	if err != nil {
+		s.metrics.CompressionErrors.Add(1)
+		return data
+	}
+	s.metrics.CompressionBytesIn.Add(int64(len(data)))
+	if len(encoded)+1 >= len(data) {
+		// Incompressible; count it so the ratio reflects what was actually stored.
+		s.metrics.CompressionBytesOut.Add(int64(len(data)))
+		return data
+	}
+	s.metrics.CompressionBytesOut.Add(int64(len(encoded) + 1))
+
+	framed := make([]byte, len(encoded)+1)
+	framed[0] = s.codec.ID()
+	copy(framed[1:], encoded)
+	return framed
+}
+
+// decompress reverses compress. Unframed data is returned unchanged.
+func (s *Service) decompress(data []byte) ([]byte, error) {
+	if len(data) == 0 || data[0] >= 0x20 || data[0] == '\t' || data[0] == '\n' || data[0] == '\r' {
+		return data, nil
+	}
+	codec, ok := codecByID(data[0])
+	if !ok {
+		s.metrics.CompressionErrors.Add(1)
+		return nil, fmt.Errorf("%w 0x%02x", errUnknownCodec, data[0])
+	}
+	decoded, err := codec.Decode(data[1:])
+	if err != nil {
+		s.metrics.CompressionErrors.Add(1)
+		return nil, fmt.Errorf("decompress with %s: %w", codec.Name(), err)
+	}
+	return decoded, nil
+}
+
+// compressionRatio reports uncompressed over stored bytes for values that reached
+// the compression threshold, or 0 if none have.
+func (s *Service) compressionRatio() float64 {
+	out := s.metrics.CompressionBytesOut.Load()
+	if out == 0 {
+		return 0
+	}
+	return float64(s.metrics.CompressionBytesIn.Load()) / float64(out)
+}
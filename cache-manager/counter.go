@@ -0,0 +1,259 @@
+package cachemanager
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+
+	"encore.dev/beta/errs"
+)
+
+var (
+	// ErrNotCounter is returned when incrementing a key whose value is not an integer.
+	ErrNotCounter = errors.New("value is not an integer")
+	// ErrCounterOverflow is returned when an increment would leave the int64 range.
+	ErrCounterOverflow = errors.New("counter overflow")
+)
+
+type IncrRequest struct {
+	Delta   int64 `json:"delta"`   // Amount to add; negative decrements (0 means 1)
+	Initial int64 `json:"initial"` // Value the counter starts from when the key holds none, before Delta
+	TTL     int   `json:"ttl"`     // seconds, applied when the counter is created; 0 means default
+}
+
+type IncrResponse struct {
+	Value     int64     `json:"value"`
+	Version   uint64    `json:"version"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// Incr atomically adds delta to the integer stored at key and returns the new value.
+// A missing or expired key starts from initial and expires after the request TTL;
+// later increments keep that expiry, so a counter created for a rate-limit window
+// resets when the window ends. The value is stored as a JSON number, so Get returns
+// it like any other entry.
+//
+//encore:api public method=POST path=/api/cache/entry/:key/incr
+func Incr(ctx context.Context, key string, req *IncrRequest) (*IncrResponse, error) {
+	if svc == nil {
+		return nil, errors.New("service not initialized")
+	}
+	return incrResult(svc.Incr(ctx, key, req))
+}
+
+func incrResult(resp *IncrResponse, err error) (*IncrResponse, error) {
+	switch {
+	case errors.Is(err, ErrNotCounter):
+		return nil, errs.WrapCode(err, errs.FailedPrecondition, "value is not an integer")
+	case errors.Is(err, ErrCounterOverflow):
+		return nil, errs.WrapCode(err, errs.OutOfRange, "counter overflow")
+	case errors.Is(err, ErrVersionConflict):
+		return nil, errs.WrapCode(err, errs.Aborted, "counter contention, retry")
+	case errors.Is(err, ErrCircuitOpen):
+		return nil, errs.WrapCode(err, errs.Unavailable, "l2 unavailable")
+	}
+	return resp, err
+}
+
+// Incr applies an increment on the key's owner, or on this instance when the owner
+// cannot be reached.
+func (s *Service) Incr(ctx context.Context, key string, req *IncrRequest) (*IncrResponse, error) {
+	if peer := s.ownerPeer(key); peer != nil {
+		if resp, err := peer.Incr(ctx, key, req); s.forwarded(err) {
+			if s.hotKeys.isHot(key) {
+				s.l1For(key).Delete(key) // Drop the pinned copy this increment made stale
+			}
+			return resp, err
+		}
+	}
+	return s.incrLocal(ctx, key, req)
+}
+
+// incrLocal applies an increment on this instance, ignoring ownership.
+//
+// Design Notes:
+//   - With a CounterRemoteCache the increment is applied by the store in one round
+//     trip, so concurrent increments from every instance are applied exactly once.
+//   - Otherwise, or for an entry the store declines, a ConditionalRemoteCache is
+//     used: a read and compare-and-set on the L2 entry, retried on conflict, with
+//     losers backing off with jitter before retrying.
+//   - Both bypass the write-behind queue, since the new value depends on the one in
+//     L2; while the L2 breaker is open they fail with ErrCircuitOpen rather than
+//     letting instances count separately.
+//   - Otherwise L1Cache.Incr is atomic per instance only; a counter missing from L1 is
+//     first loaded from L2 and the result is written back like a Set.
+//   - Counters are never served stale: an expired counter starts over from initial.
+//   - On every path a counter's BaseTTL is the TTL it was created with, kept by later
+//     increments like its expiry, so Touch restores the counter's full window.
+func (s *Service) incrLocal(ctx context.Context, key string, req *IncrRequest) (*IncrResponse, error) {
+	if err := validateKey(key); err != nil {
+		return nil, err
+	}
+	delta := req.Delta
+	if delta == 0 {
+		delta = 1
+	}
+	ttl := s.defaultTTLFor(key)
+	if req.TTL > 0 {
+		ttl = time.Duration(req.TTL) * time.Second
+	}
+
+	if counter, ok := s.l2Cache.(CounterRemoteCache); ok && s.writesL2() {
+		if resp, handled, err := s.incrCounterL2(ctx, counter, key, delta, req.Initial, ttl); handled {
+			return resp, err
+		}
+	}
+	if cas, ok := s.l2Cache.(ConditionalRemoteCache); ok && s.writesL2() {
+		return s.incrL2(ctx, cas, key, delta, req.Initial, ttl)
+	}
+
+	l1 := s.l1For(key)
+	if _, ok := l1.Get(key); !ok && s.l2Allowed() {
+		data, found, err := s.l2Cache.Get(ctx, key)
+		s.recordL2(err)
+		if err == nil && found {
+			s.entryFromL2(key, data, time.Now())
+		}
+	}
+
+	value, entry, err := l1.Incr(key, delta, req.Initial, ttl, EntryOptions{Source: "incr"})
+	if err != nil {
+		return nil, err
+	}
+	s.metrics.Incrs.Add(1)
+
+	s.putL2(ctx, key, entry)
+
+	return &IncrResponse{Value: value, Version: entry.Version, ExpiresAt: entry.ExpiresAt}, nil
+}
+
+// incrCounterL2 increments the L2 entry with the store's native increment, then
+// caches the result in L1. handled is false if the store declined the entry.
+func (s *Service) incrCounterL2(ctx context.Context, counter CounterRemoteCache, key string, delta, initial int64, ttl time.Duration) (resp *IncrResponse, handled bool, err error) {
+	if !s.l2Breaker.Allow() {
+		return nil, true, fmt.Errorf("counter: %w", ErrCircuitOpen)
+	}
+	entry, ok, err := counter.IncrCounter(ctx, key, delta, initial, ttl)
+	if errors.Is(err, ErrNotCounter) {
+		s.recordL2(nil) // The store answered; the value is just not a counter
+		return nil, true, err
+	}
+	s.recordL2(err)
+	if err != nil {
+		return nil, true, fmt.Errorf("counter: %w", err)
+	}
+	if !ok {
+		return nil, false, nil
+	}
+	value, err := strconv.ParseInt(string(entry.Value), 10, 64)
+	if err != nil {
+		return nil, true, ErrNotCounter
+	}
+	return s.cacheCounter(key, entry, value), true, nil
+}
+
+// incrL2 increments the L2 entry with compare-and-set, then caches the result in L1.
+func (s *Service) incrL2(ctx context.Context, cas ConditionalRemoteCache, key string, delta, initial int64, ttl time.Duration) (*IncrResponse, error) {
+	var value int64
+	entry, err := s.casL2(ctx, cas, key, &s.metrics.IncrRetries, func(current *CacheEntry, now time.Time) (*CacheEntry, error) {
+		next := &CacheEntry{CachedAt: now, ExpiresAt: now.Add(ttl), BaseTTL: ttl}
+		var raw json.RawMessage
+		var prev uint64
+		if current != nil {
+			raw, prev = current.Value, current.Version
+			next.ExpiresAt, next.BaseTTL = current.ExpiresAt, current.BaseTTL
+		}
+		v, err := addToCounter(raw, current != nil, delta, initial)
+		if err != nil {
+			return nil, err
+		}
+		value = v
+		next.Value = formatCounter(v)
+		next.Version = nextVersion(prev)
+		return next, nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("counter: %w", err)
+	}
+	return s.cacheCounter(key, entry, value), nil
+}
+
+// cacheCounter stores a counter entry written to L2 in L1.
+func (s *Service) cacheCounter(key string, entry *CacheEntry, value int64) *IncrResponse {
+	s.l1For(key).SetWithOptions(key, entry.Value, l1TTL(entry.ExpiresAt), EntryOptions{
+		Version: entry.Version,
+		BaseTTL: entry.BaseTTL,
+		Source:  "incr",
+	})
+	s.metrics.Incrs.Add(1)
+	return &IncrResponse{Value: value, Version: entry.Version, ExpiresAt: entry.ExpiresAt}
+}
+
+// Incr atomically adds delta to the integer held by key, starting from initial when
+// the key holds no live value (missing, expired or a negative cache entry). A new
+// counter expires after ttl, which becomes its BaseTTL; incrementing an existing one
+// keeps its expiry and BaseTTL. The check and write happen under the shard lock, so
+// concurrent increments on this cache are never lost. Returns the new value and the
+// stored counter as an entry ready to write to L2.
+// Complexity: O(1) amortized.
+func (c *L1Cache) Incr(key string, delta, initial int64, ttl time.Duration, opts EntryOptions) (value int64, stored *CacheEntry, err error) {
+	s := c.shardFor(key)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	expiresAt, baseTTL := now.Add(ttl), ttl
+	var current json.RawMessage
+	present := false
+	if entry, ok := s.cache[key]; ok && entry.expiresAt.After(now) && !entry.tombstone && entry.originError == "" {
+		current, present = entry.value, true
+		expiresAt, baseTTL = entry.expiresAt, entry.baseTTL
+	}
+	value, err = addToCounter(current, present, delta, initial)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	opts.Version = 0
+	opts.StaleTTL = 0
+	opts.BaseTTL = baseTTL
+	version := s.setUnsafe(key, formatCounter(value), expiresAt.Sub(now), opts)
+	// Pin the expiry exactly; recomputing it from the remaining TTL on every
+	// increment would let a busy counter's window creep forward.
+	if entry, ok := s.cache[key]; ok {
+		entry.setExpiry(expiresAt)
+		entry.l2Expiry = expiresAt
+		s.scheduleUnsafe(entry)
+	}
+	return value, &CacheEntry{
+		Value:     formatCounter(value),
+		CachedAt:  now,
+		ExpiresAt: expiresAt,
+		BaseTTL:   baseTTL,
+		Version:   version,
+	}, nil
+}
+
+// addToCounter returns current (or initial if !present) plus delta.
+func addToCounter(current json.RawMessage, present bool, delta, initial int64) (int64, error) {
+	base := initial
+	if present {
+		n, err := strconv.ParseInt(string(current), 10, 64)
+		if err != nil {
+			return 0, ErrNotCounter
+		}
+		base = n
+	}
+	if (delta > 0 && base > math.MaxInt64-delta) || (delta < 0 && base < math.MinInt64-delta) {
+		return 0, ErrCounterOverflow
+	}
+	return base + delta, nil
+}
+
+func formatCounter(value int64) json.RawMessage {
+	return json.RawMessage(strconv.AppendInt(nil, value, 10))
+}
@@ -0,0 +1,122 @@
+package cachemanager
+
+import (
+	"context"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"encore.app/monitoring"
+)
+
+// EvictionEventConfig enables publishing sampled L1 evictions to
+// monitoring.EvictionTopic, so dashboards can tell capacity pressure apart from
+// natural expiry.
+type EvictionEventConfig struct {
+	Enabled       bool
+	SampleRate    float64       // Fraction of evictions published (default 0.01)
+	FlushInterval time.Duration // How often a batch is published (default 5s)
+	MaxBatch      int           // Samples held per batch; more are dropped until the next flush (default 1000)
+}
+
+func (c EvictionEventConfig) withDefaults() EvictionEventConfig {
+	if c.SampleRate <= 0 || c.SampleRate > 1 {
+		c.SampleRate = 0.01
+	}
+	if c.FlushInterval <= 0 {
+		c.FlushInterval = 5 * time.Second
+	}
+	if c.MaxBatch <= 0 {
+		c.MaxBatch = 1000
+	}
+	return c
+}
+
+// evictionSampler is the EvictionListener of every L1 when eviction events are
+// enabled. It runs under L1 shard locks, so it only samples into a bounded buffer;
+// runEvictionEvents publishes the buffer.
+type evictionSampler struct {
+	config   EvictionEventConfig
+	instance string
+	drops    *atomic.Int64 // Samples dropped because the batch was full
+
+	mu    sync.Mutex
+	batch []monitoring.EvictionSample
+}
+
+func newEvictionSampler(config EvictionEventConfig, instance string, drops *atomic.Int64) *evictionSampler {
+	return &evictionSampler{
+		config:   config.withDefaults(),
+		instance: instance,
+		drops:    drops,
+	}
+}
+
+// record samples one eviction.
+// Complexity: O(1).
+func (e *evictionSampler) record(ev Eviction) {
+	if e.config.SampleRate < 1 && rand.Float64() >= e.config.SampleRate {
+		return
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if len(e.batch) >= e.config.MaxBatch {
+		e.drops.Add(1)
+		return
+	}
+	e.batch = append(e.batch, monitoring.EvictionSample{
+		Key:    ev.Key,
+		Reason: string(ev.Reason),
+		AgeMs:  float64(ev.Age) / float64(time.Millisecond),
+	})
+}
+
+// take returns the pending batch as an event, or nil if there is none.
+func (e *evictionSampler) take(now time.Time) *monitoring.EvictionEvent {
+	e.mu.Lock()
+	batch := e.batch
+	e.batch = nil
+	e.mu.Unlock()
+
+	if len(batch) == 0 {
+		return nil
+	}
+	return &monitoring.EvictionEvent{
+		Evictions:  batch,
+		SampleRate: e.config.SampleRate,
+		Instance:   e.instance,
+		Timestamp:  now,
+	}
+}
+
+// watchEvictions reports l1's evictions to the sampler, if eviction events are enabled.
+func (s *Service) watchEvictions(l1 *L1Cache) {
+	if s.evictions != nil {
+		l1.SetEvictionListener(s.evictions.record)
+	}
+}
+
+// runEvictionEvents publishes sampled evictions every FlushInterval until stopChan
+// closes, then publishes what is left.
+func (s *Service) runEvictionEvents() {
+	defer s.wg.Done()
+	ticker := time.NewTicker(s.evictions.config.FlushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stopChan:
+			s.publishEvictions(context.Background())
+			return
+		case <-ticker.C:
+			s.publishEvictions(context.Background())
+		}
+	}
+}
+
+func (s *Service) publishEvictions(ctx context.Context) {
+	if event := s.evictions.take(time.Now()); event != nil {
+		_, _ = monitoring.EvictionTopic.Publish(ctx, event)
+	}
+}
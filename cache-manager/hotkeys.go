@@ -0,0 +1,476 @@
+package cachemanager
+
+import (
+	"container/heap"
+	"context"
+	"errors"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"encore.app/monitoring"
+)
+
+// HotKeyConfig tunes hot-key detection over Get traffic.
+type HotKeyConfig struct {
+	Disabled  bool
+	Capacity  int           // Keys tracked by the heavy-hitters sketch (default 1024)
+	Window    time.Duration // Detection window; counts restart every window (default 10s)
+	Threshold int64         // Gets per window on one instance that make a key hot (default 1000)
+	MaxKeys   int           // Keys one instance promotes at once, hottest first (default 32)
+	TTL       time.Duration // TTL of the local copy pinned on non-owners (default 5s)
+	Hold      time.Duration // How long a promotion lasts unless renewed (default 1m)
+}
+
+func (c HotKeyConfig) withDefaults() HotKeyConfig {
+	if c.Capacity <= 0 {
+		c.Capacity = 1024
+	}
+	if c.Window <= 0 {
+		c.Window = 10 * time.Second
+	}
+	if c.Threshold <= 0 {
+		c.Threshold = 1000
+	}
+	if c.MaxKeys <= 0 {
+		c.MaxKeys = 32
+	}
+	if c.TTL <= 0 {
+		c.TTL = 5 * time.Second
+	}
+	if c.Hold <= 0 {
+		c.Hold = time.Minute
+	}
+	return c
+}
+
+type HotKey struct {
+	Key      string    `json:"key"`
+	Hits     int64     `json:"hits"`     // Gets in the detection window when last announced
+	Instance string    `json:"instance"` // Instance that detected it
+	Since    time.Time `json:"since"`
+	Local    bool      `json:"local"` // Detected by this instance
+}
+
+type HotKeysResponse struct {
+	Keys      []HotKey `json:"keys"`
+	Window    int      `json:"window"`    // Detection window in seconds
+	Threshold int64    `json:"threshold"` // Gets per window that make a key hot
+}
+
+// HotKeys lists the keys currently hot anywhere in the cluster, hottest first.
+// Complexity: O(h log h) for h hot keys.
+//
+//encore:api public method=GET path=/api/cache/hotkeys
+func HotKeys(ctx context.Context) (*HotKeysResponse, error) {
+	if svc == nil {
+		return nil, errors.New("service not initialized")
+	}
+	return svc.HotKeys(ctx)
+}
+
+func (s *Service) HotKeys(ctx context.Context) (*HotKeysResponse, error) {
+	t := s.hotKeys
+	if t == nil {
+		return &HotKeysResponse{Keys: []HotKey{}}, nil
+	}
+	resp := &HotKeysResponse{
+		Keys:      t.list(time.Now()),
+		Window:    int(t.config.Window / time.Second),
+		Threshold: t.config.Threshold,
+	}
+	for i := range resp.Keys {
+		resp.Keys[i].Local = resp.Keys[i].Instance == t.instance
+	}
+	return resp, nil
+}
+
+// hotKeyTracker detects hot keys on this instance and holds the hot keys announced
+// by every instance.
+//
+// Design Notes:
+//   - Each instance detects from its own Get traffic and announces transitions over
+//     monitoring.HotKeyTopic, which every instance (itself included) and monitoring
+//     consume. Keys stay hot for Hold after their last announcement; the detector
+//     renews keys that stay hot, so a lost demotion or a crashed detector cannot pin
+//     a key forever.
+//   - In a cluster, every instance pins a promoted key from its owner when the
+//     promotion arrives and serves it from that short-TTL copy in the local L1 instead
+//     of forwarding every Get, spreading the load of one key over every instance. An
+//     expired pin is renewed by the next forwarded Get. Only pinned copies are served:
+//     a copy cached locally while the owner was down may be far older than TTL. Copies
+//     can lag writes by up to TTL. Without a cluster every instance already caches
+//     what it serves, so detection only reports.
+type hotKeyTracker struct {
+	config   HotKeyConfig
+	instance string
+	sketch   *stripedHeavyHitters
+
+	mu       sync.Mutex
+	promoted map[string]time.Time // Keys this instance promoted -> last announcement
+
+	hot sync.Map // key -> *hotKeyState; announced by any instance
+}
+
+type hotKeyState struct {
+	HotKey
+	until time.Time
+}
+
+func newHotKeyTracker(config HotKeyConfig, instance string) *hotKeyTracker {
+	config = config.withDefaults()
+	return &hotKeyTracker{
+		config:   config,
+		instance: instance,
+		sketch:   newStripedHeavyHitters(config.Capacity),
+		promoted: make(map[string]time.Time),
+	}
+}
+
+// observe counts a Get. Safe on a nil tracker.
+// Complexity: O(log c) for c keys tracked per stripe.
+func (t *hotKeyTracker) observe(key string) {
+	if t != nil {
+		t.sketch.add(key)
+	}
+}
+
+// isHot reports whether key is currently hot. Safe on a nil tracker.
+func (t *hotKeyTracker) isHot(key string) bool {
+	if t == nil {
+		return false
+	}
+	v, ok := t.hot.Load(key)
+	if !ok {
+		return false
+	}
+	if time.Now().After(v.(*hotKeyState).until) {
+		t.hot.CompareAndDelete(key, v)
+		return false
+	}
+	return true
+}
+
+// apply records an announcement from any instance. Idempotent.
+func (t *hotKeyTracker) apply(event *monitoring.HotKeyEvent) {
+	if event.Action == monitoring.HotKeyDemote {
+		if v, ok := t.hot.Load(event.Key); ok && v.(*hotKeyState).Instance == event.Instance {
+			t.hot.CompareAndDelete(event.Key, v)
+		}
+		return
+	}
+	state := &hotKeyState{
+		HotKey: HotKey{Key: event.Key, Hits: event.Hits, Instance: event.Instance, Since: event.Timestamp},
+		until:  time.Now().Add(t.config.Hold),
+	}
+	if v, ok := t.hot.Load(event.Key); ok {
+		if prev := v.(*hotKeyState); prev.Since.Before(state.Since) {
+			state.Since = prev.Since
+		}
+	}
+	t.hot.Store(event.Key, state)
+}
+
+func (t *hotKeyTracker) list(now time.Time) []HotKey {
+	keys := []HotKey{}
+	t.hot.Range(func(_, v interface{}) bool {
+		if state := v.(*hotKeyState); now.Before(state.until) {
+			keys = append(keys, state.HotKey)
+		}
+		return true
+	})
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].Hits > keys[j].Hits
+	})
+	return keys
+}
+
+// rotate closes a detection window: keys over the threshold are promoted (or renewed
+// once half their hold has passed), promoted keys below it are demoted, and counts
+// restart. Returns the announcements to publish.
+func (t *hotKeyTracker) rotate(now time.Time) []*monitoring.HotKeyEvent {
+	top := t.sketch.top(t.config.Threshold, t.config.MaxKeys)
+	t.sketch.reset()
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var events []*monitoring.HotKeyEvent
+	event := func(key, action string, hits int64, renewal bool) {
+		events = append(events, &monitoring.HotKeyEvent{
+			Key:       key,
+			Action:    action,
+			Hits:      hits,
+			Window:    int(t.config.Window / time.Second),
+			Renewal:   renewal,
+			Instance:  t.instance,
+			Timestamp: now,
+		})
+	}
+
+	hot := make(map[string]bool, len(top))
+	for _, c := range top {
+		hot[c.key] = true
+		announced, ok := t.promoted[c.key]
+		switch {
+		case !ok:
+			event(c.key, monitoring.HotKeyPromote, c.count, false)
+			t.promoted[c.key] = now
+		case now.Sub(announced) >= t.config.Hold/2:
+			event(c.key, monitoring.HotKeyPromote, c.count, true)
+			t.promoted[c.key] = now
+		}
+	}
+	for key := range t.promoted {
+		if !hot[key] {
+			event(key, monitoring.HotKeyDemote, 0, false)
+			delete(t.promoted, key)
+		}
+	}
+	return events
+}
+
+// runHotKeys closes a detection window every HotKeys.Window until stopChan closes.
+func (s *Service) runHotKeys() {
+	defer s.wg.Done()
+	ticker := time.NewTicker(s.hotKeys.config.Window)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stopChan:
+			return
+		case <-ticker.C:
+			s.rotateHotKeys(context.Background())
+		}
+	}
+}
+
+// rotateHotKeys applies this window's promotions and demotions locally, then
+// announces them to other instances and monitoring.
+func (s *Service) rotateHotKeys(ctx context.Context) {
+	if s.hotKeys == nil {
+		return
+	}
+	for _, event := range s.hotKeys.rotate(time.Now()) {
+		switch {
+		case event.Action == monitoring.HotKeyDemote:
+			s.metrics.HotKeyDemotions.Add(1)
+		case !event.Renewal:
+			s.metrics.HotKeyPromotions.Add(1)
+		}
+		s.applyHotKey(ctx, event)
+		_, _ = monitoring.HotKeyTopic.Publish(ctx, event)
+	}
+}
+
+// applyHotKey records an announcement from any instance. A promoted key owned by
+// another instance is fetched from its owner once and pinned, so Gets are served
+// locally from the first one instead of after a forward.
+func (s *Service) applyHotKey(ctx context.Context, event *monitoring.HotKeyEvent) {
+	s.hotKeys.apply(event)
+	if event.Action != monitoring.HotKeyPromote || s.pinned(event.Key) {
+		return
+	}
+	if peer := s.ownerPeer(event.Key); peer != nil {
+		if resp, err := peer.Get(ctx, event.Key); s.forwarded(err) && err == nil {
+			s.pinHotKey(event.Key, resp)
+		}
+	}
+}
+
+// pinned reports whether L1 holds a live copy of key pinned from its owner. Copies
+// this instance cached itself while the owner was unreachable do not count.
+func (s *Service) pinned(key string) bool {
+	source, ok := s.l1For(key).SourceOf(key)
+	return ok && source == "peer"
+}
+
+// pinHotKey keeps a short-lived local copy of a hot key answered by its owner.
+func (s *Service) pinHotKey(key string, resp *GetResponse) {
+	if resp == nil || resp.Stale || !resp.Hit {
+		return
+	}
+	ttl := s.hotKeys.config.TTL
+	if resp.ExpiresAt != nil {
+		if remaining := time.Until(*resp.ExpiresAt); remaining < ttl {
+			ttl = remaining
+		}
+	}
+	if ttl <= 0 {
+		return
+	}
+	var value []byte
+	if resp.Found {
+		value = s.compress(resp.Value)
+	}
+	s.l1For(key).SetWithOptions(key, value, ttl, EntryOptions{
+		Version:   resp.Version,
+		Tombstone: !resp.Found,
+		Source:    "peer",
+	})
+}
+
+func (s *Service) hotKeyCount() int {
+	if s.hotKeys == nil {
+		return 0
+	}
+	return len(s.hotKeys.list(time.Now()))
+}
+
+// instanceName identifies this instance in events: its cluster node ID, else the host name.
+func instanceName(config Config) string {
+	if config.Cluster.NodeID != "" {
+		return config.Cluster.NodeID
+	}
+	host, _ := os.Hostname()
+	return host
+}
+
+// hotKeyStripes splits the sketch so concurrent Gets rarely share a lock. A key
+// always maps to the same stripe, so its count is exact within that stripe.
+const hotKeyStripes = 16
+
+type stripedHeavyHitters struct {
+	stripes [hotKeyStripes]*heavyHitters
+}
+
+func newStripedHeavyHitters(capacity int) *stripedHeavyHitters {
+	perStripe := (capacity + hotKeyStripes - 1) / hotKeyStripes
+	s := &stripedHeavyHitters{}
+	for i := range s.stripes {
+		s.stripes[i] = newHeavyHitters(perStripe)
+	}
+	return s
+}
+
+func (s *stripedHeavyHitters) add(key string) {
+	h := uint32(2166136261)
+	for i := 0; i < len(key); i++ {
+		h ^= uint32(key[i])
+		h *= 16777619
+	}
+	s.stripes[h%hotKeyStripes].add(key)
+}
+
+// top returns up to n keys with at least threshold guaranteed hits, highest first.
+func (s *stripedHeavyHitters) top(threshold int64, n int) []heavyHitter {
+	var all []heavyHitter
+	for _, stripe := range s.stripes {
+		all = append(all, stripe.top(threshold)...)
+	}
+	sort.Slice(all, func(i, j int) bool {
+		return all[i].count > all[j].count
+	})
+	if len(all) > n {
+		all = all[:n]
+	}
+	return all
+}
+
+func (s *stripedHeavyHitters) reset() {
+	for _, stripe := range s.stripes {
+		stripe.reset()
+	}
+}
+
+// heavyHitters is a Space-Saving sketch (Metwally et al., "Efficient Computation of
+// Frequent and Top-k Elements in Data Streams"). It tracks at most capacity keys; an
+// untracked key takes over the counter with the lowest count and inherits that count
+// as its possible overestimate. Any key seen more than total/capacity times is
+// guaranteed to be tracked, so memory stays bounded however many distinct keys pass.
+type heavyHitters struct {
+	mu       sync.Mutex
+	capacity int
+	counters map[string]*hhCounter
+	heap     hhHeap // Min-heap by count
+}
+
+type hhCounter struct {
+	key   string
+	count int64
+	over  int64 // Upper bound on how much count overestimates the key's hits
+	index int
+}
+
+type heavyHitter struct {
+	key   string
+	count int64 // Guaranteed hits (count - over)
+}
+
+func newHeavyHitters(capacity int) *heavyHitters {
+	return &heavyHitters{
+		capacity: capacity,
+		counters: make(map[string]*hhCounter, capacity),
+	}
+}
+
+// add counts one hit for key.
+// Complexity: O(log capacity).
+func (h *heavyHitters) add(key string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if c, ok := h.counters[key]; ok {
+		c.count++
+		heap.Fix(&h.heap, c.index)
+		return
+	}
+	if len(h.heap) < h.capacity {
+		c := &hhCounter{key: key, count: 1}
+		heap.Push(&h.heap, c)
+		h.counters[key] = c
+		return
+	}
+	c := h.heap[0]
+	delete(h.counters, c.key)
+	c.key, c.over = key, c.count
+	c.count++
+	h.counters[key] = c
+	heap.Fix(&h.heap, 0)
+}
+
+func (h *heavyHitters) top(threshold int64) []heavyHitter {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	var result []heavyHitter
+	for _, c := range h.heap {
+		if guaranteed := c.count - c.over; guaranteed >= threshold {
+			result = append(result, heavyHitter{key: c.key, count: guaranteed})
+		}
+	}
+	return result
+}
+
+func (h *heavyHitters) reset() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.counters = make(map[string]*hhCounter, h.capacity)
+	h.heap = nil
+}
+
+type hhHeap []*hhCounter
+
+func (h hhHeap) Len() int           { return len(h) }
+func (h hhHeap) Less(i, j int) bool { return h[i].count < h[j].count }
+func (h hhHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *hhHeap) Push(x interface{}) {
+	c := x.(*hhCounter)
+	c.index = len(*h)
+	*h = append(*h, c)
+}
+
+func (h *hhHeap) Pop() interface{} {
+	old := *h
+	c := old[len(old)-1]
+	old[len(old)-1] = nil
+	*h = old[:len(old)-1]
+	return c
+}
@@ -0,0 +1,135 @@
+package cachemanager
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"encore.app/invalidation"
+)
+
+// keyPatterns filters ListKeys with the invalidation service's pattern syntax, so a
+// pattern can be previewed here before it is invalidated. Shared so compiled regexes
+// are reused across calls.
+var keyPatterns = invalidation.NewPatternMatcher()
+
+const (
+	defaultKeysLimit = 100
+	maxKeysLimit     = 1000
+)
+
+// ErrReservedKey is returned for a key under one of reservedKeyPrefixes.
+var ErrReservedKey = errors.New("key uses a reserved prefix")
+
+// reservedKeyPrefixes are the L2 key spaces of the cache manager's own records:
+// leases, the namespace registry and the Redis tag index. The entry API rejects keys
+// under them, so clients cannot forge, read or delete those records, and
+// RedisCache.DeletePattern skips them.
+var reservedKeyPrefixes = []string{leaseKeyPrefix, namespaceRegistryKey, tagIndexPrefix}
+
+func reservedKey(key string) bool {
+	for _, prefix := range reservedKeyPrefixes {
+		if strings.HasPrefix(key, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// validateKey checks a key named by a client request.
+func validateKey(key string) error {
+	if key == "" {
+		return errors.New("key cannot be empty")
+	}
+	if reservedKey(key) {
+		return fmt.Errorf("key %q: %w", key, ErrReservedKey)
+	}
+	return nil
+}
+
+type ListKeysRequest struct {
+	Pattern string `json:"pattern,omitempty"` // Invalidation pattern syntax (exact, "user:*", "*:profile", regex); empty lists every key
+	Cursor  string `json:"cursor,omitempty"`  // NextCursor of the previous page; empty starts from the first key
+	Limit   int    `json:"limit,omitempty"`   // Page size (default 100, max 1000)
+}
+
+type ListKeysResponse struct {
+	Keys       []KeyInfo `json:"keys"`
+	NextCursor string    `json:"next_cursor,omitempty"` // Empty on the last page
+}
+
+// ListKeys pages through the keys held in this instance's L1, across all namespaces,
+// in key order. Values are not returned; use Get for those. L2 is not listed.
+//
+//encore:api public method=GET path=/api/cache/keys
+func ListKeys(ctx context.Context, req *ListKeysRequest) (*ListKeysResponse, error) {
+	if svc == nil {
+		return nil, errors.New("service not initialized")
+	}
+	return svc.ListKeys(ctx, req)
+}
+
+// ListKeys returns the page of keys after req.Cursor.
+//
+// Design Notes:
+//   - The cursor encodes the last key returned, not a position, so it stays valid
+//     while keys are written, evicted and invalidated between pages: each key cached
+//     throughout the listing is returned exactly once, in order. See L1Cache.ScanKeys.
+//   - Every page scans all entries. Listing is meant for debugging, not hot paths.
+//
+// Complexity: O(n log limit) per page for n L1 entries.
+func (s *Service) ListKeys(ctx context.Context, req *ListKeysRequest) (*ListKeysResponse, error) {
+	if err := keyPatterns.ValidatePattern(req.Pattern); err != nil {
+		return nil, fmt.Errorf("invalid pattern: %w", err)
+	}
+	after, err := decodeKeysCursor(req.Cursor)
+	if err != nil {
+		return nil, err
+	}
+	limit := req.Limit
+	if limit <= 0 {
+		limit = defaultKeysLimit
+	}
+	if limit > maxKeysLimit {
+		limit = maxKeysLimit
+	}
+
+	var match func(string) bool
+	if req.Pattern != "" {
+		match = keyPatterns.Compile(req.Pattern)
+	}
+
+	// Each key lives in exactly one L1, so the pages merge without duplicates. One
+	// extra key tells whether another page follows.
+	var keys []KeyInfo
+	for _, l1 := range s.allL1() {
+		keys = append(keys, l1.ScanKeys(after, limit+1, match)...)
+	}
+	sortKeyInfos(keys)
+
+	resp := &ListKeysResponse{Keys: keys}
+	if len(keys) > limit {
+		resp.Keys = keys[:limit]
+		resp.NextCursor = encodeKeysCursor(resp.Keys[limit-1].Key)
+	}
+	if resp.Keys == nil {
+		resp.Keys = []KeyInfo{}
+	}
+	return resp, nil
+}
+
+// The cursor is opaque to clients; it is the last key of the page, URL-safe encoded
+// so any key can round-trip through a query string.
+func encodeKeysCursor(key string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(key))
+}
+
+func decodeKeysCursor(cursor string) (string, error) {
+	key, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return "", fmt.Errorf("invalid cursor: %w", err)
+	}
+	return string(key), nil
+}
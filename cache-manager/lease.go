@@ -0,0 +1,372 @@
+package cachemanager
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"encore.dev/beta/errs"
+)
+
+var (
+	// ErrLeaseHeld is returned when acquiring a lease another owner holds.
+	ErrLeaseHeld = errors.New("lease held by another owner")
+	// ErrLeaseNotHeld is returned when renewing or releasing a lease the caller no
+	// longer holds: it expired, was released, or the owner or token does not match.
+	ErrLeaseNotHeld = errors.New("lease not held")
+	// ErrLeaseNoSharedStore is returned by lease calls in cluster mode without an L2
+	// that supports compare-and-set: a lease table per instance would let every
+	// instance grant the same lease.
+	ErrLeaseNoSharedStore = errors.New("leases in cluster mode need a shared L2 with compare-and-set")
+)
+
+// leaseKeyPrefix namespaces lease records in L2, apart from cache entries.
+const leaseKeyPrefix = "__lease__:"
+
+// leaseRetention is how long an L2 lease record outlives its expiry or release, so
+// the next acquire still sees the last fencing token.
+const leaseRetention = 24 * time.Hour
+
+type AcquireLeaseRequest struct {
+	Owner string `json:"owner"` // Caller-chosen identity, e.g. a hostname or request ID
+	TTL   int    `json:"ttl"`   // seconds, must be positive
+}
+
+type RenewLeaseRequest struct {
+	Owner string `json:"owner"`
+	Token uint64 `json:"token"` // Fencing token returned by acquire
+	TTL   int    `json:"ttl"`   // seconds from now; 0 means the TTL it was acquired with
+}
+
+type ReleaseLeaseRequest struct {
+	Owner string `json:"owner"`
+	Token uint64 `json:"token"`
+}
+
+// Lease is a held lease. Token increases with every acquisition of the same name, so
+// a resource guarded by the lease can reject writes carrying an older token from a
+// holder whose lease has since expired.
+type Lease struct {
+	Name      string    `json:"name"`
+	Owner     string    `json:"owner"`
+	Token     uint64    `json:"token"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// AcquireLease takes the lease on name for ttl seconds and returns its fencing token.
+// Acquiring a lease the owner already holds extends it and keeps the token, so a
+// retried acquire is harmless.
+//
+//encore:api public method=POST path=/api/cache/lease/:name/acquire
+func AcquireLease(ctx context.Context, name string, req *AcquireLeaseRequest) (*Lease, error) {
+	if svc == nil {
+		return nil, errors.New("service not initialized")
+	}
+	return leaseResult(svc.AcquireLease(ctx, name, req))
+}
+
+// RenewLease extends a lease still held by the owner under the given token.
+//
+//encore:api public method=POST path=/api/cache/lease/:name/renew
+func RenewLease(ctx context.Context, name string, req *RenewLeaseRequest) (*Lease, error) {
+	if svc == nil {
+		return nil, errors.New("service not initialized")
+	}
+	return leaseResult(svc.RenewLease(ctx, name, req))
+}
+
+// ReleaseLease frees a lease still held by the owner under the given token.
+//
+//encore:api public method=POST path=/api/cache/lease/:name/release
+func ReleaseLease(ctx context.Context, name string, req *ReleaseLeaseRequest) error {
+	if svc == nil {
+		return errors.New("service not initialized")
+	}
+	_, err := leaseResult(nil, svc.ReleaseLease(ctx, name, req))
+	return err
+}
+
+// GetLease returns the current holder of the lease on name.
+//
+//encore:api public method=GET path=/api/cache/lease/:name
+func GetLease(ctx context.Context, name string) (*Lease, error) {
+	if svc == nil {
+		return nil, errors.New("service not initialized")
+	}
+	return leaseResult(svc.GetLease(ctx, name))
+}
+
+func leaseResult(lease *Lease, err error) (*Lease, error) {
+	switch {
+	case errors.Is(err, ErrNotFound):
+		return nil, errs.WrapCode(err, errs.NotFound, "lease not held")
+	case errors.Is(err, ErrLeaseHeld):
+		return nil, errs.WrapCode(err, errs.AlreadyExists, "lease held by another owner")
+	case errors.Is(err, ErrLeaseNotHeld):
+		return nil, errs.WrapCode(err, errs.FailedPrecondition, "lease not held")
+	case errors.Is(err, ErrLeaseNoSharedStore):
+		return nil, errs.WrapCode(err, errs.FailedPrecondition, "leases need a shared l2 in cluster mode")
+	case errors.Is(err, ErrVersionConflict):
+		return nil, errs.WrapCode(err, errs.Aborted, "lease contention, retry")
+	case errors.Is(err, ErrCircuitOpen):
+		return nil, errs.WrapCode(err, errs.Unavailable, "l2 unavailable")
+	}
+	return lease, err
+}
+
+func (s *Service) AcquireLease(ctx context.Context, name string, req *AcquireLeaseRequest) (*Lease, error) {
+	if req.Owner == "" {
+		return nil, errors.New("owner cannot be empty")
+	}
+	if req.TTL <= 0 {
+		return nil, errors.New("ttl must be positive")
+	}
+	ttl := time.Duration(req.TTL) * time.Second
+
+	state, err := s.updateLease(ctx, name, func(current leaseState, now time.Time) (leaseState, error) {
+		if current.held(now) && current.Owner != req.Owner {
+			s.metrics.LeaseConflicts.Add(1)
+			return current, ErrLeaseHeld
+		}
+		next := leaseState{Owner: req.Owner, Token: current.Token, ExpiresAt: now.Add(ttl), TTL: ttl}
+		if !current.held(now) {
+			next.Token = nextVersion(current.Token)
+		}
+		return next, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	s.metrics.LeaseAcquires.Add(1)
+	return state.lease(name), nil
+}
+
+func (s *Service) RenewLease(ctx context.Context, name string, req *RenewLeaseRequest) (*Lease, error) {
+	if req.TTL < 0 {
+		return nil, errors.New("ttl cannot be negative")
+	}
+	state, err := s.updateLease(ctx, name, func(current leaseState, now time.Time) (leaseState, error) {
+		if !current.heldBy(req.Owner, req.Token, now) {
+			return current, ErrLeaseNotHeld
+		}
+		if req.TTL > 0 {
+			current.TTL = time.Duration(req.TTL) * time.Second
+		}
+		current.ExpiresAt = now.Add(current.TTL)
+		return current, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return state.lease(name), nil
+}
+
+func (s *Service) ReleaseLease(ctx context.Context, name string, req *ReleaseLeaseRequest) error {
+	_, err := s.updateLease(ctx, name, func(current leaseState, now time.Time) (leaseState, error) {
+		if !current.heldBy(req.Owner, req.Token, now) {
+			return current, ErrLeaseNotHeld
+		}
+		// Keep the token so the next acquire still moves past it.
+		return leaseState{Token: current.Token, ExpiresAt: now}, nil
+	})
+	return err
+}
+
+func (s *Service) GetLease(ctx context.Context, name string) (*Lease, error) {
+	if name == "" {
+		return nil, errors.New("lease name cannot be empty")
+	}
+
+	var state leaseState
+	if _, ok, err := s.leaseStore(); err != nil {
+		return nil, err
+	} else if ok {
+		if !s.l2Breaker.Allow() {
+			return nil, ErrCircuitOpen
+		}
+		data, found, err := s.l2Cache.Get(ctx, leaseKeyPrefix+name)
+		s.recordL2(err)
+		if err != nil {
+			return nil, fmt.Errorf("lease: %w", err)
+		}
+		if found {
+			var entry CacheEntry
+			if err := s.decodeL2(data, &entry); err == nil {
+				state, _ = decodeLeaseState(&entry)
+			}
+		}
+	} else {
+		state = s.leases.get(name)
+	}
+
+	if !state.held(time.Now()) {
+		return nil, fmt.Errorf("lease %q: %w", name, ErrNotFound)
+	}
+	return state.lease(name), nil
+}
+
+// leaseState is the record kept per lease name. A released or expired lease keeps its
+// last Token, with Owner cleared or ExpiresAt in the past.
+type leaseState struct {
+	Owner     string        `json:"owner,omitempty"`
+	Token     uint64        `json:"token"`
+	ExpiresAt time.Time     `json:"expires_at"`
+	TTL       time.Duration `json:"ttl"` // Length renewals extend the lease by
+}
+
+func (l leaseState) held(now time.Time) bool {
+	return l.Owner != "" && l.ExpiresAt.After(now)
+}
+
+func (l leaseState) heldBy(owner string, token uint64, now time.Time) bool {
+	return l.held(now) && l.Owner == owner && l.Token == token
+}
+
+func (l leaseState) lease(name string) *Lease {
+	return &Lease{Name: name, Owner: l.Owner, Token: l.Token, ExpiresAt: l.ExpiresAt}
+}
+
+// leaseStore returns the L2 leases are kept in, if any: leases need compare-and-set,
+// so a single instance without it (or without an L2) uses its own lease table. In
+// cluster mode there is no such fallback and it fails with ErrLeaseNoSharedStore.
+func (s *Service) leaseStore() (ConditionalRemoteCache, bool, error) {
+	cas, ok := s.l2Cache.(ConditionalRemoteCache)
+	if ok && s.writesL2() {
+		return cas, true, nil
+	}
+	if s.cluster != nil {
+		return nil, false, ErrLeaseNoSharedStore
+	}
+	return nil, false, nil
+}
+
+// updateLease replaces the lease on name with update's result.
+//
+// Design Notes:
+//   - With a ConditionalRemoteCache the record lives in L2 under leaseKeyPrefix and
+//     is updated with compare-and-set (see casL2), so every instance sharing it
+//     agrees on the holder. It bypasses L1 and the write-behind queue, and fails with
+//     ErrCircuitOpen while the L2 breaker is open rather than granting leases that
+//     other instances cannot see.
+//   - Otherwise, on a single instance only, leases are held in its lease table and
+//     expired ones are dropped by the TTL cleanup loop. Clustered instances refuse
+//     leases instead: each would grant from its own table.
+//   - Lease calls are not routed to an owner. Falling back to local state when the
+//     owner is unreachable, as Get and Set do, would grant a second holder.
+//   - Fencing tokens come from nextVersion over the previous token: strictly
+//     increasing per name while the record is kept (leaseRetention in L2, for the
+//     process lifetime locally), and time-based after that.
+func (s *Service) updateLease(ctx context.Context, name string, update func(current leaseState, now time.Time) (leaseState, error)) (leaseState, error) {
+	if name == "" {
+		return leaseState{}, errors.New("lease name cannot be empty")
+	}
+
+	cas, ok, err := s.leaseStore()
+	if err != nil {
+		return leaseState{}, err
+	}
+	if !ok {
+		return s.leases.update(name, update)
+	}
+
+	var state leaseState
+	_, err = s.casL2(ctx, cas, leaseKeyPrefix+name, nil, func(current *CacheEntry, now time.Time) (*CacheEntry, error) {
+		var prev leaseState
+		if current != nil {
+			var err error
+			if prev, err = decodeLeaseState(current); err != nil {
+				return nil, err
+			}
+		}
+		next, err := update(prev, now)
+		if err != nil {
+			return nil, err
+		}
+		value, err := json.Marshal(next)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal lease: %w", err)
+		}
+		state = next
+		return &CacheEntry{
+			Value:     value,
+			CachedAt:  now,
+			ExpiresAt: next.ExpiresAt.Add(leaseRetention),
+			Version:   next.Token,
+			Source:    "lease",
+		}, nil
+	})
+	if err != nil {
+		return leaseState{}, fmt.Errorf("lease: %w", err)
+	}
+	return state, nil
+}
+
+func decodeLeaseState(entry *CacheEntry) (leaseState, error) {
+	var state leaseState
+	if err := json.Unmarshal(entry.Value, &state); err != nil {
+		return leaseState{}, fmt.Errorf("failed to decode lease: %w", err)
+	}
+	return state, nil
+}
+
+// leaseTable holds leases for a single instance without a shared L2; it is never
+// used in cluster mode. The zero value is ready to use.
+type leaseTable struct {
+	mu     sync.Mutex
+	leases map[string]leaseState
+	fence  uint64 // Highest token issued, carried over when a name is dropped
+}
+
+// update applies update to the lease on name under the table lock.
+// Complexity: O(1).
+func (t *leaseTable) update(name string, update func(current leaseState, now time.Time) (leaseState, error)) (leaseState, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := time.Now()
+	current, ok := t.leases[name]
+	if !ok {
+		current.Token = t.fence
+	}
+	next, err := update(current, now)
+	if err != nil {
+		return leaseState{}, err
+	}
+	if next.Token > t.fence {
+		t.fence = next.Token
+	}
+	if !next.held(now) {
+		delete(t.leases, name)
+		return next, nil
+	}
+	if t.leases == nil {
+		t.leases = make(map[string]leaseState)
+	}
+	t.leases[name] = next
+	return next, nil
+}
+
+func (t *leaseTable) get(name string) leaseState {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.leases[name]
+}
+
+// expire drops leases that expired by now and returns how many.
+// Complexity: O(n) for n held leases.
+func (t *leaseTable) expire(now time.Time) int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	expired := 0
+	for name, lease := range t.leases {
+		if !lease.held(now) {
+			delete(t.leases, name)
+			expired++
+		}
+	}
+	return expired
+}
@@ -0,0 +1,128 @@
+package cachemanager
+
+import (
+	"context"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"encore.app/monitoring"
+)
+
+// Operations reported in monitoring.CacheMetricEvent.
+const (
+	opGet        = "get"
+	opSet        = "set"
+	opDelete     = "delete"
+	opInvalidate = "invalidate"
+)
+
+// MetricEventConfig controls the per-operation events published to
+// monitoring.CacheMetricsTopic, which feed the monitoring dashboards.
+type MetricEventConfig struct {
+	Disabled      bool
+	SampleRate    float64       // Fraction of operations published (default 0.1)
+	FlushInterval time.Duration // How often sampled events are published (default 1s)
+	MaxBatch      int           // Events held between flushes; more are dropped until the next flush (default 1000)
+}
+
+func (c MetricEventConfig) withDefaults() MetricEventConfig {
+	if c.SampleRate <= 0 || c.SampleRate > 1 {
+		c.SampleRate = 0.1
+	}
+	if c.FlushInterval <= 0 {
+		c.FlushInterval = time.Second
+	}
+	if c.MaxBatch <= 0 {
+		c.MaxBatch = 1000
+	}
+	return c
+}
+
+// metricEventSampler samples operations on the serving path into a bounded buffer;
+// runMetricEvents publishes the buffer, so requests never wait on pub/sub.
+type metricEventSampler struct {
+	config   MetricEventConfig
+	instance string
+	drops    *atomic.Int64 // Sampled events dropped because the batch was full
+
+	mu    sync.Mutex
+	batch []*monitoring.CacheMetricEvent
+}
+
+func newMetricEventSampler(config MetricEventConfig, instance string, drops *atomic.Int64) *metricEventSampler {
+	return &metricEventSampler{
+		config:   config.withDefaults(),
+		instance: instance,
+		drops:    drops,
+	}
+}
+
+// record samples one operation that started at start. size is the value size in
+// bytes for get and set, and the number of entries removed for invalidate.
+// Complexity: O(1).
+func (m *metricEventSampler) record(op, key string, hit bool, start time.Time, size int) {
+	if m.config.SampleRate < 1 && rand.Float64() >= m.config.SampleRate {
+		return
+	}
+	now := time.Now()
+	event := &monitoring.CacheMetricEvent{
+		Operation:  op,
+		Key:        key,
+		Hit:        hit,
+		Latency:    float64(now.Sub(start)) / float64(time.Millisecond),
+		Size:       size,
+		SampleRate: m.config.SampleRate,
+		Timestamp:  now,
+		Instance:   m.instance,
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if len(m.batch) >= m.config.MaxBatch {
+		m.drops.Add(1)
+		return
+	}
+	m.batch = append(m.batch, event)
+}
+
+// take returns and clears the pending events.
+func (m *metricEventSampler) take() []*monitoring.CacheMetricEvent {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	batch := m.batch
+	m.batch = nil
+	return batch
+}
+
+// recordOp reports an operation to the sampler, if metric events are enabled.
+func (s *Service) recordOp(op, key string, hit bool, start time.Time, size int) {
+	if s.metricEvents != nil {
+		s.metricEvents.record(op, key, hit, start, size)
+	}
+}
+
+// runMetricEvents publishes sampled operations every FlushInterval until stopChan
+// closes, then publishes what is left.
+func (s *Service) runMetricEvents() {
+	defer s.wg.Done()
+	ticker := time.NewTicker(s.metricEvents.config.FlushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stopChan:
+			s.publishMetricEvents(context.Background())
+			return
+		case <-ticker.C:
+			s.publishMetricEvents(context.Background())
+		}
+	}
+}
+
+func (s *Service) publishMetricEvents(ctx context.Context) {
+	for _, event := range s.metricEvents.take() {
+		_, _ = monitoring.CacheMetricsTopic.Publish(ctx, event)
+	}
+}
@@ -0,0 +1,502 @@
+package cachemanager
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultNamespace names the shared keyspace holding keys that do not belong to a
+// registered namespace. It is reported in metrics but cannot be created or updated.
+const DefaultNamespace = "default"
+
+// NamespaceSeparator ends the namespace prefix of a key: "tenant-a:user:123" belongs
+// to namespace "tenant-a" once that namespace has been created.
+const NamespaceSeparator = ":"
+
+// namespaceRegistryKey is the L2 record holding every namespace's configuration.
+const namespaceRegistryKey = "__namespaces__"
+
+// namespace is a tenant-isolated slice of L1 with its own quotas and default TTL.
+//
+// Design Notes:
+//   - Each namespace owns a separate L1Cache (own shards, policy and budgets), so a
+//     tenant filling its quota only ever evicts its own entries.
+//   - Membership is by key prefix, so no request or L2 format change is needed and
+//     every code path that sees a key can route it.
+//   - Hits and misses are counted per namespace in addition to the service totals;
+//     the default namespace's share is the remainder.
+type namespace struct {
+	name string
+	l1   *L1Cache
+
+	defaultTTL atomic.Int64 // nanoseconds; 0 = Config.DefaultTTL
+	hits       atomic.Int64
+	misses     atomic.Int64
+
+	mu        sync.Mutex // guards config and updatedAt
+	config    NamespaceConfig
+	updatedAt time.Time
+}
+
+// NamespaceConfig describes a namespace's quotas.
+type NamespaceConfig struct {
+	Name       string `json:"name"`
+	MaxEntries int    `json:"max_entries"`          // L1 entry quota
+	MaxBytes   int64  `json:"max_bytes"`            // L1 key+value byte quota (0 = unlimited)
+	DefaultTTL int    `json:"default_ttl"`          // seconds, 0 means Config.DefaultTTL
+	Policy     string `json:"policy,omitempty"`     // Eviction policy, fixed at creation ("" = lru)
+	UpdatedAt  int64  `json:"updated_at,omitempty"` // Unix nanoseconds of the last change (set by the service)
+}
+
+type UpdateNamespaceRequest struct {
+	MaxEntries int   `json:"max_entries"`
+	MaxBytes   int64 `json:"max_bytes"`
+	DefaultTTL int   `json:"default_ttl"`
+}
+
+type NamespaceInfo struct {
+	NamespaceConfig
+	Size  int   `json:"size"`  // Current L1 entries
+	Bytes int64 `json:"bytes"` // Current L1 key+value bytes
+}
+
+type ListNamespacesResponse struct {
+	Namespaces []NamespaceInfo `json:"namespaces"`
+}
+
+// NamespaceMetrics is the per-namespace breakdown in MetricsResponse.
+type NamespaceMetrics struct {
+	Hits    int64   `json:"hits"`
+	Misses  int64   `json:"misses"`
+	HitRate float64 `json:"hit_rate"`
+	Size    int     `json:"size"`
+	Bytes   int64   `json:"bytes"`
+}
+
+// CreateNamespace registers a namespace with its own L1 quota. Keys prefixed with
+// "<name>:" are routed to it from then on; entries already cached under that prefix
+// in the default namespace are dropped so they cannot shadow the new namespace.
+// The change is broadcast so every instance applies the same quotas.
+//
+//encore:api public method=POST path=/api/cache/namespaces
+func CreateNamespace(ctx context.Context, req *NamespaceConfig) (*NamespaceInfo, error) {
+	if svc == nil {
+		return nil, errors.New("service not initialized")
+	}
+	return svc.CreateNamespace(ctx, req)
+}
+
+func (s *Service) CreateNamespace(ctx context.Context, req *NamespaceConfig) (*NamespaceInfo, error) {
+	cfg := *req
+	cfg.UpdatedAt = time.Now().UnixNano()
+	if err := validateNamespaceConfig(cfg); err != nil {
+		return nil, err
+	}
+
+	ns, err := s.createNamespace(cfg)
+	if err != nil {
+		return nil, err
+	}
+	s.publishNamespace(ctx, cfg)
+	if err := s.storeNamespace(ctx, cfg); err != nil {
+		return nil, fmt.Errorf("namespace %q created but not stored, repeat it as an update: %w", cfg.Name, err)
+	}
+	return ns.info(), nil
+}
+
+// createNamespace registers cfg unless its name is taken. The check and the insert
+// happen under one namespaceMu hold, so of concurrent creates only one succeeds.
+func (s *Service) createNamespace(cfg NamespaceConfig) (*namespace, error) {
+	s.namespaceMu.Lock()
+	defer s.namespaceMu.Unlock()
+
+	if _, exists := s.namespaces.Load(cfg.Name); exists {
+		return nil, fmt.Errorf("namespace %q already exists", cfg.Name)
+	}
+	return s.applyNamespaceLocked(cfg)
+}
+
+// ListNamespaces returns every registered namespace with its current usage.
+//
+//encore:api public method=GET path=/api/cache/namespaces
+func ListNamespaces(ctx context.Context) (*ListNamespacesResponse, error) {
+	if svc == nil {
+		return nil, errors.New("service not initialized")
+	}
+	return svc.ListNamespaces(ctx)
+}
+
+func (s *Service) ListNamespaces(ctx context.Context) (*ListNamespacesResponse, error) {
+	namespaces := make([]NamespaceInfo, 0)
+	s.rangeNamespaces(func(ns *namespace) {
+		namespaces = append(namespaces, *ns.info())
+	})
+	sort.Slice(namespaces, func(i, j int) bool { return namespaces[i].Name < namespaces[j].Name })
+	return &ListNamespacesResponse{Namespaces: namespaces}, nil
+}
+
+// UpdateNamespace changes a namespace's quotas and default TTL. Shrinking a quota
+// evicts the namespace's own entries immediately; the eviction policy is fixed.
+//
+//encore:api public method=PUT path=/api/cache/namespaces/:name
+func UpdateNamespace(ctx context.Context, name string, req *UpdateNamespaceRequest) (*NamespaceInfo, error) {
+	if svc == nil {
+		return nil, errors.New("service not initialized")
+	}
+	return svc.UpdateNamespace(ctx, name, req)
+}
+
+func (s *Service) UpdateNamespace(ctx context.Context, name string, req *UpdateNamespaceRequest) (*NamespaceInfo, error) {
+	v, ok := s.namespaces.Load(name)
+	if !ok {
+		return nil, fmt.Errorf("namespace %q not found", name)
+	}
+	ns := v.(*namespace)
+
+	ns.mu.Lock()
+	cfg := ns.config
+	ns.mu.Unlock()
+	cfg.MaxEntries = req.MaxEntries
+	cfg.MaxBytes = req.MaxBytes
+	cfg.DefaultTTL = req.DefaultTTL
+	cfg.UpdatedAt = time.Now().UnixNano()
+	if err := validateNamespaceConfig(cfg); err != nil {
+		return nil, err
+	}
+
+	if _, err := s.applyNamespace(cfg); err != nil {
+		return nil, err
+	}
+	s.publishNamespace(ctx, cfg)
+	if err := s.storeNamespace(ctx, cfg); err != nil {
+		return nil, fmt.Errorf("namespace %q updated but not stored, repeat the update: %w", cfg.Name, err)
+	}
+	return ns.info(), nil
+}
+
+func validateNamespaceConfig(cfg NamespaceConfig) error {
+	if cfg.Name == "" {
+		return errors.New("namespace name cannot be empty")
+	}
+	if cfg.Name == DefaultNamespace {
+		return fmt.Errorf("namespace name %q is reserved", DefaultNamespace)
+	}
+	if len(cfg.Name) > 64 {
+		return errors.New("namespace name cannot exceed 64 characters")
+	}
+	// Names become key and Redis glob prefixes, so keep them to plain characters.
+	for _, r := range cfg.Name {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_') {
+			return fmt.Errorf("namespace name %q may only contain letters, digits, '-' and '_'", cfg.Name)
+		}
+	}
+	if cfg.MaxEntries <= 0 {
+		return errors.New("max_entries must be positive")
+	}
+	if cfg.MaxBytes < 0 {
+		return errors.New("max_bytes cannot be negative")
+	}
+	if cfg.DefaultTTL < 0 {
+		return errors.New("default_ttl cannot be negative")
+	}
+	if _, err := PolicyFactoryByName(cfg.Policy); err != nil {
+		return err
+	}
+	return nil
+}
+
+// applyNamespace creates or updates the namespace described by cfg. It is used by
+// the admin endpoints and by the broadcast handler, so it is idempotent and ignores
+// a cfg older than the namespace's current configuration.
+func (s *Service) applyNamespace(cfg NamespaceConfig) (*namespace, error) {
+	s.namespaceMu.Lock()
+	defer s.namespaceMu.Unlock()
+	return s.applyNamespaceLocked(cfg)
+}
+
+// applyNamespaceLocked is applyNamespace for callers holding namespaceMu.
+func (s *Service) applyNamespaceLocked(cfg NamespaceConfig) (*namespace, error) {
+	updatedAt := time.Unix(0, cfg.UpdatedAt)
+
+	if v, ok := s.namespaces.Load(cfg.Name); ok {
+		ns := v.(*namespace)
+		ns.mu.Lock()
+		defer ns.mu.Unlock()
+		if updatedAt.Before(ns.updatedAt) {
+			return ns, nil
+		}
+		if cfg.Policy != ns.config.Policy {
+			return nil, fmt.Errorf("namespace %q: eviction policy cannot be changed", cfg.Name)
+		}
+		ns.l1.Resize(cfg.MaxEntries, cfg.MaxBytes)
+		ns.defaultTTL.Store(int64(time.Duration(cfg.DefaultTTL) * time.Second))
+		ns.config = cfg
+		ns.updatedAt = updatedAt
+		return ns, nil
+	}
+
+	l1Config := s.config
+	l1Config.L1MaxEntries = cfg.MaxEntries
+	l1Config.L1MaxBytes = cfg.MaxBytes
+	l1Config.L1Policy = cfg.Policy
+	l1, err := newL1CacheFromConfig(l1Config)
+	if err != nil {
+		return nil, err
+	}
+	s.watchEvictions(l1)
+	ns := &namespace{
+		name:      cfg.Name,
+		l1:        l1,
+		config:    cfg,
+		updatedAt: updatedAt,
+	}
+	ns.defaultTTL.Store(int64(time.Duration(cfg.DefaultTTL) * time.Second))
+
+	s.namespaces.Store(cfg.Name, ns)
+	s.namespaceCount.Add(1)
+
+	// Keys with this prefix were cached in the default namespace until now.
+	s.l1Cache.DeletePattern(cfg.Name + NamespaceSeparator + "*")
+	return ns, nil
+}
+
+// publishNamespace broadcasts cfg so other instances apply it. Failures are ignored:
+// the local change stands, and an admin can repeat the update.
+func (s *Service) publishNamespace(ctx context.Context, cfg NamespaceConfig) {
+	_, _ = NamespaceTopic.Publish(ctx, &NamespaceEvent{Config: cfg})
+}
+
+// storeNamespace records cfg in the namespace registry in L2, unless the registry
+// already holds a newer configuration for the name.
+//
+// Design Notes:
+//   - The registry is a single persistent L2 record (namespaceRegistryKey) mapping
+//     names to configurations. Instances load it at startup (loadNamespaces) and
+//     NamespaceTopic keeps running instances current, so an instance that starts
+//     after a namespace was created still routes its keys.
+//   - With a ConditionalRemoteCache the record is updated with compare-and-set (see
+//     casL2), so concurrent changes on different instances are all kept. Otherwise
+//     this instance serializes its own updates, and a concurrent change on another
+//     instance can be lost from the registry until that namespace is next updated.
+//   - Without an L2, namespaces last as long as the process.
+func (s *Service) storeNamespace(ctx context.Context, cfg NamespaceConfig) error {
+	if !s.writesL2() {
+		return nil
+	}
+	update := func(current *CacheEntry, now time.Time) (*CacheEntry, error) {
+		registry := make(map[string]NamespaceConfig)
+		if current != nil {
+			// An unreadable registry is replaced; its namespaces return with their next update.
+			_ = json.Unmarshal(current.Value, &registry)
+		}
+		if prev, ok := registry[cfg.Name]; ok && prev.UpdatedAt > cfg.UpdatedAt {
+			return current, nil
+		}
+		registry[cfg.Name] = cfg
+		value, err := json.Marshal(registry)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal namespace registry: %w", err)
+		}
+		return &CacheEntry{Value: value, CachedAt: now, ExpiresAt: noExpiry, Source: "namespace"}, nil
+	}
+
+	if cas, ok := s.l2Cache.(ConditionalRemoteCache); ok {
+		_, err := s.casL2(ctx, cas, namespaceRegistryKey, nil, update)
+		return err
+	}
+
+	s.registryMu.Lock()
+	defer s.registryMu.Unlock()
+	current, err := s.readNamespaceRegistry(ctx)
+	if err != nil {
+		return err
+	}
+	next, err := update(current, time.Now())
+	if err != nil {
+		return err
+	}
+	data, err := s.encodeL2(next)
+	if err != nil {
+		return fmt.Errorf("failed to marshal entry: %w", err)
+	}
+	if !s.l2Breaker.Allow() {
+		return ErrCircuitOpen
+	}
+	err = s.l2Cache.Set(ctx, namespaceRegistryKey, data, 0)
+	s.recordL2(err)
+	return err
+}
+
+// loadNamespaces applies every namespace in the L2 registry. Configurations older
+// than what this instance already holds are ignored, and invalid ones are skipped.
+func (s *Service) loadNamespaces(ctx context.Context) error {
+	if !s.writesL2() {
+		return nil
+	}
+	current, err := s.readNamespaceRegistry(ctx)
+	if err != nil || current == nil {
+		return err
+	}
+	registry := make(map[string]NamespaceConfig)
+	if err := json.Unmarshal(current.Value, &registry); err != nil {
+		return fmt.Errorf("failed to decode namespace registry: %w", err)
+	}
+	for _, cfg := range registry {
+		if validateNamespaceConfig(cfg) == nil {
+			_, _ = s.applyNamespace(cfg)
+		}
+	}
+	return nil
+}
+
+// readNamespaceRegistry returns the registry record, or nil if there is none.
+func (s *Service) readNamespaceRegistry(ctx context.Context) (*CacheEntry, error) {
+	if !s.l2Breaker.Allow() {
+		return nil, ErrCircuitOpen
+	}
+	data, found, err := s.l2Cache.Get(ctx, namespaceRegistryKey)
+	s.recordL2(err)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read namespace registry: %w", err)
+	}
+	if !found {
+		return nil, nil
+	}
+	var entry CacheEntry
+	if err := s.decodeL2(data, &entry); err != nil {
+		return nil, fmt.Errorf("failed to decode namespace registry: %w", err)
+	}
+	return &entry, nil
+}
+
+// namespaceFor returns the registered namespace owning key, or nil for the default
+// namespace. Lock-free; a single map lookup when namespaces exist.
+func (s *Service) namespaceFor(key string) *namespace {
+	if s.namespaceCount.Load() == 0 {
+		return nil
+	}
+	i := strings.Index(key, NamespaceSeparator)
+	if i <= 0 {
+		return nil
+	}
+	if v, ok := s.namespaces.Load(key[:i]); ok {
+		return v.(*namespace)
+	}
+	return nil
+}
+
+// l1For returns the L1 cache holding key.
+func (s *Service) l1For(key string) *L1Cache {
+	if ns := s.namespaceFor(key); ns != nil {
+		return ns.l1
+	}
+	return s.l1Cache
+}
+
+// defaultTTLFor returns the default TTL for key's namespace.
+func (s *Service) defaultTTLFor(key string) time.Duration {
+	if ns := s.namespaceFor(key); ns != nil {
+		if ttl := time.Duration(ns.defaultTTL.Load()); ttl > 0 {
+			return ttl
+		}
+	}
+	return s.config.DefaultTTL
+}
+
+// recordHit and recordMiss count a lookup for the service and key's namespace.
+func (s *Service) recordHit(key string) {
+	s.metrics.Hits.Add(1)
+	if ns := s.namespaceFor(key); ns != nil {
+		ns.hits.Add(1)
+	}
+}
+
+func (s *Service) recordMiss(key string) {
+	s.metrics.Misses.Add(1)
+	if ns := s.namespaceFor(key); ns != nil {
+		ns.misses.Add(1)
+	}
+}
+
+func (s *Service) rangeNamespaces(fn func(ns *namespace)) {
+	s.namespaces.Range(func(_, v interface{}) bool {
+		fn(v.(*namespace))
+		return true
+	})
+}
+
+// allL1 returns the default L1 followed by every namespace's L1.
+func (s *Service) allL1() []*L1Cache {
+	caches := []*L1Cache{s.l1Cache}
+	s.rangeNamespaces(func(ns *namespace) {
+		caches = append(caches, ns.l1)
+	})
+	return caches
+}
+
+// flushNamespace drops every entry of the named namespace from L1 and L2.
+// Returns the number of L1 entries removed.
+func (s *Service) flushNamespace(ctx context.Context, name string) (int, error) {
+	v, ok := s.namespaces.Load(name)
+	if !ok {
+		return 0, fmt.Errorf("namespace %q not found", name)
+	}
+	l1 := v.(*namespace).l1
+	count := l1.Size()
+	l1.Clear()
+
+	s.deleteL2(func() error { return s.l2Cache.DeletePattern(ctx, name+NamespaceSeparator+"*") })
+	return count, nil
+}
+
+// namespaceMetrics breaks hits, misses and L1 usage down by namespace. The default
+// namespace is credited with whatever the registered namespaces did not count.
+// Returns nil when no namespaces are registered.
+func (s *Service) namespaceMetrics(hits, misses int64) map[string]NamespaceMetrics {
+	if s.namespaceCount.Load() == 0 {
+		return nil
+	}
+
+	result := make(map[string]NamespaceMetrics)
+	defaultHits, defaultMisses := hits, misses
+	s.rangeNamespaces(func(ns *namespace) {
+		m := newNamespaceMetrics(ns.hits.Load(), ns.misses.Load(), ns.l1)
+		defaultHits -= m.Hits
+		defaultMisses -= m.Misses
+		result[ns.name] = m
+	})
+	result[DefaultNamespace] = newNamespaceMetrics(defaultHits, defaultMisses, s.l1Cache)
+	return result
+}
+
+func newNamespaceMetrics(hits, misses int64, l1 *L1Cache) NamespaceMetrics {
+	hitRate := 0.0
+	if hits+misses > 0 {
+		hitRate = float64(hits) / float64(hits+misses)
+	}
+	return NamespaceMetrics{
+		Hits:    hits,
+		Misses:  misses,
+		HitRate: hitRate,
+		Size:    l1.Size(),
+		Bytes:   l1.Bytes(),
+	}
+}
+
+func (ns *namespace) info() *NamespaceInfo {
+	ns.mu.Lock()
+	cfg := ns.config
+	ns.mu.Unlock()
+	return &NamespaceInfo{
+		NamespaceConfig: cfg,
+		Size:            ns.l1.Size(),
+		Bytes:           ns.l1.Bytes(),
+	}
+}
@@ -1,10 +1,15 @@
 package cachemanager
 
 import (
+	"container/list"
+	"fmt"
 	"time"
 )
 
 // EvictionPolicy defines the interface for cache eviction strategies.
+//
+// L1Cache gives each shard its own policy instance and calls it while holding the
+// shard's write lock, so implementations do not need to be safe for concurrent use.
 type EvictionPolicy interface {
 	// ShouldEvict returns true if an entry should be evicted based on policy.
 	ShouldEvict(entry *CacheEntry, now time.Time) bool
@@ -12,9 +17,59 @@ type EvictionPolicy interface {
 	OnAccess(key string)
 	// OnSet is called when an entry is created/updated.
 	OnSet(key string, value interface{}, ttl time.Duration)
+	// OnRemove is called when an entry leaves the cache for any reason other than
+	// being returned from Victim (delete, invalidation, expiry).
+	OnRemove(key string)
+	// Victim selects the next entry to evict for capacity and stops tracking it.
+	// Returns false if the policy has nothing to evict.
+	Victim() (string, bool)
+}
+
+// OrderedPolicy is implemented by policies that can list their keys in eviction
+// order. L1Cache.Snapshot uses it so a restored cache keeps its recency ordering.
+type OrderedPolicy interface {
+	// Keys returns every tracked key, next victim first. It must not change state.
+	Keys() []string
+}
+
+// PolicyFactory creates a policy for a cache segment holding up to capacity entries.
+type PolicyFactory func(capacity int) EvictionPolicy
+
+// Eviction policy names accepted by PolicyFactoryByName and Config.L1Policy.
+const (
+	PolicyLRU     = "lru"
+	PolicyTinyLFU = "tinylfu"
+)
+
+// NewLRUPolicyFactory returns a factory producing LRU policies.
+func NewLRUPolicyFactory() PolicyFactory {
+	return func(capacity int) EvictionPolicy {
+		return NewLRUPolicy()
+	}
+}
+
+// NewTinyLFUPolicyFactory returns a factory producing W-TinyLFU policies.
+func NewTinyLFUPolicyFactory() PolicyFactory {
+	return func(capacity int) EvictionPolicy {
+		return NewTinyLFUPolicy(capacity)
+	}
+}
+
+// PolicyFactoryByName resolves a policy name ("lru", "tinylfu") to its factory.
+// An empty name selects LRU.
+func PolicyFactoryByName(name string) (PolicyFactory, error) {
+	switch name {
+	case "", PolicyLRU:
+		return NewLRUPolicyFactory(), nil
+	case PolicyTinyLFU:
+		return NewTinyLFUPolicyFactory(), nil
+	default:
+		return nil, fmt.Errorf("unknown eviction policy %q", name)
+	}
 }
 
 // TTLPolicy implements time-to-live based eviction.
+// It never selects capacity victims, so it is not suitable on its own for L1.
 type TTLPolicy struct{}
 
 // NewTTLPolicy creates a new TTL-based eviction policy.
@@ -33,29 +88,78 @@ func (p *TTLPolicy) OnAccess(key string) {
 func (p *TTLPolicy) OnSet(key string, value interface{}, ttl time.Duration) {
 }
 
-// LRUPolicy implements least-recently-used eviction.
-// This is implicitly handled by the L1Cache's internal LRU list,
-// but this interface allows for future policy extensions.
+// OnRemove is a no-op for TTL policy.
+func (p *TTLPolicy) OnRemove(key string) {
+}
+
+// Victim never selects an entry; TTL eviction is driven by expiry, not capacity.
+func (p *TTLPolicy) Victim() (string, bool) {
+	return "", false
+}
+
+// LRUPolicy implements least-recently-used eviction with a doubly-linked list.
+// Front = most recently used, back = next victim.
 type LRUPolicy struct {
+	order *list.List
+	items map[string]*list.Element
 }
 
 func NewLRUPolicy() *LRUPolicy {
-	return &LRUPolicy{}
+	return &LRUPolicy{
+		order: list.New(),
+		items: make(map[string]*list.Element),
+	}
 }
 
 func (p *LRUPolicy) ShouldEvict(entry *CacheEntry, now time.Time) bool {
-	// This method exists for interface compliance
+	// Capacity eviction goes through Victim
 	return false
 }
 
-// OnAccess updates LRU ordering (handled by L1Cache.Get moving to front).
+// OnAccess moves key to the most recently used position.
 func (p *LRUPolicy) OnAccess(key string) {
-	// Handled by L1Cache internal list operations
+	if elem, ok := p.items[key]; ok {
+		p.order.MoveToFront(elem)
+	}
 }
 
-// OnSet updates LRU ordering (handled by L1Cache.Set).
+// OnSet inserts key as most recently used, or refreshes its position.
 func (p *LRUPolicy) OnSet(key string, value interface{}, ttl time.Duration) {
-	// Handled by L1Cache internal list operations
+	if elem, ok := p.items[key]; ok {
+		p.order.MoveToFront(elem)
+		return
+	}
+	p.items[key] = p.order.PushFront(key)
+}
+
+// OnRemove stops tracking key.
+func (p *LRUPolicy) OnRemove(key string) {
+	if elem, ok := p.items[key]; ok {
+		p.order.Remove(elem)
+		delete(p.items, key)
+	}
+}
+
+// Victim returns the least recently used key.
+// Complexity: O(1).
+func (p *LRUPolicy) Victim() (string, bool) {
+	oldest := p.order.Back()
+	if oldest == nil {
+		return "", false
+	}
+	key := oldest.Value.(string)
+	p.order.Remove(oldest)
+	delete(p.items, key)
+	return key, true
+}
+
+// Keys returns keys from least to most recently used.
+func (p *LRUPolicy) Keys() []string {
+	keys := make([]string, 0, p.order.Len())
+	for elem := p.order.Back(); elem != nil; elem = elem.Prev() {
+		keys = append(keys, elem.Value.(string))
+	}
+	return keys
 }
 
 // CombinedPolicy applies both TTL and LRU eviction.
@@ -89,6 +193,17 @@ func (p *CombinedPolicy) OnSet(key string, value interface{}, ttl time.Duration)
 	p.lru.OnSet(key, value, ttl)
 }
 
+// OnRemove updates both policies.
+func (p *CombinedPolicy) OnRemove(key string) {
+	p.ttl.OnRemove(key)
+	p.lru.OnRemove(key)
+}
+
+// Victim delegates capacity eviction to LRU.
+func (p *CombinedPolicy) Victim() (string, bool) {
+	return p.lru.Victim()
+}
+
 // PolicyEngine manages eviction policy application.
 type PolicyEngine struct {
 	policy EvictionPolicy
@@ -117,4 +232,14 @@ func (e *PolicyEngine) RecordAccess(key string) {
 // RecordSet notifies policy of entry creation/update.
 func (e *PolicyEngine) RecordSet(key string, value interface{}, ttl time.Duration) {
 	e.policy.OnSet(key, value, ttl)
-}
\ No newline at end of file
+}
+
+// RecordRemove notifies policy that an entry was removed.
+func (e *PolicyEngine) RecordRemove(key string) {
+	e.policy.OnRemove(key)
+}
+
+// Victim asks the policy for the next capacity eviction candidate.
+func (e *PolicyEngine) Victim() (string, bool) {
+	return e.policy.Victim()
+}
@@ -0,0 +1,822 @@
+package cachemanager
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// RedisConfig holds connection settings for the Redis-backed L2 cache.
+type RedisConfig struct {
+	Addr           string        // host:port of the Redis server
+	Password       string        // AUTH password (empty = no auth)
+	DB             int           // Logical database selected after connect
+	PoolSize       int           // Maximum open connections
+	DialTimeout    time.Duration // Timeout for establishing a connection
+	CommandTimeout time.Duration // Per-command read/write deadline
+	KeyPrefix      string        // Prefix applied to every key (namespaces the cache in shared Redis)
+	ScanCount      int           // COUNT hint for SCAN during DeletePattern
+}
+
+// DefaultRedisConfig returns settings matching infra/local/docker-compose.yml.
+func DefaultRedisConfig() RedisConfig {
+	return RedisConfig{
+		Addr:           "localhost:6379",
+		PoolSize:       10,
+		DialTimeout:    5 * time.Second,
+		CommandTimeout: 3 * time.Second,
+		KeyPrefix:      "cache:",
+		ScanCount:      100,
+	}
+}
+
+// RedisConfigFromEnv overlays the REDIS_* and L2_CACHE_* variables documented in
+// .env.example onto DefaultRedisConfig. Invalid numeric values are ignored.
+func RedisConfigFromEnv() RedisConfig {
+	cfg := DefaultRedisConfig()
+
+	host := os.Getenv("REDIS_HOST")
+	port := os.Getenv("REDIS_PORT")
+	if host != "" || port != "" {
+		if host == "" {
+			host = "localhost"
+		}
+		if port == "" {
+			port = "6379"
+		}
+		cfg.Addr = net.JoinHostPort(host, port)
+	}
+	if v, ok := os.LookupEnv("REDIS_PASSWORD"); ok {
+		cfg.Password = v
+	}
+	if v, err := strconv.Atoi(os.Getenv("REDIS_DB")); err == nil {
+		cfg.DB = v
+	}
+	if v, err := strconv.Atoi(os.Getenv("REDIS_POOL_SIZE")); err == nil && v > 0 {
+		cfg.PoolSize = v
+	}
+	if v, err := strconv.Atoi(os.Getenv("REDIS_CONNECT_TIMEOUT")); err == nil && v > 0 {
+		cfg.DialTimeout = time.Duration(v) * time.Millisecond
+	}
+	if v, err := strconv.Atoi(os.Getenv("REDIS_COMMAND_TIMEOUT")); err == nil && v > 0 {
+		cfg.CommandTimeout = time.Duration(v) * time.Millisecond
+	}
+	if v, ok := os.LookupEnv("L2_CACHE_KEY_PREFIX"); ok {
+		cfg.KeyPrefix = v
+	}
+
+	return cfg
+}
+
+// RedisError is an error reply ("-ERR ...") returned by the server.
+// The connection remains usable after a RedisError.
+type RedisError string
+
+func (e RedisError) Error() string { return string(e) }
+
+// ErrRedisClosed is returned when a command is issued on a closed RedisCache.
+var ErrRedisClosed = errors.New("redis: cache closed")
+
+// RedisCache implements RemoteCache over the Redis serialization protocol (RESP2)
+// using only the standard library.
+//
+// Design Notes:
+//   - Fixed-size connection pool: a semaphore bounds open connections at PoolSize,
+//     and idle connections are parked in a buffered channel for reuse.
+//   - Every command gets a deadline of min(ctx deadline, CommandTimeout).
+//   - Connections that hit I/O or protocol errors are discarded, never returned to the pool.
+//   - DeletePattern uses SCAN + DEL instead of KEYS to avoid blocking the server.
+type RedisCache struct {
+	config RedisConfig
+	idle   chan *redisConn
+	slots  chan struct{}
+	closed atomic.Bool
+
+	dialed atomic.Int64 // total connections opened (for tests and diagnostics)
+}
+
+// redisConn is a single pooled connection with buffered I/O.
+type redisConn struct {
+	conn net.Conn
+	br   *bufio.Reader
+	bw   *bufio.Writer
+}
+
+// NewRedisCache creates a Redis-backed RemoteCache. Connections are established
+// lazily on first use, so an unreachable server does not fail startup.
+func NewRedisCache(config RedisConfig) *RedisCache {
+	defaults := DefaultRedisConfig()
+	if config.Addr == "" {
+		config.Addr = defaults.Addr
+	}
+	if config.PoolSize <= 0 {
+		config.PoolSize = defaults.PoolSize
+	}
+	if config.DialTimeout <= 0 {
+		config.DialTimeout = defaults.DialTimeout
+	}
+	if config.CommandTimeout <= 0 {
+		config.CommandTimeout = defaults.CommandTimeout
+	}
+	if config.ScanCount <= 0 {
+		config.ScanCount = defaults.ScanCount
+	}
+
+	return &RedisCache{
+		config: config,
+		idle:   make(chan *redisConn, config.PoolSize),
+		slots:  make(chan struct{}, config.PoolSize),
+	}
+}
+
+// Get fetches the raw value for key. Returns (nil, false, nil) when the key is absent.
+func (r *RedisCache) Get(ctx context.Context, key string) ([]byte, bool, error) {
+	reply, err := r.do(ctx, "GET", r.prefixed(key))
+	if err != nil {
+		return nil, false, err
+	}
+	if reply == nil {
+		return nil, false, nil
+	}
+	data, ok := reply.([]byte)
+	if !ok {
+		return nil, false, fmt.Errorf("redis: unexpected GET reply %T", reply)
+	}
+	return data, true, nil
+}
+
+// Set stores value under key. A positive ttl is applied with millisecond precision.
+func (r *RedisCache) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	_, err := r.do(ctx, r.setArgs(key, value, ttl)...)
+	return err
+}
+
+// GetMulti fetches keys with a single MGET. Missing keys are omitted from the result.
+func (r *RedisCache) GetMulti(ctx context.Context, keys []string) (map[string][]byte, error) {
+	found := make(map[string][]byte, len(keys))
+	if len(keys) == 0 {
+		return found, nil
+	}
+
+	args := make([]interface{}, 0, len(keys)+1)
+	args = append(args, "MGET")
+	for _, key := range keys {
+		args = append(args, r.prefixed(key))
+	}
+
+	reply, err := r.do(ctx, args...)
+	if err != nil {
+		return nil, err
+	}
+	values, ok := reply.([]interface{})
+	if !ok || len(values) != len(keys) {
+		return nil, fmt.Errorf("redis: unexpected MGET reply %T", reply)
+	}
+	for i, v := range values {
+		if data, ok := v.([]byte); ok && data != nil {
+			found[keys[i]] = data
+		}
+	}
+	return found, nil
+}
+
+// SetMulti stores items with per-item TTLs by pipelining SET commands on one
+// connection (MSET cannot set expiries). Returns the first error reply, if any.
+func (r *RedisCache) SetMulti(ctx context.Context, items []RemoteCacheItem) error {
+	if len(items) == 0 {
+		return nil
+	}
+
+	cmds := make([][]interface{}, len(items))
+	for i, item := range items {
+		cmds[i] = r.setArgs(item.Key, item.Value, item.TTL)
+	}
+
+	replies, err := r.pipeline(ctx, cmds)
+	if err != nil {
+		return err
+	}
+	for _, reply := range replies {
+		if rerr, ok := reply.(RedisError); ok {
+			return rerr
+		}
+	}
+	return nil
+}
+
+// compareAndSetScript atomically replaces KEYS[1] with ARGV[3] if its current value
+// equals ARGV[2] (ARGV[1] = "1") or it is absent (ARGV[1] = "0"). ARGV[4] is the
+// expiry in milliseconds, 0 for none. Returns 1 if the value was written.
+const compareAndSetScript = `local cur = redis.call('GET', KEYS[1])
+if ARGV[1] == '0' then
+  if cur then return 0 end
+elseif cur ~= ARGV[2] then
+  return 0
+end
+if tonumber(ARGV[4]) > 0 then
+  redis.call('SET', KEYS[1], ARGV[3], 'PX', ARGV[4])
+else
+  redis.call('SET', KEYS[1], ARGV[3])
+end
+return 1`
+
+// CompareAndSet stores value only if key currently holds old, or is absent when old
+// is nil. The check and write run server-side in one Lua script, so concurrent
+// writers from any instance are serialized by Redis.
+// Complexity: O(1) plus one round trip. The script is sent with EVAL on each call
+// (a few hundred bytes) rather than cached with EVALSHA, which avoids NOSCRIPT
+// handling after a server restart or failover.
+func (r *RedisCache) CompareAndSet(ctx context.Context, key string, old, value []byte, ttl time.Duration) (bool, error) {
+	hasOld := "0"
+	if old != nil {
+		hasOld = "1"
+	}
+	var ms int64
+	if ttl > 0 {
+		ms = ttl.Milliseconds()
+		if ms <= 0 {
+			ms = 1
+		}
+	}
+
+	reply, err := r.do(ctx, "EVAL", compareAndSetScript, 1, r.prefixed(key), hasOld, old, value, ms)
+	if err != nil {
+		return false, err
+	}
+	n, ok := reply.(int64)
+	if !ok {
+		return false, fmt.Errorf("redis: unexpected EVAL reply %T", reply)
+	}
+	return n == 1, nil
+}
+
+// incrCounterScript adds ARGV[1] to the counter entry at KEYS[1], or to ARGV[2] when
+// the key is absent or a tombstone, and stores the result as a CacheEntry payload.
+// The current payload is decoded with cjson, so field order and escaping do not
+// matter. A new entry gets ARGV[4] as cached_at, ARGV[5] as expires_at, ARGV[6] as
+// base_ttl and an expiry of ARGV[3] milliseconds; an existing one keeps its
+// expires_at, base_ttl and expiry (PTTL). The version is ARGV[7], or the previous
+// one plus one if that is not above it. Numbers are written with %d, as cjson.encode
+// would round them. Returns {1, payload}, {-1} if the value is not an integral
+// number, or {0} if the payload is not a JSON entry (e.g. compressed) or a number
+// involved leaves the range Lua numbers hold exactly.
+const incrCounterScript = `local limit = 9007199254740992
+local cur = redis.call('GET', KEYS[1])
+local base, px = tonumber(ARGV[2]), tonumber(ARGV[3])
+local expires, baseTTL, prev = ARGV[5], tonumber(ARGV[6]), 0
+if cur then
+  local ok, e = pcall(cjson.decode, cur)
+  if not ok or type(e) ~= 'table' then return {0} end
+  if e.tombstone ~= true then
+    if type(e.value) ~= 'number' or e.value ~= math.floor(e.value) then return {-1} end
+    if type(e.expires_at) ~= 'string' then return {0} end
+    base = e.value
+    expires = cjson.encode(e.expires_at)
+    baseTTL = tonumber(e.base_ttl) or 0
+    prev = tonumber(e.version) or 0
+    px = redis.call('PTTL', KEYS[1])
+  end
+end
+local n = base + tonumber(ARGV[1])
+if math.abs(base) >= limit or math.abs(n) >= limit or baseTTL >= limit or prev >= limit then return {0} end
+local version = tonumber(ARGV[7])
+if version <= prev then version = prev + 1 end
+local entry = '{"value":' .. string.format('%d', n) .. ',"cached_at":' .. ARGV[4] ..
+  ',"expires_at":' .. expires .. ',"source":"","version":' .. string.format('%d', version)
+if baseTTL > 0 then entry = entry .. ',"base_ttl":' .. string.format('%d', baseTTL) end
+entry = entry .. '}'
+if px > 0 then
+  redis.call('SET', KEYS[1], entry, 'PX', px)
+else
+  redis.call('SET', KEYS[1], entry)
+end
+return {1, entry}`
+
+// maxScriptCounter bounds the increments and initial values passed to
+// incrCounterScript, whose arithmetic is exact only below 2^53.
+const maxScriptCounter = 1 << 53
+
+// IncrCounter increments the counter entry at key in one Lua script, so concurrent
+// increments from every instance are applied by Redis without retries. Entries the
+// script cannot parse, counters near the int64 limits and non-expiring new counters
+// are declined (ok=false), for the caller to update with CompareAndSet.
+// Complexity: O(1) plus one round trip.
+func (r *RedisCache) IncrCounter(ctx context.Context, key string, delta, initial int64, ttl time.Duration) (*CacheEntry, bool, error) {
+	ms := ttl.Milliseconds()
+	if ms <= 0 || delta <= -maxScriptCounter || delta >= maxScriptCounter ||
+		initial <= -maxScriptCounter || initial >= maxScriptCounter {
+		return nil, false, nil
+	}
+	now := time.Now()
+	cachedAt, err := json.Marshal(now)
+	if err != nil {
+		return nil, false, err
+	}
+	expiresAt, err := json.Marshal(now.Add(ttl))
+	if err != nil {
+		return nil, false, err
+	}
+
+	reply, err := r.do(ctx, "EVAL", incrCounterScript, 1, r.prefixed(key),
+		delta, initial, ms, cachedAt, expiresAt, int64(ttl), nextVersion(0))
+	if err != nil {
+		return nil, false, err
+	}
+	parts, ok := reply.([]interface{})
+	if !ok || len(parts) == 0 {
+		return nil, false, fmt.Errorf("redis: unexpected EVAL reply %T", reply)
+	}
+	switch status, _ := parts[0].(int64); status {
+	case 0:
+		return nil, false, nil
+	case -1:
+		return nil, true, ErrNotCounter
+	}
+	if len(parts) < 2 {
+		return nil, false, fmt.Errorf("redis: unexpected EVAL reply %v", parts)
+	}
+	data, ok := parts[1].([]byte)
+	if !ok {
+		return nil, false, fmt.Errorf("redis: unexpected EVAL reply %T", parts[1])
+	}
+	var entry CacheEntry
+	if err := json.Unmarshal(data, &entry); err != nil {
+		return nil, false, fmt.Errorf("redis: bad counter entry: %w", err)
+	}
+	return &entry, true, nil
+}
+
+// setArgs builds a SET command with an optional PX expiry.
+func (r *RedisCache) setArgs(key string, value []byte, ttl time.Duration) []interface{} {
+	args := []interface{}{"SET", r.prefixed(key), value}
+	if ttl > 0 {
+		ms := ttl.Milliseconds()
+		if ms <= 0 {
+			ms = 1
+		}
+		args = append(args, "PX", ms)
+	}
+	return args
+}
+
+// Delete removes key. Deleting a missing key is not an error.
+func (r *RedisCache) Delete(ctx context.Context, key string) error {
+	_, err := r.do(ctx, "DEL", r.prefixed(key))
+	return err
+}
+
+// DeletePattern removes all keys matching pattern as L1Cache.DeletePattern does: a
+// trailing "*" matches any suffix (e.g., "user:*") and every other character is
+// literal, so keys containing Redis glob metacharacters are matched exactly.
+// Iterates with SCAN so the server is never blocked by a full keyspace walk. Lease
+// records and tag index sets (reservedKeyPrefixes) are never deleted.
+//
+// Complexity: O(n) over the keyspace, spread across ceil(n/ScanCount) round trips.
+func (r *RedisCache) DeletePattern(ctx context.Context, pattern string) error {
+	match := escapeRedisGlob(r.config.KeyPrefix + pattern)
+	if prefix, ok := strings.CutSuffix(pattern, "*"); ok {
+		match = escapeRedisGlob(r.config.KeyPrefix+prefix) + "*"
+	}
+	cursor := "0"
+
+	for {
+		reply, err := r.do(ctx, "SCAN", cursor, "MATCH", match, "COUNT", r.config.ScanCount)
+		if err != nil {
+			return err
+		}
+
+		next, keys, err := parseScanReply(reply)
+		if err != nil {
+			return err
+		}
+
+		args := make([]interface{}, 0, len(keys)+1)
+		args = append(args, "DEL")
+		for _, k := range keys {
+			// Leases and tag indexes are not cache entries, even when "*" matches them.
+			if !reservedKey(strings.TrimPrefix(k, r.config.KeyPrefix)) {
+				args = append(args, k)
+			}
+		}
+		if len(args) > 1 {
+			if _, err := r.do(ctx, args...); err != nil {
+				return err
+			}
+		}
+
+		if next == "0" {
+			return nil
+		}
+		cursor = next
+	}
+}
+
+// Ping checks connectivity to the server.
+func (r *RedisCache) Ping(ctx context.Context) error {
+	_, err := r.do(ctx, "PING")
+	return err
+}
+
+// Close closes all idle connections. In-flight commands finish and their
+// connections are closed on release.
+func (r *RedisCache) Close() error {
+	if r.closed.Swap(true) {
+		return nil
+	}
+	for {
+		select {
+		case c := <-r.idle:
+			c.conn.Close()
+			<-r.slots
+		default:
+			return nil
+		}
+	}
+}
+
+// tagIndexPrefix namespaces tag index sets within KeyPrefix.
+const tagIndexPrefix = "__tag__:"
+
+// AddTags records key in one Redis set per tag (SADD), pipelined in one round trip.
+// Index sets have no expiry: members whose entries have expired linger until the tag
+// is invalidated, where deleting them is a harmless no-op.
+func (r *RedisCache) AddTags(ctx context.Context, key string, tags []string) error {
+	if len(tags) == 0 {
+		return nil
+	}
+
+	cmds := make([][]interface{}, len(tags))
+	for i, tag := range tags {
+		cmds[i] = []interface{}{"SADD", r.prefixed(tagIndexPrefix + tag), key}
+	}
+
+	replies, err := r.pipeline(ctx, cmds)
+	if err != nil {
+		return err
+	}
+	for _, reply := range replies {
+		if rerr, ok := reply.(RedisError); ok {
+			return rerr
+		}
+	}
+	return nil
+}
+
+// DeleteTag deletes every key recorded under tag. Keys are deleted in batches of
+// ScanCount and then removed from the index with SREM rather than deleting the set,
+// so a key tagged concurrently is not lost from the index.
+//
+// Complexity: O(m) for m tagged keys, spread across ceil(m/ScanCount) round trips.
+func (r *RedisCache) DeleteTag(ctx context.Context, tag string) error {
+	setKey := r.prefixed(tagIndexPrefix + tag)
+	reply, err := r.do(ctx, "SMEMBERS", setKey)
+	if err != nil {
+		return err
+	}
+	members, ok := reply.([]interface{})
+	if !ok {
+		return fmt.Errorf("redis: unexpected SMEMBERS reply %T", reply)
+	}
+
+	for start := 0; start < len(members); start += r.config.ScanCount {
+		end := start + r.config.ScanCount
+		if end > len(members) {
+			end = len(members)
+		}
+		batch := members[start:end]
+
+		del := make([]interface{}, 0, len(batch)+1)
+		srem := make([]interface{}, 0, len(batch)+2)
+		del = append(del, "DEL")
+		srem = append(srem, "SREM", setKey)
+		for _, m := range batch {
+			key, _ := m.([]byte)
+			del = append(del, r.prefixed(string(key)))
+			srem = append(srem, key)
+		}
+
+		replies, err := r.pipeline(ctx, [][]interface{}{del, srem})
+		if err != nil {
+			return err
+		}
+		for _, reply := range replies {
+			if rerr, ok := reply.(RedisError); ok {
+				return rerr
+			}
+		}
+	}
+	return nil
+}
+
+// prefixed applies the configured key prefix.
+func (r *RedisCache) prefixed(key string) string {
+	return r.config.KeyPrefix + key
+}
+
+// do executes a single command on a pooled connection and returns the decoded reply:
+// string (simple string), int64, []byte (bulk, nil if absent), []interface{} (array).
+// Error replies are returned as RedisError.
+func (r *RedisCache) do(ctx context.Context, args ...interface{}) (interface{}, error) {
+	replies, err := r.pipeline(ctx, [][]interface{}{args})
+	if err != nil {
+		return nil, err
+	}
+	if rerr, ok := replies[0].(RedisError); ok {
+		return nil, rerr
+	}
+	return replies[0], nil
+}
+
+// pipeline sends cmds on one pooled connection in a single write and reads one reply
+// per command. Error replies are returned in place as RedisError values; the whole
+// batch shares one CommandTimeout.
+func (r *RedisCache) pipeline(ctx context.Context, cmds [][]interface{}) ([]interface{}, error) {
+	c, err := r.acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	deadline := time.Now().Add(r.config.CommandTimeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	if err := c.conn.SetDeadline(deadline); err != nil {
+		r.release(c, err)
+		return nil, err
+	}
+
+	replies, err := c.pipeline(cmds)
+	r.release(c, err)
+	if err != nil {
+		return nil, err
+	}
+	return replies, nil
+}
+
+// acquire returns an idle connection or dials a new one if the pool has capacity.
+// Blocks until a slot frees up or ctx is done.
+func (r *RedisCache) acquire(ctx context.Context) (*redisConn, error) {
+	if r.closed.Load() {
+		return nil, ErrRedisClosed
+	}
+
+	select {
+	case c := <-r.idle:
+		return c, nil
+	default:
+	}
+
+	select {
+	case c := <-r.idle:
+		return c, nil
+	case r.slots <- struct{}{}:
+		c, err := r.dial(ctx)
+		if err != nil {
+			<-r.slots
+			return nil, err
+		}
+		return c, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// release returns c to the pool, or discards it if the command failed at the
+// connection level (anything other than a server error reply).
+func (r *RedisCache) release(c *redisConn, err error) {
+	var rerr RedisError
+	broken := err != nil && !errors.As(err, &rerr)
+
+	if broken || r.closed.Load() {
+		c.conn.Close()
+		<-r.slots
+		return
+	}
+
+	select {
+	case r.idle <- c:
+	default:
+		c.conn.Close()
+		<-r.slots
+	}
+}
+
+// dial opens and initializes a new connection (AUTH, SELECT).
+func (r *RedisCache) dial(ctx context.Context) (*redisConn, error) {
+	dialer := net.Dialer{Timeout: r.config.DialTimeout}
+	conn, err := dialer.DialContext(ctx, "tcp", r.config.Addr)
+	if err != nil {
+		return nil, fmt.Errorf("redis: dial %s: %w", r.config.Addr, err)
+	}
+	r.dialed.Add(1)
+
+	c := &redisConn{
+		conn: conn,
+		br:   bufio.NewReader(conn),
+		bw:   bufio.NewWriter(conn),
+	}
+
+	_ = conn.SetDeadline(time.Now().Add(r.config.CommandTimeout))
+
+	if r.config.Password != "" {
+		if err := c.expectOK("AUTH", r.config.Password); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("redis: auth: %w", err)
+		}
+	}
+	if r.config.DB != 0 {
+		if err := c.expectOK("SELECT", r.config.DB); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("redis: select db %d: %w", r.config.DB, err)
+		}
+	}
+
+	return c, nil
+}
+
+// expectOK runs a command whose only valid reply is an OK status.
+func (c *redisConn) expectOK(args ...interface{}) error {
+	reply, err := c.roundTrip(args)
+	if err != nil {
+		return err
+	}
+	if rerr, ok := reply.(RedisError); ok {
+		return rerr
+	}
+	return nil
+}
+
+// roundTrip writes one command and reads its reply.
+func (c *redisConn) roundTrip(args []interface{}) (interface{}, error) {
+	if err := writeCommand(c.bw, args); err != nil {
+		return nil, err
+	}
+	if err := c.bw.Flush(); err != nil {
+		return nil, err
+	}
+	return readReply(c.br)
+}
+
+// pipeline writes all cmds, flushes once, then reads their replies in order.
+func (c *redisConn) pipeline(cmds [][]interface{}) ([]interface{}, error) {
+	for _, args := range cmds {
+		if err := writeCommand(c.bw, args); err != nil {
+			return nil, err
+		}
+	}
+	if err := c.bw.Flush(); err != nil {
+		return nil, err
+	}
+
+	replies := make([]interface{}, len(cmds))
+	for i := range replies {
+		reply, err := readReply(c.br)
+		if err != nil {
+			return nil, err
+		}
+		replies[i] = reply
+	}
+	return replies, nil
+}
+
+// writeCommand encodes args as a RESP array of bulk strings.
+func writeCommand(w *bufio.Writer, args []interface{}) error {
+	w.WriteString("*")
+	w.WriteString(strconv.Itoa(len(args)))
+	w.WriteString("\r\n")
+
+	for _, arg := range args {
+		var b []byte
+		switch v := arg.(type) {
+		case string:
+			b = []byte(v)
+		case []byte:
+			b = v
+		case int:
+			b = strconv.AppendInt(nil, int64(v), 10)
+		case int64:
+			b = strconv.AppendInt(nil, v, 10)
+		case uint64:
+			b = strconv.AppendUint(nil, v, 10)
+		case float64:
+			b = strconv.AppendFloat(nil, v, 'f', -1, 64)
+		default:
+			return fmt.Errorf("redis: unsupported argument type %T", arg)
+		}
+		w.WriteString("$")
+		w.WriteString(strconv.Itoa(len(b)))
+		w.WriteString("\r\n")
+		w.Write(b)
+		if _, err := w.WriteString("\r\n"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readReply decodes a single RESP2 reply.
+func readReply(r *bufio.Reader) (interface{}, error) {
+	line, err := readLine(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(line) == 0 {
+		return nil, errors.New("redis: empty reply line")
+	}
+
+	switch line[0] {
+	case '+':
+		return string(line[1:]), nil
+	case '-':
+		return RedisError(line[1:]), nil
+	case ':':
+		return strconv.ParseInt(string(line[1:]), 10, 64)
+	case '$':
+		n, err := strconv.Atoi(string(line[1:]))
+		if err != nil {
+			return nil, fmt.Errorf("redis: invalid bulk length %q", line)
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		buf := make([]byte, n+2)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+		return buf[:n], nil
+	case '*':
+		n, err := strconv.Atoi(string(line[1:]))
+		if err != nil {
+			return nil, fmt.Errorf("redis: invalid array length %q", line)
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		items := make([]interface{}, n)
+		for i := range items {
+			if items[i], err = readReply(r); err != nil {
+				return nil, err
+			}
+		}
+		return items, nil
+	default:
+		return nil, fmt.Errorf("redis: unexpected reply type %q", line[0])
+	}
+}
+
+// readLine reads a CRLF-terminated line without the terminator.
+func readLine(r *bufio.Reader) ([]byte, error) {
+	line, err := r.ReadSlice('\n')
+	if err != nil {
+		return nil, err
+	}
+	if len(line) < 2 || line[len(line)-2] != '\r' {
+		return nil, fmt.Errorf("redis: malformed line %q", line)
+	}
+	return line[:len(line)-2], nil
+}
+
+// parseScanReply extracts the next cursor and key batch from a SCAN reply.
+func parseScanReply(reply interface{}) (string, []string, error) {
+	parts, ok := reply.([]interface{})
+	if !ok || len(parts) != 2 {
+		return "", nil, fmt.Errorf("redis: unexpected SCAN reply %T", reply)
+	}
+	cursor, ok := parts[0].([]byte)
+	if !ok {
+		return "", nil, fmt.Errorf("redis: unexpected SCAN cursor %T", parts[0])
+	}
+	items, _ := parts[1].([]interface{})
+	keys := make([]string, 0, len(items))
+	for _, item := range items {
+		if b, ok := item.([]byte); ok {
+			keys = append(keys, string(b))
+		}
+	}
+	return string(cursor), keys, nil
+}
+
+// escapeRedisGlob escapes glob metacharacters so s matches literally in SCAN MATCH.
+func escapeRedisGlob(s string) string {
+	if !strings.ContainsAny(s, `*?[]\`) {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '*', '?', '[', ']', '\\':
+			b.WriteByte('\\')
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
@@ -0,0 +1,93 @@
+package cachemanager
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+)
+
+// RefreshAheadConfig controls refresh-ahead: origin-loaded keys that keep being read
+// are refreshed in the background shortly before they expire, so readers never see
+// the miss. Unlike warming, which preloads predicted keys on a schedule, it is driven
+// by the reads each entry actually receives.
+type RefreshAheadConfig struct {
+	Disabled      bool
+	Window        float64 // Final fraction of an entry's TTL in which a read triggers a refresh (default 0.1)
+	MinHits       int64   // L1 hits of the current value needed for a read to trigger one (default 3)
+	MaxConcurrent int     // Refreshes in flight on this instance (default 16)
+}
+
+func (c RefreshAheadConfig) withDefaults() RefreshAheadConfig {
+	if c.Window <= 0 || c.Window >= 1 {
+		c.Window = 0.1
+	}
+	if c.MinHits <= 0 {
+		c.MinHits = 3
+	}
+	if c.MaxConcurrent <= 0 {
+		c.MaxConcurrent = 16
+	}
+	return c
+}
+
+// refreshAheader decides which reads trigger a refresh and bounds how many run at once.
+type refreshAheader struct {
+	config RefreshAheadConfig
+	active atomic.Int64 // Refreshes in flight
+}
+
+func newRefreshAheader(config RefreshAheadConfig) *refreshAheader {
+	return &refreshAheader{config: config.withDefaults()}
+}
+
+// due reports whether a read of entry at now should refresh it: the entry came from
+// origin (it has a recompute time, as for XFetch), has been read at least MinHits
+// times since it was written, and is in the last Window of its TTL. Sliding entries
+// already extend on every read, and persistent ones never expire.
+func (r *refreshAheader) due(entry *CacheEntry, now time.Time) bool {
+	if entry.Delta <= 0 || entry.Tombstone || entry.Sliding || entry.BaseTTL <= 0 || persistent(entry.ExpiresAt) {
+		return false
+	}
+	if entry.hits < r.config.MinHits {
+		return false
+	}
+	window := time.Duration(float64(entry.BaseTTL) * r.config.Window)
+	return entry.ExpiresAt.Sub(now) <= window
+}
+
+// acquire takes one of MaxConcurrent refresh slots, if one is free.
+func (r *refreshAheader) acquire() bool {
+	if r.active.Add(1) > int64(r.config.MaxConcurrent) {
+		r.active.Add(-1)
+		return false
+	}
+	return true
+}
+
+func (r *refreshAheader) release() {
+	r.active.Add(-1)
+}
+
+// refreshAhead starts a background refresh of key, read while due.
+//
+// Design Notes:
+//   - The refresh is a revalidate: deduplicated per key and run through the coalescer,
+//     so it shares the origin call with a concurrent miss and accepts an L2 copy
+//     another instance already refreshed.
+//   - When MaxConcurrent refreshes are running the read starts none and the key is
+//     left to its next read, which is still inside the window. Keys are thus picked up
+//     in the order they are read, without a queue that could outgrow the entries
+//     it serves.
+//   - The refreshed value is a new write, so its hit count starts over and the key is
+//     only refreshed again if it is still being read.
+func (s *Service) refreshAhead(ctx context.Context, key string, current *CacheEntry) {
+	if !s.refreshAheader.acquire() {
+		s.metrics.RefreshAheadDeferred.Add(1)
+		return
+	}
+	if !s.revalidate(ctx, key, current, &s.metrics.RefreshAheadErrors, s.refreshAheader.release) {
+		s.refreshAheader.release()
+		return
+	}
+	s.metrics.RefreshAheads.Add(1)
+}
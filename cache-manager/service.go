@@ -13,12 +13,12 @@
 // - L1 Get: O(1) average, sub-microsecond for hot keys
 // - L1 Set: O(1) with LRU update, ~1-2μs overhead
 // - Eviction: O(1) via doubly-linked list
-// - Bottlenecks: L2 network latency (~1-5ms), global lock on eviction (can shard in v2)
+// - L1 is sharded by key hash (Config.L1Shards) to spread lock contention across segments
+// - Bottlenecks: L2 network latency (~1-5ms)
 //
 // Production Optimization Notes:
-// - For >1M keys, consider sharding L1 across multiple sync.RWMutex instances
 // - L2 batching via pipelining can reduce RTT by 5-10x for bulk operations
-// - Add compression for values >1KB to reduce memory and network overhead
+// - Values and L2 payloads >1KB are compressed (Config.CompressionThreshold, see Codec)
 // - Implement adaptive TTL based on access patterns for hot keys
 package cachemanager
 
@@ -27,10 +27,17 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
+	"math"
+	"math/rand"
+	"os"
+	"strconv"
 	"sync"
 	"sync/atomic"
 	"time"
 
+	"encore.dev/beta/errs"
+
 	"encore.app/invalidation"
 )
 
@@ -48,15 +55,64 @@ type Service struct {
 	coalescer   *RequestCoalescer
 	metrics     *Metrics
 	config      Config
+	codec       Codec             // Compression codec (nil = compression disabled)
+	writeQueue  *writeBehindQueue // Background L2 writes (nil = written synchronously)
 	wg          sync.WaitGroup
+	refreshing  sync.Map // key -> struct{}; stale keys with a background refresh running
+
+	namespaces     sync.Map     // name -> *namespace
+	namespaceCount atomic.Int64 // registered namespaces; 0 skips key routing
+	namespaceMu    sync.Mutex   // serializes namespace create/update
+	registryMu     sync.Mutex   // serializes namespace registry writes without compare-and-set
+
+	snapshotMu sync.Mutex // serializes snapshot writes
+
+	originBreaker *CircuitBreaker // Guards originFetch (nil = never trips)
+	l2Breaker     *CircuitBreaker // Guards l2Cache (nil = never trips)
+
+	cluster *cluster       // Owner routing (nil = every key is served locally)
+	hotKeys *hotKeyTracker // Hot-key detection (nil = disabled)
+
+	leases leaseTable // Leases when L2 cannot hold them (see updateLease)
+
+	evictions    *evictionSampler    // Publishes sampled L1 evictions (nil = disabled)
+	metricEvents *metricEventSampler // Publishes sampled operations (nil = disabled)
+
+	refreshAheader *refreshAheader // Refreshes keys read near expiry (nil = disabled)
 }
 
 // Config holds runtime configuration for the cache manager.
 type Config struct {
-	L1MaxEntries    int           // Maximum L1 entries before eviction
-	DefaultTTL      time.Duration // Default TTL for cached items
-	CleanupInterval time.Duration // How often to run TTL cleanup
-	L2Enabled       bool          // Whether L2 cache is available
+	L1MaxEntries     int           // Maximum L1 entries before eviction
+	L1MaxBytes       int64         // Maximum key+value bytes held in L1 (0 = unlimited)
+	L1Shards         int           // Number of independently locked L1 segments (0 = DefaultL1Shards)
+	L1Policy         string        // L1 eviction policy: "lru" (default) or "tinylfu"
+	DefaultTTL       time.Duration // Default TTL for cached items
+	StaleTTL         time.Duration // Default window an expired entry may be served while refreshing (0 = disabled)
+	EarlyRefreshBeta float64       // XFetch beta for probabilistic early refresh (0 = disabled, 1 = recommended, >1 refreshes earlier)
+	NegativeTTL      time.Duration // How long ErrNotFound results are cached as tombstones (0 = disabled)
+	ErrorTTL         time.Duration // How long other origin failures are cached in L1 (0 = disabled)
+	CleanupInterval  time.Duration // How often to run TTL cleanup
+	L2Enabled        bool          // Whether L2 cache is available
+	L2               RedisConfig   // Redis connection settings used when L2Enabled
+
+	CompressionThreshold int    // Compress L1 values and L2 payloads of at least this many bytes (0 = disabled)
+	CompressionCodec     string // Codec used to compress: "gzip" (default) or any RegisterCodec name
+
+	SnapshotPath string // L1 snapshot file written on Shutdown and loaded at startup ("" = disabled)
+
+	WriteMode   string            // "write-through" (default), "write-behind" or "l1-only"
+	WriteBehind WriteBehindConfig // Queue for write-behind writes and background origin fills
+
+	Breaker BreakerConfig // Circuit breakers around origin and L2 (each gets its own)
+
+	Cluster ClusterConfig // Static peers for owner routing (no peers = disabled)
+	HotKeys HotKeyConfig  // Heavy-hitter detection over Get traffic and local replication
+
+	EvictionEvents EvictionEventConfig // Sampled L1 evictions published to monitoring
+	MetricEvents   MetricEventConfig   // Sampled get/set/delete/invalidate events published to monitoring
+
+	RefreshAhead RefreshAheadConfig // Background refresh of keys still being read near expiry
 }
 
 // RemoteCache abstracts the L2 distributed cache (Redis, Memcached, etc.).
@@ -67,21 +123,126 @@ type RemoteCache interface {
 	DeletePattern(ctx context.Context, pattern string) error
 }
 
+// BatchRemoteCache is an optional extension of RemoteCache for stores that support
+// multi-key reads and writes in one round trip (e.g. Redis MGET/pipelining).
+// MGet and MSet use it when available and fall back to per-key calls otherwise.
+type BatchRemoteCache interface {
+	// GetMulti returns payloads for the keys that exist; missing keys are omitted.
+	GetMulti(ctx context.Context, keys []string) (map[string][]byte, error)
+	// SetMulti stores every item with its own TTL.
+	SetMulti(ctx context.Context, items []RemoteCacheItem) error
+}
+
+// RemoteCacheItem is a single write in a BatchRemoteCache.SetMulti call.
+type RemoteCacheItem struct {
+	Key   string
+	Value []byte
+	TTL   time.Duration
+}
+
+// ConditionalRemoteCache is an optional extension of RemoteCache for stores that can
+// write a key only if it has not changed since it was read. Conditional Sets use it so
+// that if_version/if_absent hold across every instance sharing the L2.
+type ConditionalRemoteCache interface {
+	// CompareAndSet stores value only if the key's current payload equals old, or the
+	// key is absent when old is nil. Returns false if the key held something else.
+	CompareAndSet(ctx context.Context, key string, old, value []byte, ttl time.Duration) (bool, error)
+}
+
+// CounterRemoteCache is an optional extension of RemoteCache for stores that can
+// increment a counter entry server-side in one round trip. Incr uses it when
+// available and falls back to compare-and-set for entries it declines.
+type CounterRemoteCache interface {
+	// IncrCounter adds delta to the integer value of the entry at key, or to initial
+	// when the key holds no live entry; a new entry expires after ttl, an existing one
+	// keeps its expiry. The version is raised past the previous one (see nextVersion).
+	// Returns the stored entry, ErrNotCounter if the value is not an integer, or
+	// ok=false if the store cannot apply this increment itself.
+	IncrCounter(ctx context.Context, key string, delta, initial int64, ttl time.Duration) (entry *CacheEntry, ok bool, err error)
+}
+
+// TaggedRemoteCache is an optional extension of RemoteCache that maintains a
+// tag -> keys index next to the entries, so a tag invalidation also reaches entries
+// that are only in L2 (or in other instances' L1, via their L2 refills).
+type TaggedRemoteCache interface {
+	// AddTags records key under each tag.
+	AddTags(ctx context.Context, key string, tags []string) error
+	// DeleteTag deletes every key recorded under tag, and the index itself.
+	DeleteTag(ctx context.Context, tag string) error
+}
+
 // OriginFetcher is called when cache misses occur to fetch from source of truth.
 type OriginFetcher interface {
 	Fetch(ctx context.Context, key string) (interface{}, error)
 }
 
+// BatchOriginFetcher is an optional extension of OriginFetcher for sources that can
+// load many keys in one call. MGet uses it, when the configured fetcher implements it,
+// to resolve all L1/L2 misses with a single origin round trip.
+type BatchOriginFetcher interface {
+	// FetchBatch returns values for the keys it found. Keys absent from the map are
+	// treated as ErrNotFound; a non-nil error fails every requested key.
+	FetchBatch(ctx context.Context, keys []string) (map[string]interface{}, error)
+}
+
+// ErrNotFound is returned (or wrapped) by OriginFetcher.Fetch when the key does not
+// exist at the source of truth. With Config.NegativeTTL set, the miss is cached as a
+// tombstone so repeated lookups of a nonexistent key do not reach origin.
+var ErrNotFound = errors.New("key not found")
+
+// ErrVersionConflict is returned by a conditional Set whose if_version or if_absent
+// condition does not hold for the key's current entry.
+var ErrVersionConflict = errors.New("version conflict")
+
 // Metrics tracks cache performance counters.
 type Metrics struct {
-	Hits      atomic.Int64
-	Misses    atomic.Int64
-	Sets      atomic.Int64
-	Deletes   atomic.Int64
-	Evictions atomic.Int64
-	L2Hits    atomic.Int64
-	L2Misses  atomic.Int64
-	L2Errors  atomic.Int64
+	Hits     atomic.Int64
+	Misses   atomic.Int64
+	Sets     atomic.Int64
+	Deletes  atomic.Int64
+	L2Hits   atomic.Int64
+	L2Misses atomic.Int64
+	L2Errors atomic.Int64
+
+	StaleHits          atomic.Int64 // Expired values served while a refresh ran
+	StaleRefreshErrors atomic.Int64 // Background revalidations that failed
+	EarlyRefreshes     atomic.Int64 // XFetch refreshes triggered before expiry
+	EarlyRefreshErrors atomic.Int64 // XFetch refreshes that failed
+	NegativeHits       atomic.Int64 // Lookups answered by a not-found tombstone
+	CachedErrorHits    atomic.Int64 // Lookups answered by a cached origin failure
+
+	RefreshAheads        atomic.Int64 // Refresh-ahead refreshes started
+	RefreshAheadErrors   atomic.Int64 // Refresh-ahead refreshes that failed
+	RefreshAheadDeferred atomic.Int64 // Due reads that started no refresh because MaxConcurrent were running
+
+	CompressionBytesIn  atomic.Int64 // Bytes offered to the codec (values at or above the threshold)
+	CompressionBytesOut atomic.Int64 // Bytes stored for those values, header included
+	CompressionErrors   atomic.Int64 // Codec failures; encode failures store the value uncompressed
+
+	SnapshotRestored atomic.Int64 // L1 entries restored from the startup snapshot
+	SnapshotErrors   atomic.Int64 // Snapshot writes or loads that failed
+
+	WriteBehindFlushed    atomic.Int64 // Queued L2 writes completed
+	WriteBehindRetries    atomic.Int64 // Failed queued writes requeued for another attempt
+	WriteBehindDrops      atomic.Int64 // Queued writes abandoned: queue full or retries exhausted
+	WriteBehindFlushes    atomic.Int64 // Batches written
+	WriteBehindFlushNanos atomic.Int64 // Total time spent writing batches
+
+	ClusterForwards  atomic.Int64 // Get/Set calls answered by the key's owner
+	ClusterFallbacks atomic.Int64 // Get/Set calls served locally because the owner was unreachable
+
+	HotKeyPromotions atomic.Int64 // Keys this instance detected as hot
+	HotKeyDemotions  atomic.Int64 // Keys this instance stopped reporting as hot
+
+	Incrs       atomic.Int64 // Counter increments applied
+	IncrRetries atomic.Int64 // Counter compare-and-sets retried after losing a race in L2
+
+	LeaseAcquires    atomic.Int64 // Leases granted or extended by acquire
+	LeaseConflicts   atomic.Int64 // Acquires refused because another owner held the lease
+	LeaseExpirations atomic.Int64 // Local leases dropped by TTL cleanup after expiring
+
+	EvictionEventDrops atomic.Int64 // Sampled evictions not published because the batch was full
+	MetricEventDrops   atomic.Int64 // Sampled operations not published because the batch was full
 }
 
 // Request and response types for API endpoints.
@@ -94,7 +255,11 @@ type GetResponse struct {
 	// Value is JSON-encoded.
 	Value     json.RawMessage `json:"value"`
 	Hit       bool            `json:"hit"`
-	Source    string          `json:"source"` // "l1", "l2", "origin"
+	Found     bool            `json:"found"`  // False for a cached not-found tombstone
+	Source    string          `json:"source"` // "l1", "l2", "origin", "stale"
+	Stale     bool            `json:"stale"`  // Value is past expiry; a background refresh was triggered
+	Version   uint64          `json:"version,omitempty"`
+	ETag      string          `json:"etag,omitempty"` // Quoted Version, for HTTP-style clients
 	CachedAt  *time.Time      `json:"cached_at,omitempty"`
 	ExpiresAt *time.Time      `json:"expires_at,omitempty"`
 }
@@ -104,16 +269,42 @@ type SetRequest struct {
 	// Value is JSON-encoded.
 	Value json.RawMessage `json:"value"`
 	TTL   int             `json:"ttl"` // seconds, 0 means default
+	// StaleTTL is how long (seconds) the value may be served after expiry while it is
+	// refreshed in the background. 0 means Config.StaleTTL, negative disables.
+	StaleTTL int `json:"stale_ttl,omitempty"`
+	// IfVersion makes the write conditional on the key currently holding this version.
+	IfVersion uint64 `json:"if_version,omitempty"`
+	// IfAbsent makes the write conditional on the key holding no value.
+	IfAbsent bool `json:"if_absent,omitempty"`
+	// Tags are surrogate keys the entry can be invalidated by (see InvalidateRequest.Tags).
+	Tags []string `json:"tags,omitempty"`
+	// Sliding restarts the TTL on every Get that finds the entry fresh, so it expires
+	// only after TTL seconds without reads (e.g. sessions).
+	Sliding bool `json:"sliding,omitempty"`
+}
+
+// MaxTagsPerEntry bounds SetRequest.Tags.
+const MaxTagsPerEntry = 32
+
+// condition returns the write condition carried by the request.
+func (r *SetRequest) condition() WriteCondition {
+	return WriteCondition{IfAbsent: r.IfAbsent, IfVersion: r.IfVersion}
 }
 
 type SetResponse struct {
 	Success   bool      `json:"success"`
+	Version   uint64    `json:"version"`
 	ExpiresAt time.Time `json:"expires_at"`
 }
 
 type InvalidateRequest struct {
 	Keys    []string `json:"keys,omitempty"`
 	Pattern string   `json:"pattern,omitempty"` // e.g., "user:*"
+	Tags    []string `json:"tags,omitempty"`    // Drop every entry set with any of these tags
+
+	// Namespace flushes every entry of one namespace, leaving others untouched.
+	// It cannot be combined with Keys, Pattern or Tags.
+	Namespace string `json:"namespace,omitempty"`
 }
 
 type InvalidateResponse struct {
@@ -122,16 +313,65 @@ type InvalidateResponse struct {
 }
 
 type MetricsResponse struct {
-	Hits      int64   `json:"hits"`
-	Misses    int64   `json:"misses"`
-	HitRate   float64 `json:"hit_rate"`
-	Sets      int64   `json:"sets"`
-	Deletes   int64   `json:"deletes"`
-	Evictions int64   `json:"evictions"`
-	L1Size    int     `json:"l1_size"`
-	L2Hits    int64   `json:"l2_hits"`
-	L2Misses  int64   `json:"l2_misses"`
-	L2Errors  int64   `json:"l2_errors"`
+	Hits        int64   `json:"hits"`
+	Misses      int64   `json:"misses"`
+	HitRate     float64 `json:"hit_rate"`
+	Sets        int64   `json:"sets"`
+	Deletes     int64   `json:"deletes"`
+	Evictions   int64   `json:"evictions"`   // Entries removed from L1 for capacity
+	Expirations int64   `json:"expirations"` // Entries removed from L1 after their TTL (and stale window)
+	L1Size      int     `json:"l1_size"`
+	L1Bytes     int64   `json:"l1_bytes"`
+	L2Hits      int64   `json:"l2_hits"`
+	L2Misses    int64   `json:"l2_misses"`
+	L2Errors    int64   `json:"l2_errors"`
+
+	StaleHits          int64 `json:"stale_hits"`
+	StaleRefreshErrors int64 `json:"stale_refresh_errors"`
+	EarlyRefreshes     int64 `json:"early_refreshes"`
+	EarlyRefreshErrors int64 `json:"early_refresh_errors"`
+	NegativeHits       int64 `json:"negative_hits"`
+	CachedErrorHits    int64 `json:"cached_error_hits"`
+
+	RefreshAheads        int64 `json:"refresh_aheads"`
+	RefreshAheadErrors   int64 `json:"refresh_ahead_errors"`
+	RefreshAheadDeferred int64 `json:"refresh_ahead_deferred"`
+
+	CompressionRatio  float64 `json:"compression_ratio"` // Uncompressed / stored bytes of values over the threshold (0 = none yet)
+	CompressionErrors int64   `json:"compression_errors"`
+
+	SnapshotRestored int64 `json:"snapshot_restored"`
+	SnapshotErrors   int64 `json:"snapshot_errors"`
+
+	WriteBehindDepth          int     `json:"write_behind_depth"` // Writes currently queued
+	WriteBehindFlushed        int64   `json:"write_behind_flushed"`
+	WriteBehindRetries        int64   `json:"write_behind_retries"`
+	WriteBehindDrops          int64   `json:"write_behind_drops"`
+	WriteBehindFlushLatencyMs float64 `json:"write_behind_flush_latency_ms"` // Mean per batch
+
+	ClusterForwards  int64 `json:"cluster_forwards"`
+	ClusterFallbacks int64 `json:"cluster_fallbacks"`
+
+	HotKeys          int   `json:"hot_keys"` // Keys currently hot on any instance
+	HotKeyPromotions int64 `json:"hot_key_promotions"`
+	HotKeyDemotions  int64 `json:"hot_key_demotions"`
+
+	Incrs       int64 `json:"incrs"`
+	IncrRetries int64 `json:"incr_retries"`
+
+	LeaseAcquires    int64 `json:"lease_acquires"`
+	LeaseConflicts   int64 `json:"lease_conflicts"`
+	LeaseExpirations int64 `json:"lease_expirations"`
+
+	EvictionEventDrops int64 `json:"eviction_event_drops"`
+	MetricEventDrops   int64 `json:"metric_event_drops"`
+
+	// Breakers reports the "origin" and "l2" circuit breakers. Omitted when disabled.
+	Breakers map[string]BreakerStatus `json:"breakers,omitempty"`
+
+	// Namespaces breaks hits, misses and L1 usage down per namespace, including
+	// "default" for keys outside any namespace. Omitted when none are registered.
+	Namespaces map[string]NamespaceMetrics `json:"namespaces,omitempty"`
 }
 
 var (
@@ -150,30 +390,151 @@ func initService() (*Service, error) {
 	var err error
 	once.Do(func() {
 		config := Config{
-			L1MaxEntries:    10000,
-			DefaultTTL:      1 * time.Hour,
-			CleanupInterval: 1 * time.Minute,
-			L2Enabled:       false, // Disabled by default for unit tests
+			L1MaxEntries:     10000,
+			L1MaxBytes:       256 << 20, // 256 MiB
+			L1Shards:         DefaultL1Shards,
+			L1Policy:         os.Getenv("CACHE_L1_POLICY"), // "" = lru
+			DefaultTTL:       1 * time.Hour,
+			StaleTTL:         0, // Opt in per entry via SetRequest.StaleTTL
+			EarlyRefreshBeta: 1.0,
+			NegativeTTL:      30 * time.Second,
+			ErrorTTL:         1 * time.Second,
+			CleanupInterval:  1 * time.Minute,
+			// Disabled by default for unit tests; opt in with L2_CACHE_ENABLED=true.
+			L2Enabled: os.Getenv("L2_CACHE_ENABLED") == "true",
+			L2:        RedisConfigFromEnv(),
+
+			CompressionThreshold: 1024,
+			CompressionCodec:     os.Getenv("CACHE_COMPRESSION_CODEC"), // "" = gzip
+
+			SnapshotPath: os.Getenv("CACHE_SNAPSHOT_PATH"), // "" = no snapshots
+
+			WriteMode:   os.Getenv("CACHE_WRITE_MODE"), // "" = write-through
+			WriteBehind: WriteBehindConfig{}.withDefaults(),
+
+			Breaker: BreakerConfig{Disabled: os.Getenv("CACHE_BREAKER_DISABLED") == "true"}.withDefaults(),
+
+			Cluster: ClusterConfigFromEnv(), // No CACHE_PEERS = not clustered
+			HotKeys: HotKeyConfig{}.withDefaults(),
+
+			// Opt in with CACHE_EVICTION_EVENTS=true.
+			EvictionEvents: EvictionEventConfig{Enabled: os.Getenv("CACHE_EVICTION_EVENTS") == "true"}.withDefaults(),
+			MetricEvents:   MetricEventConfig{Disabled: os.Getenv("CACHE_METRIC_EVENTS_DISABLED") == "true"}.withDefaults(),
+
+			RefreshAhead: RefreshAheadConfig{Disabled: os.Getenv("CACHE_REFRESH_AHEAD_DISABLED") == "true"}.withDefaults(),
+		}
+
+		l1, l1Err := newL1CacheFromConfig(config)
+		if l1Err != nil {
+			err = l1Err
+			return
+		}
+		codec, codecErr := CodecByName(config.CompressionCodec)
+		if codecErr != nil {
+			err = codecErr
+			return
+		}
+		if modeErr := validateWriteMode(config.WriteMode); modeErr != nil {
+			err = modeErr
+			return
 		}
 
 		stopChan = make(chan struct{})
 		svc = &Service{
-			l1Cache:     NewL1Cache(config.L1MaxEntries),
-			l2Cache:     nil, // Must be set via SetL2Cache for production
+			l1Cache:     l1,
+			l2Cache:     nil, // Wired below when L2Enabled, or injected via SetL2Cache
 			originFetch: nil, // Must be set via SetOriginFetcher
 			coalescer:   NewRequestCoalescer(),
 			metrics:     &Metrics{},
 			config:      config,
+			codec:       codec,
+			writeQueue:  newWriteBehindQueue(config.WriteBehind.QueueSize),
+		}
+		if !config.HotKeys.Disabled {
+			svc.hotKeys = newHotKeyTracker(config.HotKeys, instanceName(config))
+		}
+		if config.EvictionEvents.Enabled {
+			svc.evictions = newEvictionSampler(config.EvictionEvents, instanceName(config), &svc.metrics.EvictionEventDrops)
+			svc.watchEvictions(l1)
+		}
+		if !config.MetricEvents.Disabled {
+			svc.metricEvents = newMetricEventSampler(config.MetricEvents, instanceName(config), &svc.metrics.MetricEventDrops)
+		}
+		if !config.RefreshAhead.Disabled {
+			svc.refreshAheader = newRefreshAheader(config.RefreshAhead)
+		}
+		if !config.Breaker.Disabled {
+			svc.originBreaker = NewCircuitBreaker(config.Breaker)
+			svc.l2Breaker = NewCircuitBreaker(config.Breaker)
+		}
+
+		if config.L2Enabled {
+			svc.SetL2Cache(NewRedisCache(config.L2))
+		}
+		if config.Cluster.enabled() {
+			if clusterErr := svc.clusterFromConfig(config.Cluster); clusterErr != nil {
+				err = clusterErr
+				return
+			}
+		}
+
+		// Learn the namespaces created before this instance started, so the snapshot
+		// and the first requests are routed to them. An unreachable L2 is counted in
+		// L2Errors; its namespaces then arrive with their next update.
+		_ = svc.loadNamespaces(context.Background())
+
+		// Warm L1 from the last snapshot. A corrupt or unreadable snapshot is
+		// counted and skipped: starting cold beats not starting.
+		if config.SnapshotPath != "" {
+			if _, snapErr := svc.loadSnapshot(config.SnapshotPath); snapErr != nil {
+				svc.metrics.SnapshotErrors.Add(1)
+			}
 		}
 
 		// Start background cleanup goroutine
 		svc.wg.Add(1)
 		go svc.runTTLCleanup()
+
+		svc.wg.Add(1)
+		go svc.runWriteBehind()
+
+		if svc.hotKeys != nil {
+			svc.wg.Add(1)
+			go svc.runHotKeys()
+		}
+
+		if svc.evictions != nil {
+			svc.wg.Add(1)
+			go svc.runEvictionEvents()
+		}
+
+		if svc.metricEvents != nil {
+			svc.wg.Add(1)
+			go svc.runMetricEvents()
+		}
 	})
 
 	return svc, err
 }
 
+// newL1CacheFromConfig builds the L1 cache described by config.
+func newL1CacheFromConfig(config Config) (*L1Cache, error) {
+	shards := config.L1Shards
+	if shards <= 0 {
+		shards = DefaultL1Shards
+	}
+	policy, err := PolicyFactoryByName(config.L1Policy)
+	if err != nil {
+		return nil, fmt.Errorf("invalid L1 config: %w", err)
+	}
+	return NewL1CacheWithOptions(L1Options{
+		MaxEntries: config.L1MaxEntries,
+		MaxBytes:   config.L1MaxBytes,
+		Shards:     shards,
+		Policy:     policy,
+	}), nil
+}
+
 func init() {
 	var err error
 	svc, err = initService()
@@ -194,6 +555,13 @@ func (s *Service) SetOriginFetcher(fetcher OriginFetcher) {
 }
 
 // Get retrieves a value from cache with read-through to L2 and origin.
+// An expired L1 entry still inside its stale window is returned immediately with
+// Source "stale" while a single background refresh runs through the coalescer.
+// Fresh origin-loaded entries may also be refreshed early (XFetch, see shouldRefreshEarly).
+// A cached not-found tombstone is reported as Hit true, Found false; a cached origin
+// failure is returned as an error without contacting origin.
+// In a cluster (Config.Cluster) keys owned by another instance are served by it, or
+// locally while it is unreachable.
 // Complexity: O(1) average for L1 hit, O(1) + network for L2, O(1) + network + origin for miss.
 //
 //encore:api public method=GET path=/api/cache/entry/:key
@@ -201,112 +569,356 @@ func Get(ctx context.Context, key string) (*GetResponse, error) {
 	if svc == nil {
 		return nil, errors.New("service not initialized")
 	}
-	return svc.Get(ctx, key)
+	resp, err := svc.Get(ctx, key)
+	if errors.Is(err, ErrCircuitOpen) {
+		return nil, errs.WrapCode(err, errs.Unavailable, "origin unavailable")
+	}
+	return resp, err
 }
 
 func (s *Service) Get(ctx context.Context, key string) (*GetResponse, error) {
-	if key == "" {
-		return nil, errors.New("key cannot be empty")
+	if err := validateKey(key); err != nil {
+		return nil, err
+	}
+	s.hotKeys.observe(key)
+	if peer := s.ownerPeer(key); peer != nil {
+		// Hot keys are answered from a copy pinned from the owner while it lasts.
+		hot := s.hotKeys.isHot(key)
+		if hot && s.pinned(key) {
+			startTime := time.Now()
+			if resp, ok, err := s.getL1(ctx, key); ok {
+				s.recordOp(opGet, key, resp.Hit, startTime, len(resp.Value))
+				return resp, err
+			}
+		}
+		if resp, err := peer.Get(ctx, key); s.forwarded(err) {
+			if hot && err == nil {
+				s.pinHotKey(key, resp)
+			}
+			return resp, err
+		}
+	}
+	return s.getLocal(ctx, key)
+}
+
+// getLocal serves key from this instance's cache levels, ignoring ownership.
+func (s *Service) getLocal(ctx context.Context, key string) (*GetResponse, error) {
+	if err := validateKey(key); err != nil {
+		return nil, err
 	}
 
 	startTime := time.Now()
 
 	// L1 lookup
-	if entry, ok := s.l1Cache.Get(key); ok {
-		s.metrics.Hits.Add(1)
-		return &GetResponse{
-			Value:     entry.Value,
-			Hit:       true,
-			Source:    "l1",
-			CachedAt:  &entry.CachedAt,
-			ExpiresAt: &entry.ExpiresAt,
-		}, nil
+	if resp, ok, err := s.getL1(ctx, key); ok {
+		s.recordOp(opGet, key, resp.Hit, startTime, len(resp.Value))
+		return resp, err
 	}
 
 	// L1 miss - use singleflight to coalesce requests
 	result, err := s.coalescer.Do(key, func() (interface{}, error) {
-		return s.fetchWithFallback(ctx, key)
+		return s.fetchWithFallback(ctx, key, nil)
 	})
 
 	if err != nil {
-		s.metrics.Misses.Add(1)
+		s.recordMiss(key)
+		s.recordOp(opGet, key, false, startTime, 0)
 		return &GetResponse{Hit: false}, err
 	}
 
 	entry := result.(*CacheEntry)
+	s.recordOp(opGet, key, false, startTime, len(entry.Value))
 
-	// Record latency (for monitoring)
-	_ = time.Since(startTime)
+	return responseFromEntry(entry), nil
+}
 
-	return &GetResponse{
-		Value:     entry.Value,
-		Hit:       true,
-		Source:    entry.Source,
-		CachedAt:  &entry.CachedAt,
-		ExpiresAt: &entry.ExpiresAt,
-	}, nil
+// getL1 answers key from L1, recording metrics and triggering stale or early refresh.
+// ok is false on an L1 miss, in which case the caller falls back to L2/origin.
+func (s *Service) getL1(ctx context.Context, key string) (resp *GetResponse, ok bool, err error) {
+	entry, stale, ok := s.l1For(key).GetStale(key)
+	if !ok {
+		return nil, false, nil
+	}
+	value, err := s.decompress(entry.Value)
+	if err != nil {
+		// Unreadable entry: drop it and reload as on a miss.
+		s.l1For(key).Delete(key)
+		return nil, false, nil
+	}
+	entry.Value = value
+
+	if entry.OriginError != "" {
+		s.recordMiss(key)
+		s.metrics.CachedErrorHits.Add(1)
+		return &GetResponse{Hit: false}, true, fmt.Errorf("origin fetch failed (cached): %s", entry.OriginError)
+	}
+	s.recordHit(key)
+	if entry.Tombstone {
+		s.metrics.NegativeHits.Add(1)
+		return responseFromEntry(entry), true, nil
+	}
+	if stale {
+		s.metrics.StaleHits.Add(1)
+		s.revalidate(ctx, key, entry, &s.metrics.StaleRefreshErrors, nil)
+		resp := responseFromEntry(entry)
+		resp.Source = "stale"
+		resp.Stale = true
+		return resp, true, nil
+	}
+	if now := time.Now(); s.shouldRefreshEarly(entry, now) {
+		s.metrics.EarlyRefreshes.Add(1)
+		s.revalidate(ctx, key, entry, &s.metrics.EarlyRefreshErrors, nil)
+	} else if s.refreshAheader != nil && s.refreshAheader.due(entry, now) {
+		s.refreshAhead(ctx, key, entry)
+	}
+	if entry.renewL2 {
+		// A sliding entry read here; extend the L2 copy other instances load.
+		renewed := *entry
+		renewed.CachedAt, renewed.Source = time.Now(), ""
+		s.storeL2(key, &renewed, l2TTL(entry.ExpiresAt, time.Now()))
+	}
+	return responseFromEntry(entry), true, nil
+}
+
+// responseFromEntry converts a cache entry into a hit response.
+// ExpiresAt is unset for an entry that never expires.
+func responseFromEntry(entry *CacheEntry) *GetResponse {
+	resp := &GetResponse{
+		Value:    entry.Value,
+		Hit:      true,
+		Found:    !entry.Tombstone,
+		Source:   entry.Source,
+		Version:  entry.Version,
+		ETag:     etag(entry.Version),
+		CachedAt: &entry.CachedAt,
+	}
+	if !persistent(entry.ExpiresAt) {
+		resp.ExpiresAt = &entry.ExpiresAt
+	}
+	return resp
+}
+
+// etag formats version as a strong HTTP entity tag, or "" for an unversioned entry.
+func etag(version uint64) string {
+	if version == 0 {
+		return ""
+	}
+	return strconv.Quote(strconv.FormatUint(version, 10))
+}
+
+// xfetchRand returns a uniform sample in [0, 1). Replaced in tests.
+var xfetchRand = rand.Float64
+
+// shouldRefreshEarly implements XFetch (Vattani et al., "Optimal Probabilistic Cache
+// Stampede Prevention"): refresh when
+//
+//	now - delta * beta * ln(rand()) >= expiry
+//
+// where delta is how long the origin took to compute the value. The probability rises
+// towards expiry and is higher for expensive keys, so across N instances one of them
+// usually refreshes shortly before the value expires instead of all N missing at once.
+// Entries without a recorded delta (written via Set) are never refreshed early.
+func (s *Service) shouldRefreshEarly(entry *CacheEntry, now time.Time) bool {
+	beta := s.config.EarlyRefreshBeta
+	if beta <= 0 || entry.Delta <= 0 {
+		return false
+	}
+	// 1-rand is in (0, 1], keeping the logarithm finite.
+	gap := float64(entry.Delta) * beta * -math.Log(1-xfetchRand())
+	return !now.Add(time.Duration(gap)).Before(entry.ExpiresAt)
+}
+
+// revalidate refreshes current's key in the background. At most one refresh per key is
+// started; it goes through the coalescer, so a concurrent foreground miss shares the
+// same origin call. The request context is detached from cancellation because the
+// caller has already been answered. Failures are counted in failures, and done (if
+// not nil) is called once a started refresh finishes. Reports whether one started.
+func (s *Service) revalidate(ctx context.Context, key string, current *CacheEntry, failures *atomic.Int64, done func()) bool {
+	// Keep serving current without queuing refreshes that would be rejected.
+	if s.originBreaker.State() == BreakerOpen {
+		return false
+	}
+	if _, running := s.refreshing.LoadOrStore(key, struct{}{}); running {
+		return false
+	}
+	ctx = context.WithoutCancel(ctx)
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		if done != nil {
+			defer done()
+		}
+		defer s.refreshing.Delete(key)
+		_, err := s.coalescer.Do(key, func() (interface{}, error) {
+			return s.fetchWithFallback(ctx, key, current)
+		})
+		if err != nil {
+			failures.Add(1)
+		}
+	}()
+	return true
 }
 
 // fetchWithFallback attempts L2, then origin, with proper cache population.
-func (s *Service) fetchWithFallback(ctx context.Context, key string) (*CacheEntry, error) {
-	// Try L2 cache
-	if s.config.L2Enabled && s.l2Cache != nil {
-		if data, ok, err := s.l2Cache.Get(ctx, key); err == nil && ok {
-			var entry CacheEntry
-			if err := json.Unmarshal(data, &entry); err == nil {
-				// Populate L1 from L2
-				s.l1Cache.Set(key, entry.Value, entry.ExpiresAt.Sub(time.Now()))
-				s.metrics.L2Hits.Add(1)
-				entry.Source = "l2"
-				return &entry, nil
+// current is the L1 entry being refreshed, or nil on a plain miss. A refresh keeps
+// current's stale window and only accepts an L2 entry that expires later than current
+// (i.e. one another instance already refreshed); otherwise it goes to origin.
+func (s *Service) fetchWithFallback(ctx context.Context, key string, current *CacheEntry) (*CacheEntry, error) {
+	notAfter := time.Now()
+	if current != nil && current.ExpiresAt.After(notAfter) {
+		notAfter = current.ExpiresAt
+	}
+
+	// Try L2 cache, unless its breaker is open
+	if s.l2Allowed() {
+		data, ok, err := s.l2Cache.Get(ctx, key)
+		s.recordL2(err)
+		if err == nil && ok {
+			if entry, ok := s.entryFromL2(key, data, notAfter); ok {
+				return entry, nil
 			}
-		} else if err != nil {
-			s.metrics.L2Errors.Add(1)
-		} else {
+		} else if err == nil {
 			s.metrics.L2Misses.Add(1)
 		}
 	}
 
 	// Try origin fetch
 	if s.originFetch == nil {
-		return nil, errors.New("cache miss and no origin fetcher configured")
+		return nil, errOriginNotConfigured
 	}
+	return s.fetchFromOrigin(ctx, key, current)
+}
 
+// fetchFromOrigin loads key from origin (L2 already checked) and populates both
+// cache levels. current is the entry being refreshed, or nil. Fails fast with
+// ErrCircuitOpen while the origin breaker is open.
+func (s *Service) fetchFromOrigin(ctx context.Context, key string, current *CacheEntry) (*CacheEntry, error) {
+	staleTTL := s.config.StaleTTL
+	if current != nil {
+		staleTTL = current.StaleTTL
+	}
+
+	if !s.originBreaker.Allow() {
+		return nil, fmt.Errorf("origin fetch failed: %w", ErrCircuitOpen)
+	}
+	fetchStart := time.Now()
 	value, err := s.originFetch.Fetch(ctx, key)
+	delta := time.Since(fetchStart)
+	s.recordOrigin(err)
 	if err != nil {
-		return nil, fmt.Errorf("origin fetch failed: %w", err)
+		return s.cacheOriginFailure(key, err, current)
+	}
+
+	return s.storeOriginValue(key, value, delta, staleTTL)
+}
+
+var errOriginNotConfigured = errors.New("cache miss and no origin fetcher configured")
+
+// entryFromL2 decodes an L2 payload and populates L1 from it. An L2 entry past
+// ExpiresAt (e.g. L2 TTL rounding) or not newer than notAfter is treated as a miss.
+func (s *Service) entryFromL2(key string, data []byte, notAfter time.Time) (*CacheEntry, bool) {
+	var entry CacheEntry
+	if err := s.decodeL2(data, &entry); err != nil || !entry.ExpiresAt.After(notAfter) {
+		return nil, false
 	}
 
+	// Populate L1 from L2
+	s.l1For(key).SetWithOptions(key, s.compress(entry.Value), l1TTL(entry.ExpiresAt), EntryOptions{
+		StaleTTL:  entry.StaleTTL,
+		Delta:     entry.Delta,
+		Tombstone: entry.Tombstone,
+		Version:   entry.Version,
+		Tags:      entry.Tags,
+		BaseTTL:   entry.BaseTTL,
+		Sliding:   entry.Sliding,
+		Source:    "l2",
+	})
+	s.metrics.L2Hits.Add(1)
+	entry.Source = "l2"
+	return &entry, true
+}
+
+// storeOriginValue encodes a value loaded from origin and populates both cache levels.
+func (s *Service) storeOriginValue(key string, value interface{}, delta, staleTTL time.Duration) (*CacheEntry, error) {
 	valueJSON, err := json.Marshal(value)
 	if err != nil {
 		return nil, fmt.Errorf("failed to marshal origin value: %w", err)
 	}
 
 	// Populate both cache levels
-	ttl := s.config.DefaultTTL
+	ttl := s.defaultTTLFor(key)
 	expiresAt := time.Now().Add(ttl)
 
-	s.l1Cache.Set(key, valueJSON, ttl)
+	version := s.l1For(key).SetWithOptions(key, s.compress(valueJSON), ttl, EntryOptions{StaleTTL: staleTTL, Delta: delta, Source: "origin"})
 
 	entry := &CacheEntry{
 		Value:     valueJSON,
 		CachedAt:  time.Now(),
 		ExpiresAt: expiresAt,
 		Source:    "origin",
+		StaleTTL:  staleTTL,
+		Delta:     delta,
+		Version:   version,
+		BaseTTL:   ttl,
 	}
 
-	// Async L2 population (don't block response)
-	if s.config.L2Enabled && s.l2Cache != nil {
-		go func() {
-			data, _ := json.Marshal(entry)
-			_ = s.l2Cache.Set(context.Background(), key, data, ttl)
-		}()
-	}
+	s.storeL2(key, entry, ttl)
 
 	return entry, nil
 }
 
-// Set stores a value in cache with write-through to L2.
+// cacheOriginFailure records a failed origin fetch so repeated lookups are answered
+// from cache. ErrNotFound becomes a tombstone in L1 and L2 for Config.NegativeTTL and
+// is returned as a (non-error) entry. Other failures are cached in L1 only, for
+// Config.ErrorTTL, since they are usually transient and instance-specific; they are
+// not cached when refreshing current, so the existing value keeps being served.
+func (s *Service) cacheOriginFailure(key string, err error, current *CacheEntry) (*CacheEntry, error) {
+	if errors.Is(err, ErrNotFound) && s.config.NegativeTTL > 0 {
+		ttl := s.config.NegativeTTL
+		version := s.l1For(key).SetWithOptions(key, nil, ttl, EntryOptions{Tombstone: true, Source: "origin"})
+
+		entry := &CacheEntry{
+			CachedAt:  time.Now(),
+			ExpiresAt: time.Now().Add(ttl),
+			Source:    "origin",
+			Tombstone: true,
+			Version:   version,
+		}
+		s.storeL2(key, entry, ttl)
+		return entry, nil
+	}
+
+	if current == nil && !errors.Is(err, ErrNotFound) && s.config.ErrorTTL > 0 {
+		s.l1For(key).SetWithOptions(key, nil, s.config.ErrorTTL, EntryOptions{OriginError: err.Error(), Source: "origin"})
+	}
+	return nil, fmt.Errorf("origin fetch failed: %w", err)
+}
+
+// encodeL2 serializes entry as an L2 payload, compressed when large enough.
+func (s *Service) encodeL2(entry *CacheEntry) ([]byte, error) {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return nil, err
+	}
+	return s.compress(data), nil
+}
+
+// decodeL2 parses an L2 payload written by encodeL2 (or an older uncompressed one).
+func (s *Service) decodeL2(data []byte, entry *CacheEntry) error {
+	data, err := s.decompress(data)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, entry)
+}
+
+// Set stores a value in cache and in L2 according to Config.WriteMode: before
+// returning (write-through), via the write-behind queue, or not at all (l1-only).
+// Every write assigns the key a new, higher version. With if_version or if_absent the
+// write only happens if the key's current entry satisfies the condition; otherwise it
+// fails with a conflict (HTTP 409) and nothing is written.
+// In a cluster the write goes to the key's owner, as for Get.
 // Complexity: O(1) for L1 + O(1) + network for L2.
 //
 //encore:api public method=PUT path=/api/cache/entry/:key
@@ -314,53 +926,342 @@ func Set(ctx context.Context, key string, req *SetRequest) (*SetResponse, error)
 	if svc == nil {
 		return nil, errors.New("service not initialized")
 	}
-	return svc.Set(ctx, key, req)
+	resp, err := svc.Set(ctx, key, req)
+	if errors.Is(err, ErrVersionConflict) {
+		return nil, errs.WrapCode(err, errs.Aborted, "version conflict")
+	}
+	if errors.Is(err, ErrCircuitOpen) {
+		return nil, errs.WrapCode(err, errs.Unavailable, "l2 unavailable")
+	}
+	return resp, err
 }
 
 func (s *Service) Set(ctx context.Context, key string, req *SetRequest) (*SetResponse, error) {
-	if key == "" {
-		return nil, errors.New("key cannot be empty")
-	}
-	if len(req.Value) == 0 {
-		return nil, errors.New("value cannot be empty")
+	if peer := s.ownerPeer(key); peer != nil {
+		if resp, err := peer.Set(ctx, key, req); s.forwarded(err) {
+			if s.hotKeys.isHot(key) {
+				s.l1For(key).Delete(key) // Drop the pinned copy this write made stale
+			}
+			return resp, err
+		}
 	}
+	return s.setLocal(ctx, key, req)
+}
 
-	ttl := s.config.DefaultTTL
-	if req.TTL > 0 {
-		ttl = time.Duration(req.TTL) * time.Second
-	}
+// setLocal writes key on this instance, ignoring ownership.
+func (s *Service) setLocal(ctx context.Context, key string, req *SetRequest) (*SetResponse, error) {
+	startTime := time.Now()
 
-	expiresAt := time.Now().Add(ttl)
+	// Conditional writes are checked against L2 synchronously in every mode but
+	// l1-only, since a queued write could not report a conflict.
+	if !req.condition().IsZero() && s.writesL2() {
+		entry, err := s.setConditional(ctx, key, req)
+		if err != nil {
+			return nil, err
+		}
+		s.recordOp(opSet, key, false, startTime, len(req.Value))
+		return &SetResponse{
+			Success:   true,
+			Version:   entry.Version,
+			ExpiresAt: entry.ExpiresAt,
+		}, nil
+	}
 
-	// Write to L1
-	s.l1Cache.Set(key, req.Value, ttl)
-	s.metrics.Sets.Add(1)
+	entry, ttl, err := s.setL1(key, req)
+	if err != nil {
+		return nil, err
+	}
 
-	// Write to L2 (synchronous write-through)
-	if s.config.L2Enabled && s.l2Cache != nil {
-		entry := CacheEntry{
-			Value:     req.Value,
-			CachedAt:  time.Now(),
-			ExpiresAt: expiresAt,
-		}
-		data, err := json.Marshal(entry)
+	if s.writesL2() && s.config.WriteMode == WriteModeBehind {
+		s.storeL2(key, entry, ttl)
+	} else if s.writesL2() {
+		// Write to L2 (synchronous write-through), skipped while its breaker is open
+		data, err := s.encodeL2(entry)
 		if err != nil {
 			return nil, fmt.Errorf("failed to marshal entry: %w", err)
 		}
-		if err := s.l2Cache.Set(ctx, key, data, ttl); err != nil {
-			s.metrics.L2Errors.Add(1)
+		if s.l2Breaker.Allow() {
 			// Continue even if L2 fails (L1 is authoritative)
+			err := s.l2Cache.Set(ctx, key, data, ttl)
+			s.recordL2(err)
+			if err == nil {
+				s.tagL2(ctx, key, entry.Tags)
+			}
 		}
 	}
+	s.recordOp(opSet, key, false, startTime, len(req.Value))
 
 	return &SetResponse{
 		Success:   true,
-		ExpiresAt: expiresAt,
+		Version:   entry.Version,
+		ExpiresAt: entry.ExpiresAt,
 	}, nil
 }
 
+// setL1 validates req, writes it to L1 if its condition holds for the L1 entry and
+// returns the entry (for L2 write-through) with its effective TTL.
+func (s *Service) setL1(key string, req *SetRequest) (*CacheEntry, time.Duration, error) {
+	entry, ttl, err := s.newEntry(key, req)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	version, err := s.l1For(key).SetIf(key, s.compress(req.Value), ttl, EntryOptions{StaleTTL: entry.StaleTTL, Tags: entry.Tags, Sliding: entry.Sliding, Source: "set"}, req.condition())
+	if err != nil {
+		return nil, 0, err
+	}
+	entry.Version = version
+	s.metrics.Sets.Add(1)
+
+	return entry, ttl, nil
+}
+
+// setConditional applies a conditional write when L2 is shared between instances.
+// The condition is evaluated against the L2 entry, since other instances may have
+// written the key since this one cached it. With a ConditionalRemoteCache the check
+// and write are a single compare-and-set, so racing writers on any instance cannot
+// both succeed; while the L2 breaker is open such writes fail with ErrCircuitOpen.
+// Otherwise L1 is first refreshed from L2 (when reachable) and the check is atomic
+// per instance only.
+func (s *Service) setConditional(ctx context.Context, key string, req *SetRequest) (*CacheEntry, error) {
+	cas, ok := s.l2Cache.(ConditionalRemoteCache)
+	if !ok {
+		if s.l2Breaker.Allow() {
+			data, found, err := s.l2Cache.Get(ctx, key)
+			s.recordL2(err)
+			if err == nil && found {
+				s.entryFromL2(key, data, time.Now())
+			}
+		}
+		entry, ttl, err := s.setL1(key, req)
+		if err != nil {
+			return nil, err
+		}
+		if !s.l2Breaker.Allow() {
+			return entry, nil
+		}
+		data, err := s.encodeL2(entry)
+		if err != nil {
+			s.l2Breaker.Release()
+			return nil, fmt.Errorf("failed to marshal entry: %w", err)
+		}
+		err = s.l2Cache.Set(ctx, key, data, ttl)
+		s.recordL2(err)
+		s.tagL2(ctx, key, entry.Tags)
+		return entry, nil
+	}
+
+	entry, ttl, err := s.newEntry(key, req)
+	if err != nil {
+		return nil, err
+	}
+
+	if !s.l2Breaker.Allow() {
+		return nil, fmt.Errorf("conditional write: %w", ErrCircuitOpen)
+	}
+	old, found, err := s.l2Cache.Get(ctx, key)
+	s.recordL2(err)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read current entry: %w", err)
+	}
+
+	var current CacheEntry
+	present := false
+	if found {
+		if err := s.decodeL2(old, &current); err == nil && current.ExpiresAt.Add(current.StaleTTL).After(time.Now()) {
+			present = !current.Tombstone
+		}
+	} else {
+		old = nil
+	}
+	if !req.condition().Matches(current.Version, present) {
+		return nil, ErrVersionConflict
+	}
+
+	entry.Version = nextVersion(current.Version)
+	data, err := s.encodeL2(entry)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal entry: %w", err)
+	}
+	if !s.l2Breaker.Allow() {
+		return nil, fmt.Errorf("conditional write: %w", ErrCircuitOpen)
+	}
+	swapped, err := cas.CompareAndSet(ctx, key, old, data, ttl)
+	s.recordL2(err)
+	if err != nil {
+		return nil, fmt.Errorf("failed to write entry: %w", err)
+	}
+	if !swapped {
+		return nil, ErrVersionConflict
+	}
+
+	s.l1For(key).SetWithOptions(key, s.compress(req.Value), ttl, EntryOptions{StaleTTL: entry.StaleTTL, Version: entry.Version, Tags: entry.Tags, Sliding: entry.Sliding, Source: "set"})
+	s.metrics.Sets.Add(1)
+	s.tagL2(ctx, key, entry.Tags)
+	return entry, nil
+}
+
+// Compare-and-set attempts of one casL2 call. Retries wait a random time up to a
+// bound that doubles per attempt, so instances racing on a hot key spread out instead
+// of colliding again.
+const (
+	maxCASAttempts  = 16
+	firstCASBackoff = time.Millisecond
+	maxCASBackoff   = 50 * time.Millisecond
+)
+
+// casL2 replaces the L2 entry at key with update's result using compare-and-set.
+// update receives the live entry (nil if the key is missing, expired or a tombstone)
+// and returns its replacement, or an error to abort. When another writer changes the
+// entry in between, update runs again on the new one, after a backoff; retries are
+// counted in retries if set. Fails with ErrVersionConflict once the attempts are used
+// up, or ErrCircuitOpen while the L2 breaker is open.
+func (s *Service) casL2(ctx context.Context, cas ConditionalRemoteCache, key string, retries *atomic.Int64, update func(current *CacheEntry, now time.Time) (*CacheEntry, error)) (*CacheEntry, error) {
+	for attempt := 0; attempt < maxCASAttempts; attempt++ {
+		if attempt > 0 {
+			if retries != nil {
+				retries.Add(1)
+			}
+			select {
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			case <-time.After(casBackoff(attempt)):
+			}
+		}
+		if !s.l2Breaker.Allow() {
+			return nil, ErrCircuitOpen
+		}
+		old, found, err := s.l2Cache.Get(ctx, key)
+		s.recordL2(err)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read current entry: %w", err)
+		}
+
+		now := time.Now()
+		var current *CacheEntry
+		if found {
+			var decoded CacheEntry
+			if err := s.decodeL2(old, &decoded); err == nil && decoded.ExpiresAt.After(now) && !decoded.Tombstone {
+				current = &decoded
+			}
+		} else {
+			old = nil
+		}
+		next, err := update(current, now)
+		if err != nil {
+			return nil, err
+		}
+		data, err := s.encodeL2(next)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal entry: %w", err)
+		}
+
+		if !s.l2Breaker.Allow() {
+			return nil, ErrCircuitOpen
+		}
+		swapped, err := cas.CompareAndSet(ctx, key, old, data, l2TTL(next.ExpiresAt, now))
+		s.recordL2(err)
+		if err != nil {
+			return nil, fmt.Errorf("failed to write entry: %w", err)
+		}
+		if swapped {
+			return next, nil
+		}
+	}
+	return nil, ErrVersionConflict
+}
+
+// casBackoff returns a random wait in [0, min(firstCASBackoff<<(attempt-1), maxCASBackoff)).
+func casBackoff(attempt int) time.Duration {
+	bound := maxCASBackoff
+	if attempt <= 6 {
+		if b := firstCASBackoff << uint(attempt-1); b < bound {
+			bound = b
+		}
+	}
+	return time.Duration(rand.Int63n(int64(bound)))
+}
+
+// tagL2 records key under tags in the L2 tag index, when the L2 maintains one and its
+// breaker allows the call. Failures are counted but not surfaced, like other
+// write-through errors.
+func (s *Service) tagL2(ctx context.Context, key string, tags []string) {
+	if len(tags) == 0 {
+		return
+	}
+	tagged, ok := s.l2Cache.(TaggedRemoteCache)
+	if !ok || !s.l2Breaker.Allow() {
+		return
+	}
+	s.recordL2(tagged.AddTags(ctx, key, tags))
+}
+
+// newEntry validates req and builds the entry it describes, with its effective TTL.
+func (s *Service) newEntry(key string, req *SetRequest) (*CacheEntry, time.Duration, error) {
+	if err := validateKey(key); err != nil {
+		return nil, 0, err
+	}
+	if len(req.Value) == 0 {
+		return nil, 0, errors.New("value cannot be empty")
+	}
+	if req.IfAbsent && req.IfVersion != 0 {
+		return nil, 0, errors.New("if_absent and if_version are mutually exclusive")
+	}
+	tags, err := normalizeTags(req.Tags)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	ttl := s.defaultTTLFor(key)
+	if req.TTL > 0 {
+		ttl = time.Duration(req.TTL) * time.Second
+	}
+
+	staleTTL := s.config.StaleTTL
+	if req.StaleTTL > 0 {
+		staleTTL = time.Duration(req.StaleTTL) * time.Second
+	} else if req.StaleTTL < 0 {
+		staleTTL = 0
+	}
+
+	return &CacheEntry{
+		Value:     req.Value,
+		CachedAt:  time.Now(),
+		ExpiresAt: time.Now().Add(ttl),
+		StaleTTL:  staleTTL,
+		Tags:      tags,
+		BaseTTL:   ttl,
+		Sliding:   req.Sliding,
+	}, ttl, nil
+}
+
+// normalizeTags validates tags and removes duplicates, preserving order.
+func normalizeTags(tags []string) ([]string, error) {
+	if len(tags) == 0 {
+		return nil, nil
+	}
+	if len(tags) > MaxTagsPerEntry {
+		return nil, fmt.Errorf("too many tags: %d (max %d)", len(tags), MaxTagsPerEntry)
+	}
+
+	seen := make(map[string]bool, len(tags))
+	result := make([]string, 0, len(tags))
+	for _, tag := range tags {
+		if tag == "" {
+			return nil, errors.New("tags cannot be empty")
+		}
+		if !seen[tag] {
+			seen[tag] = true
+			result = append(result, tag)
+		}
+	}
+	return result, nil
+}
+
 // Invalidate removes keys from cache and publishes invalidation event.
-// Complexity: O(k) for k keys, O(n) for pattern matching.
+// Keys, Pattern and Tags may be combined; a tag drops every entry set with it.
+// Namespace alone flushes one namespace, in L1 and L2, without scanning other keys.
+// Complexity: O(k) for k keys, O(n) for pattern matching, O(m) for m tagged entries.
 //
 //encore:api public method=POST path=/api/cache/invalidate
 func Invalidate(ctx context.Context, req *InvalidateRequest) (*InvalidateResponse, error) {
@@ -371,34 +1272,65 @@ func Invalidate(ctx context.Context, req *InvalidateRequest) (*InvalidateRespons
 }
 
 func (s *Service) Invalidate(ctx context.Context, req *InvalidateRequest) (*InvalidateResponse, error) {
+	if req.Namespace != "" {
+		return s.invalidateNamespace(ctx, req)
+	}
+	for _, key := range req.Keys {
+		if reservedKey(key) {
+			return nil, fmt.Errorf("invalidate %q: %w", key, ErrReservedKey)
+		}
+	}
+	if reservedKey(req.Pattern) {
+		return nil, fmt.Errorf("invalidate %q: %w", req.Pattern, ErrReservedKey)
+	}
+
 	count := 0
 
 	// Invalidate specific keys
 	for _, key := range req.Keys {
-		if s.l1Cache.Delete(key) {
+		startTime := time.Now()
+		found := s.l1For(key).Delete(key)
+		if found {
 			count++
 		}
-		if s.config.L2Enabled && s.l2Cache != nil {
-			_ = s.l2Cache.Delete(ctx, key)
-		}
+		s.deleteL2(func() error { return s.l2Cache.Delete(ctx, key) })
 		s.metrics.Deletes.Add(1)
+		s.recordOp(opDelete, key, found, startTime, 0)
 	}
 
 	// Invalidate by pattern
 	if req.Pattern != "" {
-		deleted := s.l1Cache.DeletePattern(req.Pattern)
+		startTime := time.Now()
+		deleted := 0
+		for _, l1 := range s.allL1() {
+			deleted += l1.DeletePattern(req.Pattern)
+		}
 		count += deleted
-		if s.config.L2Enabled && s.l2Cache != nil {
-			_ = s.l2Cache.DeletePattern(ctx, req.Pattern)
+		s.deleteL2(func() error { return s.l2Cache.DeletePattern(ctx, req.Pattern) })
+		s.metrics.Deletes.Add(int64(deleted))
+		s.recordOp(opInvalidate, req.Pattern, deleted > 0, startTime, deleted)
+	}
+
+	// Invalidate by tag
+	for _, tag := range req.Tags {
+		startTime := time.Now()
+		deleted := 0
+		for _, l1 := range s.allL1() {
+			deleted += l1.DeleteTag(tag)
 		}
+		count += deleted
+		s.deleteTagL2(ctx, tag)
 		s.metrics.Deletes.Add(int64(deleted))
+		s.recordOp(opInvalidate, tag, deleted > 0, startTime, deleted)
 	}
 
-	// Publish invalidation event for distributed coordination
-	if count > 0 {
+	// Publish invalidation event for distributed coordination. Tags are always
+	// broadcast: other instances may hold tagged entries this one never cached.
+	if count > 0 || len(req.Tags) > 0 {
 		event := &invalidation.InvalidationEvent{
 			Pattern:     req.Pattern,
 			MatchedKeys: req.Keys,
+			Tags:        req.Tags,
 			TriggeredBy: "cache_manager",
 			Timestamp:   time.Now(),
 			RequestID:   "",
@@ -412,6 +1344,44 @@ func (s *Service) Invalidate(ctx context.Context, req *InvalidateRequest) (*Inva
 	}, nil
 }
 
+// invalidateNamespace flushes req.Namespace and broadcasts it as a pattern
+// invalidation, which other instances route to the same namespace.
+func (s *Service) invalidateNamespace(ctx context.Context, req *InvalidateRequest) (*InvalidateResponse, error) {
+	if len(req.Keys) > 0 || req.Pattern != "" || len(req.Tags) > 0 {
+		return nil, errors.New("namespace cannot be combined with keys, pattern or tags")
+	}
+
+	startTime := time.Now()
+	count, err := s.flushNamespace(ctx, req.Namespace)
+	if err != nil {
+		return nil, err
+	}
+	s.metrics.Deletes.Add(int64(count))
+	pattern := req.Namespace + NamespaceSeparator + "*"
+	s.recordOp(opInvalidate, pattern, count > 0, startTime, count)
+
+	event := &invalidation.InvalidationEvent{
+		Pattern:     pattern,
+		TriggeredBy: "cache_manager",
+		Timestamp:   time.Now(),
+		RequestID:   "",
+	}
+	_, _ = invalidation.CacheInvalidateTopic.Publish(ctx, event)
+
+	return &InvalidateResponse{
+		Invalidated: count,
+		Success:     true,
+	}, nil
+}
+
+// deleteTagL2 deletes tag's entries from L2 when the L2 maintains a tag index, even
+// while its breaker is open (see deleteL2).
+func (s *Service) deleteTagL2(ctx context.Context, tag string) {
+	if tagged, ok := s.l2Cache.(TaggedRemoteCache); ok {
+		s.deleteL2(func() error { return tagged.DeleteTag(ctx, tag) })
+	}
+}
+
 // GetMetrics returns current cache performance metrics.
 //
 //encore:api public method=GET path=/api/cache/metrics
@@ -432,21 +1402,77 @@ func (s *Service) GetMetrics(ctx context.Context) (*MetricsResponse, error) {
 		hitRate = float64(hits) / float64(total)
 	}
 
+	l1Size, l1Bytes := 0, int64(0)
+	var evictions, expirations int64
+	for _, l1 := range s.allL1() {
+		l1Size += l1.Size()
+		l1Bytes += l1.Bytes()
+		evictions += l1.Evictions()
+		expirations += l1.Expirations()
+	}
+
 	return &MetricsResponse{
-		Hits:      hits,
-		Misses:    misses,
-		HitRate:   hitRate,
-		Sets:      s.metrics.Sets.Load(),
-		Deletes:   s.metrics.Deletes.Load(),
-		Evictions: s.metrics.Evictions.Load(),
-		L1Size:    s.l1Cache.Size(),
-		L2Hits:    s.metrics.L2Hits.Load(),
-		L2Misses:  s.metrics.L2Misses.Load(),
-		L2Errors:  s.metrics.L2Errors.Load(),
+		Hits:        hits,
+		Misses:      misses,
+		HitRate:     hitRate,
+		Sets:        s.metrics.Sets.Load(),
+		Deletes:     s.metrics.Deletes.Load(),
+		Evictions:   evictions,
+		Expirations: expirations,
+		L1Size:      l1Size,
+		L1Bytes:     l1Bytes,
+		L2Hits:      s.metrics.L2Hits.Load(),
+		L2Misses:    s.metrics.L2Misses.Load(),
+		L2Errors:    s.metrics.L2Errors.Load(),
+
+		StaleHits:          s.metrics.StaleHits.Load(),
+		StaleRefreshErrors: s.metrics.StaleRefreshErrors.Load(),
+		EarlyRefreshes:     s.metrics.EarlyRefreshes.Load(),
+		EarlyRefreshErrors: s.metrics.EarlyRefreshErrors.Load(),
+		NegativeHits:       s.metrics.NegativeHits.Load(),
+		CachedErrorHits:    s.metrics.CachedErrorHits.Load(),
+
+		RefreshAheads:        s.metrics.RefreshAheads.Load(),
+		RefreshAheadErrors:   s.metrics.RefreshAheadErrors.Load(),
+		RefreshAheadDeferred: s.metrics.RefreshAheadDeferred.Load(),
+
+		CompressionRatio:  s.compressionRatio(),
+		CompressionErrors: s.metrics.CompressionErrors.Load(),
+
+		SnapshotRestored: s.metrics.SnapshotRestored.Load(),
+		SnapshotErrors:   s.metrics.SnapshotErrors.Load(),
+
+		WriteBehindDepth:          s.writeBehindDepth(),
+		WriteBehindFlushed:        s.metrics.WriteBehindFlushed.Load(),
+		WriteBehindRetries:        s.metrics.WriteBehindRetries.Load(),
+		WriteBehindDrops:          s.metrics.WriteBehindDrops.Load(),
+		WriteBehindFlushLatencyMs: s.writeBehindLatencyMs(),
+
+		ClusterForwards:  s.metrics.ClusterForwards.Load(),
+		ClusterFallbacks: s.metrics.ClusterFallbacks.Load(),
+
+		HotKeys:          s.hotKeyCount(),
+		HotKeyPromotions: s.metrics.HotKeyPromotions.Load(),
+		HotKeyDemotions:  s.metrics.HotKeyDemotions.Load(),
+
+		Incrs:       s.metrics.Incrs.Load(),
+		IncrRetries: s.metrics.IncrRetries.Load(),
+
+		LeaseAcquires:    s.metrics.LeaseAcquires.Load(),
+		LeaseConflicts:   s.metrics.LeaseConflicts.Load(),
+		LeaseExpirations: s.metrics.LeaseExpirations.Load(),
+
+		EvictionEventDrops: s.metrics.EvictionEventDrops.Load(),
+		MetricEventDrops:   s.metrics.MetricEventDrops.Load(),
+
+		Breakers: s.breakerStatuses(),
+
+		Namespaces: s.namespaceMetrics(hits, misses),
 	}, nil
 }
 
-// runTTLCleanup periodically removes expired entries from L1.
+// runTTLCleanup periodically removes expired entries from L1, and expired leases
+// from the local lease table.
 func (s *Service) runTTLCleanup() {
 	defer s.wg.Done()
 	ticker := time.NewTicker(s.config.CleanupInterval)
@@ -457,13 +1483,16 @@ func (s *Service) runTTLCleanup() {
 		case <-stopChan:
 			return
 		case <-ticker.C:
-			evicted := s.l1Cache.CleanupExpired()
-			s.metrics.Evictions.Add(int64(evicted))
+			for _, l1 := range s.allL1() {
+				l1.CleanupExpired()
+			}
+			s.metrics.LeaseExpirations.Add(int64(s.leases.expire(time.Now())))
 		}
 	}
 }
 
-// Shutdown gracefully stops the service.
+// Shutdown gracefully stops the service, writing an L1 snapshot when
+// Config.SnapshotPath is set.
 func (s *Service) Shutdown() {
 	// Avoid panic if Shutdown is called before init or multiple times.
 	if stopChan != nil {
@@ -475,4 +1504,17 @@ func (s *Service) Shutdown() {
 		}
 	}
 	s.wg.Wait()
+
+	// Background writers have stopped; drain what they left queued.
+	s.flushWriteBehind(context.Background(), true)
+
+	if s.config.SnapshotPath != "" {
+		if _, err := s.writeSnapshot(s.config.SnapshotPath); err != nil {
+			s.metrics.SnapshotErrors.Add(1)
+		}
+	}
+
+	if closer, ok := s.l2Cache.(io.Closer); ok {
+		_ = closer.Close()
+	}
 }
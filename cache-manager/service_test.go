@@ -1,16 +1,30 @@
 package cachemanager
 
 import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
 	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"testing"
 	"time"
 
 	"encore.app/invalidation"
+	"encore.app/monitoring"
 )
 
 func mustJSON(t *testing.T, v any) json.RawMessage {
@@ -99,9 +113,10 @@ func (m *MockOriginFetcher) ResetCalls() {
 
 // MockRemoteCache simulates L2 distributed cache.
 type MockRemoteCache struct {
-	mu    sync.RWMutex
-	data  map[string][]byte
-	calls map[string]int
+	mu     sync.RWMutex
+	data   map[string][]byte
+	calls  map[string]int
+	setErr error
 }
 
 func NewMockRemoteCache() *MockRemoteCache {
@@ -126,10 +141,20 @@ func (m *MockRemoteCache) Set(ctx context.Context, key string, value []byte, ttl
 	defer m.mu.Unlock()
 
 	m.calls["set"]++
+	if m.setErr != nil {
+		return m.setErr
+	}
 	m.data[key] = value
 	return nil
 }
 
+// FailSets makes Set return err until called again with nil.
+func (m *MockRemoteCache) FailSets(err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.setErr = err
+}
+
 func (m *MockRemoteCache) Delete(ctx context.Context, key string) error {
 	m.mu.Lock()
 	defer m.mu.Unlock()
@@ -318,6 +343,557 @@ func TestL1Cache_CleanupExpired(t *testing.T) {
 	}
 }
 
+func TestShardedL1Cache_ShardCount(t *testing.T) {
+	tests := []struct {
+		maxEntries int
+		shards     int
+		want       int
+	}{
+		{maxEntries: 1000, shards: 16, want: 16},
+		{maxEntries: 1000, shards: 10, want: 16}, // rounded up to power of two
+		{maxEntries: 1000, shards: 0, want: 1},
+		{maxEntries: 4, shards: 64, want: 4}, // capped to capacity
+	}
+
+	for _, tt := range tests {
+		cache := NewShardedL1Cache(tt.maxEntries, tt.shards)
+		if got := cache.ShardCount(); got != tt.want {
+			t.Errorf("NewShardedL1Cache(%d, %d).ShardCount() = %d, want %d",
+				tt.maxEntries, tt.shards, got, tt.want)
+		}
+	}
+}
+
+func TestShardedL1Cache_Operations(t *testing.T) {
+	cache := NewShardedL1Cache(1000, 8)
+
+	for i := 0; i < 100; i++ {
+		cache.Set(fmt.Sprintf("user:%d", i), mustJSON(t, i), 1*time.Hour)
+		cache.Set(fmt.Sprintf("product:%d", i), mustJSON(t, i), 1*time.Hour)
+	}
+	cache.Set("short", mustJSON(t, "gone"), 10*time.Millisecond)
+
+	if cache.Size() != 201 {
+		t.Errorf("Expected size 201, got %d", cache.Size())
+	}
+
+	entry, ok := cache.Get("user:42")
+	if !ok || string(entry.Value) != "42" {
+		t.Errorf("Expected user:42 = 42, got %v, ok=%v", entry, ok)
+	}
+
+	if deleted := cache.DeletePattern("user:*"); deleted != 100 {
+		t.Errorf("Expected 100 pattern deletions across shards, got %d", deleted)
+	}
+
+	time.Sleep(20 * time.Millisecond)
+	if expired := cache.CleanupExpired(); expired != 1 {
+		t.Errorf("Expected 1 expired entry, got %d", expired)
+	}
+
+	if cache.Size() != 100 {
+		t.Errorf("Expected size 100, got %d", cache.Size())
+	}
+
+	cache.Clear()
+	if cache.Size() != 0 {
+		t.Errorf("Expected size 0 after clear, got %d", cache.Size())
+	}
+}
+
+func TestShardedL1Cache_Capacity(t *testing.T) {
+	cache := NewShardedL1Cache(64, 4)
+
+	for i := 0; i < 1000; i++ {
+		cache.Set(fmt.Sprintf("key%d", i), mustJSON(t, i), 1*time.Hour)
+	}
+
+	if cache.Size() > 64 {
+		t.Errorf("Expected size <= 64, got %d", cache.Size())
+	}
+
+	// Most recently written key must survive eviction in its shard
+	if _, ok := cache.Get("key999"); !ok {
+		t.Error("Most recent key should not be evicted")
+	}
+}
+
+func TestShardedL1Cache_Concurrent(t *testing.T) {
+	cache := NewShardedL1Cache(1000, 16)
+
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 500; i++ {
+				key := fmt.Sprintf("key%d", (g*500+i)%200)
+				cache.Set(key, json.RawMessage(`"v"`), 1*time.Hour)
+				cache.Get(key)
+				if i%50 == 0 {
+					cache.Delete(key)
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+
+	if cache.Size() > 200 {
+		t.Errorf("Expected at most 200 entries, got %d", cache.Size())
+	}
+}
+
+func TestL1Cache_ByteBudget(t *testing.T) {
+	cache := NewL1CacheWithBudget(1000, 100, 1)
+
+	// Each entry: 4-byte key + 20-byte value = 24 bytes
+	value := json.RawMessage(`"aaaaaaaaaaaaaaaaaa"`)
+	for i := 0; i < 4; i++ {
+		cache.Set(fmt.Sprintf("key%d", i), value, 1*time.Hour)
+	}
+	if cache.Bytes() != 96 {
+		t.Errorf("Expected 96 bytes, got %d", cache.Bytes())
+	}
+
+	// Fifth entry exceeds 100 bytes, evicting key0 from the LRU tail
+	cache.Set("key4", value, 1*time.Hour)
+	if _, ok := cache.Get("key0"); ok {
+		t.Error("key0 should be evicted by byte budget")
+	}
+	if cache.Bytes() > 100 {
+		t.Errorf("Expected bytes <= 100, got %d", cache.Bytes())
+	}
+
+	// Growing an existing entry evicts others, not itself
+	big := json.RawMessage(`"` + strings.Repeat("b", 60) + `"`)
+	cache.Set("key4", big, 1*time.Hour)
+	if _, ok := cache.Get("key4"); !ok {
+		t.Error("key4 should survive its own update")
+	}
+	if cache.Bytes() > 100 {
+		t.Errorf("Expected bytes <= 100 after update, got %d", cache.Bytes())
+	}
+
+	// Values larger than the whole budget are not cached
+	huge := json.RawMessage(`"` + strings.Repeat("c", 200) + `"`)
+	cache.Set("huge", huge, 1*time.Hour)
+	if _, ok := cache.Get("huge"); ok {
+		t.Error("Oversized value should not be cached")
+	}
+
+	cache.Delete("key4")
+	cache.Clear()
+	if cache.Bytes() != 0 {
+		t.Errorf("Expected 0 bytes after clear, got %d", cache.Bytes())
+	}
+}
+
+func TestService_Metrics_L1Bytes(t *testing.T) {
+	svc, _, _ := setupTestService()
+
+	svc.Set(context.Background(), "key1", &SetRequest{Key: "key1", Value: json.RawMessage(`"value1"`)})
+
+	resp, err := svc.GetMetrics(context.Background())
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if want := int64(len("key1") + len(`"value1"`)); resp.L1Bytes != want {
+		t.Errorf("Expected l1_bytes %d, got %d", want, resp.L1Bytes)
+	}
+}
+
+func TestL1Cache_StaleWindow(t *testing.T) {
+	cache := NewL1Cache(100)
+
+	cache.SetWithOptions("key1", mustJSON(t, "value1"), 50*time.Millisecond, EntryOptions{StaleTTL: 200 * time.Millisecond})
+	cache.Set("key2", mustJSON(t, "value2"), 50*time.Millisecond)
+
+	time.Sleep(100 * time.Millisecond)
+
+	if _, ok := cache.Get("key1"); ok {
+		t.Error("Get should not return an expired entry")
+	}
+	entry, stale, ok := cache.GetStale("key1")
+	if !ok || !stale {
+		t.Fatalf("Expected stale entry, got ok=%v stale=%v", ok, stale)
+	}
+	if mustJSONString(t, entry.Value) != "value1" {
+		t.Errorf("Expected value1, got %s", string(entry.Value))
+	}
+	if entry.StaleTTL != 200*time.Millisecond {
+		t.Errorf("Expected stale TTL 200ms, got %v", entry.StaleTTL)
+	}
+	if _, _, ok := cache.GetStale("key2"); ok {
+		t.Error("Entry without stale window should not be served stale")
+	}
+
+	// Stale entries survive cleanup until the window closes
+	if removed := cache.CleanupExpired(); removed != 0 {
+		t.Errorf("Expected 0 removed during stale window, got %d", removed)
+	}
+	time.Sleep(200 * time.Millisecond)
+	if removed := cache.CleanupExpired(); removed != 1 {
+		t.Errorf("Expected 1 removed after stale window, got %d", removed)
+	}
+}
+
+func TestService_Get_StaleWhileRevalidate(t *testing.T) {
+	svc, mockOrigin, _ := setupTestService()
+	ctx := context.Background()
+
+	_, err := svc.Set(ctx, "key1", &SetRequest{Key: "key1", Value: mustJSON(t, "old"), TTL: 1, StaleTTL: 60})
+	if err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+	mockOrigin.Set("key1", "new")
+	mockOrigin.delay = 50 * time.Millisecond
+
+	time.Sleep(1100 * time.Millisecond)
+
+	// Concurrent readers all get the stale value without waiting on origin
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			start := time.Now()
+			resp, err := svc.Get(ctx, "key1")
+			if err != nil {
+				t.Errorf("Get failed: %v", err)
+				return
+			}
+			if !resp.Stale || resp.Source != "stale" || mustJSONString(t, resp.Value) != "old" {
+				t.Errorf("Expected stale old value, got stale=%v source=%s value=%s", resp.Stale, resp.Source, string(resp.Value))
+			}
+			if elapsed := time.Since(start); elapsed > 40*time.Millisecond {
+				t.Errorf("Stale read blocked for %v", elapsed)
+			}
+		}()
+	}
+	wg.Wait()
+
+	svc.wg.Wait()
+	if calls := mockOrigin.CallCount(); calls != 1 {
+		t.Errorf("Expected 1 background origin call, got %d", calls)
+	}
+
+	resp, err := svc.Get(ctx, "key1")
+	if err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	if resp.Stale || resp.Source != "l1" || mustJSONString(t, resp.Value) != "new" {
+		t.Errorf("Expected refreshed value from l1, got stale=%v source=%s value=%s", resp.Stale, resp.Source, string(resp.Value))
+	}
+
+	metrics, _ := svc.GetMetrics(ctx)
+	if metrics.StaleHits != 10 {
+		t.Errorf("Expected 10 stale hits, got %d", metrics.StaleHits)
+	}
+}
+
+func TestService_Get_StaleRefreshError(t *testing.T) {
+	svc, mockOrigin, _ := setupTestService()
+	ctx := context.Background()
+
+	svc.Set(ctx, "key1", &SetRequest{Key: "key1", Value: mustJSON(t, "old"), TTL: 1, StaleTTL: 60})
+	mockOrigin.SetError("key1", errors.New("origin down"))
+
+	time.Sleep(1100 * time.Millisecond)
+
+	resp, err := svc.Get(ctx, "key1")
+	if err != nil || !resp.Stale {
+		t.Fatalf("Expected stale response, got resp=%+v err=%v", resp, err)
+	}
+	svc.wg.Wait()
+
+	// Failed refresh keeps serving stale
+	resp, err = svc.Get(ctx, "key1")
+	if err != nil || !resp.Stale || mustJSONString(t, resp.Value) != "old" {
+		t.Errorf("Expected stale old value after failed refresh, got resp=%+v err=%v", resp, err)
+	}
+	svc.wg.Wait()
+
+	metrics, _ := svc.GetMetrics(ctx)
+	if metrics.StaleRefreshErrors != 2 {
+		t.Errorf("Expected 2 stale refresh errors, got %d", metrics.StaleRefreshErrors)
+	}
+}
+
+func TestService_ShouldRefreshEarly(t *testing.T) {
+	svc, _, _ := setupTestService()
+	svc.config.EarlyRefreshBeta = 1.0
+
+	orig := xfetchRand
+	defer func() { xfetchRand = orig }()
+
+	now := time.Now()
+	entry := &CacheEntry{ExpiresAt: now.Add(1 * time.Second), Delta: 100 * time.Millisecond}
+
+	// -ln(1) = 0: never early
+	xfetchRand = func() float64 { return 0 }
+	if svc.shouldRefreshEarly(entry, now) {
+		t.Error("Expected no early refresh for zero gap")
+	}
+
+	// -ln(e^-20) = 20: gap 2s exceeds the 1s remaining
+	xfetchRand = func() float64 { return 1 - math.Exp(-20) }
+	if !svc.shouldRefreshEarly(entry, now) {
+		t.Error("Expected early refresh when gap exceeds remaining TTL")
+	}
+
+	// Larger beta refreshes earlier: gap 10*100ms*-ln(0.5) ≈ 693ms
+	xfetchRand = func() float64 { return 0.5 }
+	if svc.shouldRefreshEarly(entry, now) {
+		t.Error("Expected no early refresh with beta 1")
+	}
+	svc.config.EarlyRefreshBeta = 10
+	if !svc.shouldRefreshEarly(entry, now.Add(500*time.Millisecond)) {
+		t.Error("Expected early refresh with beta 10")
+	}
+
+	// Disabled by beta 0 or unknown delta
+	xfetchRand = func() float64 { return 1 - math.Exp(-20) }
+	svc.config.EarlyRefreshBeta = 0
+	if svc.shouldRefreshEarly(entry, now) {
+		t.Error("Expected no early refresh when beta is 0")
+	}
+	svc.config.EarlyRefreshBeta = 1
+	if svc.shouldRefreshEarly(&CacheEntry{ExpiresAt: entry.ExpiresAt}, now) {
+		t.Error("Expected no early refresh without delta")
+	}
+}
+
+func TestService_Get_EarlyRefresh(t *testing.T) {
+	svc, mockOrigin, _ := setupTestService()
+	svc.config.EarlyRefreshBeta = 1.0
+	svc.config.DefaultTTL = 200 * time.Millisecond
+	ctx := context.Background()
+
+	orig := xfetchRand
+	defer func() { xfetchRand = orig }()
+	xfetchRand = func() float64 { return 0 }
+
+	mockOrigin.Set("key1", "v1")
+	mockOrigin.delay = 10 * time.Millisecond
+	if _, err := svc.Get(ctx, "key1"); err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	entry, ok := svc.l1Cache.Get("key1")
+	if !ok || entry.Delta < 10*time.Millisecond {
+		t.Fatalf("Expected recorded delta >= 10ms, got %+v", entry)
+	}
+
+	// Force the early refresh draw: gap >= 10ms * 30 exceeds the remaining TTL
+	xfetchRand = func() float64 { return 1 - math.Exp(-30) }
+	mockOrigin.Set("key1", "v2")
+	resp, err := svc.Get(ctx, "key1")
+	if err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	if resp.Source != "l1" || mustJSONString(t, resp.Value) != "v1" {
+		t.Errorf("Expected current l1 value while refreshing, got source=%s value=%s", resp.Source, string(resp.Value))
+	}
+	svc.wg.Wait()
+
+	// Refresh must bypass the L2 copy of the same generation and reach origin
+	if calls := mockOrigin.CallCount(); calls != 2 {
+		t.Errorf("Expected 2 origin calls, got %d", calls)
+	}
+	xfetchRand = func() float64 { return 0 }
+	resp, _ = svc.Get(ctx, "key1")
+	if mustJSONString(t, resp.Value) != "v2" {
+		t.Errorf("Expected refreshed value v2, got %s", string(resp.Value))
+	}
+
+	metrics, _ := svc.GetMetrics(ctx)
+	if metrics.EarlyRefreshes != 1 || metrics.EarlyRefreshErrors != 0 {
+		t.Errorf("Expected 1 early refresh and 0 errors, got %d/%d", metrics.EarlyRefreshes, metrics.EarlyRefreshErrors)
+	}
+}
+
+func TestService_RefreshAhead(t *testing.T) {
+	svc, mockOrigin, _ := setupTestService()
+	svc.config.DefaultTTL = 300 * time.Millisecond
+	svc.refreshAheader = newRefreshAheader(RefreshAheadConfig{Window: 0.5, MinHits: 2, MaxConcurrent: 1})
+	ctx := context.Background()
+
+	mockOrigin.delay = 10 * time.Millisecond
+	for _, key := range []string{"hot", "cold", "queued"} {
+		mockOrigin.Set(key, "v1")
+		if _, err := svc.Get(ctx, key); err != nil {
+			t.Fatalf("Get %s failed: %v", key, err)
+		}
+	}
+	// First hits land outside the refresh window
+	svc.Get(ctx, "hot")
+	svc.Get(ctx, "queued")
+	if svc.refreshAheader.active.Load() != 0 || mockOrigin.CallCount() != 3 {
+		t.Fatalf("Expected no refresh outside the window")
+	}
+
+	time.Sleep(180 * time.Millisecond)
+	for _, key := range []string{"hot", "cold", "queued"} {
+		mockOrigin.Set(key, "v2")
+	}
+
+	// Second hit inside the last half of the TTL refreshes in the background
+	resp, err := svc.Get(ctx, "hot")
+	if err != nil || mustJSONString(t, resp.Value) != "v1" {
+		t.Fatalf("Expected current value v1 while refreshing, got %v, err=%v", resp, err)
+	}
+	svc.wg.Wait()
+	if calls := mockOrigin.CallCount(); calls != 4 {
+		t.Errorf("Expected 4 origin calls, got %d", calls)
+	}
+
+	// A single hit is not enough
+	svc.Get(ctx, "cold")
+	svc.wg.Wait()
+	if calls := mockOrigin.CallCount(); calls != 4 {
+		t.Errorf("Expected no refresh for a key with one hit, got %d origin calls", calls)
+	}
+
+	// With every slot taken the read leaves the key to a later one
+	svc.refreshAheader.active.Store(1)
+	svc.Get(ctx, "queued")
+	svc.refreshAheader.active.Store(0)
+	svc.wg.Wait()
+	if calls := mockOrigin.CallCount(); calls != 4 {
+		t.Errorf("Expected no refresh while at the concurrency limit, got %d origin calls", calls)
+	}
+
+	resp, _ = svc.Get(ctx, "hot")
+	if mustJSONString(t, resp.Value) != "v2" {
+		t.Errorf("Expected refreshed value v2, got %s", string(resp.Value))
+	}
+	// The refreshed value counts its hits from zero
+	if keys := svc.l1Cache.ScanKeys("", 1, nil); len(keys) != 1 || keys[0].Key != "cold" || keys[0].Hits != 1 {
+		t.Errorf("Expected cold with 1 hit, got %+v", keys)
+	}
+	if keys := svc.l1Cache.ScanKeys("cold", 1, nil); len(keys) != 1 || keys[0].Key != "hot" || keys[0].Hits != 1 {
+		t.Errorf("Expected hot with 1 hit after its refresh, got %+v", keys)
+	}
+
+	metrics, _ := svc.GetMetrics(ctx)
+	if metrics.RefreshAheads != 1 || metrics.RefreshAheadErrors != 0 || metrics.RefreshAheadDeferred != 1 {
+		t.Errorf("Expected 1 refresh, 0 errors and 1 deferred, got %d/%d/%d",
+			metrics.RefreshAheads, metrics.RefreshAheadErrors, metrics.RefreshAheadDeferred)
+	}
+}
+
+func TestService_NegativeCache_Tombstone(t *testing.T) {
+	svc, mockOrigin, _ := setupTestService()
+	svc.config.NegativeTTL = 1 * time.Hour
+	ctx := context.Background()
+
+	mockOrigin.SetError("missing", fmt.Errorf("lookup user: %w", ErrNotFound))
+
+	resp, err := svc.Get(ctx, "missing")
+	if err != nil {
+		t.Fatalf("Expected tombstone, got error: %v", err)
+	}
+	if !resp.Hit || resp.Found || resp.Source != "origin" {
+		t.Errorf("Expected hit=true found=false source=origin, got %+v", resp)
+	}
+
+	resp, err = svc.Get(ctx, "missing")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !resp.Hit || resp.Found || resp.Source != "l1" || resp.Value != nil {
+		t.Errorf("Expected cached tombstone from l1, got %+v", resp)
+	}
+	if calls := mockOrigin.CallCount(); calls != 1 {
+		t.Errorf("Expected 1 origin call, got %d", calls)
+	}
+
+	// Writing the key replaces the tombstone
+	svc.Set(ctx, "missing", &SetRequest{Key: "missing", Value: mustJSON(t, "now here")})
+	resp, _ = svc.Get(ctx, "missing")
+	if !resp.Found || mustJSONString(t, resp.Value) != "now here" {
+		t.Errorf("Expected value after Set, got %+v", resp)
+	}
+
+	metrics, _ := svc.GetMetrics(ctx)
+	if metrics.NegativeHits != 1 {
+		t.Errorf("Expected 1 negative hit, got %d", metrics.NegativeHits)
+	}
+}
+
+func TestService_NegativeCache_Disabled(t *testing.T) {
+	svc, mockOrigin, _ := setupTestService()
+	mockOrigin.SetError("missing", ErrNotFound)
+
+	for i := 0; i < 2; i++ {
+		_, err := svc.Get(context.Background(), "missing")
+		if !errors.Is(err, ErrNotFound) {
+			t.Errorf("Expected ErrNotFound, got %v", err)
+		}
+	}
+	if calls := mockOrigin.CallCount(); calls != 2 {
+		t.Errorf("Expected 2 origin calls without negative caching, got %d", calls)
+	}
+}
+
+func TestService_NegativeCache_L2Tombstone(t *testing.T) {
+	svc, mockOrigin, mockL2 := setupTestService()
+	ctx := context.Background()
+
+	data, _ := json.Marshal(CacheEntry{
+		CachedAt:  time.Now(),
+		ExpiresAt: time.Now().Add(time.Minute),
+		Tombstone: true,
+	})
+	mockL2.Set(ctx, "missing", data, time.Minute)
+
+	resp, err := svc.Get(ctx, "missing")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if resp.Found || resp.Source != "l2" {
+		t.Errorf("Expected tombstone from l2, got %+v", resp)
+	}
+	if entry, ok := svc.l1Cache.Get("missing"); !ok || !entry.Tombstone {
+		t.Error("Expected L2 tombstone to populate L1")
+	}
+	if calls := mockOrigin.CallCount(); calls != 0 {
+		t.Errorf("Expected no origin calls, got %d", calls)
+	}
+}
+
+func TestService_NegativeCache_Errors(t *testing.T) {
+	svc, mockOrigin, _ := setupTestService()
+	svc.config.ErrorTTL = 100 * time.Millisecond
+	ctx := context.Background()
+
+	mockOrigin.SetError("flaky", errors.New("connection refused"))
+
+	if _, err := svc.Get(ctx, "flaky"); err == nil {
+		t.Fatal("Expected origin error")
+	}
+	_, err := svc.Get(ctx, "flaky")
+	if err == nil || !strings.Contains(err.Error(), "connection refused") {
+		t.Errorf("Expected cached origin error, got %v", err)
+	}
+	if calls := mockOrigin.CallCount(); calls != 1 {
+		t.Errorf("Expected 1 origin call while error is cached, got %d", calls)
+	}
+
+	time.Sleep(150 * time.Millisecond)
+	mockOrigin.SetError("flaky", nil)
+	mockOrigin.Set("flaky", "ok")
+
+	resp, err := svc.Get(ctx, "flaky")
+	if err != nil || mustJSONString(t, resp.Value) != "ok" {
+		t.Errorf("Expected recovery after ErrorTTL, got resp=%+v err=%v", resp, err)
+	}
+
+	metrics, _ := svc.GetMetrics(ctx)
+	if metrics.CachedErrorHits != 1 {
+		t.Errorf("Expected 1 cached error hit, got %d", metrics.CachedErrorHits)
+	}
+}
+
 func TestService_Get_L1Hit(t *testing.T) {
 	svc, _, _ := setupTestService()
 
@@ -661,32 +1237,91 @@ func TestHandleRefreshEvent(t *testing.T) {
 	}
 }
 
-func TestConcurrentAccess(t *testing.T) {
-	svc, mockOrigin, _ := setupTestService()
-
-	// Set up origin data
-	for i := 0; i < 100; i++ {
-		mockOrigin.Set(fmt.Sprintf("key%d", i), fmt.Sprintf("value%d", i))
-	}
+func TestHandleInvalidateEvent_ClearsTombstone(t *testing.T) {
+	testSvc, mockOrigin, _ := setupTestService()
+	testSvc.config.NegativeTTL = 1 * time.Hour
+	mockOrigin.SetError("missing", ErrNotFound)
 
-	// Concurrent reads and writes
-	var wg sync.WaitGroup
-	errors := make(chan error, 300)
+	prev := svc
+	svc = testSvc
+	defer func() { svc = prev }()
 
-	// 100 concurrent readers
-	for i := 0; i < 100; i++ {
-		wg.Add(1)
-		go func(key string) {
-			defer wg.Done()
-			_, err := svc.Get(context.Background(), key)
-			if err != nil {
-				errors <- err
-			}
-		}(fmt.Sprintf("key%d", i%50))
+	if _, err := testSvc.Get(context.Background(), "missing"); err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	if entry, ok := testSvc.l1Cache.Get("missing"); !ok || !entry.Tombstone {
+		t.Fatal("Expected tombstone in L1")
 	}
 
-	// 100 concurrent writers
-	for i := 0; i < 100; i++ {
+	event := &invalidation.InvalidationEvent{
+		MatchedKeys: []string{"missing"},
+		Timestamp:   time.Now(),
+	}
+	if err := HandleInvalidateEvent(context.Background(), event); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if _, ok := testSvc.l1Cache.Get("missing"); ok {
+		t.Error("Tombstone should be cleared by invalidation event")
+	}
+}
+
+func TestHandleInvalidateEvent_Tags(t *testing.T) {
+	testSvc, _, _ := setupTestService()
+	prev := svc
+	svc = testSvc
+	defer func() { svc = prev }()
+
+	ctx := context.Background()
+	testSvc.Set(ctx, "page:1", &SetRequest{Value: mustJSON(t, "p1"), Tags: []string{"product:42"}})
+	testSvc.Set(ctx, "search:shoes", &SetRequest{Value: mustJSON(t, "s"), Tags: []string{"product:42", "product:7"}})
+	testSvc.Set(ctx, "page:2", &SetRequest{Value: mustJSON(t, "p2"), Tags: []string{"product:7"}})
+
+	event := &invalidation.InvalidationEvent{
+		Tags:        []string{"product:42"},
+		TriggeredBy: "admin",
+		Timestamp:   time.Now(),
+	}
+	if err := HandleInvalidateEvent(ctx, event); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	for _, key := range []string{"page:1", "search:shoes"} {
+		if _, ok := testSvc.l1Cache.Get(key); ok {
+			t.Errorf("%s should be dropped by its tag", key)
+		}
+	}
+	if _, ok := testSvc.l1Cache.Get("page:2"); !ok {
+		t.Error("page:2 does not carry the tag and should remain")
+	}
+}
+
+func TestConcurrentAccess(t *testing.T) {
+	svc, mockOrigin, _ := setupTestService()
+
+	// Set up origin data
+	for i := 0; i < 100; i++ {
+		mockOrigin.Set(fmt.Sprintf("key%d", i), fmt.Sprintf("value%d", i))
+	}
+
+	// Concurrent reads and writes
+	var wg sync.WaitGroup
+	errors := make(chan error, 300)
+
+	// 100 concurrent readers
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func(key string) {
+			defer wg.Done()
+			_, err := svc.Get(context.Background(), key)
+			if err != nil {
+				errors <- err
+			}
+		}(fmt.Sprintf("key%d", i%50))
+	}
+
+	// 100 concurrent writers
+	for i := 0; i < 100; i++ {
 		wg.Add(1)
 		go func(i int) {
 			defer wg.Done()
@@ -761,10 +1396,13 @@ func TestTTLCleanup_Background(t *testing.T) {
 	// Wait for cleanup to run
 	time.Sleep(200 * time.Millisecond)
 
-	// Check evictions happened
-	evictions := svc.metrics.Evictions.Load()
-	if evictions < 2 {
-		t.Errorf("Expected at least 2 evictions, got %d", evictions)
+	// Check expirations happened, counted apart from capacity evictions
+	expirations := svc.l1Cache.Expirations()
+	if expirations < 2 {
+		t.Errorf("Expected at least 2 expirations, got %d", expirations)
+	}
+	if evictions := svc.l1Cache.Evictions(); evictions != 0 {
+		t.Errorf("Expected no capacity evictions, got %d", evictions)
 	}
 
 	// Verify expired keys removed
@@ -817,6 +1455,24 @@ func BenchmarkL1Cache_ConcurrentGet(b *testing.B) {
 	})
 }
 
+func BenchmarkShardedL1Cache_ConcurrentGet(b *testing.B) {
+	cache := NewShardedL1Cache(10000, DefaultL1Shards)
+
+	// Pre-populate
+	for i := 0; i < 1000; i++ {
+		cache.Set(fmt.Sprintf("key%d", i), json.RawMessage(`"value"`), 1*time.Hour)
+	}
+
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			cache.Get(fmt.Sprintf("key%d", i%1000))
+			i++
+		}
+	})
+}
+
 func BenchmarkRequestCoalescer(b *testing.B) {
 	coalescer := NewRequestCoalescer()
 
@@ -884,128 +1540,3086 @@ func TestService_CustomTTL(t *testing.T) {
 	}
 }
 
-func TestL1Cache_Size(t *testing.T) {
-	cache := NewL1Cache(100)
+// mockBatchOrigin adds FetchBatch to MockOriginFetcher. Missing keys are omitted
+// from the result rather than failing the batch.
+type mockBatchOrigin struct {
+	*MockOriginFetcher
+	batchCalls atomic.Int64
+	lastBatch  []string
+}
 
-	if cache.Size() != 0 {
-		t.Errorf("Expected size 0, got %d", cache.Size())
+func (m *mockBatchOrigin) FetchBatch(ctx context.Context, keys []string) (map[string]interface{}, error) {
+	m.batchCalls.Add(1)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.lastBatch = append([]string(nil), keys...)
+	values := make(map[string]interface{}, len(keys))
+	for _, key := range keys {
+		if v, ok := m.data[key]; ok {
+			values[key] = v
+		}
 	}
+	return values, nil
+}
 
-	cache.Set("key1", mustJSON(t, "value1"), 1*time.Hour)
-	cache.Set("key2", mustJSON(t, "value2"), 1*time.Hour)
+func TestService_MGet(t *testing.T) {
+	svc, mockOrigin, mockL2 := setupTestService()
+	ctx := context.Background()
 
-	if cache.Size() != 2 {
-		t.Errorf("Expected size 2, got %d", cache.Size())
+	svc.l1Cache.Set("l1key", mustJSON(t, "from-l1"), time.Hour)
+	l2Entry := CacheEntry{Value: mustJSON(t, "from-l2"), CachedAt: time.Now(), ExpiresAt: time.Now().Add(time.Hour), Source: "origin"}
+	mockL2.Set(ctx, "l2key", mustJSON(t, l2Entry), time.Hour)
+	mockOrigin.Set("originkey", "from-origin")
+
+	resp, err := svc.MGet(ctx, &MGetRequest{Keys: []string{"l1key", "l2key", "originkey", "missing", "", "originkey"}})
+	if err != nil {
+		t.Fatalf("MGet failed: %v", err)
+	}
+	if len(resp.Results) != 6 {
+		t.Fatalf("Expected 6 results, got %d", len(resp.Results))
 	}
 
-	cache.Delete("key1")
+	expect := []struct {
+		key, value, source string
+	}{
+		{"l1key", "from-l1", "l1"},
+		{"l2key", "from-l2", "l2"},
+		{"originkey", "from-origin", "origin"},
+	}
+	for i, e := range expect {
+		r := resp.Results[i]
+		if r.Key != e.key || r.Error != "" || r.Source != e.source || mustJSONString(t, r.Value) != e.value {
+			t.Errorf("Result %d: expected %s=%s from %s, got %+v", i, e.key, e.value, e.source, r)
+		}
+	}
+	if resp.Results[3].Error == "" || resp.Results[3].Found {
+		t.Errorf("Expected error for missing key, got %+v", resp.Results[3])
+	}
+	if resp.Results[4].Error == "" {
+		t.Errorf("Expected error for empty key, got %+v", resp.Results[4])
+	}
+	if resp.Results[5].Source != "origin" || mustJSONString(t, resp.Results[5].Value) != "from-origin" {
+		t.Errorf("Expected duplicate key to share the origin result, got %+v", resp.Results[5])
+	}
 
-	if cache.Size() != 1 {
-		t.Errorf("Expected size 1, got %d", cache.Size())
+	// "originkey" and "missing" each hit origin exactly once despite the duplicate
+	if calls := mockOrigin.CallCount(); calls != 2 {
+		t.Errorf("Expected 2 origin calls, got %d", calls)
+	}
+
+	// Fetched values populate L1
+	if _, ok := svc.l1Cache.Get("originkey"); !ok {
+		t.Error("Expected origin value to be cached in L1")
+	}
+	if _, ok := svc.l1Cache.Get("l2key"); !ok {
+		t.Error("Expected L2 value to be promoted to L1")
 	}
 }
 
-func TestL1Cache_Clear(t *testing.T) {
-	cache := NewL1Cache(100)
+func TestService_MGet_BatchOrigin(t *testing.T) {
+	svc, mockOrigin, _ := setupTestService()
+	svc.config.NegativeTTL = time.Minute
+	origin := &mockBatchOrigin{MockOriginFetcher: mockOrigin}
+	svc.originFetch = origin
+	ctx := context.Background()
 
-	cache.Set("key1", mustJSON(t, "value1"), 1*time.Hour)
-	cache.Set("key2", mustJSON(t, "value2"), 1*time.Hour)
+	mockOrigin.Set("a", "A")
+	mockOrigin.Set("b", "B")
 
-	cache.Clear()
+	resp, err := svc.MGet(ctx, &MGetRequest{Keys: []string{"b", "a", "nope"}})
+	if err != nil {
+		t.Fatalf("MGet failed: %v", err)
+	}
+	if got := origin.batchCalls.Load(); got != 1 {
+		t.Errorf("Expected 1 batch origin call, got %d", got)
+	}
+	if mockOrigin.CallCount() != 0 {
+		t.Errorf("Expected no single-key origin calls, got %d", mockOrigin.CallCount())
+	}
+	if strings.Join(origin.lastBatch, ",") != "a,b,nope" {
+		t.Errorf("Expected sorted batch a,b,nope, got %v", origin.lastBatch)
+	}
+	if mustJSONString(t, resp.Results[0].Value) != "B" || mustJSONString(t, resp.Results[1].Value) != "A" {
+		t.Errorf("Expected results in request order, got %+v", resp.Results)
+	}
+	if r := resp.Results[2]; r.Found || r.Error != "" {
+		t.Errorf("Expected tombstoned miss for nope, got %+v", r)
+	}
 
-	if cache.Size() != 0 {
-		t.Errorf("Expected size 0 after clear, got %d", cache.Size())
+	// Second call is served entirely from L1, including the tombstone
+	if _, err := svc.MGet(ctx, &MGetRequest{Keys: []string{"a", "b", "nope"}}); err != nil {
+		t.Fatalf("MGet failed: %v", err)
+	}
+	if got := origin.batchCalls.Load(); got != 1 {
+		t.Errorf("Expected cached results on second MGet, got %d batch calls", got)
 	}
+}
 
-	if _, ok := cache.Get("key1"); ok {
-		t.Error("Cache should be empty after clear")
+func TestService_MGet_Limits(t *testing.T) {
+	svc, _, _ := setupTestService()
+
+	if _, err := svc.MGet(context.Background(), &MGetRequest{}); err == nil {
+		t.Error("Expected error for empty keys")
+	}
+	if _, err := svc.MGet(context.Background(), &MGetRequest{Keys: make([]string, MaxBatchKeys+1)}); err == nil {
+		t.Error("Expected error for too many keys")
 	}
 }
 
-func TestRequestCoalescer_InFlight(t *testing.T) {
-	coalescer := NewRequestCoalescer()
+func TestService_MSet(t *testing.T) {
+	svc, _, mockL2 := setupTestService()
+	ctx := context.Background()
 
-	if coalescer.InFlight() != 0 {
-		t.Errorf("Expected 0 in-flight, got %d", coalescer.InFlight())
+	resp, err := svc.MSet(ctx, &MSetRequest{Entries: []SetRequest{
+		{Key: "k1", Value: mustJSON(t, "v1")},
+		{Key: "", Value: mustJSON(t, "bad")},
+		{Key: "k2", Value: mustJSON(t, "v2"), TTL: 60},
+	}})
+	if err != nil {
+		t.Fatalf("MSet failed: %v", err)
 	}
 
-	// Start a slow call
-	done := make(chan bool)
-	go func() {
-		coalescer.Do("key1", func() (interface{}, error) {
-			time.Sleep(100 * time.Millisecond)
-			return "result", nil
-		})
-		done <- true
-	}()
+	if !resp.Results[0].Success || !resp.Results[2].Success {
+		t.Errorf("Expected valid entries to succeed, got %+v", resp.Results)
+	}
+	if resp.Results[1].Success || resp.Results[1].Error == "" {
+		t.Errorf("Expected empty key to fail, got %+v", resp.Results[1])
+	}
+	if until := time.Until(*resp.Results[2].ExpiresAt); until > time.Minute || until < 50*time.Second {
+		t.Errorf("Expected k2 to expire in ~60s, got %v", until)
+	}
 
-	// Give goroutine time to start
-	time.Sleep(10 * time.Millisecond)
+	for _, key := range []string{"k1", "k2"} {
+		if _, ok := svc.l1Cache.Get(key); !ok {
+			t.Errorf("Expected %s in L1", key)
+		}
+		if _, ok, _ := mockL2.Get(ctx, key); !ok {
+			t.Errorf("Expected %s in L2", key)
+		}
+	}
+}
 
-	// Should have 1 in-flight
-	if coalescer.InFlight() != 1 {
-		t.Errorf("Expected 1 in-flight, got %d", coalescer.InFlight())
+func TestL1Cache_SetIf(t *testing.T) {
+	cache := NewL1Cache(10)
+
+	v1, err := cache.SetIf("key", mustJSON(t, "a"), time.Hour, EntryOptions{}, WriteCondition{IfAbsent: true})
+	if err != nil || v1 == 0 {
+		t.Fatalf("Expected if_absent write to succeed, got version=%d err=%v", v1, err)
+	}
+	if _, err := cache.SetIf("key", mustJSON(t, "b"), time.Hour, EntryOptions{}, WriteCondition{IfAbsent: true}); !errors.Is(err, ErrVersionConflict) {
+		t.Errorf("Expected conflict for if_absent on existing key, got %v", err)
+	}
+	if _, err := cache.SetIf("key", mustJSON(t, "b"), time.Hour, EntryOptions{}, WriteCondition{IfVersion: v1 + 1}); !errors.Is(err, ErrVersionConflict) {
+		t.Errorf("Expected conflict for wrong version, got %v", err)
 	}
 
-	<-done
+	v2, err := cache.SetIf("key", mustJSON(t, "b"), time.Hour, EntryOptions{}, WriteCondition{IfVersion: v1})
+	if err != nil || v2 <= v1 {
+		t.Fatalf("Expected matching version to write a higher version, got version=%d (prev %d) err=%v", v2, v1, err)
+	}
+	entry, _ := cache.Get("key")
+	if entry.Version != v2 || mustJSONString(t, entry.Value) != "b" {
+		t.Errorf("Expected b at version %d, got %+v", v2, entry)
+	}
 
-	// Should be back to 0
-	time.Sleep(10 * time.Millisecond)
-	if coalescer.InFlight() != 0 {
-		t.Errorf("Expected 0 in-flight after completion, got %d", coalescer.InFlight())
+	// Back-to-back unconditional writes still increase the version
+	v3 := cache.SetWithOptions("key", mustJSON(t, "c"), time.Hour, EntryOptions{})
+	v4 := cache.SetWithOptions("key", mustJSON(t, "d"), time.Hour, EntryOptions{})
+	if v3 <= v2 || v4 <= v3 {
+		t.Errorf("Expected increasing versions, got %d, %d, %d", v2, v3, v4)
+	}
+
+	// A tombstone counts as absent
+	cache.SetWithOptions("gone", nil, time.Hour, EntryOptions{Tombstone: true})
+	if _, err := cache.SetIf("gone", mustJSON(t, "x"), time.Hour, EntryOptions{}, WriteCondition{IfAbsent: true}); err != nil {
+		t.Errorf("Expected if_absent to succeed over a tombstone, got %v", err)
 	}
 }
 
-func TestRequestCoalescer_Forget(t *testing.T) {
-	coalescer := NewRequestCoalescer()
+func TestService_Set_Conditional(t *testing.T) {
+	svc, _, mockL2 := setupTestService()
+	ctx := context.Background()
 
-	// Start a call
-	go coalescer.Do("key1", func() (interface{}, error) {
-		time.Sleep(100 * time.Millisecond)
-		return "result", nil
-	})
+	created, err := svc.Set(ctx, "key", &SetRequest{Value: mustJSON(t, "v1"), IfAbsent: true})
+	if err != nil {
+		t.Fatalf("if_absent Set failed: %v", err)
+	}
 
-	time.Sleep(10 * time.Millisecond)
+	resp, err := svc.Get(ctx, "key")
+	if err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	if resp.Version != created.Version || resp.ETag != fmt.Sprintf("%q", strconv.FormatUint(created.Version, 10)) {
+		t.Errorf("Expected version %d with matching ETag, got %d / %s", created.Version, resp.Version, resp.ETag)
+	}
 
-	// Forget the key
-	coalescer.Forget("key1")
+	if _, err := svc.Set(ctx, "key", &SetRequest{Value: mustJSON(t, "x"), IfAbsent: true}); !errors.Is(err, ErrVersionConflict) {
+		t.Errorf("Expected conflict for if_absent on existing key, got %v", err)
+	}
 
-	// Should allow new call for same key
-	callCount := 0
-	coalescer.Do("key1", func() (interface{}, error) {
-		callCount++
-		return "new_result", nil
-	})
+	updated, err := svc.Set(ctx, "key", &SetRequest{Value: mustJSON(t, "v2"), IfVersion: created.Version})
+	if err != nil {
+		t.Fatalf("if_version Set failed: %v", err)
+	}
+	if updated.Version <= created.Version {
+		t.Errorf("Expected version to increase past %d, got %d", created.Version, updated.Version)
+	}
 
-	if callCount != 1 {
-		t.Error("Forget should allow new call")
+	// The loser of a read-modify-write race is rejected and the winner's value kept
+	if _, err := svc.Set(ctx, "key", &SetRequest{Value: mustJSON(t, "lost"), IfVersion: created.Version}); !errors.Is(err, ErrVersionConflict) {
+		t.Errorf("Expected conflict for stale if_version, got %v", err)
+	}
+	if resp, _ := svc.Get(ctx, "key"); mustJSONString(t, resp.Value) != "v2" {
+		t.Errorf("Expected v2 to survive the conflicting write, got %s", resp.Value)
+	}
+
+	// The version travels through L2 to instances that never saw the write
+	var l2Entry CacheEntry
+	data, _, _ := mockL2.Get(ctx, "key")
+	if err := json.Unmarshal(data, &l2Entry); err != nil || l2Entry.Version != updated.Version {
+		t.Errorf("Expected L2 entry at version %d, got %d (err %v)", updated.Version, l2Entry.Version, err)
+	}
+	other, _, _ := setupTestService()
+	other.l2Cache = mockL2
+	if _, err := other.Set(ctx, "key", &SetRequest{Value: mustJSON(t, "v3"), IfVersion: updated.Version}); err != nil {
+		t.Errorf("Expected second instance to accept the L2 version, got %v", err)
+	}
+
+	if _, err := svc.Set(ctx, "key", &SetRequest{Value: mustJSON(t, "x"), IfAbsent: true, IfVersion: 1}); err == nil || errors.Is(err, ErrVersionConflict) {
+		t.Errorf("Expected validation error for if_absent with if_version, got %v", err)
 	}
 }
 
-func TestPolicyEngine(t *testing.T) {
-	engine := DefaultPolicyEngine()
+func TestL1Cache_DeleteTag(t *testing.T) {
+	cache := NewShardedL1Cache(100, 4)
 
-	entry := &CacheEntry{
-		Value:     mustJSON(t, "test"),
-		ExpiresAt: time.Now().Add(1 * time.Hour),
+	for i := 0; i < 10; i++ {
+		tags := []string{"all"}
+		if i%2 == 0 {
+			tags = append(tags, "even")
+		}
+		cache.SetWithOptions(fmt.Sprintf("key%d", i), mustJSON(t, i), time.Hour, EntryOptions{Tags: tags})
 	}
 
-	// Should not evict valid entry
-	if engine.ShouldEvict(entry) {
-		t.Error("Should not evict non-expired entry")
+	// Rewriting a key replaces its tags
+	cache.SetWithOptions("key0", mustJSON(t, 0), time.Hour, EntryOptions{Tags: []string{"all"}})
+
+	if n := cache.DeleteTag("even"); n != 4 {
+		t.Errorf("Expected 4 keys deleted for tag even, got %d", n)
+	}
+	if _, ok := cache.Get("key0"); !ok {
+		t.Error("key0 was retagged and should survive")
+	}
+	if _, ok := cache.Get("key2"); ok {
+		t.Error("key2 should be deleted")
+	}
+	if n := cache.DeleteTag("missing"); n != 0 {
+		t.Errorf("Expected 0 for unknown tag, got %d", n)
+	}
+	if n := cache.DeleteTag("all"); n != 6 {
+		t.Errorf("Expected remaining 6 keys deleted for tag all, got %d", n)
 	}
 
-	// Expired entry should be evicted
-	expiredEntry := &CacheEntry{
-		Value:     mustJSON(t, "test"),
-		ExpiresAt: time.Now().Add(-1 * time.Hour),
+	for _, s := range cache.shards {
+		if len(s.tags) != 0 {
+			t.Errorf("Expected empty tag index, got %v", s.tags)
+		}
 	}
+}
 
-	if !engine.ShouldEvict(expiredEntry) {
-		t.Error("Should evict expired entry")
+func TestL1Cache_TagIndexEviction(t *testing.T) {
+	cache := NewL1Cache(2)
+	cache.SetWithOptions("a", mustJSON(t, "a"), time.Hour, EntryOptions{Tags: []string{"t"}})
+	cache.SetWithOptions("b", mustJSON(t, "b"), time.Hour, EntryOptions{Tags: []string{"t"}})
+	cache.SetWithOptions("c", mustJSON(t, "c"), time.Hour, EntryOptions{Tags: []string{"t"}})
+
+	// "a" was evicted and must have left the index
+	if keys := cache.shards[0].tags["t"]; len(keys) != 2 {
+		t.Errorf("Expected 2 indexed keys after eviction, got %v", keys)
+	}
+	if n := cache.DeleteTag("t"); n != 2 {
+		t.Errorf("Expected 2 keys deleted, got %d", n)
+	}
+}
+
+func TestService_InvalidateTags(t *testing.T) {
+	svc, _, _ := setupTestService()
+	ctx := context.Background()
+
+	svc.Set(ctx, "page:1", &SetRequest{Value: mustJSON(t, "p1"), Tags: []string{"product:42", "product:42"}})
+	svc.Set(ctx, "other", &SetRequest{Value: mustJSON(t, "o")})
+
+	entry, _ := svc.l1Cache.Get("page:1")
+	if len(entry.Tags) != 1 {
+		t.Errorf("Expected duplicate tags to be collapsed, got %v", entry.Tags)
 	}
 
-	// Test access recording
-	engine.RecordAccess("key1")
-	engine.RecordSet("key2", "value2", 1*time.Hour)
+	resp, err := svc.Invalidate(ctx, &InvalidateRequest{Tags: []string{"product:42"}})
+	if err != nil {
+		t.Fatalf("Invalidate failed: %v", err)
+	}
+	if resp.Invalidated != 1 {
+		t.Errorf("Expected 1 invalidated, got %d", resp.Invalidated)
+	}
+	if _, ok := svc.l1Cache.Get("other"); !ok {
+		t.Error("Untagged key should remain")
+	}
+
+	if _, err := svc.Set(ctx, "bad", &SetRequest{Value: mustJSON(t, "x"), Tags: []string{""}}); err == nil {
+		t.Error("Expected error for empty tag")
+	}
+	if _, err := svc.Set(ctx, "bad", &SetRequest{Value: mustJSON(t, "x"), Tags: make([]string, MaxTagsPerEntry+1)}); err == nil {
+		t.Error("Expected error for too many tags")
+	}
+}
+
+func TestL1Cache_Resize(t *testing.T) {
+	cache := NewL1Cache(10)
+	for i := 0; i < 10; i++ {
+		cache.Set(fmt.Sprintf("key%d", i), mustJSON(t, i), 1*time.Hour)
+	}
+
+	cache.Resize(4, 0)
+	if cache.Size() != 4 {
+		t.Errorf("Expected size 4 after shrinking, got %d", cache.Size())
+	}
+	if _, ok := cache.Get("key9"); !ok {
+		t.Error("Most recently used key should survive shrinking")
+	}
+
+	cache.Resize(20, 0)
+	for i := 0; i < 20; i++ {
+		cache.Set(fmt.Sprintf("new%d", i), mustJSON(t, i), 1*time.Hour)
+	}
+	if cache.Size() != 20 {
+		t.Errorf("Expected size 20 after growing, got %d", cache.Size())
+	}
+}
+
+func TestService_Namespaces(t *testing.T) {
+	svc, mockOrigin, _ := setupTestService()
+	svc.config.L1Shards = 1 // exact quotas; sharded budgets round up per shard
+	ctx := context.Background()
+
+	svc.Set(ctx, "tenant-a:stale", &SetRequest{Value: mustJSON(t, "old")})
+
+	info, err := svc.CreateNamespace(ctx, &NamespaceConfig{Name: "tenant-a", MaxEntries: 4, DefaultTTL: 60})
+	if err != nil {
+		t.Fatalf("CreateNamespace failed: %v", err)
+	}
+	if info.Name != "tenant-a" || info.MaxEntries != 4 || info.Size != 0 {
+		t.Errorf("Unexpected namespace info: %+v", info)
+	}
+	if _, ok := svc.l1Cache.Get("tenant-a:stale"); ok {
+		t.Error("Entries cached under the prefix before creation should be dropped")
+	}
+
+	// A noisy tenant only evicts its own entries.
+	svc.Set(ctx, "shared", &SetRequest{Value: mustJSON(t, "s")})
+	for i := 0; i < 10; i++ {
+		svc.Set(ctx, fmt.Sprintf("tenant-a:%d", i), &SetRequest{Value: mustJSON(t, i)})
+	}
+	if _, ok := svc.l1Cache.Get("shared"); !ok {
+		t.Error("Default namespace entry should not be evicted by tenant-a")
+	}
+	ns := svc.namespaceFor("tenant-a:9")
+	if ns == nil {
+		t.Fatal("Expected tenant-a:9 to belong to tenant-a")
+	}
+	if size := ns.l1.Size(); size != 4 {
+		t.Errorf("Expected tenant-a to hold its quota of 4, got %d", size)
+	}
+
+	// Namespace default TTL applies when the request has none.
+	resp, err := svc.Set(ctx, "tenant-a:ttl", &SetRequest{Value: mustJSON(t, "v")})
+	if err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+	if ttl := time.Until(resp.ExpiresAt); ttl > time.Minute {
+		t.Errorf("Expected namespace default TTL of 60s, got %v", ttl)
+	}
+
+	// Hits and misses are broken down per namespace.
+	svc.Get(ctx, "tenant-a:ttl")
+	svc.Get(ctx, "shared")
+	mockOrigin.SetError("tenant-a:missing", errors.New("boom"))
+	svc.Get(ctx, "tenant-a:missing")
+
+	metrics, _ := svc.GetMetrics(ctx)
+	if got := metrics.Namespaces["tenant-a"]; got.Hits != 1 || got.Misses != 1 || got.Size != ns.l1.Size() {
+		t.Errorf("Unexpected tenant-a metrics: %+v", got)
+	}
+	if got := metrics.Namespaces[DefaultNamespace]; got.Hits != 1 || got.Misses != 0 || got.Size != 1 {
+		t.Errorf("Unexpected default metrics: %+v", got)
+	}
+	if metrics.L1Size != 1+ns.l1.Size() {
+		t.Errorf("Expected L1 size across namespaces to be %d, got %d", 1+ns.l1.Size(), metrics.L1Size)
+	}
+
+	// Shrinking the quota evicts immediately.
+	info, err = svc.UpdateNamespace(ctx, "tenant-a", &UpdateNamespaceRequest{MaxEntries: 1, DefaultTTL: 60})
+	if err != nil {
+		t.Fatalf("UpdateNamespace failed: %v", err)
+	}
+	if info.Size != 1 {
+		t.Errorf("Expected 1 entry after shrinking quota, got %d", info.Size)
+	}
+
+	list, _ := svc.ListNamespaces(ctx)
+	if len(list.Namespaces) != 1 || list.Namespaces[0].MaxEntries != 1 {
+		t.Errorf("Unexpected namespace list: %+v", list.Namespaces)
+	}
+}
+
+func TestService_Namespaces_Validation(t *testing.T) {
+	testSvc, _, _ := setupTestService()
+	ctx := context.Background()
+
+	prev := svc
+	svc = testSvc
+	defer func() { svc = prev }()
+
+	invalid := []NamespaceConfig{
+		{Name: "", MaxEntries: 10},
+		{Name: DefaultNamespace, MaxEntries: 10},
+		{Name: "a:b", MaxEntries: 10},
+		{Name: "ok", MaxEntries: 0},
+		{Name: "ok", MaxEntries: 10, Policy: "fifo"},
+	}
+	for _, cfg := range invalid {
+		cfg := cfg
+		if _, err := svc.CreateNamespace(ctx, &cfg); err == nil {
+			t.Errorf("Expected error for %+v", cfg)
+		}
+	}
+
+	if _, err := svc.CreateNamespace(ctx, &NamespaceConfig{Name: "ok", MaxEntries: 10}); err != nil {
+		t.Fatalf("CreateNamespace failed: %v", err)
+	}
+	if _, err := svc.CreateNamespace(ctx, &NamespaceConfig{Name: "ok", MaxEntries: 10}); err == nil {
+		t.Error("Expected error for duplicate namespace")
+	}
+
+	// Of concurrent creates with one name, exactly one succeeds.
+	var created atomic.Int64
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := svc.CreateNamespace(ctx, &NamespaceConfig{Name: "race", MaxEntries: 10}); err == nil {
+				created.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+	if n := created.Load(); n != 1 {
+		t.Errorf("Expected one concurrent create to succeed, got %d", n)
+	}
+	if _, err := svc.UpdateNamespace(ctx, "missing", &UpdateNamespaceRequest{MaxEntries: 10}); err == nil {
+		t.Error("Expected error updating unknown namespace")
+	}
+
+	// Older broadcasts do not overwrite newer configuration.
+	stale := NamespaceConfig{Name: "ok", MaxEntries: 99, UpdatedAt: 1}
+	if err := HandleNamespaceEvent(ctx, &NamespaceEvent{Config: stale}); err != nil {
+		t.Fatalf("HandleNamespaceEvent failed: %v", err)
+	}
+	if ns := svc.namespaceFor("ok:x"); ns == nil || ns.info().MaxEntries != 10 {
+		t.Error("Stale namespace event should be ignored")
+	}
+}
+
+func TestService_NamespaceRegistry(t *testing.T) {
+	server := newFakeRedisServer(t)
+	ctx := context.Background()
+
+	// Two instances sharing one Redis create namespaces concurrently.
+	instances := make([]*Service, 2)
+	for i := range instances {
+		rc := newTestRedisCache(server.Addr())
+		defer rc.Close()
+		instances[i], _, _ = setupTestService()
+		instances[i].SetL2Cache(rc)
+	}
+	var wg sync.WaitGroup
+	for i, instance := range instances {
+		wg.Add(1)
+		go func(i int, instance *Service) {
+			defer wg.Done()
+			name := fmt.Sprintf("tenant-%d", i)
+			if _, err := instance.CreateNamespace(ctx, &NamespaceConfig{Name: name, MaxEntries: 10}); err != nil {
+				t.Errorf("CreateNamespace failed: %v", err)
+			}
+		}(i, instance)
+	}
+	wg.Wait()
+	if _, err := instances[0].UpdateNamespace(ctx, "tenant-0", &UpdateNamespaceRequest{MaxEntries: 20, DefaultTTL: 60}); err != nil {
+		t.Fatalf("UpdateNamespace failed: %v", err)
+	}
+
+	// An instance started later learns both, with the latest quotas.
+	rc := newTestRedisCache(server.Addr())
+	defer rc.Close()
+	late, _, _ := setupTestService()
+	late.SetL2Cache(rc)
+	if err := late.loadNamespaces(ctx); err != nil {
+		t.Fatalf("loadNamespaces failed: %v", err)
+	}
+	list, _ := late.ListNamespaces(ctx)
+	if len(list.Namespaces) != 2 || list.Namespaces[0].MaxEntries != 20 || list.Namespaces[0].DefaultTTL != 60 || list.Namespaces[1].Name != "tenant-1" {
+		t.Fatalf("Expected both namespaces from the registry, got %+v", list.Namespaces)
+	}
+	if late.namespaceFor("tenant-1:x") == nil {
+		t.Error("Expected keys to be routed to a loaded namespace")
+	}
+
+	// Without compare-and-set the registry is kept too.
+	a, _, mockL2 := setupTestService()
+	a.CreateNamespace(ctx, &NamespaceConfig{Name: "solo", MaxEntries: 5})
+	b, _, _ := setupTestService()
+	b.SetL2Cache(mockL2)
+	if err := b.loadNamespaces(ctx); err != nil || b.namespaceFor("solo:x") == nil {
+		t.Errorf("Expected namespace from the registry, err=%v", err)
+	}
+	if _, err := a.Get(ctx, namespaceRegistryKey); !errors.Is(err, ErrReservedKey) {
+		t.Errorf("Expected the registry key to be reserved, got %v", err)
+	}
+}
+
+func TestService_InvalidateNamespace(t *testing.T) {
+	svc, _, mockL2 := setupTestService()
+	ctx := context.Background()
+
+	if _, err := svc.CreateNamespace(ctx, &NamespaceConfig{Name: "tenant-a", MaxEntries: 10}); err != nil {
+		t.Fatalf("CreateNamespace failed: %v", err)
+	}
+	svc.Set(ctx, "tenant-a:1", &SetRequest{Value: mustJSON(t, 1)})
+	svc.Set(ctx, "tenant-a:2", &SetRequest{Value: mustJSON(t, 2)})
+	svc.Set(ctx, "tenant-b:1", &SetRequest{Value: mustJSON(t, 1)})
+
+	resp, err := svc.Invalidate(ctx, &InvalidateRequest{Namespace: "tenant-a"})
+	if err != nil {
+		t.Fatalf("Invalidate failed: %v", err)
+	}
+	if resp.Invalidated != 2 {
+		t.Errorf("Expected 2 invalidated, got %d", resp.Invalidated)
+	}
+	if _, ok := svc.l1Cache.Get("tenant-b:1"); !ok {
+		t.Error("Keys outside the namespace should remain")
+	}
+	if _, ok, _ := mockL2.Get(ctx, "tenant-a:1"); ok {
+		t.Error("Namespace should be flushed from L2")
+	}
+	if _, ok, _ := mockL2.Get(ctx, "tenant-b:1"); !ok {
+		t.Error("L2 keys outside the namespace should remain")
+	}
+
+	if _, err := svc.Invalidate(ctx, &InvalidateRequest{Namespace: "tenant-a", Pattern: "x*"}); err == nil {
+		t.Error("Expected error combining namespace with pattern")
+	}
+	if _, err := svc.Invalidate(ctx, &InvalidateRequest{Namespace: "unknown"}); err == nil {
+		t.Error("Expected error for unknown namespace")
+	}
+}
+
+func TestGzipCodec(t *testing.T) {
+	codec, err := CodecByName("")
+	if err != nil || codec.Name() != CodecGzip {
+		t.Fatalf("Expected gzip as default codec, got %v, %v", codec, err)
+	}
+
+	src := bytes.Repeat([]byte(`{"name":"widget","tags":["a","b"]}`), 50)
+	encoded, err := codec.Encode(src)
+	if err != nil {
+		t.Fatalf("Encode failed: %v", err)
+	}
+	if len(encoded) >= len(src) {
+		t.Errorf("Expected compression, got %d >= %d bytes", len(encoded), len(src))
+	}
+	decoded, err := codec.Decode(encoded)
+	if err != nil || !bytes.Equal(decoded, src) {
+		t.Errorf("Round trip mismatch: %v", err)
+	}
+
+	if _, err := CodecByName("zstd"); err == nil {
+		t.Error("Expected error for unknown codec")
+	}
+	if err := RegisterCodec(NewGzipCodec(gzip.BestCompression)); err == nil {
+		t.Error("Expected error registering a duplicate codec")
+	}
+}
+
+func TestService_Compression(t *testing.T) {
+	svc, _, mockL2 := setupTestService()
+	svc.config.CompressionThreshold = 256
+	svc.codec, _ = CodecByName(CodecGzip)
+	ctx := context.Background()
+
+	large := mustJSON(t, strings.Repeat("compressible ", 100))
+	if _, err := svc.Set(ctx, "large", &SetRequest{Value: large}); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+	svc.Set(ctx, "small", &SetRequest{Value: mustJSON(t, "tiny")})
+
+	stored, _ := svc.l1Cache.Get("large")
+	if stored.Value[0] != gzipCodecID || len(stored.Value) >= len(large) {
+		t.Errorf("Expected L1 to hold the compressed value, got %d bytes", len(stored.Value))
+	}
+	if stored, _ := svc.l1Cache.Get("small"); mustJSONString(t, stored.Value) != "tiny" {
+		t.Error("Values below the threshold should be stored as is")
+	}
+
+	resp, err := svc.Get(ctx, "large")
+	if err != nil || !bytes.Equal(resp.Value, large) {
+		t.Fatalf("Expected decompressed value from L1, got err=%v", err)
+	}
+
+	payload, _, _ := mockL2.Get(ctx, "large")
+	if payload[0] != gzipCodecID {
+		t.Error("Expected L2 payload to be compressed")
+	}
+
+	// L2 hit: compressed payload is decoded and L1 is refilled.
+	svc.l1Cache.Clear()
+	resp, err = svc.Get(ctx, "large")
+	if err != nil || resp.Source != "l2" || !bytes.Equal(resp.Value, large) {
+		t.Fatalf("Expected decompressed value from L2, got %+v, err=%v", resp, err)
+	}
+
+	// Payloads written before compression was enabled remain readable.
+	plain, _ := json.Marshal(&CacheEntry{Value: large, ExpiresAt: time.Now().Add(time.Hour)})
+	mockL2.Set(ctx, "legacy", plain, time.Hour)
+	if resp, err := svc.Get(ctx, "legacy"); err != nil || !bytes.Equal(resp.Value, large) {
+		t.Errorf("Expected legacy payload to be readable, err=%v", err)
+	}
+
+	metrics, _ := svc.GetMetrics(ctx)
+	if metrics.CompressionRatio <= 5 {
+		t.Errorf("Expected a high compression ratio, got %.2f", metrics.CompressionRatio)
+	}
+
+	// A frame naming an unknown codec is dropped and reloaded like a miss.
+	mockL2.Set(ctx, "corrupt", []byte{0x1f, 'x'}, time.Hour)
+	if _, err := svc.Get(ctx, "corrupt"); err == nil {
+		t.Error("Expected miss for undecodable payload")
+	}
+	if m, _ := svc.GetMetrics(ctx); m.CompressionErrors == 0 {
+		t.Error("Expected compression error to be counted")
+	}
+}
+
+func TestService_Snapshot(t *testing.T) {
+	svc, _, _ := setupTestService()
+	svc.config.SnapshotPath = filepath.Join(t.TempDir(), "l1.snap")
+	ctx := context.Background()
+
+	if _, err := svc.CreateNamespace(ctx, &NamespaceConfig{Name: "tenant-a", MaxEntries: 10, DefaultTTL: 60}); err != nil {
+		t.Fatalf("CreateNamespace failed: %v", err)
+	}
+	setResp, _ := svc.Set(ctx, "a", &SetRequest{Value: mustJSON(t, "A"), Tags: []string{"t1"}})
+	svc.Set(ctx, "b", &SetRequest{Value: mustJSON(t, "B")})
+	svc.Set(ctx, "c", &SetRequest{Value: mustJSON(t, "C"), TTL: 60})
+	svc.Set(ctx, "tenant-a:x", &SetRequest{Value: mustJSON(t, "X")})
+	svc.l1Cache.Get("a") // a becomes most recently used
+
+	resp, err := svc.Snapshot(ctx)
+	if err != nil {
+		t.Fatalf("Snapshot failed: %v", err)
+	}
+	if resp.Entries != 4 || resp.Bytes == 0 {
+		t.Errorf("Unexpected snapshot response: %+v", resp)
+	}
+
+	restored, _, _ := setupTestService()
+	n, err := restored.loadSnapshot(svc.config.SnapshotPath)
+	if err != nil || n != 4 {
+		t.Fatalf("Expected 4 entries restored, got %d, err=%v", n, err)
+	}
+
+	// Recency survives: b is the least recently used, a the most.
+	order := restored.l1Cache.shards[0].policy.(OrderedPolicy).Keys()
+	if len(order) != 3 || order[0] != "b" || order[2] != "a" {
+		t.Errorf("Expected LRU order [b c a], got %v", order)
+	}
+
+	entry, ok := restored.l1Cache.Get("a")
+	if !ok || mustJSONString(t, entry.Value) != "A" || entry.Version != setResp.Version || len(entry.Tags) != 1 {
+		t.Errorf("Entry not restored with its metadata: %+v", entry)
+	}
+	if entry, _ := restored.l1Cache.Get("c"); time.Until(entry.ExpiresAt) > time.Minute {
+		t.Errorf("Expected remaining TTL to be kept, got %v", time.Until(entry.ExpiresAt))
+	}
+	if ns := restored.namespaceFor("tenant-a:x"); ns == nil || ns.l1.Size() != 1 {
+		t.Error("Expected namespace and its entries to be restored")
+	}
+}
+
+func TestService_Snapshot_SkipsExpiredAndCorrupt(t *testing.T) {
+	svc, _, _ := setupTestService()
+	path := filepath.Join(t.TempDir(), "l1.snap")
+
+	if n, err := svc.loadSnapshot(path); n != 0 || err != nil {
+		t.Errorf("Missing snapshot should be ignored, got %d, %v", n, err)
+	}
+
+	// Taken two hours ago: the 1h entry has expired, the 3h one has 1h left.
+	header := snapshotHeader{CreatedAt: time.Now().Add(-2 * time.Hour), Entries: 2}
+	entries := []snapshotEntry{
+		{Key: "expired", Value: mustJSON(t, 1), TTL: time.Hour},
+		{Key: "live", Value: mustJSON(t, 2), TTL: 3 * time.Hour},
+	}
+	var buf bytes.Buffer
+	if _, err := encodeSnapshot(&buf, header, entries); err != nil {
+		t.Fatalf("encodeSnapshot failed: %v", err)
+	}
+	os.WriteFile(path, buf.Bytes(), 0o644)
+
+	if n, err := svc.loadSnapshot(path); n != 1 || err != nil {
+		t.Fatalf("Expected 1 live entry restored, got %d, err=%v", n, err)
+	}
+	if _, ok := svc.l1Cache.Get("expired"); ok {
+		t.Error("Entry expired while down should be dropped")
+	}
+	if entry, ok := svc.l1Cache.Get("live"); !ok || time.Until(entry.ExpiresAt) > time.Hour {
+		t.Error("Live entry should be restored with its remaining TTL")
+	}
+
+	// Corrupting one body byte fails the checksum; nothing is restored.
+	corrupt := buf.Bytes()
+	corrupt[len(snapshotMagic)+10] ^= 0xff
+	os.WriteFile(path, corrupt, 0o644)
+
+	fresh, _, _ := setupTestService()
+	if _, err := fresh.loadSnapshot(path); !errors.Is(err, errSnapshotCorrupt) {
+		t.Errorf("Expected corrupt snapshot error, got %v", err)
+	}
+	if fresh.l1Cache.Size() != 0 {
+		t.Error("Corrupt snapshot should not be partially restored")
+	}
+
+	if _, err := fresh.Snapshot(context.Background()); err == nil {
+		t.Error("Expected error when no snapshot path is configured")
+	}
+}
+
+func TestWriteBehindQueue(t *testing.T) {
+	q := newWriteBehindQueue(2)
+
+	q.push(&writeBehindItem{key: "a", data: []byte("1")})
+	q.push(&writeBehindItem{key: "b", data: []byte("1")})
+	q.push(&writeBehindItem{key: "a", data: []byte("2")}) // coalesced in place
+	if q.push(&writeBehindItem{key: "c"}) {
+		t.Error("Expected push to a full queue to fail")
+	}
+	// A retry must not overwrite the newer queued write.
+	q.push(&writeBehindItem{key: "b", data: []byte("old"), attempts: 1})
+
+	batch := q.take(10)
+	if len(batch) != 2 || batch[0].key != "a" || string(batch[0].data) != "2" || string(batch[1].data) != "1" {
+		t.Errorf("Unexpected batch: %+v", batch)
+	}
+	if q.len() != 0 {
+		t.Errorf("Expected empty queue, got %d", q.len())
+	}
+}
+
+func TestService_WriteBehind(t *testing.T) {
+	svc, _, mockL2 := setupTestService()
+	svc.config.WriteMode = WriteModeBehind
+	svc.config.WriteBehind = WriteBehindConfig{QueueSize: 2, BatchSize: 10, MaxRetries: 1}
+	svc.writeQueue = newWriteBehindQueue(2)
+	ctx := context.Background()
+
+	svc.Set(ctx, "k1", &SetRequest{Value: mustJSON(t, 1)})
+	svc.Set(ctx, "k1", &SetRequest{Value: mustJSON(t, 2)})
+	svc.MSet(ctx, &MSetRequest{Entries: []SetRequest{{Key: "k2", Value: mustJSON(t, 2)}, {Key: "k3", Value: mustJSON(t, 3)}}})
+
+	if mockL2.CallCount("set") != 0 {
+		t.Error("Write-behind Set should not write L2 before a flush")
+	}
+	metrics, _ := svc.GetMetrics(ctx)
+	if metrics.WriteBehindDepth != 2 || metrics.WriteBehindDrops != 1 {
+		t.Errorf("Expected depth 2 and 1 drop, got %d and %d", metrics.WriteBehindDepth, metrics.WriteBehindDrops)
+	}
+
+	svc.flushWriteBehind(ctx, false)
+	data, ok, _ := mockL2.Get(ctx, "k1")
+	var entry CacheEntry
+	json.Unmarshal(data, &entry)
+	if !ok || string(entry.Value) != "2" {
+		t.Error("Expected latest k1 value in L2 after flush")
+	}
+
+	// A failing L2 is retried on the next flush, then dropped.
+	mockL2.FailSets(errors.New("l2 down"))
+	svc.Set(ctx, "k4", &SetRequest{Value: mustJSON(t, 4)})
+	svc.flushWriteBehind(ctx, false)
+	if svc.writeQueue.len() != 1 {
+		t.Errorf("Expected failed write to be requeued, depth %d", svc.writeQueue.len())
+	}
+	svc.flushWriteBehind(ctx, false)
+
+	metrics, _ = svc.GetMetrics(ctx)
+	if metrics.WriteBehindDepth != 0 || metrics.WriteBehindRetries != 1 || metrics.WriteBehindDrops != 2 {
+		t.Errorf("Unexpected write-behind metrics: %+v", metrics)
+	}
+	if metrics.WriteBehindFlushed != 2 {
+		t.Errorf("Expected 2 flushed writes, got %d", metrics.WriteBehindFlushed)
+	}
+}
+
+func TestService_WriteBehind_ShutdownFlushes(t *testing.T) {
+	svc, mockOrigin, mockL2 := setupTestService()
+	svc.writeQueue = newWriteBehindQueue(10)
+	ctx := context.Background()
+
+	// In write-through mode origin fills are queued instead of spawning goroutines.
+	mockOrigin.Set("origin-key", "v")
+	if _, err := svc.Get(ctx, "origin-key"); err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	if mockL2.CallCount("set") != 0 || svc.writeQueue.len() != 1 {
+		t.Fatal("Expected origin fill to be queued")
+	}
+
+	svc.Shutdown()
+	if _, ok, _ := mockL2.Get(ctx, "origin-key"); !ok {
+		t.Error("Shutdown should flush queued writes")
+	}
+}
+
+func TestService_WriteModeL1Only(t *testing.T) {
+	svc, mockOrigin, mockL2 := setupTestService()
+	svc.config.WriteMode = WriteModeL1Only
+	ctx := context.Background()
+
+	svc.Set(ctx, "k", &SetRequest{Value: mustJSON(t, 1)})
+	svc.Set(ctx, "cond", &SetRequest{Value: mustJSON(t, 1), IfAbsent: true})
+	mockOrigin.Set("origin-key", "v")
+	svc.Get(ctx, "origin-key")
+
+	if mockL2.CallCount("set") != 0 {
+		t.Errorf("Expected no L2 writes in l1-only mode, got %d", mockL2.CallCount("set"))
+	}
+	if _, ok := svc.l1Cache.Get("cond"); !ok {
+		t.Error("Conditional write should be applied to L1")
+	}
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	b := NewCircuitBreaker(BreakerConfig{FailureRate: 0.5, MinRequests: 4, Cooldown: 50 * time.Millisecond, Probes: 2})
+
+	// Below MinRequests the failure rate is not evaluated.
+	b.Record(true)
+	b.Record(true)
+	b.Record(false)
+	if b.State() != BreakerClosed {
+		t.Fatal("Breaker should stay closed below MinRequests")
+	}
+	b.Record(false)
+	if b.State() != BreakerOpen || b.Allow() {
+		t.Fatal("Breaker should open at the failure rate and reject calls")
+	}
+
+	// After the cooldown only Probes calls are admitted; a failed probe reopens.
+	time.Sleep(60 * time.Millisecond)
+	if b.State() != BreakerHalfOpen {
+		t.Fatalf("Expected half-open after cooldown, got %s", b.State())
+	}
+	if !b.Allow() || !b.Allow() || b.Allow() {
+		t.Fatal("Half-open breaker should admit exactly Probes calls")
+	}
+	b.Record(true)
+	b.Record(false)
+	if b.State() != BreakerOpen {
+		t.Fatal("Failed probe should reopen the breaker")
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	for i := 0; i < 2; i++ {
+		if !b.Allow() {
+			t.Fatal("Probe should be admitted")
+		}
+		b.Record(true)
+	}
+	if b.State() != BreakerClosed {
+		t.Fatal("Successful probes should close the breaker")
+	}
+
+	status := b.Status()
+	if status.Opens != 2 || status.Rejected != 2 || status.OpenedAt != nil {
+		t.Errorf("Unexpected status: %+v", status)
+	}
+
+	var disabled *CircuitBreaker
+	if !disabled.Allow() {
+		t.Error("Nil breaker should allow calls")
+	}
+}
+
+func TestService_Breakers(t *testing.T) {
+	svc, mockOrigin, mockL2 := setupTestService()
+	cfg := BreakerConfig{MinRequests: 1, Cooldown: time.Hour}
+	svc.originBreaker = NewCircuitBreaker(cfg)
+	svc.l2Breaker = NewCircuitBreaker(cfg)
+	ctx := context.Background()
+
+	svc.Set(ctx, "stale-key", &SetRequest{Value: mustJSON(t, "old"), TTL: 1, StaleTTL: 60})
+
+	// An open L2 breaker skips L2 reads entirely.
+	svc.l2Breaker.Record(false)
+	gets := mockL2.CallCount("get")
+	if _, err := svc.Get(ctx, "missing"); err == nil {
+		t.Fatal("Expected origin error")
+	}
+	if mockL2.CallCount("get") != gets {
+		t.Error("Get should not call L2 while its breaker is open")
+	}
+
+	// That origin failure opened the origin breaker: misses fail fast.
+	calls := mockOrigin.CallCount()
+	if _, err := svc.Get(ctx, "other"); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("Expected ErrCircuitOpen, got %v", err)
+	}
+	if mockOrigin.CallCount() != calls {
+		t.Error("Origin should not be called while its breaker is open")
+	}
+
+	// Stale entries are still served, without queuing a refresh.
+	time.Sleep(1100 * time.Millisecond)
+	resp, err := svc.Get(ctx, "stale-key")
+	if err != nil || !resp.Stale || mustJSONString(t, resp.Value) != "old" {
+		t.Fatalf("Expected stale value, got resp=%+v err=%v", resp, err)
+	}
+	svc.wg.Wait()
+	if mockOrigin.CallCount() != calls {
+		t.Error("Stale read should not refresh while the origin breaker is open")
+	}
+
+	metrics, _ := svc.GetMetrics(ctx)
+	if metrics.Breakers["l2"].State != "open" || metrics.Breakers["origin"].State != "open" {
+		t.Errorf("Expected both breakers open, got %+v", metrics.Breakers)
+	}
+	if metrics.Breakers["origin"].Rejected != 1 {
+		t.Errorf("Expected 1 rejected origin call, got %d", metrics.Breakers["origin"].Rejected)
+	}
+}
+
+func TestService_BreakerCallAccounting(t *testing.T) {
+	svc, _, mockL2 := setupTestService()
+	svc.l2Breaker = NewCircuitBreaker(BreakerConfig{FailureRate: 0.5, MinRequests: 2, Cooldown: 50 * time.Millisecond, Probes: 1})
+	ctx := context.Background()
+
+	// Per-key writes admitted by one Allow record one outcome
+	mockL2.FailSets(errors.New("l2 down"))
+	svc.MSet(ctx, &MSetRequest{Entries: []SetRequest{
+		{Key: "a", Value: mustJSON(t, 1)},
+		{Key: "b", Value: mustJSON(t, 2)},
+		{Key: "c", Value: mustJSON(t, 3)},
+	}})
+	if status := svc.l2Breaker.Status(); status.Requests != 1 || status.Failures != 1 || status.State != "closed" {
+		t.Fatalf("Expected one failed outcome for the batch, got %+v", status)
+	}
+	if metrics, _ := svc.GetMetrics(ctx); metrics.L2Errors != 3 {
+		t.Errorf("Expected 3 L2 errors counted per key, got %d", metrics.L2Errors)
+	}
+	mockL2.FailSets(nil)
+
+	// Invalidations still reach L2 while the breaker is open, without probing it
+	mockL2.Set(ctx, "a", []byte(`{"value":1}`), 0)
+	mockL2.Set(ctx, "b", []byte(`{"value":2}`), 0)
+	svc.l2Breaker.Record(false)
+	svc.Invalidate(ctx, &InvalidateRequest{Keys: []string{"a"}, Pattern: "b*"})
+	for _, key := range []string{"a", "b"} {
+		if _, found, _ := mockL2.Get(ctx, key); found {
+			t.Errorf("Expected %s to be deleted from L2 while the breaker is open", key)
+		}
+	}
+	if status := svc.l2Breaker.Status(); status.State != "open" {
+		t.Fatalf("Deletes made while open should not be recorded, got %+v", status)
+	}
+
+	// A probe released without a call neither closes nor reopens the breaker
+	time.Sleep(60 * time.Millisecond)
+	if !svc.l2Breaker.Allow() {
+		t.Fatal("Expected a probe after the cooldown")
+	}
+	svc.l2Breaker.Release()
+	if state := svc.l2Breaker.State(); state != BreakerHalfOpen {
+		t.Fatalf("Expected half-open after a released probe, got %s", state)
+	}
+	if !svc.l2Breaker.Allow() {
+		t.Fatal("Released probe slot should be available again")
+	}
+	svc.l2Breaker.Record(true)
+	if state := svc.l2Breaker.State(); state != BreakerClosed {
+		t.Errorf("Expected closed after a successful probe, got %s", state)
+	}
+}
+
+// downPeer simulates an unreachable cluster member.
+type downPeer struct{}
+
+func (downPeer) Get(ctx context.Context, key string) (*GetResponse, error) {
+	return nil, ErrPeerUnavailable
+}
+
+func (downPeer) Set(ctx context.Context, key string, req *SetRequest) (*SetResponse, error) {
+	return nil, ErrPeerUnavailable
+}
+
+func (downPeer) Incr(ctx context.Context, key string, req *IncrRequest) (*IncrResponse, error) {
+	return nil, ErrPeerUnavailable
+}
+
+func (downPeer) Expire(ctx context.Context, key string, req *ExpireRequest) (*ExpiryResponse, error) {
+	return nil, ErrPeerUnavailable
+}
+
+func (downPeer) Touch(ctx context.Context, key string) (*ExpiryResponse, error) {
+	return nil, ErrPeerUnavailable
+}
+
+func (downPeer) Persist(ctx context.Context, key string) (*ExpiryResponse, error) {
+	return nil, ErrPeerUnavailable
+}
+
+func (downPeer) MGet(ctx context.Context, req *MGetRequest) (*MGetResponse, error) {
+	return nil, ErrPeerUnavailable
+}
+
+func (downPeer) MSet(ctx context.Context, req *MSetRequest) (*MSetResponse, error) {
+	return nil, ErrPeerUnavailable
+}
+
+func TestService_Cluster(t *testing.T) {
+	ids := []string{"node-a", "node-b", "node-c"}
+	nodes := make(map[string]*Service)
+	origins := make(map[string]*MockOriginFetcher)
+	for _, id := range ids {
+		nodes[id], origins[id], _ = setupTestService()
+	}
+	for _, id := range ids {
+		var members []ClusterMember
+		for _, peer := range ids {
+			members = append(members, ClusterMember{ID: peer, Peer: NewLocalPeer(nodes[peer])})
+		}
+		if err := nodes[id].SetCluster(id, members, 0); err != nil {
+			t.Fatalf("SetCluster failed: %v", err)
+		}
+	}
+	ctx := context.Background()
+
+	// ownedBy returns a key whose owner is id.
+	ownedBy := func(id string, prefix string) string {
+		for i := 0; ; i++ {
+			key := fmt.Sprintf("%s-%d", prefix, i)
+			if nodes[id].ownerPeer(key) == nil && nodes["node-a"].ownerPeer(key) != nil {
+				return key
+			}
+		}
+	}
+
+	// Writes land on the owner only; reads from any member see them.
+	setKey := ownedBy("node-b", "set")
+	if _, err := nodes["node-a"].Set(ctx, setKey, &SetRequest{Value: mustJSON(t, "v")}); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+	if _, ok := nodes["node-b"].l1Cache.Get(setKey); !ok {
+		t.Error("Owner should hold the forwarded write")
+	}
+	if _, ok := nodes["node-a"].l1Cache.Get(setKey); ok {
+		t.Error("Non-owner should not store the key")
+	}
+	resp, err := nodes["node-c"].Get(ctx, setKey)
+	if err != nil || mustJSONString(t, resp.Value) != "v" {
+		t.Fatalf("Expected v from owner, got resp=%+v err=%v", resp, err)
+	}
+
+	// Counters are incremented on the owner, whichever member is called.
+	counterKey := ownedBy("node-b", "counter")
+	for _, id := range ids {
+		if _, err := nodes[id].Incr(ctx, counterKey, &IncrRequest{}); err != nil {
+			t.Fatalf("Incr via %s failed: %v", id, err)
+		}
+	}
+	if value, ok := nodes["node-b"].l1Cache.Get(counterKey); !ok || string(value.Value) != "3" {
+		t.Errorf("Expected the owner to count 3, got %+v (found=%v)", value, ok)
+	}
+	if _, ok := nodes["node-a"].l1Cache.Get(counterKey); ok {
+		t.Error("Non-owner should not hold the counter")
+	}
+
+	// Expiry updates reach the owner's entry too.
+	if _, err := nodes["node-a"].Persist(ctx, setKey); err != nil {
+		t.Fatalf("Persist failed: %v", err)
+	}
+	if entry, ok := nodes["node-b"].l1Cache.Get(setKey); !ok || !persistent(entry.ExpiresAt) {
+		t.Errorf("Expected the owner's entry to be persistent, got %+v", entry)
+	}
+	if _, err := nodes["node-c"].Expire(ctx, setKey, &ExpireRequest{TTL: 30}); err != nil {
+		t.Fatalf("Expire failed: %v", err)
+	}
+	if resp, err := nodes["node-a"].Touch(ctx, setKey); err != nil || resp.TTL != 30 {
+		t.Errorf("Expected the owner's 30s TTL, got %+v (err %v)", resp, err)
+	}
+
+	// Batches are split by owner, and each part is written and read there.
+	batchKeys := []string{ownedBy("node-b", "batch"), ownedBy("node-c", "batch"), "batch-local"}
+	for i := 0; nodes["node-a"].ownerPeer(batchKeys[2]) != nil; i++ {
+		batchKeys[2] = fmt.Sprintf("batch-local-%d", i)
+	}
+	mset := &MSetRequest{}
+	for _, key := range batchKeys {
+		mset.Entries = append(mset.Entries, SetRequest{Key: key, Value: mustJSON(t, key)})
+	}
+	if _, err := nodes["node-a"].MSet(ctx, mset); err != nil {
+		t.Fatalf("MSet failed: %v", err)
+	}
+	for i, id := range []string{"node-b", "node-c", "node-a"} {
+		if _, ok := nodes[id].l1Cache.Get(batchKeys[i]); !ok {
+			t.Errorf("Expected %s to hold %s", id, batchKeys[i])
+		}
+	}
+	if _, ok := nodes["node-a"].l1Cache.Get(batchKeys[0]); ok {
+		t.Error("Non-owner should not store a batch entry")
+	}
+	mget, err := nodes["node-c"].MGet(ctx, &MGetRequest{Keys: append(batchKeys, batchKeys[0])})
+	if err != nil || len(mget.Results) != 4 {
+		t.Fatalf("MGet failed: %+v (err %v)", mget, err)
+	}
+	for i, res := range mget.Results {
+		if key := batchKeys[i%3]; res.Key != key || !res.Hit || mustJSONString(t, res.Value) != key {
+			t.Errorf("Expected a hit on %s at %d, got %+v", key, i, res)
+		}
+	}
+
+	// Misses are loaded from origin once, by the owner.
+	fillKey := ownedBy("node-b", "fill")
+	origins["node-b"].Set(fillKey, "from-b")
+	for _, id := range ids {
+		if _, err := nodes[id].Get(ctx, fillKey); err != nil {
+			t.Fatalf("Get via %s failed: %v", id, err)
+		}
+	}
+	if origins["node-b"].CallCount() != 1 || origins["node-a"].CallCount() != 0 || origins["node-c"].CallCount() != 0 {
+		t.Errorf("Expected one origin call on the owner, got a=%d b=%d c=%d",
+			origins["node-a"].CallCount(), origins["node-b"].CallCount(), origins["node-c"].CallCount())
+	}
+
+	// An unreachable owner falls back to serving locally.
+	nodes["node-a"].cluster.peers["node-b"] = downPeer{}
+	origins["node-a"].Set(fillKey, "from-a")
+	resp, err = nodes["node-a"].Get(ctx, fillKey)
+	if err != nil || mustJSONString(t, resp.Value) != "from-a" {
+		t.Fatalf("Expected local fallback, got resp=%+v err=%v", resp, err)
+	}
+	mresp, err := nodes["node-a"].MSet(ctx, &MSetRequest{Entries: []SetRequest{{Key: batchKeys[0], Value: mustJSON(t, "a")}}})
+	if err != nil || !mresp.Results[0].Success {
+		t.Fatalf("Expected local batch fallback, got %+v (err %v)", mresp, err)
+	}
+	if _, ok := nodes["node-a"].l1Cache.Get(batchKeys[0]); !ok {
+		t.Error("Expected the batch entry to be stored locally while its owner is down")
+	}
+
+	metrics, _ := nodes["node-a"].GetMetrics(ctx)
+	if metrics.ClusterFallbacks != 2 || metrics.ClusterForwards != 7 {
+		t.Errorf("Expected 7 forwards and 2 fallbacks, got %d and %d", metrics.ClusterForwards, metrics.ClusterFallbacks)
+	}
+}
+
+func TestClusterConfig(t *testing.T) {
+	t.Setenv("CACHE_NODE_ID", "a")
+	t.Setenv("CACHE_PEERS", "a=http://a:4000, b=http://b:4000,bad")
+	cfg := ClusterConfigFromEnv()
+	if len(cfg.Peers) != 2 || cfg.Peers[1].ID != "b" || cfg.Peers[1].Addr != "http://b:4000" {
+		t.Fatalf("Unexpected peers: %+v", cfg.Peers)
+	}
+	if err := cfg.validate(); err != nil {
+		t.Errorf("Expected valid config, got %v", err)
+	}
+
+	invalid := []ClusterConfig{
+		{NodeID: "c", Peers: cfg.Peers},
+		{NodeID: "a", Peers: []PeerConfig{{ID: "a"}, {ID: "a"}}},
+		{NodeID: "a", Peers: []PeerConfig{{ID: "a"}, {ID: "b"}}},
+	}
+	for _, c := range invalid {
+		if c.validate() == nil {
+			t.Errorf("Expected %+v to be rejected", c)
+		}
+	}
+}
+
+func TestHTTPPeer(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		op, ok := strings.CutPrefix(r.URL.EscapedPath(), "/api/cache/peer/entry/user%2F1")
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		switch r.Method + " " + op {
+		case "GET ":
+			json.NewEncoder(w).Encode(GetResponse{Value: json.RawMessage(`"v"`), Hit: true, Found: true, Source: "l1"})
+		case "PUT ":
+			w.WriteHeader(http.StatusConflict)
+			w.Write([]byte(`{"code":"aborted","message":"version conflict"}`))
+		case "POST /incr":
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"code":"failed_precondition","message":"value is not an integer"}`))
+		case "POST /touch":
+			w.WriteHeader(http.StatusTooManyRequests)
+			w.Write([]byte(`{"code":"resource_exhausted","message":"circuit open"}`))
+		case "POST /persist":
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"code":"not_found","message":"key not found"}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	ctx := context.Background()
+	peer := NewHTTPPeer(server.URL+"/", time.Second)
+
+	resp, err := peer.Get(ctx, "user/1")
+	if err != nil || string(resp.Value) != `"v"` || resp.Source != "l1" {
+		t.Fatalf("Expected forwarded hit, got resp=%+v err=%v", resp, err)
+	}
+	if _, err := peer.Set(ctx, "user/1", &SetRequest{Value: mustJSON(t, 1), IfVersion: 3}); !errors.Is(err, ErrVersionConflict) {
+		t.Errorf("Expected ErrVersionConflict, got %v", err)
+	}
+	if _, err := peer.Incr(ctx, "user/1", &IncrRequest{Delta: 1}); !errors.Is(err, ErrNotCounter) {
+		t.Errorf("Expected ErrNotCounter, got %v", err)
+	}
+	if _, err := peer.Touch(ctx, "user/1"); !errors.Is(err, ErrCircuitOpen) || errors.Is(err, ErrPeerUnavailable) {
+		t.Errorf("Expected the owner's open breaker as ErrCircuitOpen, got %v", err)
+	}
+	if _, err := peer.Persist(ctx, "user/1"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound, got %v", err)
+	}
+
+	server.Close()
+	if _, err := peer.Get(ctx, "user/1"); !errors.Is(err, ErrPeerUnavailable) {
+		t.Errorf("Expected ErrPeerUnavailable from a stopped peer, got %v", err)
+	}
+}
+
+func TestHeavyHitters(t *testing.T) {
+	h := newHeavyHitters(4)
+	for i := 0; i < 100; i++ {
+		h.add("hot")
+		h.add(fmt.Sprintf("cold-%d", i)) // Many distinct keys churn the other counters
+		if i%2 == 0 {
+			h.add("warm")
+		}
+	}
+
+	top := h.top(60)
+	if len(top) != 1 || top[0].key != "hot" || top[0].count < 60 {
+		t.Fatalf("Expected only hot above 60 guaranteed hits, got %+v", top)
+	}
+	if len(h.top(40)) != 2 {
+		t.Error("Expected hot and warm above 40 guaranteed hits")
+	}
+	if len(h.counters) > 4 {
+		t.Errorf("Sketch should track at most 4 keys, tracks %d", len(h.counters))
+	}
+
+	h.reset()
+	if len(h.top(1)) != 0 {
+		t.Error("Reset should clear counts")
+	}
+}
+
+func TestService_HotKeys(t *testing.T) {
+	a, _, _ := setupTestService()
+	b, originB, _ := setupTestService()
+	members := []ClusterMember{{ID: "a", Peer: NewLocalPeer(a)}, {ID: "b", Peer: NewLocalPeer(b)}}
+	a.SetCluster("a", members, 0)
+	b.SetCluster("b", members, 0)
+	a.hotKeys = newHotKeyTracker(HotKeyConfig{Threshold: 5, MaxKeys: 1}, "a")
+	ctx := context.Background()
+
+	key := ""
+	for i := 0; key == ""; i++ {
+		if k := fmt.Sprintf("viral-%d", i); a.ownerPeer(k) != nil {
+			key = k
+		}
+	}
+	originB.Set(key, "v")
+
+	for i := 0; i < 10; i++ {
+		a.Get(ctx, key)
+	}
+	a.Get(ctx, "other")
+	for i := 0; i < 20; i++ {
+		a.Get(ctx, leaseKeyPrefix+"x") // Rejected before it is counted
+	}
+	a.rotateHotKeys(ctx)
+	if !a.hotKeys.isHot(key) || a.hotKeys.isHot("other") || a.hotKeys.isHot(leaseKeyPrefix+"x") {
+		t.Fatal("Expected only the repeated key to be promoted")
+	}
+
+	// Promotion pinned a copy from the owner; Gets are served from it.
+	before, _ := a.GetMetrics(ctx)
+	for i := 0; i < 3; i++ {
+		resp, err := a.Get(ctx, key)
+		if err != nil || mustJSONString(t, resp.Value) != "v" {
+			t.Fatalf("Get failed: resp=%+v err=%v", resp, err)
+		}
+	}
+	after, _ := a.GetMetrics(ctx)
+	if forwards := after.ClusterForwards - before.ClusterForwards; forwards != 0 {
+		t.Errorf("Expected no forwards after promotion, got %d", forwards)
+	}
+
+	// A copy this instance cached itself is not served: the owner answers and is pinned.
+	a.l1For(key).SetWithOptions(key, a.compress(mustJSON(t, "old")), time.Hour, EntryOptions{Source: "origin"})
+	if resp, err := a.Get(ctx, key); err != nil || mustJSONString(t, resp.Value) != "v" {
+		t.Fatalf("Expected the owner's value over a local copy: resp=%+v err=%v", resp, err)
+	}
+	if !a.pinned(key) {
+		t.Error("Expected the owner's answer to replace the local copy")
+	}
+
+	// A promotion announced by another instance pins the key before any Get.
+	b.hotKeys = newHotKeyTracker(HotKeyConfig{Threshold: 5, MaxKeys: 1}, "b")
+	remote := ""
+	for i := 0; remote == ""; i++ {
+		if k := fmt.Sprintf("viral-%d", i); b.ownerPeer(k) != nil {
+			remote = k
+		}
+	}
+	a.Set(ctx, remote, &SetRequest{Key: remote, Value: mustJSON(t, "w")})
+	b.applyHotKey(ctx, &monitoring.HotKeyEvent{Key: remote, Action: monitoring.HotKeyPromote, Hits: 10, Instance: "a", Timestamp: time.Now()})
+	before, _ = b.GetMetrics(ctx)
+	if resp, err := b.Get(ctx, remote); err != nil || mustJSONString(t, resp.Value) != "w" || resp.Source != "l1" {
+		t.Fatalf("Expected an L1 hit on the pinned copy: resp=%+v err=%v", resp, err)
+	}
+	after, _ = b.GetMetrics(ctx)
+	if forwards := after.ClusterForwards - before.ClusterForwards; forwards != 0 {
+		t.Errorf("Expected no forward for a key promoted elsewhere, got %d", forwards)
+	}
+
+	resp, _ := a.HotKeys(ctx)
+	if len(resp.Keys) != 1 || resp.Keys[0].Key != key || !resp.Keys[0].Local || resp.Keys[0].Hits < 5 {
+		t.Errorf("Unexpected hot keys: %+v", resp.Keys)
+	}
+
+	// A window below the threshold demotes it.
+	a.rotateHotKeys(ctx)
+	if a.hotKeys.isHot(key) {
+		t.Error("Expected key to be demoted")
+	}
+	metrics, _ := a.GetMetrics(ctx)
+	if metrics.HotKeyPromotions != 1 || metrics.HotKeyDemotions != 1 || metrics.HotKeys != 0 {
+		t.Errorf("Unexpected hot-key metrics: %+v", metrics)
+	}
+}
+
+func TestL1Cache_ScanKeys(t *testing.T) {
+	cache := NewShardedL1Cache(1000, 16)
+	var all []string
+	for i := 0; i < 200; i++ {
+		key := fmt.Sprintf("key:%03d", i)
+		cache.Set(key, json.RawMessage(`"v"`), time.Hour)
+		all = append(all, key)
+	}
+
+	var got []string
+	after := ""
+	for {
+		page := cache.ScanKeys(after, 7, nil)
+		if len(page) == 0 {
+			break
+		}
+		for _, info := range page {
+			got = append(got, info.Key)
+		}
+		after = page[len(page)-1].Key
+	}
+	if len(got) != len(all) {
+		t.Fatalf("Expected %d keys, got %d", len(all), len(got))
+	}
+	for i := range all {
+		if got[i] != all[i] {
+			t.Fatalf("Expected key order %v, got %v at %d", all[i], got[i], i)
+		}
+	}
+
+	page := cache.ScanKeys("", 10, func(key string) bool { return strings.HasSuffix(key, "7") })
+	if len(page) != 10 || page[0].Key != "key:007" || page[9].Key != "key:097" {
+		t.Errorf("Expected keys ending in 7 in order, got %v", page)
+	}
+}
+
+func TestService_ListKeys(t *testing.T) {
+	svc, _, _ := setupTestService()
+	ctx := context.Background()
+
+	for i := 0; i < 10; i++ {
+		key := fmt.Sprintf("user:%d", i)
+		svc.Set(ctx, key, &SetRequest{Key: key, Value: mustJSON(t, "v")})
+	}
+	svc.Set(ctx, "product:1", &SetRequest{Key: "product:1", Value: mustJSON(t, "v"), TTL: 30})
+	svc.Get(ctx, "user:3")
+
+	resp, err := svc.ListKeys(ctx, &ListKeysRequest{Pattern: "product:*"})
+	if err != nil {
+		t.Fatalf("ListKeys failed: %v", err)
+	}
+	if len(resp.Keys) != 1 || resp.NextCursor != "" {
+		t.Fatalf("Expected a single product key and no cursor, got %+v", resp)
+	}
+	info := resp.Keys[0]
+	if info.Key != "product:1" || info.TTL != 30 || info.Source != "set" || info.Size == 0 || info.LastAccess != nil {
+		t.Errorf("Unexpected key info: %+v", info)
+	}
+
+	// Page through user keys while mutating: keys present throughout are listed once,
+	// in order, however the cache changes between pages.
+	var listed []string
+	cursor := ""
+	for page := 0; ; page++ {
+		resp, err := svc.ListKeys(ctx, &ListKeysRequest{Pattern: "user:*", Cursor: cursor, Limit: 3})
+		if err != nil {
+			t.Fatalf("ListKeys failed: %v", err)
+		}
+		for _, info := range resp.Keys {
+			listed = append(listed, info.Key)
+			if info.Key == "user:3" && info.LastAccess == nil {
+				t.Error("Expected last access for a key that was read")
+			}
+		}
+		if page == 0 {
+			svc.l1Cache.Delete("user:0")
+			svc.l1Cache.Delete("user:8")
+			svc.Set(ctx, "user:00", &SetRequest{Key: "user:00", Value: mustJSON(t, "v")})
+		}
+		if resp.NextCursor == "" {
+			break
+		}
+		cursor = resp.NextCursor
+	}
+	want := []string{"user:0", "user:1", "user:2", "user:3", "user:4", "user:5", "user:6", "user:7", "user:9"}
+	if strings.Join(listed, ",") != strings.Join(want, ",") {
+		t.Errorf("Expected %v, got %v", want, listed)
+	}
+
+	if _, err := svc.ListKeys(ctx, &ListKeysRequest{Cursor: "not base64!"}); err == nil {
+		t.Error("Expected an error for an invalid cursor")
+	}
+	if _, err := svc.ListKeys(ctx, &ListKeysRequest{Pattern: "user:["}); err == nil {
+		t.Error("Expected an error for an invalid pattern")
+	}
+}
+
+func TestL1Cache_Incr(t *testing.T) {
+	cache := NewShardedL1Cache(100, 4)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				if _, _, err := cache.Incr("hits", 1, 10, time.Hour, EntryOptions{}); err != nil {
+					t.Errorf("Incr failed: %v", err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	if entry, ok := cache.Get("hits"); !ok || string(entry.Value) != "810" {
+		t.Errorf("Expected 810 after 800 increments from 10, got %v", entry)
+	}
+
+	// The window set at creation is kept by later increments
+	_, first, _ := cache.Incr("window", 1, 0, 50*time.Millisecond, EntryOptions{})
+	value, entry, _ := cache.Incr("window", -3, 0, time.Hour, EntryOptions{})
+	if value != -2 || !entry.ExpiresAt.Equal(first.ExpiresAt) || entry.BaseTTL != 50*time.Millisecond {
+		t.Errorf("Expected -2 expiring at %v with its creation TTL, got %d: %+v", first.ExpiresAt, value, entry)
+	}
+	time.Sleep(60 * time.Millisecond)
+	if value, _, _ := cache.Incr("window", 1, 100, time.Hour, EntryOptions{}); value != 101 {
+		t.Errorf("Expected an expired counter to restart from initial, got %d", value)
+	}
+
+	cache.Set("name", mustJSON(t, "alice"), time.Hour)
+	if _, _, err := cache.Incr("name", 1, 0, time.Hour, EntryOptions{}); !errors.Is(err, ErrNotCounter) {
+		t.Errorf("Expected ErrNotCounter, got %v", err)
+	}
+	cache.Incr("max", 1, math.MaxInt64-1, time.Hour, EntryOptions{})
+	if _, _, err := cache.Incr("max", 1, 0, time.Hour, EntryOptions{}); !errors.Is(err, ErrCounterOverflow) {
+		t.Errorf("Expected ErrCounterOverflow, got %v", err)
+	}
+}
+
+func TestService_Incr(t *testing.T) {
+	a, _, mockL2 := setupTestService()
+	ctx := context.Background()
+
+	for _, tt := range []struct {
+		delta int64
+		want  int64
+	}{{0, 1}, {5, 6}, {-2, 4}} {
+		resp, err := a.Incr(ctx, "views", &IncrRequest{Delta: tt.delta, TTL: 60})
+		if err != nil {
+			t.Fatalf("Incr failed: %v", err)
+		}
+		if resp.Value != tt.want {
+			t.Errorf("Expected %d, got %d", tt.want, resp.Value)
+		}
+	}
+	if resp, err := a.Get(ctx, "views"); err != nil || string(resp.Value) != "4" {
+		t.Errorf("Expected Get to return 4, got %+v (err %v)", resp, err)
+	}
+
+	// Another instance without the counter in L1 continues from L2
+	b, _, _ := setupTestService()
+	b.SetL2Cache(mockL2)
+	if resp, err := b.Incr(ctx, "views", &IncrRequest{}); err != nil || resp.Value != 5 {
+		t.Errorf("Expected 5 from the L2 copy, got %+v (err %v)", resp, err)
+	}
+
+	a.Set(ctx, "name", &SetRequest{Value: mustJSON(t, "alice")})
+	if _, err := a.Incr(ctx, "name", &IncrRequest{}); !errors.Is(err, ErrNotCounter) {
+		t.Errorf("Expected ErrNotCounter, got %v", err)
+	}
+	if a.metrics.Incrs.Load() != 3 {
+		t.Errorf("Expected 3 increments counted, got %d", a.metrics.Incrs.Load())
+	}
+
+	// A counter keeps the TTL it was created with, so Touch restores the full window.
+	a.Incr(ctx, "window", &IncrRequest{TTL: 60})
+	time.Sleep(50 * time.Millisecond)
+	a.Incr(ctx, "window", &IncrRequest{})
+	touched, err := a.Touch(ctx, "window")
+	if err != nil || time.Until(*touched.ExpiresAt) < time.Minute-25*time.Millisecond {
+		t.Errorf("Expected Touch to restore the 60s window, got %+v (err %v)", touched, err)
+	}
+	data, _, _ := mockL2.Get(ctx, "window")
+	var stored CacheEntry
+	if err := a.decodeL2(data, &stored); err != nil || stored.BaseTTL != time.Minute {
+		t.Errorf("Expected the L2 counter to keep its 60s base TTL, got %+v (err %v)", stored, err)
+	}
+}
+
+func TestL1Cache_SlidingExpiration(t *testing.T) {
+	cache := NewL1Cache(10)
+	cache.SetWithOptions("session", mustJSON(t, "s"), 100*time.Millisecond, EntryOptions{Sliding: true})
+	cache.Set("fixed", mustJSON(t, "f"), 100*time.Millisecond)
+
+	// Reads keep a sliding entry alive past its original TTL
+	renewed := 0
+	for i := 0; i < 5; i++ {
+		time.Sleep(40 * time.Millisecond)
+		entry, ok := cache.Get("session")
+		if !ok {
+			t.Fatalf("Sliding entry expired after %d reads", i)
+		}
+		if entry.renewL2 {
+			renewed++
+		}
+	}
+	if _, ok := cache.Get("fixed"); ok {
+		t.Error("Expected the non-sliding entry to expire")
+	}
+	// The L2 copy is renewed at most once per half TTL, not on every read
+	if renewed == 0 || renewed > 3 {
+		t.Errorf("Expected 1-3 L2 renewals over 5 reads, got %d", renewed)
+	}
+
+	time.Sleep(150 * time.Millisecond)
+	if _, ok := cache.Get("session"); ok {
+		t.Error("Expected the sliding entry to expire once reads stop")
+	}
+}
+
+func TestService_ExpiryOps(t *testing.T) {
+	svc, _, mockL2 := setupTestService()
+	ctx := context.Background()
+
+	set, _ := svc.Set(ctx, "k", &SetRequest{Value: mustJSON(t, "v"), TTL: 60})
+	l2Expiry := func() time.Time {
+		data, _, _ := mockL2.Get(ctx, "k")
+		var entry CacheEntry
+		if err := svc.decodeL2(data, &entry); err != nil {
+			t.Fatalf("decode L2 entry: %v", err)
+		}
+		return entry.ExpiresAt
+	}
+
+	resp, err := svc.Expire(ctx, "k", &ExpireRequest{TTL: 600})
+	if err != nil || resp.TTL != 600 || resp.ExpiresAt == nil {
+		t.Fatalf("Expected a 600s TTL, got %+v (err %v)", resp, err)
+	}
+	if !l2Expiry().Equal(*resp.ExpiresAt) {
+		t.Errorf("Expected L2 to expire at %v, got %v", *resp.ExpiresAt, l2Expiry())
+	}
+	if got, _ := svc.Get(ctx, "k"); got.Version != set.Version || string(got.Value) != `"v"` {
+		t.Errorf("Expected value and version unchanged, got %+v", got)
+	}
+
+	// Touch restarts the TTL set by Expire
+	if resp, err := svc.Touch(ctx, "k"); err != nil || resp.TTL != 600 {
+		t.Errorf("Expected touch to restore 600s, got %+v (err %v)", resp, err)
+	}
+
+	resp, err = svc.Persist(ctx, "k")
+	if err != nil || resp.TTL != -1 || resp.ExpiresAt != nil {
+		t.Fatalf("Expected no expiry after persist, got %+v (err %v)", resp, err)
+	}
+	if !persistent(l2Expiry()) {
+		t.Error("Expected the L2 copy to be persistent")
+	}
+	if got, _ := svc.Get(ctx, "k"); got.ExpiresAt != nil {
+		t.Errorf("Expected Get to report no expiry, got %v", got.ExpiresAt)
+	}
+	if keys, _ := svc.ListKeys(ctx, &ListKeysRequest{Pattern: "k"}); len(keys.Keys) != 1 || keys.Keys[0].TTL != -1 {
+		t.Errorf("Expected ListKeys to report ttl -1, got %+v", keys.Keys)
+	}
+	if resp, _ := svc.Touch(ctx, "k"); resp.TTL != -1 {
+		t.Errorf("Expected touch to leave a persistent entry alone, got %+v", resp)
+	}
+
+	// Another instance applies the update to the copy it loads from L2
+	b, _, _ := setupTestService()
+	b.SetL2Cache(mockL2)
+	if resp, err := b.Expire(ctx, "k", &ExpireRequest{TTL: 30}); err != nil || resp.TTL != 30 {
+		t.Errorf("Expected b to expire the L2 copy, got %+v (err %v)", resp, err)
+	}
+
+	if _, err := svc.Touch(ctx, "missing"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound, got %v", err)
+	}
+	if _, err := svc.Expire(ctx, "k", &ExpireRequest{}); err == nil {
+		t.Error("Expected an error for a zero TTL")
+	}
+}
+
+func TestService_SlidingExpiration(t *testing.T) {
+	svc, _, mockL2 := setupTestService()
+	ctx := context.Background()
+
+	svc.Set(ctx, "session", &SetRequest{Value: mustJSON(t, "s"), TTL: 1, Sliding: true})
+	sets := mockL2.CallCount("set")
+
+	time.Sleep(600 * time.Millisecond)
+	resp, err := svc.Get(ctx, "session")
+	if err != nil || time.Until(*resp.ExpiresAt) < 900*time.Millisecond {
+		t.Fatalf("Expected the read to restart the 1s TTL, got %+v (err %v)", resp, err)
+	}
+	if mockL2.CallCount("set") != sets+1 {
+		t.Errorf("Expected the L2 copy to be renewed once, got %d writes", mockL2.CallCount("set")-sets)
+	}
+
+	// Instances loading the renewed copy keep sliding it
+	b, _, _ := setupTestService()
+	b.SetL2Cache(mockL2)
+	time.Sleep(600 * time.Millisecond)
+	if resp, err := b.Get(ctx, "session"); err != nil || resp.Source != "l2" {
+		t.Fatalf("Expected b to load the renewed session from L2, got %+v (err %v)", resp, err)
+	}
+	if resp, _ := b.Get(ctx, "session"); time.Until(*resp.ExpiresAt) < 900*time.Millisecond {
+		t.Errorf("Expected b to slide the session, got expiry %v", resp.ExpiresAt)
+	}
+}
+
+func TestService_Lease(t *testing.T) {
+	svc, _, _ := setupTestService()
+	ctx := context.Background()
+
+	a, err := svc.AcquireLease(ctx, "job", &AcquireLeaseRequest{Owner: "a", TTL: 30})
+	if err != nil || a.Owner != "a" || a.Token == 0 {
+		t.Fatalf("Expected a to acquire the lease, got %+v (err %v)", a, err)
+	}
+	if _, err := svc.AcquireLease(ctx, "job", &AcquireLeaseRequest{Owner: "b", TTL: 30}); !errors.Is(err, ErrLeaseHeld) {
+		t.Errorf("Expected ErrLeaseHeld, got %v", err)
+	}
+	// A retried acquire extends the lease under the same token
+	if again, _ := svc.AcquireLease(ctx, "job", &AcquireLeaseRequest{Owner: "a", TTL: 60}); again.Token != a.Token || !again.ExpiresAt.After(a.ExpiresAt) {
+		t.Errorf("Expected a to extend token %d, got %+v", a.Token, again)
+	}
+
+	if _, err := svc.RenewLease(ctx, "job", &RenewLeaseRequest{Owner: "a", Token: a.Token + 1}); !errors.Is(err, ErrLeaseNotHeld) {
+		t.Errorf("Expected ErrLeaseNotHeld for a stale token, got %v", err)
+	}
+	if _, err := svc.RenewLease(ctx, "job", &RenewLeaseRequest{Owner: "a", Token: a.Token}); err != nil {
+		t.Errorf("Renew failed: %v", err)
+	}
+	if got, err := svc.GetLease(ctx, "job"); err != nil || got.Owner != "a" || got.Token != a.Token {
+		t.Errorf("Expected a to hold token %d, got %+v (err %v)", a.Token, got, err)
+	}
+
+	if err := svc.ReleaseLease(ctx, "job", &ReleaseLeaseRequest{Owner: "b", Token: a.Token}); !errors.Is(err, ErrLeaseNotHeld) {
+		t.Errorf("Expected b's release to fail, got %v", err)
+	}
+	if err := svc.ReleaseLease(ctx, "job", &ReleaseLeaseRequest{Owner: "a", Token: a.Token}); err != nil {
+		t.Fatalf("Release failed: %v", err)
+	}
+	if _, err := svc.GetLease(ctx, "job"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected a released lease to be free, got %v", err)
+	}
+
+	b, err := svc.AcquireLease(ctx, "job", &AcquireLeaseRequest{Owner: "b", TTL: 1})
+	if err != nil || b.Token <= a.Token {
+		t.Fatalf("Expected b to acquire a token above %d, got %+v (err %v)", a.Token, b, err)
+	}
+
+	// TTL cleanup drops the expired lease; the next holder's token still increases
+	if n := svc.leases.expire(time.Now().Add(2 * time.Second)); n != 1 {
+		t.Errorf("Expected 1 expired lease, got %d", n)
+	}
+	if _, err := svc.RenewLease(ctx, "job", &RenewLeaseRequest{Owner: "b", Token: b.Token}); !errors.Is(err, ErrLeaseNotHeld) {
+		t.Errorf("Expected b's expired lease to be gone, got %v", err)
+	}
+	if c, _ := svc.AcquireLease(ctx, "job", &AcquireLeaseRequest{Owner: "c", TTL: 30}); c.Token <= b.Token {
+		t.Errorf("Expected a token above %d, got %d", b.Token, c.Token)
+	}
+
+	if _, err := svc.AcquireLease(ctx, "job", &AcquireLeaseRequest{TTL: 30}); err == nil {
+		t.Error("Expected an error for an empty owner")
+	}
+
+	// Clustered instances without a shared compare-and-set L2 refuse leases, since
+	// each would grant them from its own table.
+	other, _, _ := setupTestService()
+	svc.SetCluster("a", []ClusterMember{{ID: "a"}, {ID: "b", Peer: NewLocalPeer(other)}}, 0)
+	if _, err := svc.AcquireLease(ctx, "job2", &AcquireLeaseRequest{Owner: "a", TTL: 30}); !errors.Is(err, ErrLeaseNoSharedStore) {
+		t.Errorf("Expected ErrLeaseNoSharedStore, got %v", err)
+	}
+	if _, err := svc.GetLease(ctx, "job"); !errors.Is(err, ErrLeaseNoSharedStore) {
+		t.Errorf("Expected ErrLeaseNoSharedStore, got %v", err)
+	}
+}
+
+func TestL1Cache_ExpiryHeap(t *testing.T) {
+	cache := NewL1Cache(3)
+	cache.Set("short", mustJSON(t, 1), 50*time.Millisecond)
+	cache.Set("long", mustJSON(t, 2), time.Hour)
+	cache.SetWithOptions("forever", mustJSON(t, 3), NoExpiry, EntryOptions{})
+	// Moving an expiry later reorders the heap; rewriting as persistent leaves it
+	cache.Set("long", mustJSON(t, 2), 60*time.Millisecond)
+	cache.Set("short", mustJSON(t, 1), time.Hour)
+
+	s := cache.shards[0]
+	if len(s.expiry) != 2 {
+		t.Fatalf("Expected 2 scheduled entries, got %d", len(s.expiry))
+	}
+
+	time.Sleep(80 * time.Millisecond)
+	if n := cache.CleanupExpired(); n != 1 {
+		t.Errorf("Expected 1 expired entry, got %d", n)
+	}
+	if _, ok := cache.Get("short"); !ok {
+		t.Error("Expected short to survive its rescheduled expiry")
+	}
+	if _, ok := cache.Get("forever"); !ok {
+		t.Error("Expected the persistent entry to survive cleanup")
+	}
+
+	// Capacity evictions are counted apart from expirations
+	cache.Set("a", mustJSON(t, 4), time.Hour)
+	cache.Set("b", mustJSON(t, 5), time.Hour)
+	if cache.Evictions() != 1 || cache.Expirations() != 1 {
+		t.Errorf("Expected 1 eviction and 1 expiration, got %d and %d", cache.Evictions(), cache.Expirations())
+	}
+	if len(s.expiry) != cache.Size()-1 {
+		t.Errorf("Expected every expiring entry scheduled once, got %d for %d entries", len(s.expiry), cache.Size())
+	}
+	for i, entry := range s.expiry {
+		if entry.heapIndex != i {
+			t.Errorf("Entry %q has heap index %d at position %d", entry.key, entry.heapIndex, i)
+		}
+	}
+}
+
+func TestL1Cache_EvictionListener(t *testing.T) {
+	cache := NewL1Cache(2)
+	var got []Eviction
+	cache.SetEvictionListener(func(ev Eviction) { got = append(got, ev) })
+
+	cache.Set("a", mustJSON(t, 1), time.Hour)
+	cache.Set("b", mustJSON(t, 2), 30*time.Millisecond)
+	time.Sleep(10 * time.Millisecond)
+	cache.Set("a", mustJSON(t, 3), time.Hour) // replaced
+	time.Sleep(30 * time.Millisecond)
+	cache.CleanupExpired() // b expired
+	cache.Set("c", mustJSON(t, 4), time.Hour)
+	cache.Set("d", mustJSON(t, 5), time.Hour) // a evicted for capacity
+	cache.Delete("c")                         // invalidated
+	cache.Clear()                             // d invalidated
+
+	want := []Eviction{
+		{Key: "a", Reason: EvictionReplaced},
+		{Key: "b", Reason: EvictionExpired},
+		{Key: "a", Reason: EvictionCapacity},
+		{Key: "c", Reason: EvictionInvalidated},
+		{Key: "d", Reason: EvictionInvalidated},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("Expected %d evictions, got %+v", len(want), got)
+	}
+	for i := range want {
+		if got[i].Key != want[i].Key || got[i].Reason != want[i].Reason {
+			t.Errorf("Eviction %d: expected %s/%s, got %s/%s", i, want[i].Key, want[i].Reason, got[i].Key, got[i].Reason)
+		}
+	}
+	// Age is measured from the write of the value removed
+	if got[0].Age < 10*time.Millisecond || got[1].Age < 40*time.Millisecond || got[2].Age > got[1].Age {
+		t.Errorf("Unexpected ages: %v, %v, %v", got[0].Age, got[1].Age, got[2].Age)
+	}
+}
+
+func TestEvictionSampler(t *testing.T) {
+	var drops atomic.Int64
+	sampler := newEvictionSampler(EvictionEventConfig{SampleRate: 1, MaxBatch: 2}, "node-1", &drops)
+	cache := NewL1Cache(1)
+	cache.SetEvictionListener(sampler.record)
+
+	for i := 0; i < 4; i++ {
+		cache.Set(fmt.Sprintf("k%d", i), mustJSON(t, i), time.Hour)
+	}
+	event := sampler.take(time.Now())
+	if event == nil || len(event.Evictions) != 2 || event.Instance != "node-1" || event.SampleRate != 1 {
+		t.Fatalf("Expected a batch of 2 samples, got %+v", event)
+	}
+	if event.Evictions[0].Key != "k0" || event.Evictions[0].Reason != "capacity" {
+		t.Errorf("Expected k0 evicted for capacity, got %+v", event.Evictions[0])
+	}
+	if drops.Load() != 1 {
+		t.Errorf("Expected 1 sample dropped from the full batch, got %d", drops.Load())
+	}
+	if sampler.take(time.Now()) != nil {
+		t.Error("Expected no batch after the last was taken")
+	}
+
+	// Sampling keeps roughly SampleRate of the evictions
+	sampler = newEvictionSampler(EvictionEventConfig{SampleRate: 0.1, MaxBatch: 10000}, "node-1", &drops)
+	for i := 0; i < 10000; i++ {
+		sampler.record(Eviction{Key: "k", Reason: EvictionExpired})
+	}
+	if n := len(sampler.take(time.Now()).Evictions); n < 800 || n > 1200 {
+		t.Errorf("Expected about 1000 samples, got %d", n)
+	}
+}
+
+func TestService_MetricEvents(t *testing.T) {
+	svc, mockOrigin, _ := setupTestService()
+	svc.metricEvents = newMetricEventSampler(MetricEventConfig{SampleRate: 1}, "node-1", &svc.metrics.MetricEventDrops)
+	ctx := context.Background()
+	mockOrigin.Set("loaded", "from-origin")
+
+	if _, err := svc.Set(ctx, "user:1", &SetRequest{Value: mustJSON(t, "alice")}); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+	if _, err := svc.Get(ctx, "user:1"); err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	if _, err := svc.Get(ctx, "loaded"); err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	if _, err := svc.Invalidate(ctx, &InvalidateRequest{Keys: []string{"loaded"}, Pattern: "user:*"}); err != nil {
+		t.Fatalf("Invalidate failed: %v", err)
+	}
+	if _, err := svc.MSet(ctx, &MSetRequest{Entries: []SetRequest{{Key: "batch:1", Value: mustJSON(t, 1)}}}); err != nil {
+		t.Fatalf("MSet failed: %v", err)
+	}
+	if _, err := svc.MGet(ctx, &MGetRequest{Keys: []string{"batch:1", "loaded"}}); err != nil {
+		t.Fatalf("MGet failed: %v", err)
+	}
+
+	events := svc.metricEvents.take()
+	want := []struct {
+		op   string
+		key  string
+		hit  bool
+		size int
+	}{
+		{opSet, "user:1", false, len(mustJSON(t, "alice"))},
+		{opGet, "user:1", true, len(mustJSON(t, "alice"))},
+		{opGet, "loaded", false, len(mustJSON(t, "from-origin"))},
+		{opDelete, "loaded", true, 0},
+		{opInvalidate, "user:*", true, 1},
+		{opSet, "batch:1", false, 1},
+		{opGet, "batch:1", true, 1},
+		{opGet, "loaded", false, len(mustJSON(t, "from-origin"))},
+	}
+	if len(events) != len(want) {
+		t.Fatalf("Expected %d events, got %+v", len(want), events)
+	}
+	for i, w := range want {
+		e := events[i]
+		if e.Operation != w.op || e.Key != w.key || e.Hit != w.hit || e.Size != w.size {
+			t.Errorf("Event %d: expected %+v, got %+v", i, w, e)
+		}
+		if e.Instance != "node-1" || e.SampleRate != 1 || e.Latency < 0 || e.Timestamp.IsZero() {
+			t.Errorf("Event %d: unexpected metadata %+v", i, e)
+		}
+	}
+
+	// A full batch drops further events until the next flush
+	svc.metricEvents = newMetricEventSampler(MetricEventConfig{SampleRate: 1, MaxBatch: 1}, "node-1", &svc.metrics.MetricEventDrops)
+	svc.Get(ctx, "user:2")
+	svc.Get(ctx, "user:3")
+	if n := len(svc.metricEvents.take()); n != 1 {
+		t.Errorf("Expected 1 event in the batch, got %d", n)
+	}
+	if drops := svc.metrics.MetricEventDrops.Load(); drops != 1 {
+		t.Errorf("Expected 1 dropped event, got %d", drops)
+	}
+
+	// Without a sampler nothing is recorded
+	svc.metricEvents = nil
+	svc.Get(ctx, "user:1")
+}
+
+func TestL1Cache_Size(t *testing.T) {
+	cache := NewL1Cache(100)
+
+	if cache.Size() != 0 {
+		t.Errorf("Expected size 0, got %d", cache.Size())
+	}
+
+	cache.Set("key1", mustJSON(t, "value1"), 1*time.Hour)
+	cache.Set("key2", mustJSON(t, "value2"), 1*time.Hour)
+
+	if cache.Size() != 2 {
+		t.Errorf("Expected size 2, got %d", cache.Size())
+	}
+
+	cache.Delete("key1")
+
+	if cache.Size() != 1 {
+		t.Errorf("Expected size 1, got %d", cache.Size())
+	}
+}
+
+func TestL1Cache_Clear(t *testing.T) {
+	cache := NewL1Cache(100)
+
+	cache.Set("key1", mustJSON(t, "value1"), 1*time.Hour)
+	cache.Set("key2", mustJSON(t, "value2"), 1*time.Hour)
+
+	cache.Clear()
+
+	if cache.Size() != 0 {
+		t.Errorf("Expected size 0 after clear, got %d", cache.Size())
+	}
+
+	if _, ok := cache.Get("key1"); ok {
+		t.Error("Cache should be empty after clear")
+	}
+}
+
+func TestRequestCoalescer_InFlight(t *testing.T) {
+	coalescer := NewRequestCoalescer()
+
+	if coalescer.InFlight() != 0 {
+		t.Errorf("Expected 0 in-flight, got %d", coalescer.InFlight())
+	}
+
+	// Start a slow call
+	done := make(chan bool)
+	go func() {
+		coalescer.Do("key1", func() (interface{}, error) {
+			time.Sleep(100 * time.Millisecond)
+			return "result", nil
+		})
+		done <- true
+	}()
+
+	// Give goroutine time to start
+	time.Sleep(10 * time.Millisecond)
+
+	// Should have 1 in-flight
+	if coalescer.InFlight() != 1 {
+		t.Errorf("Expected 1 in-flight, got %d", coalescer.InFlight())
+	}
+
+	<-done
+
+	// Should be back to 0
+	time.Sleep(10 * time.Millisecond)
+	if coalescer.InFlight() != 0 {
+		t.Errorf("Expected 0 in-flight after completion, got %d", coalescer.InFlight())
+	}
+}
+
+func TestRequestCoalescer_Forget(t *testing.T) {
+	coalescer := NewRequestCoalescer()
+
+	// Start a call
+	go coalescer.Do("key1", func() (interface{}, error) {
+		time.Sleep(100 * time.Millisecond)
+		return "result", nil
+	})
+
+	time.Sleep(10 * time.Millisecond)
+
+	// Forget the key
+	coalescer.Forget("key1")
+
+	// Should allow new call for same key
+	callCount := 0
+	coalescer.Do("key1", func() (interface{}, error) {
+		callCount++
+		return "new_result", nil
+	})
+
+	if callCount != 1 {
+		t.Error("Forget should allow new call")
+	}
+}
+
+func TestPolicyEngine(t *testing.T) {
+	engine := DefaultPolicyEngine()
+
+	entry := &CacheEntry{
+		Value:     mustJSON(t, "test"),
+		ExpiresAt: time.Now().Add(1 * time.Hour),
+	}
+
+	// Should not evict valid entry
+	if engine.ShouldEvict(entry) {
+		t.Error("Should not evict non-expired entry")
+	}
+
+	// Expired entry should be evicted
+	expiredEntry := &CacheEntry{
+		Value:     mustJSON(t, "test"),
+		ExpiresAt: time.Now().Add(-1 * time.Hour),
+	}
+
+	if !engine.ShouldEvict(expiredEntry) {
+		t.Error("Should evict expired entry")
+	}
+
+	// Test access recording
+	engine.RecordAccess("key1")
+	engine.RecordSet("key2", "value2", 1*time.Hour)
+}
+
+func TestLRUPolicy_VictimOrder(t *testing.T) {
+	p := NewLRUPolicy()
+	p.OnSet("a", nil, time.Hour)
+	p.OnSet("b", nil, time.Hour)
+	p.OnSet("c", nil, time.Hour)
+	p.OnAccess("a")
+	p.OnRemove("c")
+
+	var order []string
+	for {
+		key, ok := p.Victim()
+		if !ok {
+			break
+		}
+		order = append(order, key)
+	}
+
+	if strings.Join(order, ",") != "b,a" {
+		t.Errorf("Expected victims b,a, got %v", order)
+	}
+}
+
+func TestPolicyFactoryByName(t *testing.T) {
+	for _, name := range []string{"", PolicyLRU, PolicyTinyLFU} {
+		if _, err := PolicyFactoryByName(name); err != nil {
+			t.Errorf("PolicyFactoryByName(%q) failed: %v", name, err)
+		}
+	}
+	if _, err := PolicyFactoryByName("mru"); err == nil {
+		t.Error("Expected error for unknown policy")
+	}
+	if _, err := newL1CacheFromConfig(Config{L1MaxEntries: 10, L1Policy: "mru"}); err == nil {
+		t.Error("Expected error for unknown L1Policy")
+	}
+}
+
+func TestCountMinSketch(t *testing.T) {
+	s := newCountMinSketch(100)
+
+	for i := 0; i < 5; i++ {
+		s.increment("hot")
+	}
+	s.increment("warm")
+
+	if got := s.estimate("hot"); got < 5 {
+		t.Errorf("Expected hot estimate >= 5, got %d", got)
+	}
+	if got := s.estimate("warm"); got < 1 {
+		t.Errorf("Expected warm estimate >= 1, got %d", got)
+	}
+
+	// Counters saturate
+	for i := 0; i < 50; i++ {
+		s.increment("hot")
+	}
+	if got := s.estimate("hot"); got != cmsMaxCounter {
+		t.Errorf("Expected saturated estimate %d, got %d", cmsMaxCounter, got)
+	}
+
+	// Aging halves counters
+	before := s.estimate("hot")
+	s.reset()
+	if got := s.estimate("hot"); got != before/2 {
+		t.Errorf("Expected estimate %d after reset, got %d", before/2, got)
+	}
+}
+
+func TestTinyLFUPolicy_ScanResistance(t *testing.T) {
+	const capacity = 100
+
+	newCache := func(policy PolicyFactory) *L1Cache {
+		return NewL1CacheWithOptions(L1Options{MaxEntries: capacity, Shards: 1, Policy: policy})
+	}
+	run := func(cache *L1Cache) int {
+		// Build a frequently used working set
+		for round := 0; round < 5; round++ {
+			for i := 0; i < 50; i++ {
+				key := fmt.Sprintf("hot:%d", i)
+				if _, ok := cache.Get(key); !ok {
+					cache.Set(key, mustJSON(t, i), time.Hour)
+				}
+			}
+		}
+
+		// One-off scan larger than the cache
+		for i := 0; i < 1000; i++ {
+			cache.Set(fmt.Sprintf("scan:%d", i), mustJSON(t, i), time.Hour)
+		}
+
+		hits := 0
+		for i := 0; i < 50; i++ {
+			if _, ok := cache.Get(fmt.Sprintf("hot:%d", i)); ok {
+				hits++
+			}
+		}
+		return hits
+	}
+
+	lruCache := newCache(NewLRUPolicyFactory())
+	tinyCache := newCache(NewTinyLFUPolicyFactory())
+	lruHits := run(lruCache)
+	tinyHits := run(tinyCache)
+
+	if lruHits != 0 {
+		t.Errorf("Expected scan to flush LRU hot set, got %d hits", lruHits)
+	}
+	if tinyHits < 45 {
+		t.Errorf("Expected TinyLFU to retain hot set, got %d/50 hits", tinyHits)
+	}
+	if size := tinyCache.Size(); size > capacity {
+		t.Errorf("Expected TinyLFU cache size <= %d, got %d", capacity, size)
+	}
+}
+
+func TestTinyLFUPolicy_TracksRemovals(t *testing.T) {
+	cache := NewL1CacheWithOptions(L1Options{MaxEntries: 10, Shards: 1, Policy: NewTinyLFUPolicyFactory()})
+
+	for i := 0; i < 10; i++ {
+		cache.Set(fmt.Sprintf("key%d", i), mustJSON(t, i), time.Hour)
+	}
+	cache.Delete("key3")
+	cache.DeletePattern("key5*")
+
+	for i := 10; i < 30; i++ {
+		cache.Set(fmt.Sprintf("key%d", i), mustJSON(t, i), time.Hour)
+	}
+
+	if size := cache.Size(); size != 10 {
+		t.Errorf("Expected size 10, got %d", size)
+	}
+	cache.Clear()
+	if size := cache.Size(); size != 0 {
+		t.Errorf("Expected size 0 after clear, got %d", size)
+	}
+}
+
+// fakeRedisServer is an in-process RESP server supporting the subset of
+// commands used by RedisCache.
+type fakeRedisServer struct {
+	ln       net.Listener
+	password string
+
+	mu      sync.Mutex
+	data    map[string][]byte
+	expires map[string]time.Time
+	sets    map[string]map[string]struct{}
+	cursors []string
+	calls   map[string]int
+
+	accepted atomic.Int64
+	stall    atomic.Bool // when set, commands are read but never answered
+}
+
+func newFakeRedisServer(t *testing.T) *fakeRedisServer {
+	t.Helper()
+	return newFakeRedisServerWithPassword(t, "")
+}
+
+func newFakeRedisServerWithPassword(t *testing.T, password string) *fakeRedisServer {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	s := &fakeRedisServer{
+		ln:       ln,
+		password: password,
+		data:     make(map[string][]byte),
+		expires:  make(map[string]time.Time),
+		sets:     make(map[string]map[string]struct{}),
+		calls:    make(map[string]int),
+	}
+	go s.serve()
+	t.Cleanup(func() { ln.Close() })
+	return s
+}
+
+func (s *fakeRedisServer) Addr() string {
+	return s.ln.Addr().String()
+}
+
+func (s *fakeRedisServer) serve() {
+	for {
+		conn, err := s.ln.Accept()
+		if err != nil {
+			return
+		}
+		s.accepted.Add(1)
+		go s.handle(conn)
+	}
+}
+
+func (s *fakeRedisServer) handle(conn net.Conn) {
+	defer conn.Close()
+	br := bufio.NewReader(conn)
+	bw := bufio.NewWriter(conn)
+	authed := s.password == ""
+
+	for {
+		req, err := readReply(br)
+		if err != nil {
+			return
+		}
+		parts, _ := req.([]interface{})
+		args := make([]string, len(parts))
+		for i, p := range parts {
+			b, _ := p.([]byte)
+			args[i] = string(b)
+		}
+		if len(args) == 0 || s.stall.Load() {
+			continue
+		}
+
+		cmd := strings.ToUpper(args[0])
+		if !authed && cmd != "AUTH" {
+			bw.WriteString("-NOAUTH Authentication required.\r\n")
+			bw.Flush()
+			continue
+		}
+
+		switch cmd {
+		case "AUTH":
+			if args[1] != s.password {
+				bw.WriteString("-ERR invalid password\r\n")
+			} else {
+				authed = true
+				bw.WriteString("+OK\r\n")
+			}
+		default:
+			s.exec(bw, cmd, args[1:])
+		}
+		bw.Flush()
+	}
+}
+
+func (s *fakeRedisServer) exec(bw *bufio.Writer, cmd string, args []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.calls[cmd]++
+
+	switch cmd {
+	case "PING":
+		bw.WriteString("+PONG\r\n")
+	case "SELECT":
+		bw.WriteString("+OK\r\n")
+	case "GET":
+		val, ok := s.getUnsafe(args[0])
+		if !ok {
+			bw.WriteString("$-1\r\n")
+			return
+		}
+		fmt.Fprintf(bw, "$%d\r\n%s\r\n", len(val), val)
+	case "MGET":
+		fmt.Fprintf(bw, "*%d\r\n", len(args))
+		for _, k := range args {
+			if val, ok := s.getUnsafe(k); ok {
+				fmt.Fprintf(bw, "$%d\r\n%s\r\n", len(val), val)
+			} else {
+				bw.WriteString("$-1\r\n")
+			}
+		}
+	case "SET":
+		s.data[args[0]] = []byte(args[1])
+		delete(s.expires, args[0])
+		if len(args) >= 4 && strings.ToUpper(args[2]) == "PX" {
+			ms, _ := strconv.Atoi(args[3])
+			s.expires[args[0]] = time.Now().Add(time.Duration(ms) * time.Millisecond)
+		}
+		bw.WriteString("+OK\r\n")
+	case "EVAL":
+		// Only the scripts used by RedisCache are supported.
+		if args[0] == incrCounterScript {
+			s.incrCounterUnsafe(bw, args[2], args[3:])
+			return
+		}
+		if args[0] != compareAndSetScript {
+			bw.WriteString("-ERR unknown script\r\n")
+			return
+		}
+		key, hasOld, old, value := args[2], args[3], args[4], args[5]
+		cur, ok := s.getUnsafe(key)
+		if (hasOld == "0" && ok) || (hasOld == "1" && (!ok || string(cur) != old)) {
+			bw.WriteString(":0\r\n")
+			return
+		}
+		s.data[key] = []byte(value)
+		delete(s.expires, key)
+		if ms, _ := strconv.Atoi(args[6]); ms > 0 {
+			s.expires[key] = time.Now().Add(time.Duration(ms) * time.Millisecond)
+		}
+		bw.WriteString(":1\r\n")
+	case "DEL":
+		n := 0
+		for _, k := range args {
+			if _, ok := s.getUnsafe(k); ok {
+				n++
+			} else if _, ok := s.sets[k]; ok {
+				n++
+			}
+			delete(s.data, k)
+			delete(s.expires, k)
+			delete(s.sets, k)
+		}
+		fmt.Fprintf(bw, ":%d\r\n", n)
+	case "SADD":
+		set, ok := s.sets[args[0]]
+		if !ok {
+			set = make(map[string]struct{})
+			s.sets[args[0]] = set
+		}
+		n := 0
+		for _, m := range args[1:] {
+			if _, ok := set[m]; !ok {
+				set[m] = struct{}{}
+				n++
+			}
+		}
+		fmt.Fprintf(bw, ":%d\r\n", n)
+	case "SREM":
+		n := 0
+		for _, m := range args[1:] {
+			if _, ok := s.sets[args[0]][m]; ok {
+				delete(s.sets[args[0]], m)
+				n++
+			}
+		}
+		if len(s.sets[args[0]]) == 0 {
+			delete(s.sets, args[0])
+		}
+		fmt.Fprintf(bw, ":%d\r\n", n)
+	case "SMEMBERS":
+		members := make([]string, 0, len(s.sets[args[0]]))
+		for m := range s.sets[args[0]] {
+			members = append(members, m)
+		}
+		sort.Strings(members)
+		fmt.Fprintf(bw, "*%d\r\n", len(members))
+		for _, m := range members {
+			fmt.Fprintf(bw, "$%d\r\n%s\r\n", len(m), m)
+		}
+	case "SCAN":
+		cursor, _ := strconv.Atoi(args[0])
+		match, count := "*", 10
+		for i := 1; i+1 < len(args); i += 2 {
+			switch strings.ToUpper(args[i]) {
+			case "MATCH":
+				match = args[i+1]
+			case "COUNT":
+				count, _ = strconv.Atoi(args[i+1])
+			}
+		}
+		keys := make([]string, 0, len(s.data))
+		for k := range s.data {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+
+		// Cursors resume from a key rather than an index so that keys
+		// deleted between calls don't cause others to be skipped.
+		start := 0
+		if cursor > 0 && cursor <= len(s.cursors) {
+			start = sort.SearchStrings(keys, s.cursors[cursor-1])
+		}
+		end := start + count
+		if end >= len(keys) {
+			end = len(keys)
+		}
+		var batch []string
+		for _, k := range keys[start:end] {
+			if ok, _ := path.Match(match, k); ok {
+				batch = append(batch, k)
+			}
+		}
+		next := 0
+		if end < len(keys) {
+			s.cursors = append(s.cursors, keys[end])
+			next = len(s.cursors)
+		}
+		nextStr := strconv.Itoa(next)
+		fmt.Fprintf(bw, "*2\r\n$%d\r\n%s\r\n*%d\r\n", len(nextStr), nextStr, len(batch))
+		for _, k := range batch {
+			fmt.Fprintf(bw, "$%d\r\n%s\r\n", len(k), k)
+		}
+	default:
+		fmt.Fprintf(bw, "-ERR unknown command '%s'\r\n", cmd)
+	}
+}
+
+func (s *fakeRedisServer) getUnsafe(key string) ([]byte, bool) {
+	if exp, ok := s.expires[key]; ok && time.Now().After(exp) {
+		delete(s.data, key)
+		delete(s.expires, key)
+		return nil, false
+	}
+	val, ok := s.data[key]
+	return val, ok
+}
+
+// incrCounterUnsafe emulates incrCounterScript on key with the script's ARGV,
+// decoding the current payload into float64 numbers as cjson does.
+func (s *fakeRedisServer) incrCounterUnsafe(bw *bufio.Writer, key string, argv []string) {
+	delta, _ := strconv.ParseFloat(argv[0], 64)
+	base, _ := strconv.ParseFloat(argv[1], 64)
+	px, _ := strconv.ParseInt(argv[2], 10, 64)
+	baseTTL, _ := strconv.ParseFloat(argv[5], 64)
+	expires, prev := argv[4], 0.0
+	if cur, ok := s.getUnsafe(key); ok {
+		var entry map[string]interface{}
+		if err := json.Unmarshal(cur, &entry); err != nil {
+			bw.WriteString("*1\r\n:0\r\n")
+			return
+		}
+		if entry["tombstone"] != true {
+			value, ok := entry["value"].(float64)
+			if !ok || value != math.Floor(value) {
+				bw.WriteString("*1\r\n:-1\r\n")
+				return
+			}
+			expiresAt, ok := entry["expires_at"].(string)
+			if !ok {
+				bw.WriteString("*1\r\n:0\r\n")
+				return
+			}
+			quoted, _ := json.Marshal(expiresAt)
+			base, expires = value, string(quoted)
+			baseTTL, _ = entry["base_ttl"].(float64)
+			prev, _ = entry["version"].(float64)
+			px = -1
+			if exp, ok := s.expires[key]; ok {
+				px = time.Until(exp).Milliseconds()
+			}
+		}
+	}
+	n := base + delta
+	if math.Abs(base) >= maxScriptCounter || math.Abs(n) >= maxScriptCounter || baseTTL >= maxScriptCounter || prev >= maxScriptCounter {
+		bw.WriteString("*1\r\n:0\r\n")
+		return
+	}
+	version, _ := strconv.ParseFloat(argv[6], 64)
+	if version <= prev {
+		version = prev + 1
+	}
+	entry := fmt.Sprintf(`{"value":%d,"cached_at":%s,"expires_at":%s,"source":"","version":%d`, int64(n), argv[3], expires, int64(version))
+	if baseTTL > 0 {
+		entry += fmt.Sprintf(`,"base_ttl":%d`, int64(baseTTL))
+	}
+	entry += "}"
+
+	s.data[key] = []byte(entry)
+	delete(s.expires, key)
+	if px > 0 {
+		s.expires[key] = time.Now().Add(time.Duration(px) * time.Millisecond)
+	}
+	fmt.Fprintf(bw, "*2\r\n:1\r\n$%d\r\n%s\r\n", len(entry), entry)
+}
+
+// Calls returns how many times cmd has been executed.
+func (s *fakeRedisServer) Calls(cmd string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.calls[cmd]
+}
+
+func (s *fakeRedisServer) Keys() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	keys := make([]string, 0, len(s.data))
+	for k := range s.data {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func newTestRedisCache(addr string) *RedisCache {
+	return NewRedisCache(RedisConfig{
+		Addr:           addr,
+		PoolSize:       4,
+		DialTimeout:    time.Second,
+		CommandTimeout: 500 * time.Millisecond,
+		KeyPrefix:      "cache:",
+		ScanCount:      3,
+	})
+}
+
+func TestRedisCache_SetGetDelete(t *testing.T) {
+	server := newFakeRedisServer(t)
+	rc := newTestRedisCache(server.Addr())
+	defer rc.Close()
+	ctx := context.Background()
+
+	if err := rc.Ping(ctx); err != nil {
+		t.Fatalf("Ping failed: %v", err)
+	}
+
+	if _, ok, err := rc.Get(ctx, "missing"); err != nil || ok {
+		t.Errorf("Expected miss for missing key, got ok=%v err=%v", ok, err)
+	}
+
+	if err := rc.Set(ctx, "key1", []byte(`{"a":1}`), time.Hour); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+
+	data, ok, err := rc.Get(ctx, "key1")
+	if err != nil || !ok || string(data) != `{"a":1}` {
+		t.Errorf("Expected stored value, got %q ok=%v err=%v", data, ok, err)
+	}
+
+	// Keys are namespaced by prefix on the server
+	if keys := server.Keys(); len(keys) != 1 || keys[0] != "cache:key1" {
+		t.Errorf("Expected prefixed key on server, got %v", keys)
+	}
+
+	if err := rc.Delete(ctx, "key1"); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	if _, ok, _ := rc.Get(ctx, "key1"); ok {
+		t.Error("key1 should be deleted")
+	}
+}
+
+func TestRedisCache_TTL(t *testing.T) {
+	server := newFakeRedisServer(t)
+	rc := newTestRedisCache(server.Addr())
+	defer rc.Close()
+	ctx := context.Background()
+
+	rc.Set(ctx, "short", []byte("v"), 30*time.Millisecond)
+	time.Sleep(60 * time.Millisecond)
+
+	if _, ok, _ := rc.Get(ctx, "short"); ok {
+		t.Error("short should have expired")
+	}
+}
+
+func TestRedisCache_Multi(t *testing.T) {
+	server := newFakeRedisServer(t)
+	rc := newTestRedisCache(server.Addr())
+	defer rc.Close()
+	ctx := context.Background()
+
+	err := rc.SetMulti(ctx, []RemoteCacheItem{
+		{Key: "a", Value: []byte("1"), TTL: time.Minute},
+		{Key: "b", Value: []byte("2"), TTL: time.Minute},
+		{Key: "c", Value: []byte("3"), TTL: 20 * time.Millisecond},
+	})
+	if err != nil {
+		t.Fatalf("SetMulti failed: %v", err)
+	}
+	time.Sleep(40 * time.Millisecond)
+
+	found, err := rc.GetMulti(ctx, []string{"a", "missing", "b", "c"})
+	if err != nil {
+		t.Fatalf("GetMulti failed: %v", err)
+	}
+	if len(found) != 2 || string(found["a"]) != "1" || string(found["b"]) != "2" {
+		t.Errorf("Expected a=1 and b=2 only, got %v", found)
+	}
+	if calls := server.Calls("MGET"); calls != 1 {
+		t.Errorf("Expected a single MGET, got %d", calls)
+	}
+	if server.Calls("GET") != 0 {
+		t.Error("Expected no single-key GETs")
+	}
+
+	// The pooled connection stays usable after a pipeline
+	if _, ok, err := rc.Get(ctx, "a"); err != nil || !ok {
+		t.Errorf("Expected Get after pipeline to succeed, got ok=%v err=%v", ok, err)
+	}
+}
+
+func TestRedisCache_CompareAndSet(t *testing.T) {
+	server := newFakeRedisServer(t)
+	rc := newTestRedisCache(server.Addr())
+	defer rc.Close()
+	ctx := context.Background()
+
+	if ok, err := rc.CompareAndSet(ctx, "k", nil, []byte("a"), time.Minute); err != nil || !ok {
+		t.Fatalf("Expected create on absent key, got ok=%v err=%v", ok, err)
+	}
+	if ok, _ := rc.CompareAndSet(ctx, "k", nil, []byte("b"), time.Minute); ok {
+		t.Error("Expected create to fail on existing key")
+	}
+	if ok, _ := rc.CompareAndSet(ctx, "k", []byte("stale"), []byte("b"), time.Minute); ok {
+		t.Error("Expected swap to fail on mismatched value")
+	}
+	if ok, err := rc.CompareAndSet(ctx, "k", []byte("a"), []byte("b"), time.Minute); err != nil || !ok {
+		t.Fatalf("Expected swap on matching value, got ok=%v err=%v", ok, err)
+	}
+	if data, _, _ := rc.Get(ctx, "k"); string(data) != "b" {
+		t.Errorf("Expected b, got %q", data)
+	}
+}
+
+func TestService_Set_ConditionalRedis(t *testing.T) {
+	server := newFakeRedisServer(t)
+	ctx := context.Background()
+
+	// Two instances sharing one Redis
+	instances := make([]*Service, 2)
+	for i := range instances {
+		rc := newTestRedisCache(server.Addr())
+		defer rc.Close()
+		instances[i], _, _ = setupTestService()
+		instances[i].SetL2Cache(rc)
+	}
+	a, b := instances[0], instances[1]
+
+	first, err := a.Set(ctx, "key", &SetRequest{Value: mustJSON(t, "v1")})
+	if err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+	if resp, err := b.Get(ctx, "key"); err != nil || resp.Version != first.Version {
+		t.Fatalf("Expected b to read version %d from L2, got %+v (err %v)", first.Version, resp, err)
+	}
+
+	// a updates; b's L1 still holds the old version, but the check runs against L2
+	if _, err := a.Set(ctx, "key", &SetRequest{Value: mustJSON(t, "v2"), IfVersion: first.Version}); err != nil {
+		t.Fatalf("Conditional Set on a failed: %v", err)
+	}
+	if _, err := b.Set(ctx, "key", &SetRequest{Value: mustJSON(t, "lost"), IfVersion: first.Version}); !errors.Is(err, ErrVersionConflict) {
+		t.Errorf("Expected conflict on b, got %v", err)
+	}
+
+	// Concurrent creators across both instances: exactly one wins
+	var wg sync.WaitGroup
+	var wins atomic.Int64
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, err := instances[i%2].Set(ctx, "lock", &SetRequest{Value: mustJSON(t, i), IfAbsent: true})
+			if err == nil {
+				wins.Add(1)
+			} else if !errors.Is(err, ErrVersionConflict) {
+				t.Errorf("Unexpected error: %v", err)
+			}
+		}(i)
+	}
+	wg.Wait()
+	if wins.Load() != 1 {
+		t.Errorf("Expected exactly one if_absent winner, got %d", wins.Load())
+	}
+}
+
+func TestService_Incr_Redis(t *testing.T) {
+	server := newFakeRedisServer(t)
+	ctx := context.Background()
+
+	instances := make([]*Service, 2)
+	for i := range instances {
+		rc := newTestRedisCache(server.Addr())
+		defer rc.Close()
+		instances[i], _, _ = setupTestService()
+		instances[i].SetL2Cache(rc)
+	}
+
+	// Increments from both instances are each applied exactly once
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	seen := make(map[int64]bool)
+	for i := 0; i < 40; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			resp, err := instances[i%2].Incr(ctx, "rate:user:1", &IncrRequest{TTL: 60})
+			if err != nil {
+				t.Errorf("Incr failed: %v", err)
+				return
+			}
+			mu.Lock()
+			seen[resp.Value] = true
+			mu.Unlock()
+		}(i)
+	}
+	wg.Wait()
+	if len(seen) != 40 || !seen[1] || !seen[40] {
+		t.Errorf("Expected each of 1..40 returned once, got %d distinct values", len(seen))
+	}
+
+	resp, err := instances[1].Incr(ctx, "rate:user:1", &IncrRequest{Delta: 10, TTL: 3600})
+	if err != nil || resp.Value != 50 {
+		t.Fatalf("Expected 50, got %+v (err %v)", resp, err)
+	}
+	if until := time.Until(resp.ExpiresAt); until > time.Minute {
+		t.Errorf("Expected the 60s window to be kept, expires in %v", until)
+	}
+
+	// Increments are applied by one script each: nothing was retried, and the stored
+	// entry is read back like any other.
+	for _, svc := range instances {
+		if m, _ := svc.GetMetrics(ctx); m.IncrRetries != 0 {
+			t.Errorf("Expected no compare-and-set retries, got %d", m.IncrRetries)
+		}
+	}
+	rc := newTestRedisCache(server.Addr())
+	defer rc.Close()
+	reader, _, _ := setupTestService()
+	reader.SetL2Cache(rc)
+	if got, err := reader.Get(ctx, "rate:user:1"); err != nil || got.Source != "l2" || string(got.Value) != "50" || got.Version != resp.Version {
+		t.Errorf("Expected counter 50 at version %d from L2, got %+v (err %v)", resp.Version, got, err)
+	}
+
+	// Touch restores the window the counter was created with.
+	time.Sleep(50 * time.Millisecond)
+	if touched, err := instances[0].Touch(ctx, "rate:user:1"); err != nil || time.Until(*touched.ExpiresAt) < time.Minute-25*time.Millisecond {
+		t.Errorf("Expected Touch to restore the 60s window, got %+v (err %v)", touched, err)
+	}
+
+	// The script decodes the entry, whatever its field order and escaping.
+	expiresAt, _ := json.Marshal(time.Now().Add(time.Minute))
+	payload := `{"tags":["a\"b,\"value\":1"],"version":7,"expires_at":` + string(expiresAt) + `,"source":"set","value":41}`
+	rc.Set(ctx, "reordered", []byte(payload), time.Minute)
+	evals := server.Calls("EVAL")
+	if resp, err := instances[1].Incr(ctx, "reordered", &IncrRequest{}); err != nil || resp.Value != 42 || resp.Version <= 7 {
+		t.Errorf("Expected 42 above version 7, got %+v (err %v)", resp, err)
+	}
+	if server.Calls("EVAL") != evals+1 {
+		t.Errorf("Expected the script alone, got %d EVALs", server.Calls("EVAL")-evals)
+	}
+	rc.Set(ctx, "fraction", []byte(`{"expires_at":`+string(expiresAt)+`,"value":1.5}`), time.Minute)
+	if _, err := instances[1].Incr(ctx, "fraction", &IncrRequest{}); !errors.Is(err, ErrNotCounter) {
+		t.Errorf("Expected ErrNotCounter for a fraction, got %v", err)
+	}
+
+	// Non-integers are rejected; values beyond the script's range fall back to
+	// compare-and-set.
+	instances[0].Set(ctx, "name", &SetRequest{Value: mustJSON(t, "alice")})
+	if _, err := instances[1].Incr(ctx, "name", &IncrRequest{}); !errors.Is(err, ErrNotCounter) {
+		t.Errorf("Expected ErrNotCounter, got %v", err)
+	}
+	instances[0].Set(ctx, "big", &SetRequest{Value: mustJSON(t, int64(math.MaxInt64-1))})
+	evals = server.Calls("EVAL")
+	if resp, err := instances[1].Incr(ctx, "big", &IncrRequest{}); err != nil || resp.Value != math.MaxInt64 {
+		t.Errorf("Expected MaxInt64, got %+v (err %v)", resp, err)
+	}
+	if server.Calls("EVAL") != evals+2 {
+		t.Errorf("Expected the script and one compare-and-set, got %d EVALs", server.Calls("EVAL")-evals)
+	}
+}
+
+func TestService_Expiry_Redis(t *testing.T) {
+	server := newFakeRedisServer(t)
+	ctx := context.Background()
+
+	instances := make([]*Service, 3)
+	for i := range instances {
+		rc := newTestRedisCache(server.Addr())
+		defer rc.Close()
+		instances[i], _, _ = setupTestService()
+		instances[i].SetL2Cache(rc)
+	}
+	a, b, c := instances[0], instances[1], instances[2]
+
+	a.Set(ctx, "k", &SetRequest{Value: mustJSON(t, "v"), TTL: 60})
+	if _, err := b.Persist(ctx, "k"); err != nil {
+		t.Fatalf("Persist failed: %v", err)
+	}
+	if resp, err := c.Get(ctx, "k"); err != nil || resp.Source != "l2" || resp.ExpiresAt != nil {
+		t.Errorf("Expected c to load a persistent copy from L2, got %+v (err %v)", resp, err)
+	}
+
+	if _, err := a.Expire(ctx, "k", &ExpireRequest{TTL: 30}); err != nil {
+		t.Fatalf("Expire failed: %v", err)
+	}
+	c.l1Cache.Delete("k")
+	resp, err := c.Get(ctx, "k")
+	if err != nil || resp.ExpiresAt == nil || time.Until(*resp.ExpiresAt) > 30*time.Second {
+		t.Errorf("Expected a 30s expiry from L2, got %+v (err %v)", resp, err)
+	}
+	if _, err := a.Persist(ctx, "missing"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound, got %v", err)
+	}
+}
+
+func TestService_Lease_Redis(t *testing.T) {
+	server := newFakeRedisServer(t)
+	ctx := context.Background()
+
+	instances := make([]*Service, 2)
+	for i := range instances {
+		rc := newTestRedisCache(server.Addr())
+		defer rc.Close()
+		instances[i], _, _ = setupTestService()
+		instances[i].SetL2Cache(rc)
+	}
+	a, b := instances[0], instances[1]
+
+	// Owners racing on both instances: exactly one gets the lease
+	var wg sync.WaitGroup
+	var winners atomic.Int64
+	var held *Lease
+	var mu sync.Mutex
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			lease, err := instances[i%2].AcquireLease(ctx, "job", &AcquireLeaseRequest{Owner: fmt.Sprintf("owner-%d", i), TTL: 30})
+			if err == nil {
+				winners.Add(1)
+				mu.Lock()
+				held = lease
+				mu.Unlock()
+			} else if !errors.Is(err, ErrLeaseHeld) && !errors.Is(err, ErrVersionConflict) {
+				t.Errorf("Unexpected acquire error: %v", err)
+			}
+		}(i)
+	}
+	wg.Wait()
+	if winners.Load() != 1 {
+		t.Fatalf("Expected exactly one holder, got %d", winners.Load())
+	}
+
+	for _, s := range instances {
+		if got, err := s.GetLease(ctx, "job"); err != nil || got.Owner != held.Owner || got.Token != held.Token {
+			t.Errorf("Expected every instance to see %+v, got %+v (err %v)", held, got, err)
+		}
+	}
+
+	if err := b.ReleaseLease(ctx, "job", &ReleaseLeaseRequest{Owner: held.Owner, Token: held.Token}); err != nil {
+		t.Fatalf("Release failed: %v", err)
+	}
+	next, err := a.AcquireLease(ctx, "job", &AcquireLeaseRequest{Owner: "late", TTL: 30})
+	if err != nil || next.Token <= held.Token {
+		t.Errorf("Expected a token above %d after release, got %+v (err %v)", held.Token, next, err)
+	}
+	// Lease records stay out of the L1 key space
+	if _, ok := a.l1Cache.Get(leaseKeyPrefix + "job"); ok {
+		t.Error("Expected leases not to be cached in L1")
+	}
+
+	// The entry API cannot reach lease records
+	if _, err := a.Set(ctx, leaseKeyPrefix+"job", &SetRequest{Value: mustJSON(t, "forged")}); !errors.Is(err, ErrReservedKey) {
+		t.Errorf("Expected ErrReservedKey from Set, got %v", err)
+	}
+	if _, err := a.Get(ctx, leaseKeyPrefix+"job"); !errors.Is(err, ErrReservedKey) {
+		t.Errorf("Expected ErrReservedKey from Get, got %v", err)
+	}
+	if _, err := a.Invalidate(ctx, &InvalidateRequest{Keys: []string{leaseKeyPrefix + "job"}}); !errors.Is(err, ErrReservedKey) {
+		t.Errorf("Expected ErrReservedKey from Invalidate, got %v", err)
+	}
+	if resp, _ := a.MGet(ctx, &MGetRequest{Keys: []string{leaseKeyPrefix + "job"}}); resp.Results[0].Error == "" {
+		t.Error("Expected an error for a reserved key in MGet")
+	}
+	if _, err := a.Invalidate(ctx, &InvalidateRequest{Pattern: "*"}); err != nil {
+		t.Fatalf("Invalidate failed: %v", err)
+	}
+	if got, err := b.GetLease(ctx, "job"); err != nil || got.Token != next.Token {
+		t.Errorf("Expected the lease to survive a \"*\" invalidation, got %+v (err %v)", got, err)
+	}
+}
+
+func TestService_InvalidateTags_RedisL2(t *testing.T) {
+	server := newFakeRedisServer(t)
+	ctx := context.Background()
+
+	instances := make([]*Service, 2)
+	for i := range instances {
+		rc := newTestRedisCache(server.Addr())
+		defer rc.Close()
+		instances[i], _, _ = setupTestService()
+		instances[i].SetL2Cache(rc)
+	}
+	a, b := instances[0], instances[1]
+
+	a.Set(ctx, "page:1", &SetRequest{Value: mustJSON(t, "p1"), Tags: []string{"product:42"}})
+	a.MSet(ctx, &MSetRequest{Entries: []SetRequest{
+		{Key: "search:x", Value: mustJSON(t, "s"), Tags: []string{"product:42"}},
+		{Key: "page:2", Value: mustJSON(t, "p2"), Tags: []string{"product:7"}},
+	}})
+
+	// b learns page:1 (and its tags) from L2
+	if resp, err := b.Get(ctx, "page:1"); err != nil || resp.Source != "l2" {
+		t.Fatalf("Expected L2 hit on b, got %+v (err %v)", resp, err)
+	}
+	if entry, _ := b.l1Cache.Get("page:1"); len(entry.Tags) != 1 || entry.Tags[0] != "product:42" {
+		t.Errorf("Expected tags restored from L2, got %v", entry.Tags)
+	}
+
+	if _, err := a.Invalidate(ctx, &InvalidateRequest{Tags: []string{"product:42"}}); err != nil {
+		t.Fatalf("Invalidate failed: %v", err)
+	}
+
+	// L2 no longer holds the tagged keys; the untagged one remains
+	if keys := strings.Join(server.Keys(), ","); keys != "cache:page:2" {
+		t.Errorf("Expected only cache:page:2 left in L2, got %s", keys)
+	}
+
+	// What the pub/sub event does on b
+	b.l1Cache.DeleteTag("product:42")
+	if _, err := b.Get(ctx, "search:x"); err == nil {
+		t.Error("Expected search:x to be gone from every level")
+	}
+}
+
+func TestRedisCache_DeletePattern(t *testing.T) {
+	server := newFakeRedisServer(t)
+	rc := newTestRedisCache(server.Addr())
+	defer rc.Close()
+	ctx := context.Background()
+
+	// More keys than ScanCount to exercise cursor iteration
+	for i := 0; i < 10; i++ {
+		rc.Set(ctx, fmt.Sprintf("user:%d", i), []byte("u"), time.Hour)
+	}
+	rc.Set(ctx, "product:1", []byte("p"), time.Hour)
+
+	if err := rc.DeletePattern(ctx, "user:*"); err != nil {
+		t.Fatalf("DeletePattern failed: %v", err)
+	}
+
+	if keys := server.Keys(); len(keys) != 1 || keys[0] != "cache:product:1" {
+		t.Errorf("Expected only product:1 to remain, got %v", keys)
+	}
+
+	// Glob metacharacters other than the trailing "*" are literal.
+	for _, key := range []string{"q?[1]:a", "qx1:a", "q?[1]", "q?1:a"} {
+		rc.Set(ctx, key, []byte("q"), time.Hour)
+	}
+	if err := rc.DeletePattern(ctx, "q?[1]:*"); err != nil {
+		t.Fatalf("DeletePattern failed: %v", err)
+	}
+	if err := rc.DeletePattern(ctx, "q?1:a"); err != nil {
+		t.Fatalf("DeletePattern failed: %v", err)
+	}
+	if keys := server.Keys(); strings.Join(keys, ",") != "cache:product:1,cache:q?[1],cache:qx1:a" {
+		t.Errorf("Expected only the literal matches deleted, got %v", keys)
+	}
+}
+
+func TestRedisCache_Auth(t *testing.T) {
+	server := newFakeRedisServerWithPassword(t, "secret")
+
+	bad := newTestRedisCache(server.Addr())
+	defer bad.Close()
+	if err := bad.Ping(context.Background()); err == nil {
+		t.Error("Expected auth failure without password")
+	}
+
+	cfg := bad.config
+	cfg.Password = "secret"
+	good := NewRedisCache(cfg)
+	defer good.Close()
+	if err := good.Ping(context.Background()); err != nil {
+		t.Errorf("Expected successful ping with password, got %v", err)
+	}
+}
+
+func TestRedisCache_ConnectionPool(t *testing.T) {
+	server := newFakeRedisServer(t)
+	rc := newTestRedisCache(server.Addr())
+	defer rc.Close()
+	ctx := context.Background()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			key := fmt.Sprintf("key%d", i)
+			if err := rc.Set(ctx, key, []byte("v"), time.Hour); err != nil {
+				t.Errorf("Set failed: %v", err)
+			}
+			if _, ok, err := rc.Get(ctx, key); err != nil || !ok {
+				t.Errorf("Get failed: ok=%v err=%v", ok, err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	if n := server.accepted.Load(); n > 4 {
+		t.Errorf("Expected at most PoolSize (4) connections, got %d", n)
+	}
+}
+
+func TestRedisCache_CommandTimeout(t *testing.T) {
+	server := newFakeRedisServer(t)
+	server.stall.Store(true)
+	rc := newTestRedisCache(server.Addr())
+	defer rc.Close()
+
+	start := time.Now()
+	_, _, err := rc.Get(context.Background(), "key1")
+	if err == nil {
+		t.Fatal("Expected timeout error from stalled server")
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("Expected command timeout near 500ms, took %v", elapsed)
+	}
+
+	// Broken connection must not be reused
+	server.stall.Store(false)
+	if err := rc.Ping(context.Background()); err != nil {
+		t.Errorf("Expected fresh connection after timeout, got %v", err)
+	}
+}
+
+func TestService_RedisL2(t *testing.T) {
+	server := newFakeRedisServer(t)
+	rc := newTestRedisCache(server.Addr())
+	defer rc.Close()
+
+	svc, _, _ := setupTestService()
+	svc.SetL2Cache(rc)
+
+	_, err := svc.Set(context.Background(), "key1", &SetRequest{Key: "key1", Value: mustJSON(t, "value1")})
+	if err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+
+	// Simulate a second instance sharing the same L2
+	svc.l1Cache.Clear()
+
+	resp, err := svc.Get(context.Background(), "key1")
+	if err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	if resp.Source != "l2" || mustJSONString(t, resp.Value) != "value1" {
+		t.Errorf("Expected L2 hit with value1, got %+v", resp)
+	}
 }
@@ -0,0 +1,314 @@
+package cachemanager
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Snapshot file layout:
+//
+//	magic "CMSNAP" | version uint16 | body | CRC-32C(body) uint32
+//
+// The body is a stream of JSON values: a snapshotHeader followed by one
+// snapshotEntry per cached key, in eviction order (next victim first) per shard.
+//
+// Design Notes:
+//   - The checksum covers the whole body and is verified before anything is
+//     restored, so a truncated or corrupt file leaves L1 empty instead of half loaded.
+//   - The file is written to a temporary name, synced and renamed, so a crash while
+//     writing never replaces the previous snapshot with a partial one.
+//   - TTLs are stored as durations remaining at snapshot time; the time spent down is
+//     subtracted on restore and entries that expired meanwhile are dropped.
+//   - Values are stored as held in L1 (possibly compressed, see Codec).
+//   - Cached origin failures are not snapshotted; they are transient by design.
+const (
+	snapshotMagic   = "CMSNAP"
+	snapshotVersion = 1
+)
+
+var snapshotCRCTable = crc32.MakeTable(crc32.Castagnoli)
+
+var (
+	errSnapshotCorrupt  = errors.New("snapshot corrupt")
+	errSnapshotDisabled = errors.New("snapshot path not configured")
+)
+
+type snapshotHeader struct {
+	CreatedAt  time.Time         `json:"created_at"`
+	Entries    int               `json:"entries"`
+	Namespaces []NamespaceConfig `json:"namespaces,omitempty"` // Restored before entries so keys route to them
+}
+
+type snapshotEntry struct {
+	Key       string        `json:"key"`
+	Value     []byte        `json:"value,omitempty"` // As held in L1
+	TTL       time.Duration `json:"ttl"`             // Remaining at CreatedAt
+	StaleTTL  time.Duration `json:"stale_ttl,omitempty"`
+	Delta     time.Duration `json:"delta,omitempty"`
+	Version   uint64        `json:"version,omitempty"`
+	Tags      []string      `json:"tags,omitempty"`
+	Tombstone bool          `json:"tombstone,omitempty"`
+	BaseTTL   time.Duration `json:"base_ttl,omitempty"`
+	Sliding   bool          `json:"sliding,omitempty"`
+}
+
+type SnapshotResponse struct {
+	Path      string    `json:"path"`
+	Entries   int       `json:"entries"`
+	Bytes     int64     `json:"bytes"` // File size
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Snapshot writes L1 to Config.SnapshotPath now, e.g. before a planned restart.
+// The snapshot is also written by Shutdown and loaded at startup.
+// Complexity: O(n) for n L1 entries; each shard is locked only while it is copied.
+//
+//encore:api public method=POST path=/api/cache/snapshot
+func Snapshot(ctx context.Context) (*SnapshotResponse, error) {
+	if svc == nil {
+		return nil, errors.New("service not initialized")
+	}
+	return svc.Snapshot(ctx)
+}
+
+func (s *Service) Snapshot(ctx context.Context) (*SnapshotResponse, error) {
+	if s.config.SnapshotPath == "" {
+		return nil, errSnapshotDisabled
+	}
+	resp, err := s.writeSnapshot(s.config.SnapshotPath)
+	if err != nil {
+		s.metrics.SnapshotErrors.Add(1)
+		return nil, err
+	}
+	return resp, nil
+}
+
+// snapshot copies every live L1 entry, shard by shard, in eviction order.
+func (c *L1Cache) snapshot(now time.Time) []snapshotEntry {
+	var entries []snapshotEntry
+	for _, s := range c.shards {
+		s.mu.RLock()
+		var keys []string
+		if ordered, ok := s.policy.(OrderedPolicy); ok {
+			keys = ordered.Keys()
+		} else {
+			keys = make([]string, 0, len(s.cache))
+			for key := range s.cache {
+				keys = append(keys, key)
+			}
+		}
+		for _, key := range keys {
+			entry, ok := s.cache[key]
+			if !ok || entry.originError != "" || !entry.expiresAt.After(now) {
+				continue
+			}
+			entries = append(entries, snapshotEntry{
+				Key:       key,
+				Value:     entry.value,
+				TTL:       entry.expiresAt.Sub(now),
+				StaleTTL:  entry.staleUntil.Sub(entry.expiresAt),
+				Delta:     entry.delta,
+				Version:   entry.version,
+				Tags:      entry.tags,
+				Tombstone: entry.tombstone,
+				BaseTTL:   entry.baseTTL,
+				Sliding:   entry.sliding,
+			})
+		}
+		s.mu.RUnlock()
+	}
+	return entries
+}
+
+// writeSnapshot writes the default and namespace L1 caches to path.
+func (s *Service) writeSnapshot(path string) (*SnapshotResponse, error) {
+	s.snapshotMu.Lock()
+	defer s.snapshotMu.Unlock()
+
+	now := time.Now()
+	header := snapshotHeader{CreatedAt: now}
+	var entries []snapshotEntry
+	s.rangeNamespaces(func(ns *namespace) {
+		header.Namespaces = append(header.Namespaces, ns.info().NamespaceConfig)
+	})
+	for _, l1 := range s.allL1() {
+		entries = append(entries, l1.snapshot(now)...)
+	}
+	header.Entries = len(entries)
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, fmt.Errorf("create snapshot directory: %w", err)
+	}
+	tmp := path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return nil, fmt.Errorf("create snapshot: %w", err)
+	}
+	defer os.Remove(tmp) // no-op after a successful rename
+
+	size, err := encodeSnapshot(f, header, entries)
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return nil, fmt.Errorf("write snapshot: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return nil, fmt.Errorf("write snapshot: %w", err)
+	}
+
+	return &SnapshotResponse{
+		Path:      path,
+		Entries:   len(entries),
+		Bytes:     size,
+		CreatedAt: now,
+	}, nil
+}
+
+// encodeSnapshot writes the framed snapshot to w and returns the bytes written.
+func encodeSnapshot(w io.Writer, header snapshotHeader, entries []snapshotEntry) (int64, error) {
+	bw := bufio.NewWriter(w)
+	prefix := make([]byte, len(snapshotMagic)+2)
+	copy(prefix, snapshotMagic)
+	binary.BigEndian.PutUint16(prefix[len(snapshotMagic):], snapshotVersion)
+	if _, err := bw.Write(prefix); err != nil {
+		return 0, err
+	}
+
+	body := &countingWriter{w: bw, crc: crc32.New(snapshotCRCTable)}
+	enc := json.NewEncoder(body)
+	if err := enc.Encode(header); err != nil {
+		return 0, err
+	}
+	for i := range entries {
+		if err := enc.Encode(&entries[i]); err != nil {
+			return 0, err
+		}
+	}
+
+	trailer := make([]byte, 4)
+	binary.BigEndian.PutUint32(trailer, body.crc.Sum32())
+	if _, err := bw.Write(trailer); err != nil {
+		return 0, err
+	}
+	if err := bw.Flush(); err != nil {
+		return 0, err
+	}
+	return int64(len(prefix)) + body.n + int64(len(trailer)), nil
+}
+
+// decodeSnapshot verifies and parses a snapshot file's contents.
+func decodeSnapshot(data []byte) (snapshotHeader, []snapshotEntry, error) {
+	var header snapshotHeader
+	prefixLen := len(snapshotMagic) + 2
+	if len(data) < prefixLen+4 || string(data[:len(snapshotMagic)]) != snapshotMagic {
+		return header, nil, fmt.Errorf("%w: bad magic", errSnapshotCorrupt)
+	}
+	if v := binary.BigEndian.Uint16(data[len(snapshotMagic):]); v != snapshotVersion {
+		return header, nil, fmt.Errorf("unsupported snapshot version %d", v)
+	}
+
+	body := data[prefixLen : len(data)-4]
+	sum := binary.BigEndian.Uint32(data[len(data)-4:])
+	if crc32.Checksum(body, snapshotCRCTable) != sum {
+		return header, nil, fmt.Errorf("%w: checksum mismatch", errSnapshotCorrupt)
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(body))
+	if err := dec.Decode(&header); err != nil {
+		return header, nil, fmt.Errorf("%w: %v", errSnapshotCorrupt, err)
+	}
+	entries := make([]snapshotEntry, 0, header.Entries)
+	for dec.More() {
+		var entry snapshotEntry
+		if err := dec.Decode(&entry); err != nil {
+			return header, nil, fmt.Errorf("%w: %v", errSnapshotCorrupt, err)
+		}
+		entries = append(entries, entry)
+	}
+	if len(entries) != header.Entries {
+		return header, nil, fmt.Errorf("%w: expected %d entries, found %d", errSnapshotCorrupt, header.Entries, len(entries))
+	}
+	return header, entries, nil
+}
+
+// loadSnapshot restores L1 from path. A missing file is not an error; a corrupt or
+// unsupported one is rejected as a whole. Returns the number of entries restored.
+func (s *Service) loadSnapshot(path string) (int, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("read snapshot: %w", err)
+	}
+
+	header, entries, err := decodeSnapshot(data)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, cfg := range header.Namespaces {
+		if validateNamespaceConfig(cfg) == nil {
+			_, _ = s.applyNamespace(cfg)
+		}
+	}
+
+	// Entries are replayed in eviction order, so the most recently used keys are
+	// inserted last and end up most recently used again.
+	elapsed := time.Since(header.CreatedAt)
+	if elapsed < 0 {
+		elapsed = 0
+	}
+	restored := 0
+	for _, entry := range entries {
+		ttl := entry.TTL
+		if ttl != NoExpiry {
+			ttl -= elapsed
+		}
+		if ttl <= 0 {
+			continue
+		}
+		s.l1For(entry.Key).SetWithOptions(entry.Key, entry.Value, ttl, EntryOptions{
+			StaleTTL:  entry.StaleTTL,
+			Delta:     entry.Delta,
+			Version:   entry.Version,
+			Tags:      entry.Tags,
+			Tombstone: entry.Tombstone,
+			BaseTTL:   entry.BaseTTL,
+			Sliding:   entry.Sliding,
+			Source:    "snapshot",
+		})
+		restored++
+	}
+	s.metrics.SnapshotRestored.Add(int64(restored))
+	return restored, nil
+}
+
+// countingWriter feeds a running checksum and counts bytes written through it.
+type countingWriter struct {
+	w   io.Writer
+	crc hash.Hash32
+	n   int64
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	n, err := w.w.Write(p)
+	w.crc.Write(p[:n])
+	w.n += int64(n)
+	return n, err
+}
@@ -8,6 +8,7 @@ import (
 	"encore.dev/pubsub"
 
 	"encore.app/invalidation"
+	"encore.app/monitoring"
 )
 
 // RefreshEvent represents a cache refresh command broadcast to all instances.
@@ -27,6 +28,37 @@ var CacheRefreshTopic = pubsub.NewTopic[*RefreshEvent](
 	},
 )
 
+// NamespaceEvent carries a namespace's configuration after a create or update.
+type NamespaceEvent struct {
+	Config NamespaceConfig `json:"config"`
+}
+
+// NamespaceTopic broadcasts namespace changes so every instance enforces the same quotas.
+var NamespaceTopic = pubsub.NewTopic[*NamespaceEvent](
+	"cache-namespace",
+	pubsub.TopicConfig{
+		DeliveryGuarantee: pubsub.AtLeastOnce,
+	},
+)
+
+// Subscribe to hot-key announcements so every instance replicates hot keys.
+var _ = pubsub.NewSubscription(
+	monitoring.HotKeyTopic,
+	"cache-manager-hotkeys",
+	pubsub.SubscriptionConfig[*monitoring.HotKeyEvent]{
+		Handler: HandleHotKeyEvent,
+	},
+)
+
+// HandleHotKeyEvent applies a hot-key promotion or demotion from any instance.
+func HandleHotKeyEvent(ctx context.Context, event *monitoring.HotKeyEvent) error {
+	if svc == nil || svc.hotKeys == nil {
+		return nil
+	}
+	svc.applyHotKey(ctx, event)
+	return nil
+}
+
 // Subscribe to cache invalidation events from other instances.
 // This ensures eventual consistency across all cache-manager instances.
 var _ = pubsub.NewSubscription(
@@ -46,19 +78,56 @@ func HandleInvalidateEvent(ctx context.Context, event *invalidation.Invalidation
 
 	// Invalidate specific keys (preferred)
 	for _, key := range event.MatchedKeys {
-		svc.l1Cache.Delete(key)
+		svc.l1For(key).Delete(key)
 		svc.metrics.Deletes.Add(1)
 	}
 
 	// Invalidate by pattern (fallback)
 	if event.Pattern != "" {
-		deleted := svc.l1Cache.DeletePattern(event.Pattern)
-		svc.metrics.Deletes.Add(int64(deleted))
+		for _, l1 := range svc.allL1() {
+			deleted := l1.DeletePattern(event.Pattern)
+			svc.metrics.Deletes.Add(int64(deleted))
+		}
+	}
+
+	// Invalidate by tag. Events from the cache manager already cleared L2; for
+	// other sources (e.g. POST /invalidate/tag) every instance clears it too, which
+	// is idempotent and ensures no instance refills L1 from a stale L2 entry.
+	for _, tag := range event.Tags {
+		for _, l1 := range svc.allL1() {
+			deleted := l1.DeleteTag(tag)
+			svc.metrics.Deletes.Add(int64(deleted))
+		}
+		if event.TriggeredBy != "cache_manager" {
+			svc.deleteTagL2(ctx, tag)
+		}
 	}
 
 	return nil
 }
 
+// Subscribe to namespace changes made on any instance.
+var _ = pubsub.NewSubscription(
+	NamespaceTopic,
+	"cache-manager-namespace",
+	pubsub.SubscriptionConfig[*NamespaceEvent]{
+		Handler: HandleNamespaceEvent,
+	},
+)
+
+// HandleNamespaceEvent creates or updates a namespace from another instance.
+// Redelivered and out-of-order events are ignored (see applyNamespace).
+func HandleNamespaceEvent(ctx context.Context, event *NamespaceEvent) error {
+	if svc == nil {
+		return nil
+	}
+	if err := validateNamespaceConfig(event.Config); err != nil {
+		return err
+	}
+	_, err := svc.applyNamespace(event.Config)
+	return err
+}
+
 // Subscribe to cache refresh events from warming service.
 var _ = pubsub.NewSubscription(
 	CacheRefreshTopic,
@@ -77,21 +146,27 @@ func HandleRefreshEvent(ctx context.Context, event *RefreshEvent) error {
 
 	ttl := time.Duration(event.TTL) * time.Second
 	if ttl == 0 {
-		ttl = svc.config.DefaultTTL
+		ttl = svc.defaultTTLFor(event.Key)
 	}
 
-	svc.l1Cache.Set(event.Key, event.Value, ttl)
-
-	if svc.config.L2Enabled && svc.l2Cache != nil {
-		go func() {
-			entry := CacheEntry{
-				Value:     event.Value,
-				CachedAt:  time.Now(),
-				ExpiresAt: time.Now().Add(ttl),
-			}
-			// Serialize and store (implementation depends on L2 provider)
-			_ = entry // TODO: implement L2 storage
-		}()
+	version := svc.l1For(event.Key).SetWithOptions(event.Key, svc.compress(event.Value), ttl, EntryOptions{StaleTTL: svc.config.StaleTTL, Source: "refresh"})
+
+	if svc.writesL2() {
+		entry := CacheEntry{
+			Value:     event.Value,
+			CachedAt:  time.Now(),
+			ExpiresAt: time.Now().Add(ttl),
+			StaleTTL:  svc.config.StaleTTL,
+			Version:   version,
+			BaseTTL:   ttl,
+		}
+		data, err := svc.encodeL2(&entry)
+		if err != nil {
+			return err
+		}
+		if svc.l2Breaker.Allow() {
+			svc.recordL2(svc.l2Cache.Set(ctx, event.Key, data, ttl))
+		}
 	}
 
 	return nil
@@ -0,0 +1,329 @@
+package cachemanager
+
+import (
+	"container/list"
+	"time"
+)
+
+// TinyLFUPolicy implements W-TinyLFU (Einziger et al.), the policy used by Caffeine.
+//
+// Layout:
+//   - Window LRU (~1% of capacity): admits every new key so recency bursts are absorbed.
+//   - Main SLRU (~99%): split into probation (20%) and protected (80%) segments.
+//     Keys enter probation from the window and are promoted to protected on a hit.
+//   - Count-min sketch: approximate access frequency for every key ever seen,
+//     including keys no longer cached.
+//
+// When the window overflows, its LRU key competes with the main segment's victim and
+// the one with the higher estimated frequency stays. A one-off scan therefore churns
+// through the window without displacing the frequently used working set, which is
+// where plain LRU collapses.
+//
+// Design Notes:
+//   - Counters are 4-bit (saturating at 15) and halved every 10×capacity increments,
+//     so the sketch favors recent popularity and costs ~capacity bytes per row.
+//   - Ties go to the incumbent, which protects the main segment from churn.
+//   - Not safe for concurrent use; L1Cache calls it under the shard lock.
+type TinyLFUPolicy struct {
+	sketch *countMinSketch
+	items  map[string]*tinyLFUItem
+
+	window    *list.List
+	probation *list.List
+	protected *list.List
+
+	windowCap    int
+	mainCap      int
+	protectedCap int
+}
+
+type tinyLFUSegment uint8
+
+const (
+	segmentWindow tinyLFUSegment = iota
+	segmentProbation
+	segmentProtected
+)
+
+type tinyLFUItem struct {
+	elem    *list.Element // Value is the key
+	segment tinyLFUSegment
+}
+
+// NewTinyLFUPolicy creates a W-TinyLFU policy sized for capacity entries.
+func NewTinyLFUPolicy(capacity int) *TinyLFUPolicy {
+	if capacity < 1 {
+		capacity = 1
+	}
+	windowCap := capacity / 100
+	if windowCap < 1 {
+		windowCap = 1
+	}
+	mainCap := capacity - windowCap
+	if mainCap < 0 {
+		mainCap = 0
+	}
+
+	return &TinyLFUPolicy{
+		sketch:       newCountMinSketch(capacity),
+		items:        make(map[string]*tinyLFUItem, capacity),
+		window:       list.New(),
+		probation:    list.New(),
+		protected:    list.New(),
+		windowCap:    windowCap,
+		mainCap:      mainCap,
+		protectedCap: mainCap * 8 / 10,
+	}
+}
+
+func (p *TinyLFUPolicy) ShouldEvict(entry *CacheEntry, now time.Time) bool {
+	// Capacity eviction goes through Victim
+	return false
+}
+
+// OnAccess records the access in the sketch and updates the key's segment:
+// window keys move to front, probation keys are promoted to protected, protected
+// keys move to front.
+// Complexity: O(1).
+func (p *TinyLFUPolicy) OnAccess(key string) {
+	p.sketch.increment(key)
+
+	item, ok := p.items[key]
+	if !ok {
+		return
+	}
+
+	switch item.segment {
+	case segmentWindow:
+		p.window.MoveToFront(item.elem)
+	case segmentProbation:
+		p.probation.Remove(item.elem)
+		item.elem = p.protected.PushFront(key)
+		item.segment = segmentProtected
+		p.demoteProtectedOverflow()
+	case segmentProtected:
+		p.protected.MoveToFront(item.elem)
+	}
+}
+
+// OnSet admits new keys into the window; updates count as an access.
+func (p *TinyLFUPolicy) OnSet(key string, value interface{}, ttl time.Duration) {
+	if _, ok := p.items[key]; ok {
+		p.OnAccess(key)
+		return
+	}
+
+	p.sketch.increment(key)
+	p.items[key] = &tinyLFUItem{
+		elem:    p.window.PushFront(key),
+		segment: segmentWindow,
+	}
+	p.drainWindow()
+}
+
+// OnRemove stops tracking key. Its sketch frequency is retained so a quickly
+// re-requested key is still recognized as popular.
+func (p *TinyLFUPolicy) OnRemove(key string) {
+	item, ok := p.items[key]
+	if !ok {
+		return
+	}
+	p.listFor(item.segment).Remove(item.elem)
+	delete(p.items, key)
+}
+
+// Victim selects the next key to evict.
+//
+// Window overflow first flows into main while main has room. If the window is still
+// over its share, its LRU candidate duels the main victim on estimated frequency and
+// the loser is evicted (the winner, if it was the candidate, moves into probation).
+// Otherwise, e.g. when a byte budget forces eviction below the entry limit, the
+// probation, protected and window tails are tried in that order.
+// Complexity: O(1) amortized.
+func (p *TinyLFUPolicy) Victim() (string, bool) {
+	p.drainWindow()
+
+	if p.window.Len() > p.windowCap {
+		candidate := p.window.Back()
+		victim := p.mainVictim()
+		if victim == nil {
+			return p.evict(candidate), true
+		}
+
+		candidateKey := candidate.Value.(string)
+		victimKey := victim.Value.(string)
+		if p.sketch.estimate(candidateKey) > p.sketch.estimate(victimKey) {
+			evicted := p.evict(victim)
+			p.moveToProbation(candidate)
+			return evicted, true
+		}
+		return p.evict(candidate), true
+	}
+
+	if victim := p.mainVictim(); victim != nil {
+		return p.evict(victim), true
+	}
+	if candidate := p.window.Back(); candidate != nil {
+		return p.evict(candidate), true
+	}
+	return "", false
+}
+
+// Keys returns probation, then protected, then window keys, each least recently
+// used first. Replaying them through OnSet keeps recency but not segment membership
+// or sketch frequencies, which rebuild as the restored keys are accessed.
+func (p *TinyLFUPolicy) Keys() []string {
+	keys := make([]string, 0, len(p.items))
+	for _, l := range []*list.List{p.probation, p.protected, p.window} {
+		for elem := l.Back(); elem != nil; elem = elem.Prev() {
+			keys = append(keys, elem.Value.(string))
+		}
+	}
+	return keys
+}
+
+// drainWindow moves window overflow into probation while main has room, so the
+// main segment fills up before any admission duel takes place.
+func (p *TinyLFUPolicy) drainWindow() {
+	for p.window.Len() > p.windowCap && p.mainLen() < p.mainCap {
+		p.moveToProbation(p.window.Back())
+	}
+}
+
+// mainVictim returns the LRU element of probation, falling back to protected.
+func (p *TinyLFUPolicy) mainVictim() *list.Element {
+	if elem := p.probation.Back(); elem != nil {
+		return elem
+	}
+	return p.protected.Back()
+}
+
+func (p *TinyLFUPolicy) mainLen() int {
+	return p.probation.Len() + p.protected.Len()
+}
+
+// moveToProbation moves a window element to the front of probation.
+func (p *TinyLFUPolicy) moveToProbation(elem *list.Element) {
+	key := elem.Value.(string)
+	item := p.items[key]
+	p.window.Remove(elem)
+	item.elem = p.probation.PushFront(key)
+	item.segment = segmentProbation
+}
+
+// demoteProtectedOverflow moves protected LRU keys back to probation while
+// protected exceeds its share.
+func (p *TinyLFUPolicy) demoteProtectedOverflow() {
+	for p.protected.Len() > p.protectedCap {
+		elem := p.protected.Back()
+		if elem == nil {
+			return
+		}
+		key := elem.Value.(string)
+		item := p.items[key]
+		p.protected.Remove(elem)
+		item.elem = p.probation.PushFront(key)
+		item.segment = segmentProbation
+	}
+}
+
+// evict removes elem from its segment and stops tracking its key.
+func (p *TinyLFUPolicy) evict(elem *list.Element) string {
+	key := elem.Value.(string)
+	p.OnRemove(key)
+	return key
+}
+
+func (p *TinyLFUPolicy) listFor(segment tinyLFUSegment) *list.List {
+	switch segment {
+	case segmentProbation:
+		return p.probation
+	case segmentProtected:
+		return p.protected
+	default:
+		return p.window
+	}
+}
+
+const (
+	cmsDepth      = 4
+	cmsMaxCounter = 15
+)
+
+// countMinSketch estimates key frequencies in fixed memory.
+// Each key maps to one saturating counter per row; the estimate is the row minimum,
+// which may over-count on collisions but never under-counts (until aging).
+// Complexity: O(depth) per operation.
+type countMinSketch struct {
+	rows       [cmsDepth][]uint8
+	mask       uint64
+	additions  int
+	sampleSize int // additions before all counters are halved
+}
+
+func newCountMinSketch(capacity int) *countMinSketch {
+	width := 16
+	for width < capacity {
+		width <<= 1
+	}
+
+	s := &countMinSketch{
+		mask:       uint64(width - 1),
+		sampleSize: 10 * capacity,
+	}
+	for i := range s.rows {
+		s.rows[i] = make([]uint8, width)
+	}
+	return s
+}
+
+// indexes derives one counter index per row from a single 64-bit FNV-1a hash
+// using double hashing (h1 + i*h2).
+func (s *countMinSketch) indexes(key string) [cmsDepth]uint64 {
+	h := uint64(14695981039346656037)
+	for i := 0; i < len(key); i++ {
+		h ^= uint64(key[i])
+		h *= 1099511628211
+	}
+	h1 := h
+	h2 := (h >> 32) | 1
+
+	var idx [cmsDepth]uint64
+	for i := range idx {
+		idx[i] = (h1 + uint64(i)*h2) & s.mask
+	}
+	return idx
+}
+
+func (s *countMinSketch) increment(key string) {
+	for row, i := range s.indexes(key) {
+		if s.rows[row][i] < cmsMaxCounter {
+			s.rows[row][i]++
+		}
+	}
+
+	s.additions++
+	if s.additions >= s.sampleSize {
+		s.reset()
+	}
+}
+
+func (s *countMinSketch) estimate(key string) uint8 {
+	min := uint8(cmsMaxCounter)
+	for row, i := range s.indexes(key) {
+		if c := s.rows[row][i]; c < min {
+			min = c
+		}
+	}
+	return min
+}
+
+// reset halves every counter so old popularity decays.
+func (s *countMinSketch) reset() {
+	for row := range s.rows {
+		for i := range s.rows[row] {
+			s.rows[row][i] >>= 1
+		}
+	}
+	s.additions /= 2
+}
@@ -0,0 +1,242 @@
+package cachemanager
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"encore.dev/beta/errs"
+)
+
+type ExpireRequest struct {
+	TTL int `json:"ttl"` // seconds from now, must be positive
+}
+
+type ExpiryResponse struct {
+	TTL       int        `json:"ttl"`                  // Seconds until expiry, rounded up; -1 if the entry never expires
+	ExpiresAt *time.Time `json:"expires_at,omitempty"` // Unset if the entry never expires
+	Sliding   bool       `json:"sliding,omitempty"`
+}
+
+// Expire sets key to expire ttl seconds from now, without rewriting its value. The new
+// TTL is also the one later touches and sliding hits restore.
+//
+//encore:api public method=POST path=/api/cache/entry/:key/expire
+func Expire(ctx context.Context, key string, req *ExpireRequest) (*ExpiryResponse, error) {
+	if svc == nil {
+		return nil, errors.New("service not initialized")
+	}
+	return expiryResult(svc.Expire(ctx, key, req))
+}
+
+// Touch restarts key's TTL from now, using the TTL it was written with. Entries that
+// never expire are left unchanged.
+//
+//encore:api public method=POST path=/api/cache/entry/:key/touch
+func Touch(ctx context.Context, key string) (*ExpiryResponse, error) {
+	if svc == nil {
+		return nil, errors.New("service not initialized")
+	}
+	return expiryResult(svc.Touch(ctx, key))
+}
+
+// Persist removes key's expiry (and sliding expiration); it stays cached until it is
+// invalidated, overwritten or evicted for capacity.
+//
+//encore:api public method=POST path=/api/cache/entry/:key/persist
+func Persist(ctx context.Context, key string) (*ExpiryResponse, error) {
+	if svc == nil {
+		return nil, errors.New("service not initialized")
+	}
+	return expiryResult(svc.Persist(ctx, key))
+}
+
+func expiryResult(resp *ExpiryResponse, err error) (*ExpiryResponse, error) {
+	switch {
+	case errors.Is(err, ErrNotFound):
+		return nil, errs.WrapCode(err, errs.NotFound, "key not found")
+	case errors.Is(err, ErrVersionConflict):
+		return nil, errs.WrapCode(err, errs.Aborted, "concurrent update, retry")
+	case errors.Is(err, ErrCircuitOpen):
+		return nil, errs.WrapCode(err, errs.Unavailable, "l2 unavailable")
+	}
+	return resp, err
+}
+
+func (s *Service) Expire(ctx context.Context, key string, req *ExpireRequest) (*ExpiryResponse, error) {
+	u, err := req.update()
+	if err != nil {
+		return nil, err
+	}
+	return s.updateExpiry(ctx, key, u)
+}
+
+func (s *Service) Touch(ctx context.Context, key string) (*ExpiryResponse, error) {
+	return s.updateExpiry(ctx, key, expiryUpdate{touch: true})
+}
+
+func (s *Service) Persist(ctx context.Context, key string) (*ExpiryResponse, error) {
+	return s.updateExpiry(ctx, key, expiryUpdate{ttl: NoExpiry})
+}
+
+// expiryUpdate is an Expire to ttl (NoExpiry to persist) or, with touch set, a Touch.
+type expiryUpdate struct {
+	ttl   time.Duration
+	touch bool
+}
+
+// update returns the expiry update r asks for.
+func (r *ExpireRequest) update() (expiryUpdate, error) {
+	if r.TTL <= 0 {
+		return expiryUpdate{}, errors.New("ttl must be positive; use persist to remove the expiry")
+	}
+	return expiryUpdate{ttl: time.Duration(r.TTL) * time.Second}, nil
+}
+
+// forward applies u on peer.
+func (u expiryUpdate) forward(ctx context.Context, peer Peer, key string) (*ExpiryResponse, error) {
+	switch {
+	case u.touch:
+		return peer.Touch(ctx, key)
+	case u.ttl == NoExpiry:
+		return peer.Persist(ctx, key)
+	}
+	return peer.Expire(ctx, key, &ExpireRequest{TTL: int(u.ttl / time.Second)})
+}
+
+// apply returns an entry's expiry, base TTL and sliding flag after the update.
+func (u expiryUpdate) apply(now, expiresAt time.Time, baseTTL time.Duration, sliding bool) (time.Time, time.Duration, bool) {
+	ttl := u.ttl
+	if u.touch {
+		if persistent(expiresAt) || baseTTL <= 0 {
+			return expiresAt, baseTTL, sliding
+		}
+		ttl = baseTTL
+	}
+	if ttl == NoExpiry {
+		return noExpiry, 0, false
+	}
+	return now.Add(ttl), ttl, sliding
+}
+
+// updateExpiry applies u on the key's owner, or on this instance when the owner
+// cannot be reached.
+func (s *Service) updateExpiry(ctx context.Context, key string, u expiryUpdate) (*ExpiryResponse, error) {
+	if peer := s.ownerPeer(key); peer != nil {
+		if resp, err := u.forward(ctx, peer, key); s.forwarded(err) {
+			if s.hotKeys.isHot(key) {
+				s.l1For(key).Delete(key) // Drop the pinned copy, which has the old expiry
+			}
+			return resp, err
+		}
+	}
+	return s.updateExpiryLocal(ctx, key, u)
+}
+
+// updateExpiryLocal applies u to key's entry in L1 and L2 on this instance, ignoring
+// ownership.
+//
+// Design Notes:
+//   - Only the expiry changes: the value and version are kept, and clients send no
+//     payload. The L2 copy is still rewritten as a whole, since its ExpiresAt is what
+//     other instances check when they load it.
+//   - With a ConditionalRemoteCache the L2 entry is updated with compare-and-set, so
+//     a concurrent Set is never overwritten with the old value. Otherwise the update
+//     is applied to L1 (loading the key from L2 first if needed) and written back.
+//   - Other instances keep the expiry their L1 copy had until they reload it.
+func (s *Service) updateExpiryLocal(ctx context.Context, key string, u expiryUpdate) (*ExpiryResponse, error) {
+	if err := validateKey(key); err != nil {
+		return nil, err
+	}
+
+	if cas, ok := s.l2Cache.(ConditionalRemoteCache); ok && s.writesL2() {
+		entry, err := s.casL2(ctx, cas, key, nil, func(current *CacheEntry, now time.Time) (*CacheEntry, error) {
+			if current == nil {
+				return nil, ErrNotFound
+			}
+			current.ExpiresAt, current.BaseTTL, current.Sliding = u.apply(now, current.ExpiresAt, current.BaseTTL, current.Sliding)
+			return current, nil
+		})
+		if err == nil {
+			s.l1For(key).SetWithOptions(key, s.compress(entry.Value), l1TTL(entry.ExpiresAt), EntryOptions{
+				StaleTTL: entry.StaleTTL,
+				Delta:    entry.Delta,
+				Version:  entry.Version,
+				Tags:     entry.Tags,
+				BaseTTL:  entry.BaseTTL,
+				Sliding:  entry.Sliding,
+				Source:   "l2",
+			})
+			return expiryResponse(entry), nil
+		}
+		// A key missing from L2 may still be held in L1, e.g. after a failed L2 write.
+		if !errors.Is(err, ErrNotFound) {
+			return nil, fmt.Errorf("update expiry: %w", err)
+		}
+	}
+
+	l1 := s.l1For(key)
+	entry, ok := l1.updateExpiry(key, u)
+	if !ok && s.l2Allowed() {
+		data, found, err := s.l2Cache.Get(ctx, key)
+		s.recordL2(err)
+		if err == nil && found {
+			if _, loaded := s.entryFromL2(key, data, time.Now()); loaded {
+				entry, ok = l1.updateExpiry(key, u)
+			}
+		}
+	}
+	if !ok {
+		return nil, fmt.Errorf("update expiry: %w", ErrNotFound)
+	}
+
+	if s.writesL2() {
+		value, err := s.decompress(entry.Value)
+		if err != nil {
+			return nil, fmt.Errorf("update expiry: %w", err)
+		}
+		entry.Value = value
+		s.putL2(ctx, key, entry)
+	}
+	return expiryResponse(entry), nil
+}
+
+// l1TTL is the TTL to store an entry expiring at expiresAt with.
+func l1TTL(expiresAt time.Time) time.Duration {
+	if persistent(expiresAt) {
+		return NoExpiry
+	}
+	return time.Until(expiresAt)
+}
+
+func expiryResponse(entry *CacheEntry) *ExpiryResponse {
+	resp := &ExpiryResponse{TTL: -1, Sliding: entry.Sliding}
+	if !persistent(entry.ExpiresAt) {
+		expiresAt := entry.ExpiresAt
+		resp.ExpiresAt = &expiresAt
+		resp.TTL = int((time.Until(expiresAt) + time.Second - 1) / time.Second)
+	}
+	return resp
+}
+
+// updateExpiry applies u to key if it holds a live value (not expired, a tombstone
+// or a cached error). Returns the updated entry.
+// Complexity: O(1).
+func (c *L1Cache) updateExpiry(key string, u expiryUpdate) (*CacheEntry, bool) {
+	s := c.shardFor(key)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	entry, ok := s.cache[key]
+	if !ok || !entry.expiresAt.After(now) || entry.tombstone || entry.originError != "" {
+		return nil, false
+	}
+	var expiresAt time.Time
+	expiresAt, entry.baseTTL, entry.sliding = u.apply(now, entry.expiresAt, entry.baseTTL, entry.sliding)
+	entry.setExpiry(expiresAt)
+	entry.l2Expiry = expiresAt
+	s.scheduleUnsafe(entry)
+	return entry.cacheEntry(), true
+}
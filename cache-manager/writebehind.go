@@ -0,0 +1,296 @@
+package cachemanager
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Write modes accepted by Config.WriteMode.
+const (
+	WriteModeThrough = "write-through" // Set/MSet write L2 before returning (default)
+	WriteModeBehind  = "write-behind"  // Set/MSet return after L1; L2 writes are queued and batched
+	WriteModeL1Only  = "l1-only"       // Writes stay in this instance's L1; L2 is still read and invalidated
+)
+
+// WriteBehindConfig tunes the queue that carries background L2 writes: every write in
+// write-behind mode, and origin fills in write-through mode.
+type WriteBehindConfig struct {
+	QueueSize     int           // Max queued keys; new keys beyond it are dropped (default 10000)
+	BatchSize     int           // Max entries per L2 round trip (default 100)
+	FlushInterval time.Duration // How often the queue is flushed (default 50ms)
+	MaxRetries    int           // Retries per entry after a failed write before it is dropped (default 3, -1 = none)
+}
+
+func (c WriteBehindConfig) withDefaults() WriteBehindConfig {
+	if c.QueueSize <= 0 {
+		c.QueueSize = 10000
+	}
+	if c.BatchSize <= 0 {
+		c.BatchSize = 100
+	}
+	if c.FlushInterval <= 0 {
+		c.FlushInterval = 50 * time.Millisecond
+	}
+	if c.MaxRetries < 0 {
+		c.MaxRetries = 0
+	} else if c.MaxRetries == 0 {
+		c.MaxRetries = 3
+	}
+	return c
+}
+
+func validateWriteMode(mode string) error {
+	switch mode {
+	case "", WriteModeThrough, WriteModeBehind, WriteModeL1Only:
+		return nil
+	default:
+		return fmt.Errorf("unknown write mode %q", mode)
+	}
+}
+
+// writesL2 reports whether writes are propagated to L2 under the write mode.
+func (s *Service) writesL2() bool {
+	return s.config.L2Enabled && s.l2Cache != nil && s.config.WriteMode != WriteModeL1Only
+}
+
+type writeBehindItem struct {
+	key      string
+	data     []byte // Encoded L2 payload
+	ttl      time.Duration
+	tags     []string
+	attempts int // Failed writes so far
+}
+
+// writeBehindQueue is a bounded FIFO of pending L2 writes.
+//
+// Design Notes:
+//   - Writes to a key already queued replace its payload in place, so a hot key costs
+//     one slot and one L2 write per flush however often it changes.
+//   - When full, writes for new keys are dropped rather than blocking callers; L1
+//     already holds the value, so a drop only delays L2 until the next write or fill.
+//   - A mutex and slice instead of a channel: coalescing needs the key index anyway,
+//     and retries must not jump ahead of or displace newer writes.
+type writeBehindQueue struct {
+	mu       sync.Mutex
+	items    []*writeBehindItem
+	index    map[string]*writeBehindItem
+	capacity int
+}
+
+func newWriteBehindQueue(capacity int) *writeBehindQueue {
+	return &writeBehindQueue{
+		index:    make(map[string]*writeBehindItem),
+		capacity: capacity,
+	}
+}
+
+// push queues item, coalescing with a queued write for the same key. A retry never
+// overwrites a queued write, which is newer. Returns false if the queue is full.
+func (q *writeBehindQueue) push(item *writeBehindItem) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if queued, ok := q.index[item.key]; ok {
+		if item.attempts == 0 {
+			queued.data, queued.ttl, queued.tags, queued.attempts = item.data, item.ttl, item.tags, 0
+		}
+		return true
+	}
+	if len(q.items) >= q.capacity {
+		return false
+	}
+	q.items = append(q.items, item)
+	q.index[item.key] = item
+	return true
+}
+
+// take removes and returns up to n items from the head of the queue.
+func (q *writeBehindQueue) take(n int) []*writeBehindItem {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if n > len(q.items) {
+		n = len(q.items)
+	}
+	batch := make([]*writeBehindItem, n)
+	copy(batch, q.items[:n])
+	for _, item := range batch {
+		delete(q.index, item.key)
+	}
+	// Shift rather than reslice so the backing array does not grow without bound.
+	remaining := copy(q.items, q.items[n:])
+	for i := remaining; i < len(q.items); i++ {
+		q.items[i] = nil
+	}
+	q.items = q.items[:remaining]
+	return batch
+}
+
+func (q *writeBehindQueue) len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.items)
+}
+
+// storeL2 writes entry to L2 in the background via the write-behind queue.
+// Without a queue (services built outside initService) the write is synchronous.
+func (s *Service) storeL2(key string, entry *CacheEntry, ttl time.Duration) {
+	if !s.writesL2() {
+		return
+	}
+	data, err := s.encodeL2(entry)
+	if err != nil {
+		return
+	}
+	s.enqueueL2(&writeBehindItem{key: key, data: data, ttl: ttl, tags: entry.Tags})
+}
+
+// putL2 rewrites key's L2 entry as a Set would: queued in write-behind mode, otherwise
+// synchronously unless the L2 breaker is open. Failures are counted, not returned,
+// since L1 already holds the entry.
+func (s *Service) putL2(ctx context.Context, key string, entry *CacheEntry) {
+	if !s.writesL2() {
+		return
+	}
+	ttl := l2TTL(entry.ExpiresAt, time.Now())
+	if s.config.WriteMode == WriteModeBehind {
+		s.storeL2(key, entry, ttl)
+		return
+	}
+	data, err := s.encodeL2(entry)
+	if err != nil || !s.l2Breaker.Allow() {
+		return
+	}
+	s.recordL2(s.l2Cache.Set(ctx, key, data, ttl))
+}
+
+func (s *Service) enqueueL2(item *writeBehindItem) {
+	if s.writeQueue == nil {
+		if s.l2Breaker.Allow() {
+			s.writeL2Batch(context.Background(), []*writeBehindItem{item})
+		}
+		return
+	}
+	if !s.writeQueue.push(item) {
+		s.metrics.WriteBehindDrops.Add(1)
+	}
+}
+
+// runWriteBehind flushes the queue every FlushInterval until stopChan closes.
+// Shutdown drains what is left after this returns.
+func (s *Service) runWriteBehind() {
+	defer s.wg.Done()
+	ticker := time.NewTicker(s.config.WriteBehind.withDefaults().FlushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stopChan:
+			return
+		case <-ticker.C:
+			s.flushWriteBehind(context.Background(), false)
+		}
+	}
+}
+
+// flushWriteBehind writes the entries queued when it starts, in batches. Failed
+// entries are requeued until MaxRetries, so during an L2 outage each flush makes one
+// attempt per entry and the queue (not goroutines) absorbs the backlog. With final
+// set, as on Shutdown, failed entries are dropped instead.
+func (s *Service) flushWriteBehind(ctx context.Context, final bool) {
+	q := s.writeQueue
+	if q == nil {
+		return
+	}
+	cfg := s.config.WriteBehind.withDefaults()
+
+	for pending := q.len(); pending > 0; {
+		n := cfg.BatchSize
+		if n > pending {
+			n = pending
+		}
+		// While the L2 breaker is open, entries wait in the queue (new keys are
+		// dropped once it fills) instead of using up their retries.
+		if !s.l2Breaker.Allow() {
+			if final {
+				s.metrics.WriteBehindDrops.Add(int64(len(q.take(pending))))
+			}
+			return
+		}
+		batch := q.take(n)
+		if len(batch) == 0 {
+			// Drained concurrently; no L2 call was made.
+			s.l2Breaker.Release()
+			return
+		}
+		pending -= len(batch)
+
+		start := time.Now()
+		failed := s.writeL2Batch(ctx, batch)
+		s.metrics.WriteBehindFlushes.Add(1)
+		s.metrics.WriteBehindFlushNanos.Add(int64(time.Since(start)))
+		s.metrics.WriteBehindFlushed.Add(int64(len(batch) - len(failed)))
+
+		for _, item := range failed {
+			item.attempts++
+			if final || item.attempts > cfg.MaxRetries || !q.push(item) {
+				s.metrics.WriteBehindDrops.Add(1)
+				continue
+			}
+			s.metrics.WriteBehindRetries.Add(1)
+		}
+	}
+}
+
+// writeL2Batch writes items to L2, in one round trip when supported, then indexes
+// their tags. The caller has checked the L2 breaker once for the whole batch, so the
+// writes record one outcome; tagging checks it again. Returns the items not written.
+func (s *Service) writeL2Batch(ctx context.Context, items []*writeBehindItem) []*writeBehindItem {
+	if batch, ok := s.l2Cache.(BatchRemoteCache); ok {
+		remote := make([]RemoteCacheItem, len(items))
+		for i, item := range items {
+			remote[i] = RemoteCacheItem{Key: item.key, Value: item.data, TTL: item.ttl}
+		}
+		if err := batch.SetMulti(ctx, remote); err != nil {
+			s.recordL2(err)
+			return items
+		}
+		s.recordL2(nil)
+		for _, item := range items {
+			s.tagL2(ctx, item.key, item.tags)
+		}
+		return nil
+	}
+
+	var failed, written []*writeBehindItem
+	for _, item := range items {
+		if err := s.l2Cache.Set(ctx, item.key, item.data, item.ttl); err != nil {
+			failed = append(failed, item)
+			continue
+		}
+		written = append(written, item)
+	}
+	s.recordL2Batch(len(failed))
+	for _, item := range written {
+		s.tagL2(ctx, item.key, item.tags)
+	}
+	return failed
+}
+
+// writeBehindLatencyMs is the mean time per flushed batch, in milliseconds.
+func (s *Service) writeBehindLatencyMs() float64 {
+	flushes := s.metrics.WriteBehindFlushes.Load()
+	if flushes == 0 {
+		return 0
+	}
+	return float64(s.metrics.WriteBehindFlushNanos.Load()) / float64(flushes) / float64(time.Millisecond)
+}
+
+func (s *Service) writeBehindDepth() int {
+	if s.writeQueue == nil {
+		return 0
+	}
+	return s.writeQueue.len()
+}
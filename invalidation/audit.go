@@ -13,12 +13,13 @@ import (
 // AuditLog represents an invalidation event for audit trail and compliance.
 type AuditLog struct {
 	ID          int64     `json:"id"`
-	Pattern     string    `json:"pattern"`      // Pattern or key(s) invalidated
-	Keys        []string  `json:"keys"`         // Actual keys invalidated (if known)
-	TriggeredBy string    `json:"triggered_by"` // Source: cache_manager, admin, warming
-	Timestamp   time.Time `json:"timestamp"`    // When invalidation occurred
-	RequestID   string    `json:"request_id"`   // Correlation ID for tracing
-	Latency     int64     `json:"latency"`      // Invalidation latency in milliseconds
+	Pattern     string    `json:"pattern"`        // Pattern or key(s) invalidated
+	Keys        []string  `json:"keys"`           // Actual keys invalidated (if known)
+	Tags        []string  `json:"tags,omitempty"` // Tags invalidated (tag invalidations only)
+	TriggeredBy string    `json:"triggered_by"`   // Source: cache_manager, admin, warming
+	Timestamp   time.Time `json:"timestamp"`      // When invalidation occurred
+	RequestID   string    `json:"request_id"`     // Correlation ID for tracing
+	Latency     int64     `json:"latency"`        // Invalidation latency in milliseconds
 }
 
 // AuditLogger provides persistent storage of invalidation events.
@@ -58,6 +59,8 @@ func (al *AuditLogger) ensureSchema(ctx context.Context) error {
 			created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
 		);
 
+		ALTER TABLE invalidation_audit ADD COLUMN IF NOT EXISTS tags JSONB;
+
 		CREATE INDEX IF NOT EXISTS idx_invalidation_audit_timestamp 
 		ON invalidation_audit(timestamp DESC);
 
@@ -85,17 +88,24 @@ func (al *AuditLogger) Insert(ctx context.Context, log AuditLog) error {
 	if err != nil {
 		return fmt.Errorf("failed to marshal keys: %w", err)
 	}
+	var tagsJSON []byte
+	if len(log.Tags) > 0 {
+		if tagsJSON, err = json.Marshal(log.Tags); err != nil {
+			return fmt.Errorf("failed to marshal tags: %w", err)
+		}
+	}
 
 	query := `
 		INSERT INTO invalidation_audit 
-		(pattern, keys, triggered_by, timestamp, request_id, latency_ms)
-		VALUES ($1, $2, $3, $4, $5, $6)
+		(pattern, keys, tags, triggered_by, timestamp, request_id, latency_ms)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
 		ON CONFLICT (request_id) DO NOTHING
 	`
 
 	_, err = al.db.Exec(ctx, query,
 		log.Pattern,
 		keysJSON,
+		tagsJSON,
 		log.TriggeredBy,
 		log.Timestamp,
 		log.RequestID,
@@ -117,7 +127,7 @@ func (al *AuditLogger) GetRecent(ctx context.Context, limit, offset int, pattern
 
 	if patternFilter != "" {
 		query = `
-			SELECT id, pattern, keys, triggered_by, timestamp, request_id, latency_ms
+			SELECT id, pattern, keys, tags, triggered_by, timestamp, request_id, latency_ms
 			FROM invalidation_audit
 			WHERE pattern LIKE $1
 			ORDER BY timestamp DESC
@@ -126,7 +136,7 @@ func (al *AuditLogger) GetRecent(ctx context.Context, limit, offset int, pattern
 		args = []interface{}{"%" + patternFilter + "%", limit, offset}
 	} else {
 		query = `
-			SELECT id, pattern, keys, triggered_by, timestamp, request_id, latency_ms
+			SELECT id, pattern, keys, tags, triggered_by, timestamp, request_id, latency_ms
 			FROM invalidation_audit
 			ORDER BY timestamp DESC
 			LIMIT $1 OFFSET $2
@@ -143,12 +153,13 @@ func (al *AuditLogger) GetRecent(ctx context.Context, limit, offset int, pattern
 	logs := make([]AuditLog, 0, limit)
 	for rows.Next() {
 		var log AuditLog
-		var keysJSON []byte
+		var keysJSON, tagsJSON []byte
 
 		err := rows.Scan(
 			&log.ID,
 			&log.Pattern,
 			&keysJSON,
+			&tagsJSON,
 			&log.TriggeredBy,
 			&log.Timestamp,
 			&log.RequestID,
@@ -164,6 +175,9 @@ func (al *AuditLogger) GetRecent(ctx context.Context, limit, offset int, pattern
 				log.Keys = []string{} // Fallback to empty on error
 			}
 		}
+		if len(tagsJSON) > 0 {
+			_ = json.Unmarshal(tagsJSON, &log.Tags)
+		}
 
 		logs = append(logs, log)
 	}
@@ -199,7 +213,7 @@ func (al *AuditLogger) GetCount(ctx context.Context, patternFilter string) (int,
 // GetByRequestID retrieves audit logs by request ID for tracing.
 func (al *AuditLogger) GetByRequestID(ctx context.Context, requestID string) ([]AuditLog, error) {
 	query := `
-		SELECT id, pattern, keys, triggered_by, timestamp, request_id, latency_ms
+		SELECT id, pattern, keys, tags, triggered_by, timestamp, request_id, latency_ms
 		FROM invalidation_audit
 		WHERE request_id = $1
 		ORDER BY timestamp DESC
@@ -214,12 +228,13 @@ func (al *AuditLogger) GetByRequestID(ctx context.Context, requestID string) ([]
 	logs := make([]AuditLog, 0)
 	for rows.Next() {
 		var log AuditLog
-		var keysJSON []byte
+		var keysJSON, tagsJSON []byte
 
 		err := rows.Scan(
 			&log.ID,
 			&log.Pattern,
 			&keysJSON,
+			&tagsJSON,
 			&log.TriggeredBy,
 			&log.Timestamp,
 			&log.RequestID,
@@ -235,6 +250,9 @@ func (al *AuditLogger) GetByRequestID(ctx context.Context, requestID string) ([]
 				log.Keys = []string{}
 			}
 		}
+		if len(tagsJSON) > 0 {
+			_ = json.Unmarshal(tagsJSON, &log.Tags)
+		}
 
 		logs = append(logs, log)
 	}
@@ -249,7 +267,7 @@ func (al *AuditLogger) GetByRequestID(ctx context.Context, requestID string) ([]
 // GetByTimeRange retrieves audit logs within a time range.
 func (al *AuditLogger) GetByTimeRange(ctx context.Context, start, end time.Time, limit int) ([]AuditLog, error) {
 	query := `
-		SELECT id, pattern, keys, triggered_by, timestamp, request_id, latency_ms
+		SELECT id, pattern, keys, tags, triggered_by, timestamp, request_id, latency_ms
 		FROM invalidation_audit
 		WHERE timestamp BETWEEN $1 AND $2
 		ORDER BY timestamp DESC
@@ -265,12 +283,13 @@ func (al *AuditLogger) GetByTimeRange(ctx context.Context, start, end time.Time,
 	logs := make([]AuditLog, 0, limit)
 	for rows.Next() {
 		var log AuditLog
-		var keysJSON []byte
+		var keysJSON, tagsJSON []byte
 
 		err := rows.Scan(
 			&log.ID,
 			&log.Pattern,
 			&keysJSON,
+			&tagsJSON,
 			&log.TriggeredBy,
 			&log.Timestamp,
 			&log.RequestID,
@@ -286,6 +305,9 @@ func (al *AuditLogger) GetByTimeRange(ctx context.Context, start, end time.Time,
 				log.Keys = []string{}
 			}
 		}
+		if len(tagsJSON) > 0 {
+			_ = json.Unmarshal(tagsJSON, &log.Tags)
+		}
 
 		logs = append(logs, log)
 	}
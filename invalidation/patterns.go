@@ -119,19 +119,10 @@ func (pm *PatternMatcher) matchWildcard(pattern string, keys []string) []string
 // matchRegex performs regex matching with caching.
 // Complexity: O(1) cache lookup + O(n*m) matching where n = keys, m = regex complexity
 func (pm *PatternMatcher) matchRegex(pattern string, keys []string) []string {
-	// Try to get cached regex
-	var re *regexp.Regexp
-	if cached, ok := pm.regexCache.Load(pattern); ok {
-		re = cached.(*regexp.Regexp)
-	} else {
-		// Compile and cache
-		var err error
-		re, err = regexp.Compile(pattern)
-		if err != nil {
-			// Invalid regex, return no matches
-			return []string{}
-		}
-		pm.regexCache.Store(pattern, re)
+	re := pm.regex(pattern)
+	if re == nil {
+		// Invalid regex, return no matches
+		return []string{}
 	}
 
 	// Match against all keys
@@ -145,6 +136,54 @@ func (pm *PatternMatcher) matchRegex(pattern string, keys []string) []string {
 	return matches
 }
 
+// regex returns the compiled pattern from the cache, compiling it on first use.
+// Returns nil for an invalid regex.
+func (pm *PatternMatcher) regex(pattern string) *regexp.Regexp {
+	if cached, ok := pm.regexCache.Load(pattern); ok {
+		return cached.(*regexp.Regexp)
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil
+	}
+	pm.regexCache.Store(pattern, re)
+	return re
+}
+
+// Compile returns a predicate that reports whether a single key matches pattern, with
+// the same semantics as Match. The pattern is classified (and any regex compiled)
+// once, so callers can filter keys as they iterate, e.g. while scanning a cache,
+// without first collecting them into a slice.
+// Complexity: O(k) per key for exact and wildcard patterns, k = key length
+func (pm *PatternMatcher) Compile(pattern string) func(key string) bool {
+	switch {
+	case pattern == "":
+		return func(string) bool { return false }
+	case !IsWildcard(pattern) && !IsRegex(pattern):
+		return func(key string) bool { return key == pattern }
+	case pattern == "*":
+		return func(string) bool { return true }
+	case IsWildcard(pattern) && strings.HasPrefix(pattern, "*") && strings.HasSuffix(pattern, "*"):
+		substring := strings.Trim(pattern, "*")
+		return func(key string) bool { return strings.Contains(key, substring) }
+	case IsWildcard(pattern) && strings.HasPrefix(pattern, "*"):
+		suffix := strings.TrimPrefix(pattern, "*")
+		return func(key string) bool { return strings.HasSuffix(key, suffix) }
+	case IsWildcard(pattern) && strings.HasSuffix(pattern, "*"):
+		prefix := strings.TrimSuffix(pattern, "*")
+		return func(key string) bool { return strings.HasPrefix(key, prefix) }
+	}
+
+	if IsWildcard(pattern) {
+		pattern = wildcardToRegex(pattern)
+	}
+	re := pm.regex(pattern)
+	if re == nil {
+		return func(string) bool { return false }
+	}
+	return re.MatchString
+}
+
 // wildcardToRegex converts a wildcard pattern to a regex pattern.
 // Example: "user:*:profile" -> "^user:.*:profile$"
 func wildcardToRegex(pattern string) string {
@@ -51,6 +51,7 @@ type Metrics struct {
 	TotalInvalidations   atomic.Int64
 	KeyInvalidations     atomic.Int64
 	PatternInvalidations atomic.Int64
+	TagInvalidations     atomic.Int64
 	AuditWrites          atomic.Int64
 	PubSubPublishes      atomic.Int64
 	Errors               atomic.Int64
@@ -93,11 +94,12 @@ func init() {
 
 // InvalidationEvent represents a cache invalidation broadcast to all cache instances.
 type InvalidationEvent struct {
-	Pattern     string    `json:"pattern"`      // Pattern or exact key
-	MatchedKeys []string  `json:"matched_keys"` // Keys that matched the pattern
-	TriggeredBy string    `json:"triggered_by"` // Source: "cache_manager", "admin", "warming"
-	Timestamp   time.Time `json:"timestamp"`    // When invalidation was triggered
-	RequestID   string    `json:"request_id"`   // For tracing and correlation
+	Pattern     string    `json:"pattern"`        // Pattern or exact key
+	MatchedKeys []string  `json:"matched_keys"`   // Keys that matched the pattern
+	Tags        []string  `json:"tags,omitempty"` // Surrogate keys; every entry carrying one is dropped
+	TriggeredBy string    `json:"triggered_by"`   // Source: "cache_manager", "admin", "warming"
+	Timestamp   time.Time `json:"timestamp"`      // When invalidation was triggered
+	RequestID   string    `json:"request_id"`     // For tracing and correlation
 }
 
 // Pub/Sub topic for cache invalidation events
@@ -140,6 +142,19 @@ type InvalidatePatternResponse struct {
 	PublishedAt      time.Time `json:"published_at"`
 }
 
+type InvalidateTagRequest struct {
+	Tags        []string `json:"tags"`         // Surrogate keys set on entries via SetRequest.Tags
+	TriggeredBy string   `json:"triggered_by"` // Source identifier
+	RequestID   string   `json:"request_id"`   // Optional correlation ID
+}
+
+type InvalidateTagResponse struct {
+	Success     bool      `json:"success"`
+	Tags        []string  `json:"tags"`
+	RequestID   string    `json:"request_id"`
+	PublishedAt time.Time `json:"published_at"`
+}
+
 type GetAuditLogsRequest struct {
 	Limit   int    `json:"limit"`             // Number of logs to retrieve
 	Offset  int    `json:"offset"`            // Pagination offset
@@ -156,6 +171,7 @@ type MetricsResponse struct {
 	TotalInvalidations       int64   `json:"total_invalidations"`
 	KeyInvalidations         int64   `json:"key_invalidations"`
 	PatternInvalidations     int64   `json:"pattern_invalidations"`
+	TagInvalidations         int64   `json:"tag_invalidations"`
 	AuditWrites              int64   `json:"audit_writes"`
 	PubSubPublishes          int64   `json:"pubsub_publishes"`
 	Errors                   int64   `json:"errors"`
@@ -325,6 +341,88 @@ func (s *Service) InvalidatePattern(ctx context.Context, req *InvalidatePatternR
 	}, nil
 }
 
+// InvalidateTag invalidates every cache entry carrying any of the given tags and
+// broadcasts the event. Tags group keys that share no common prefix (e.g. all keys
+// rendered from one product); each cache instance resolves them from its tag index.
+//
+// Complexity: O(t) where t = number of tags; each instance then does O(m) for m tagged entries
+//
+//encore:api public method=POST path=/invalidate/tag
+func InvalidateTag(ctx context.Context, req *InvalidateTagRequest) (*InvalidateTagResponse, error) {
+	if svc == nil {
+		return nil, errors.New("service not initialized")
+	}
+	return svc.InvalidateTag(ctx, req)
+}
+
+func (s *Service) InvalidateTag(ctx context.Context, req *InvalidateTagRequest) (*InvalidateTagResponse, error) {
+	startTime := time.Now()
+
+	// Validation
+	if len(req.Tags) == 0 {
+		return nil, errors.New("tags cannot be empty")
+	}
+	for _, tag := range req.Tags {
+		if tag == "" {
+			return nil, errors.New("tags cannot contain an empty tag")
+		}
+	}
+	if req.TriggeredBy == "" {
+		req.TriggeredBy = "unknown"
+	}
+	if req.RequestID == "" {
+		req.RequestID = generateRequestID()
+	}
+
+	uniqueTags := deduplicateKeys(req.Tags)
+
+	// Create invalidation event
+	event := &InvalidationEvent{
+		Pattern:     "",
+		MatchedKeys: []string{},
+		Tags:        uniqueTags,
+		TriggeredBy: req.TriggeredBy,
+		Timestamp:   time.Now(),
+		RequestID:   req.RequestID,
+	}
+
+	// Publish to Pub/Sub
+	_, err := CacheInvalidateTopic.Publish(ctx, event)
+	if err != nil {
+		s.metrics.Errors.Add(1)
+		return nil, fmt.Errorf("failed to publish invalidation event: %w", err)
+	}
+	s.metrics.PubSubPublishes.Add(1)
+
+	// Write audit log (async)
+	go func() {
+		auditLog := AuditLog{
+			Pattern:     formatTagsAsPattern(uniqueTags),
+			Tags:        uniqueTags,
+			TriggeredBy: req.TriggeredBy,
+			Timestamp:   event.Timestamp,
+			RequestID:   req.RequestID,
+			Latency:     time.Since(startTime).Milliseconds(),
+		}
+		if err := s.auditLogger.Insert(context.Background(), auditLog); err != nil {
+			s.metrics.Errors.Add(1)
+		} else {
+			s.metrics.AuditWrites.Add(1)
+		}
+	}()
+
+	// Update metrics
+	s.metrics.TotalInvalidations.Add(1)
+	s.metrics.TagInvalidations.Add(1)
+
+	return &InvalidateTagResponse{
+		Success:     true,
+		Tags:        uniqueTags,
+		RequestID:   req.RequestID,
+		PublishedAt: event.Timestamp,
+	}, nil
+}
+
 // GetAuditLogs retrieves invalidation audit history with pagination.
 //
 //encore:api public method=GET path=/audit/logs
@@ -396,6 +494,7 @@ func (s *Service) GetMetrics(ctx context.Context) (*MetricsResponse, error) {
 		TotalInvalidations:       total,
 		KeyInvalidations:         s.metrics.KeyInvalidations.Load(),
 		PatternInvalidations:     pattern,
+		TagInvalidations:         s.metrics.TagInvalidations.Load(),
 		AuditWrites:              s.metrics.AuditWrites.Load(),
 		PubSubPublishes:          s.metrics.PubSubPublishes.Load(),
 		Errors:                   s.metrics.Errors.Load(),
@@ -434,6 +533,16 @@ func formatKeysAsPattern(keys []string) string {
 	return string(data)
 }
 
+// formatTagsAsPattern renders tags for the audit log's pattern column, prefixed with
+// "tag:" so tag invalidations can be told apart from (and filtered like) key patterns.
+func formatTagsAsPattern(tags []string) string {
+	if len(tags) == 1 {
+		return "tag:" + tags[0]
+	}
+	data, _ := json.Marshal(tags)
+	return "tag:" + string(data)
+}
+
 // generateRequestID creates a unique request identifier for tracing.
 func generateRequestID() string {
 	return fmt.Sprintf("inv-%d-%d", time.Now().UnixNano(), time.Now().Nanosecond()%1000)
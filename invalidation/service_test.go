@@ -229,6 +229,33 @@ func TestPatternMatcher_ValidatePattern(t *testing.T) {
 	}
 }
 
+func TestPatternMatcher_Compile(t *testing.T) {
+	pm := NewPatternMatcher()
+	keys := []string{"user:123", "user:456", "user:123:profile", "product:123", "session:abc"}
+
+	patterns := []string{"", "*", "user:123", "user:*", "*:profile", "*:123*", "user:*:profile", "^user:[0-9]+$", "user:["}
+	for _, pattern := range patterns {
+		match := pm.Compile(pattern)
+		var got []string
+		for _, key := range keys {
+			if match(key) {
+				got = append(got, key)
+			}
+		}
+		want := pm.Match(pattern, keys)
+		if len(got) != len(want) {
+			t.Errorf("Pattern %q: Compile matched %v, Match returned %v", pattern, got, want)
+			continue
+		}
+		for i := range got {
+			if got[i] != want[i] {
+				t.Errorf("Pattern %q: Compile matched %v, Match returned %v", pattern, got, want)
+				break
+			}
+		}
+	}
+}
+
 func TestService_InvalidateKey(t *testing.T) {
 	svc := setupTestService()
 	ctx := context.Background()
@@ -353,6 +380,60 @@ func TestService_InvalidatePattern_EmptyPattern(t *testing.T) {
 	}
 }
 
+func TestService_InvalidateTag(t *testing.T) {
+	svc := setupTestService()
+	ctx := context.Background()
+
+	req := &InvalidateTagRequest{
+		Tags:        []string{"product:42", "product:42", "category:shoes"},
+		TriggeredBy: "test",
+		RequestID:   "test-req-tag",
+	}
+
+	resp, err := svc.InvalidateTag(ctx, req)
+	if err != nil {
+		t.Fatalf("InvalidateTag failed: %v", err)
+	}
+
+	if !resp.Success {
+		t.Error("Expected success=true")
+	}
+
+	if len(resp.Tags) != 2 {
+		t.Errorf("Expected 2 unique tags, got %v", resp.Tags)
+	}
+
+	if svc.metrics.TagInvalidations.Load() != 1 {
+		t.Errorf("Expected 1 tag invalidation, got %d", svc.metrics.TagInvalidations.Load())
+	}
+
+	// Audit log is written asynchronously
+	var logs []AuditLog
+	for i := 0; i < 100 && len(logs) == 0; i++ {
+		logs, _ = svc.auditLogger.GetByRequestID(ctx, "test-req-tag")
+		time.Sleep(time.Millisecond)
+	}
+	if len(logs) != 1 {
+		t.Fatalf("Expected 1 audit log, got %d", len(logs))
+	}
+	if len(logs[0].Tags) != 2 || logs[0].Pattern != `tag:["product:42","category:shoes"]` {
+		t.Errorf("Expected tag audit entry, got pattern=%s tags=%v", logs[0].Pattern, logs[0].Tags)
+	}
+}
+
+func TestService_InvalidateTag_EmptyTags(t *testing.T) {
+	svc := setupTestService()
+	ctx := context.Background()
+
+	if _, err := svc.InvalidateTag(ctx, &InvalidateTagRequest{TriggeredBy: "test"}); err == nil {
+		t.Error("Expected error for empty tags")
+	}
+
+	if _, err := svc.InvalidateTag(ctx, &InvalidateTagRequest{Tags: []string{""}}); err == nil {
+		t.Error("Expected error for empty tag")
+	}
+}
+
 func TestService_GetMetrics(t *testing.T) {
 	svc := setupTestService()
 	ctx := context.Background()
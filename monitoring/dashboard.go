@@ -79,12 +79,14 @@ type GetOverviewRequest struct {
 }
 
 type GetOverviewResponse struct {
-	Summary         SummaryStats    `json:"summary"`
-	Timeline        []TimelinePoint `json:"timeline"`
-	TopKeys         []KeyStats      `json:"top_keys"`
-	SystemHealth    SystemHealth    `json:"system_health"`
-	RecentAlerts    []Alert         `json:"recent_alerts"`
-	RecentAnomalies []Anomaly       `json:"recent_anomalies"`
+	Summary         SummaryStats     `json:"summary"`
+	Timeline        []TimelinePoint  `json:"timeline"`
+	TopKeys         []KeyStats       `json:"top_keys"`
+	SystemHealth    SystemHealth     `json:"system_health"`
+	RecentAlerts    []Alert          `json:"recent_alerts"`
+	RecentAnomalies []Anomaly        `json:"recent_anomalies"`
+	HotKeys         []HotKeyEvent    `json:"hot_keys"`            // Keys currently promoted by cache-manager instances
+	Evictions       map[string]int64 `json:"evictions_by_reason"` // L1 evictions since startup per reason (capacity, expired, ...)
 }
 
 type SummaryStats struct {
@@ -281,6 +283,8 @@ func (d *Dashboard) GetOverview(ctx context.Context, req *GetOverviewRequest) (*
 		SystemHealth:    systemHealth,
 		RecentAlerts:    recentAlerts,
 		RecentAnomalies: recentAnomalies,
+		HotKeys:         d.collector.GetHotKeys(),
+		Evictions:       d.collector.GetEvictionsByReason(),
 	}, nil
 }
 
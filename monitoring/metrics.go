@@ -32,6 +32,14 @@ type MetricsCollector struct {
 	// Time-series data for windowed aggregation
 	timeSeries *TimeSeries
 
+	// Keys currently hot on some cache-manager instance, by "instance/key"
+	hotKeysMu sync.Mutex
+	hotKeys   map[string]HotKeyEvent
+
+	// Evictions broken down by their "reason" label
+	evictionsMu       sync.Mutex
+	evictionsByReason map[string]int64
+
 	config Config
 }
 
@@ -40,8 +48,58 @@ func NewMetricsCollector(config Config) *MetricsCollector {
 	return &MetricsCollector{
 		latencyBuffer: NewRingBuffer(10000), // Keep last 10K latency samples
 		timeSeries:    NewTimeSeries(config.MetricsRetention),
+		hotKeys:       make(map[string]HotKeyEvent),
 		config:        config,
+
+		evictionsByReason: make(map[string]int64),
+	}
+}
+
+// RecordHotKey applies a hot-key promotion or demotion.
+// Complexity: O(1).
+func (mc *MetricsCollector) RecordHotKey(event *HotKeyEvent) {
+	id := event.Instance + "/" + event.Key
+	mc.hotKeysMu.Lock()
+	defer mc.hotKeysMu.Unlock()
+
+	if event.Action == HotKeyDemote {
+		delete(mc.hotKeys, id)
+		return
+	}
+	if current, ok := mc.hotKeys[id]; ok && event.Renewal {
+		// Keep the promotion time, refresh the hit count.
+		current.Hits = event.Hits
+		mc.hotKeys[id] = current
+		return
+	}
+	mc.hotKeys[id] = *event
+}
+
+// GetHotKeys returns the keys currently hot, hottest first.
+func (mc *MetricsCollector) GetHotKeys() []HotKeyEvent {
+	mc.hotKeysMu.Lock()
+	keys := make([]HotKeyEvent, 0, len(mc.hotKeys))
+	for _, event := range mc.hotKeys {
+		keys = append(keys, event)
 	}
+	mc.hotKeysMu.Unlock()
+
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].Hits > keys[j].Hits
+	})
+	return keys
+}
+
+// GetEvictionsByReason returns the evictions recorded so far per reason.
+func (mc *MetricsCollector) GetEvictionsByReason() map[string]int64 {
+	mc.evictionsMu.Lock()
+	defer mc.evictionsMu.Unlock()
+
+	counts := make(map[string]int64, len(mc.evictionsByReason))
+	for reason, n := range mc.evictionsByReason {
+		counts[reason] = n
+	}
+	return counts
 }
 
 // RecordMetric records a metric event.
@@ -59,6 +117,11 @@ func (mc *MetricsCollector) RecordMetric(event MetricEvent) {
 		mc.cacheDeletes.Add(int64(event.Value))
 	case MetricCacheEviction:
 		mc.evictions.Add(int64(event.Value))
+		if reason := event.Labels["reason"]; reason != "" {
+			mc.evictionsMu.Lock()
+			mc.evictionsByReason[reason] += int64(event.Value)
+			mc.evictionsMu.Unlock()
+		}
 	case MetricInvalidation:
 		mc.invalidations.Add(int64(event.Value))
 	case MetricWarming:
@@ -23,6 +23,7 @@ package monitoring
 import (
 	"context"
 	"errors"
+	"math"
 	"sync"
 	"time"
 
@@ -66,11 +67,12 @@ const (
 	MetricCacheMiss       MetricType = "cache.miss"
 	MetricCacheSet        MetricType = "cache.set"
 	MetricCacheDelete     MetricType = "cache.delete"
-	MetricCacheEviction   MetricType = "cache.eviction"
+	MetricCacheEviction   MetricType = "cache.eviction" // Labels: reason, instance
 	MetricInvalidation    MetricType = "invalidation"
 	MetricWarming         MetricType = "warming"
 	MetricError           MetricType = "error"
 	MetricLatency         MetricType = "latency"
+	MetricHotKey          MetricType = "cache.hotkey" // Labels: key, action, instance
 )
 
 // MetricEvent represents a single metric event from any service.
@@ -337,14 +339,17 @@ var _ = pubsub.NewSubscription(
 )
 
 // CacheMetricEvent represents a metric event from cache-manager.
+// cache-manager publishes a sample of its operations; SampleRate is the fraction
+// published, so each event stands for 1/SampleRate operations.
 type CacheMetricEvent struct {
-	Operation string    `json:"operation"` // "get", "set", "delete", "invalidate"
-	Key       string    `json:"key"`
-	Hit       bool      `json:"hit"`
-	Latency   float64   `json:"latency"` // Milliseconds
-	Size      int       `json:"size"`
-	Timestamp time.Time `json:"timestamp"`
-	Instance  string    `json:"instance"`
+	Operation  string    `json:"operation"`             // "get", "set", "delete", "invalidate"
+	Key        string    `json:"key"`                   // The pattern or tag for "invalidate"
+	Hit        bool      `json:"hit"`                   // L1 hit for "get"; key or matches found for "delete" and "invalidate"
+	Latency    float64   `json:"latency"`               // Milliseconds
+	Size       int       `json:"size"`                  // Value bytes; entries removed for "invalidate"
+	SampleRate float64   `json:"sample_rate,omitempty"` // 0 means every operation is published
+	Timestamp  time.Time `json:"timestamp"`
+	Instance   string    `json:"instance"`
 }
 
 var CacheMetricsTopic = pubsub.NewTopic[*CacheMetricEvent](
@@ -359,47 +364,50 @@ func HandleCacheMetric(ctx context.Context, event *CacheMetricEvent) error {
 	if svc == nil {
 		return nil
 	}
-
-	// Record hit/miss
-	if event.Operation == "get" {
-		if event.Hit {
-			svc.collector.RecordMetric(MetricEvent{
-				Type:      MetricCacheHit,
-				Value:     1,
-				Timestamp: event.Timestamp,
-				Source:    "cache-manager",
-			})
-		} else {
-			svc.collector.RecordMetric(MetricEvent{
-				Type:      MetricCacheMiss,
-				Value:     1,
-				Timestamp: event.Timestamp,
-				Source:    "cache-manager",
-			})
-		}
+	for _, metric := range cacheMetrics(event) {
+		svc.collector.RecordMetric(metric)
 	}
+	return nil
+}
 
-	// Record operation
-	switch event.Operation {
-	case "set":
-		svc.collector.RecordMetric(MetricEvent{
-			Type:      MetricCacheSet,
-			Value:     1,
+// cacheMetrics converts an operation into the metrics it counts towards. Counts are
+// scaled by the sample rate; latency is recorded once, as a sample of the distribution.
+func cacheMetrics(event *CacheMetricEvent) []MetricEvent {
+	weight := 1.0
+	if event.SampleRate > 0 && event.SampleRate < 1 {
+		weight = math.Round(1 / event.SampleRate)
+	}
+	var metrics []MetricEvent
+	count := func(metricType MetricType, value float64) {
+		metrics = append(metrics, MetricEvent{
+			Type:      metricType,
+			Value:     value,
 			Timestamp: event.Timestamp,
 			Source:    "cache-manager",
 		})
+	}
+
+	// Record hit/miss and operation
+	switch event.Operation {
+	case "get":
+		if event.Hit {
+			count(MetricCacheHit, weight)
+		} else {
+			count(MetricCacheMiss, weight)
+		}
+	case "set":
+		count(MetricCacheSet, weight)
 	case "delete":
-		svc.collector.RecordMetric(MetricEvent{
-			Type:      MetricCacheDelete,
-			Value:     1,
-			Timestamp: event.Timestamp,
-			Source:    "cache-manager",
-		})
+		count(MetricCacheDelete, weight)
+	case "invalidate":
+		if event.Size > 0 {
+			count(MetricCacheDelete, float64(event.Size)*weight)
+		}
 	}
 
 	// Record latency
 	if event.Latency > 0 {
-		svc.collector.RecordMetric(MetricEvent{
+		metrics = append(metrics, MetricEvent{
 			Type:      MetricLatency,
 			Value:     event.Latency,
 			Timestamp: event.Timestamp,
@@ -407,10 +415,133 @@ func HandleCacheMetric(ctx context.Context, event *CacheMetricEvent) error {
 			Labels:    map[string]string{"operation": event.Operation},
 		})
 	}
+	return metrics
+}
+
+// Subscribe to hot-key promotions and demotions
+var _ = pubsub.NewSubscription(
+	HotKeyTopic,
+	"monitoring-hotkeys",
+	pubsub.SubscriptionConfig[*HotKeyEvent]{
+		Handler: HandleHotKeyEvent,
+	},
+)
+
+// Hot-key event actions.
+const (
+	HotKeyPromote = "promote"
+	HotKeyDemote  = "demote"
+)
+
+// HotKeyEvent reports a key becoming hot, or no longer hot, on a cache-manager instance.
+// cache-manager instances also subscribe, to replicate hot keys locally.
+type HotKeyEvent struct {
+	Key       string    `json:"key"`
+	Action    string    `json:"action"`            // "promote" or "demote"
+	Hits      int64     `json:"hits"`              // Gets in the last detection window
+	Window    int       `json:"window"`            // Detection window in seconds
+	Renewal   bool      `json:"renewal,omitempty"` // Re-announcement of a key that stayed hot
+	Instance  string    `json:"instance"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+var HotKeyTopic = pubsub.NewTopic[*HotKeyEvent](
+	"cache-hotkeys",
+	pubsub.TopicConfig{
+		DeliveryGuarantee: pubsub.AtLeastOnce,
+	},
+)
+
+// HandleHotKeyEvent tracks the current hot keys for the dashboard.
+func HandleHotKeyEvent(ctx context.Context, event *HotKeyEvent) error {
+	if svc == nil {
+		return nil
+	}
 
+	svc.collector.RecordHotKey(event)
+	if event.Renewal {
+		return nil
+	}
+	svc.collector.RecordMetric(MetricEvent{
+		Type:      MetricHotKey,
+		Value:     1,
+		Timestamp: event.Timestamp,
+		Source:    "cache-manager",
+		Labels:    map[string]string{"key": event.Key, "action": event.Action, "instance": event.Instance},
+	})
 	return nil
 }
 
+// Subscribe to sampled L1 evictions
+var _ = pubsub.NewSubscription(
+	EvictionTopic,
+	"monitoring-evictions",
+	pubsub.SubscriptionConfig[*EvictionEvent]{
+		Handler: HandleEvictionEvent,
+	},
+)
+
+// EvictionEvent is a batch of L1 evictions sampled on one cache-manager instance.
+type EvictionEvent struct {
+	Evictions  []EvictionSample `json:"evictions"`
+	SampleRate float64          `json:"sample_rate"` // Fraction of evictions included in the batch
+	Instance   string           `json:"instance"`
+	Timestamp  time.Time        `json:"timestamp"`
+}
+
+// EvictionSample is one evicted entry.
+type EvictionSample struct {
+	Key    string  `json:"key"`
+	Reason string  `json:"reason"` // "capacity", "expired", "invalidated" or "replaced"
+	AgeMs  float64 `json:"age_ms"` // Time since the value was written
+}
+
+var EvictionTopic = pubsub.NewTopic[*EvictionEvent](
+	"cache-evictions",
+	pubsub.TopicConfig{
+		DeliveryGuarantee: pubsub.AtLeastOnce,
+	},
+)
+
+// HandleEvictionEvent records a batch as one MetricCacheEviction per reason, scaled
+// by the sample rate to estimate the evictions the batch stands for.
+func HandleEvictionEvent(ctx context.Context, event *EvictionEvent) error {
+	if svc == nil {
+		return nil
+	}
+	for _, metric := range evictionMetrics(event) {
+		svc.collector.RecordMetric(metric)
+	}
+	return nil
+}
+
+func evictionMetrics(event *EvictionEvent) []MetricEvent {
+	rate := event.SampleRate
+	if rate <= 0 || rate > 1 {
+		rate = 1
+	}
+	counts := make(map[string]int)
+	var reasons []string
+	for _, e := range event.Evictions {
+		if counts[e.Reason] == 0 {
+			reasons = append(reasons, e.Reason)
+		}
+		counts[e.Reason]++
+	}
+
+	metrics := make([]MetricEvent, 0, len(reasons))
+	for _, reason := range reasons {
+		metrics = append(metrics, MetricEvent{
+			Type:      MetricCacheEviction,
+			Value:     math.Round(float64(counts[reason]) / rate),
+			Timestamp: event.Timestamp,
+			Source:    "cache-manager",
+			Labels:    map[string]string{"reason": reason, "instance": event.Instance},
+		})
+	}
+	return metrics
+}
+
 // Subscribe to warming completion events
 var _ = pubsub.NewSubscription(
 	warming.WarmCompletedTopic,
@@ -652,4 +652,95 @@ func BenchmarkAnomalyDetector_Detect(b *testing.B) {
 	for i := 0; i < b.N; i++ {
 		detector.Detect(stats)
 	}
-}
\ No newline at end of file
+}
+
+func TestMetricsCollector_HotKeys(t *testing.T) {
+	collector := NewMetricsCollector(DefaultConfig())
+	now := time.Now()
+
+	collector.RecordHotKey(&HotKeyEvent{Key: "a", Action: HotKeyPromote, Hits: 100, Instance: "i1", Timestamp: now})
+	collector.RecordHotKey(&HotKeyEvent{Key: "b", Action: HotKeyPromote, Hits: 500, Instance: "i1", Timestamp: now})
+	collector.RecordHotKey(&HotKeyEvent{Key: "a", Action: HotKeyPromote, Hits: 900, Renewal: true, Instance: "i1", Timestamp: now.Add(time.Minute)})
+
+	keys := collector.GetHotKeys()
+	if len(keys) != 2 || keys[0].Key != "a" || keys[0].Hits != 900 {
+		t.Fatalf("Expected a (900 hits) then b, got %+v", keys)
+	}
+	if !keys[0].Timestamp.Equal(now) {
+		t.Error("Renewal should keep the promotion time")
+	}
+
+	collector.RecordHotKey(&HotKeyEvent{Key: "a", Action: HotKeyDemote, Instance: "i1"})
+	if keys := collector.GetHotKeys(); len(keys) != 1 || keys[0].Key != "b" {
+		t.Errorf("Expected only b after demotion, got %+v", keys)
+	}
+}
+
+func TestEvictionMetrics(t *testing.T) {
+	collector := NewMetricsCollector(DefaultConfig())
+	event := &EvictionEvent{
+		Evictions: []EvictionSample{
+			{Key: "a", Reason: "capacity"},
+			{Key: "b", Reason: "expired"},
+			{Key: "c", Reason: "capacity"},
+		},
+		SampleRate: 0.1,
+		Instance:   "i1",
+		Timestamp:  time.Now(),
+	}
+
+	metrics := evictionMetrics(event)
+	if len(metrics) != 2 {
+		t.Fatalf("Expected one metric per reason, got %+v", metrics)
+	}
+	for _, m := range metrics {
+		if m.Type != MetricCacheEviction || m.Labels["instance"] != "i1" {
+			t.Errorf("Unexpected metric %+v", m)
+		}
+		collector.RecordMetric(m)
+	}
+
+	// Counts are scaled by the sample rate
+	byReason := collector.GetEvictionsByReason()
+	if byReason["capacity"] != 20 || byReason["expired"] != 10 {
+		t.Errorf("Expected 20 capacity and 10 expired evictions, got %v", byReason)
+	}
+	if counters := collector.GetCounters(); counters.Evictions != 30 {
+		t.Errorf("Expected 30 evictions in total, got %d", counters.Evictions)
+	}
+}
+
+func TestCacheMetrics(t *testing.T) {
+	collector := NewMetricsCollector(DefaultConfig())
+	now := time.Now()
+	events := []*CacheMetricEvent{
+		{Operation: "get", Hit: true, Latency: 2, SampleRate: 0.1, Timestamp: now},
+		{Operation: "get", Hit: false, Latency: 8, SampleRate: 0.1, Timestamp: now},
+		{Operation: "set", Timestamp: now},
+		{Operation: "invalidate", Key: "user:*", Size: 3, SampleRate: 0.5, Timestamp: now},
+	}
+	latencies := 0
+	for _, event := range events {
+		for _, m := range cacheMetrics(event) {
+			if m.Type == MetricLatency {
+				latencies++
+			}
+			collector.RecordMetric(m)
+		}
+	}
+
+	// Counts are scaled by the sample rate; latency samples are not
+	counters := collector.GetCounters()
+	if counters.CacheHits != 10 || counters.CacheMisses != 10 {
+		t.Errorf("Expected 10 hits and 10 misses, got %+v", counters)
+	}
+	if counters.CacheSets != 1 {
+		t.Errorf("Expected 1 set from an unsampled event, got %d", counters.CacheSets)
+	}
+	if counters.CacheDeletes != 6 {
+		t.Errorf("Expected 6 deletes from the invalidation, got %d", counters.CacheDeletes)
+	}
+	if latencies != 2 {
+		t.Errorf("Expected 2 latency samples, got %d", latencies)
+	}
+}